@@ -0,0 +1,233 @@
+// Package client provides a Go client for the HTTP API of roshi-server.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Client speaks to a single roshi-server, or a load balancer in front of
+// several. It implements cluster.Inserter, cluster.Deleter, and
+// farm.Selecter, so it can be used in their place. A Client is safe for
+// concurrent use.
+type Client struct {
+	url     string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// Option changes the default behavior of a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used for requests. The default is a
+// client with a timeout of DefaultTimeout.
+func WithHTTPClient(c *http.Client) Option {
+	return func(client *Client) { client.http = c }
+}
+
+// WithTimeout sets the timeout of every request, including retries
+// separately. The default is DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return func(client *Client) {
+		c := *client.http
+		c.Timeout = d
+		client.http = &c
+	}
+}
+
+// WithRetries retries failed requests up to n times, waiting backoff before
+// the first retry, and twice as long before every further one. Requests are
+// retried when the server can't be reached, or fails with a server error
+// other than 507 Insufficient Storage. That includes Inserts and Deletes,
+// which are idempotent. By default, requests aren't retried.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(client *Client) {
+		client.retries = n
+		client.backoff = backoff
+	}
+}
+
+// DefaultTimeout is the default timeout of every request.
+const DefaultTimeout = 10 * time.Second
+
+// New creates and returns a new Client for the roshi-server at the given
+// base URL, e.g. "http://localhost:6302". Options may be used to change the
+// default behavior.
+func New(url string, options ...Option) *Client {
+	client := &Client{
+		url:  strings.TrimSuffix(url, "/"),
+		http: &http.Client{Timeout: DefaultTimeout},
+	}
+	for _, option := range options {
+		option(client)
+	}
+	return client
+}
+
+// Error is returned for requests which the server rejected or failed, with
+// the HTTP status code and the error message of the server.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("HTTP %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// Temporary returns true if the request may succeed when retried.
+func (e Error) Temporary() bool {
+	return e.Code >= 500 && e.Code != http.StatusInsufficientStorage
+}
+
+// Insert inserts the tuples.
+func (c *Client) Insert(tuples []common.KeyScoreMember) error {
+	return c.write("POST", tuples)
+}
+
+// Delete deletes the tuples.
+func (c *Client) Delete(tuples []common.KeyScoreMember) error {
+	return c.write("DELETE", tuples)
+}
+
+func (c *Client) write(method string, tuples []common.KeyScoreMember) error {
+	if tuples == nil {
+		tuples = []common.KeyScoreMember{}
+	}
+	body, err := json.Marshal(tuples)
+	if err != nil {
+		return err
+	}
+	return c.do(method, url.Values{}, body, nil)
+}
+
+// SelectOffset returns up to limit records of each key, starting at offset,
+// from the newest or oldest end depending on order. The server caps limit
+// to its -max.size.
+func (c *Client) SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error) {
+	query := url.Values{}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	if order == common.Ascending {
+		query.Set("order", "asc")
+	}
+	return c.selectKeys(keys, query)
+}
+
+// SelectOffsetFloor returns up to limit records of each key, starting at
+// offset from the newest end, skipping records with scores below minScore,
+// see farm.Farm.SelectOffsetFloor.
+func (c *Client) SelectOffsetFloor(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, error) {
+	query := url.Values{}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("minScore", strconv.FormatFloat(minScore, 'g', -1, 64))
+	return c.selectKeys(keys, query)
+}
+
+// SelectRange returns up to limit records of each key, from start to stop,
+// by descending score. The cursor of the last record of a key, see
+// common.KeyScoreMember.Cursor, is the start of the next page.
+func (c *Client) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	query := url.Values{}
+	query.Set("start", start.String())
+	query.Set("stop", stop.String())
+	query.Set("limit", strconv.Itoa(limit))
+	return c.selectKeys(keys, query)
+}
+
+func (c *Client) selectKeys(keys []string, query url.Values) (map[string][]common.KeyScoreMember, error) {
+	body, err := json.Marshal(common.Keys(keys))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := c.do("GET", query, body, &response); err != nil {
+		return nil, err
+	}
+
+	// The keys of the records object are JSON strings, which mangle keys
+	// that aren't valid UTF-8. The keys of the records themselves are exact.
+	records := make(map[string][]common.KeyScoreMember, len(keys))
+	for _, key := range keys {
+		records[key] = []common.KeyScoreMember{}
+	}
+	for _, a := range response.Records {
+		if len(a) > 0 {
+			records[a[0].Key] = a
+		}
+	}
+	return records, nil
+}
+
+// do performs the request, retrying as configured, and decodes the response
+// into v, unless it's nil.
+func (c *Client) do(method string, query url.Values, body []byte, v interface{}) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.doOnce(method, query, body, v)
+		if err == nil || attempt >= c.retries || !temporary(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (c *Client) doOnce(method string, query url.Values, body []byte, v interface{}) error {
+	u := c.url + "/"
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body) // so the connection is reused
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		var response struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.Error == "" {
+			response.Error = http.StatusText(resp.StatusCode)
+		}
+		return Error{Code: resp.StatusCode, Message: response.Error}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// temporary returns true if the error of a request is worth a retry: every
+// error other than an Error, which is a response of the server, is a
+// problem reaching it.
+func temporary(err error) bool {
+	if e, ok := err.(Error); ok {
+		return e.Temporary()
+	}
+	return true
+}
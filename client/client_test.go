@@ -0,0 +1,221 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+var (
+	_ cluster.Inserter = &Client{}
+	_ cluster.Deleter  = &Client{}
+	_ farm.Selecter    = &Client{}
+)
+
+func TestInsertDelete(t *testing.T) {
+	var (
+		tuples = []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}
+		method string
+		got    []common.KeyScoreMember
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		got = nil
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprintf(w, `{"inserted":%d}`, len(got))
+	}))
+	defer server.Close()
+	c := New(server.URL)
+
+	if err := c.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "POST"; expected != method {
+		t.Errorf("expected %s, got %s", expected, method)
+	}
+	if !reflect.DeepEqual(tuples, got) {
+		t.Errorf("expected %v, got %v", tuples, got)
+	}
+
+	if err := c.Delete(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "DELETE"; expected != method {
+		t.Errorf("expected %s, got %s", expected, method)
+	}
+	if !reflect.DeepEqual(tuples, got) {
+		t.Errorf("expected %v, got %v", tuples, got)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	var (
+		records = map[string][]common.KeyScoreMember{
+			"foo": {{Key: "foo", Score: 2, Member: "b"}, {Key: "foo", Score: 1, Member: "a"}},
+			"bar": {},
+		}
+		query map[string]string
+		keys  [][]byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("expected GET, got %s", r.Method)
+		}
+		query = map[string]string{}
+		for k := range r.URL.Query() {
+			query[k] = r.URL.Query().Get(k)
+		}
+		keys = nil
+		if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"records": records, "duration": "1ms"})
+	}))
+	defer server.Close()
+	c := New(server.URL)
+
+	got, err := c.SelectOffset([]string{"foo", "bar"}, 5, 10, common.Ascending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, got) {
+		t.Errorf("expected %v, got %v", records, got)
+	}
+	if expected := map[string]string{"offset": "5", "limit": "10", "order": "asc"}; !reflect.DeepEqual(expected, query) {
+		t.Errorf("expected query %v, got %v", expected, query)
+	}
+	if expected := [][]byte{[]byte("foo"), []byte("bar")}; !reflect.DeepEqual(expected, keys) {
+		t.Errorf("expected keys %q, got %q", expected, keys)
+	}
+
+	if _, err := c.SelectOffsetFloor([]string{"foo"}, 0, 10, 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"offset": "0", "limit": "10", "minScore": "1.5"}; !reflect.DeepEqual(expected, query) {
+		t.Errorf("expected query %v, got %v", expected, query)
+	}
+
+	// Cursors survive the round trip.
+	start, stop := records["foo"][0].Cursor(), common.Cursor{Score: 0.5, Member: "a/b"}
+	if _, err := c.SelectRange([]string{"foo"}, start, stop, 10); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]common.Cursor{"start": start, "stop": stop} {
+		var got common.Cursor
+		if err := got.Parse(query[name]); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if expected != got {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+	if _, ok := query["offset"]; ok {
+		t.Errorf("expected no offset with a range, got %q", query["offset"])
+	}
+}
+
+func TestSelectBinaryKeys(t *testing.T) {
+	keys := []string{string([]byte{0, 255}), string([]byte{1, 255}), "empty"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requested common.Keys
+		if err := json.NewDecoder(r.Body).Decode(&requested); err != nil {
+			t.Error(err)
+		}
+		records := map[string][]common.KeyScoreMember{}
+		for _, key := range requested[:2] {
+			records[key] = []common.KeyScoreMember{{Key: key, Score: 1, Member: key}}
+		}
+		records[requested[2]] = []common.KeyScoreMember{}
+		json.NewEncoder(w).Encode(map[string]interface{}{"records": records})
+	}))
+	defer server.Close()
+
+	got, err := New(server.URL).SelectOffset(keys, 0, 10, common.Descending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]common.KeyScoreMember{
+		keys[0]: {{Key: keys[0], Score: 1, Member: keys[0]}},
+		keys[1]: {{Key: keys[1], Score: 1, Member: keys[1]}},
+		keys[2]: {},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusInternalServerError, http.StatusInsufficientStorage} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "failtown", "code": code})
+		}))
+		err := New(server.URL).Insert(nil)
+		server.Close()
+
+		if expected, got := (Error{Code: code, Message: "failtown"}), err; expected != got {
+			t.Errorf("expected %#v, got %#v", expected, got)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	for _, tc := range []struct {
+		code     int
+		retries  int
+		requests int
+		success  bool
+	}{
+		{http.StatusServiceUnavailable, 0, 1, false},
+		{http.StatusServiceUnavailable, 1, 2, false},
+		{http.StatusServiceUnavailable, 2, 3, true},
+		{http.StatusBadRequest, 2, 1, false},
+		{http.StatusInsufficientStorage, 2, 1, false},
+	} {
+		// Two failures, then success.
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests++; requests <= 2 {
+				w.WriteHeader(tc.code)
+				return
+			}
+			fmt.Fprint(w, `{"records":{}}`)
+		}))
+		_, err := New(server.URL, WithRetries(tc.retries, time.Millisecond)).SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+		server.Close()
+
+		if expected, got := tc.requests, requests; expected != got {
+			t.Errorf("HTTP %d, %d retries: expected %d request(s), got %d", tc.code, tc.retries, expected, got)
+		}
+		if expected, got := tc.success, err == nil; expected != got {
+			t.Errorf("HTTP %d, %d retries: expected success %v, got error %v", tc.code, tc.retries, expected, err)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	began := time.Now()
+	if err := New(server.URL, WithTimeout(10*time.Millisecond)).Insert(nil); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Errorf("expected a timeout after 10ms, took %s", elapsed)
+	}
+}
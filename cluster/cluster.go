@@ -3,8 +3,11 @@
 package cluster
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"strings"
 	"sync"
@@ -18,10 +21,6 @@ import (
 	"github.com/soundcloud/roshi/pool"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 // Cluster defines methods that efficiently provide ZSET semantics on a
 // cluster.
 type Cluster interface {
@@ -40,9 +39,13 @@ type Inserter interface {
 }
 
 // Selecter defines the methods to retrieve elements from a sorted set.
+// SelectOffset walks the members of each key in the given order; SelectRange
+// always walks them by descending score. CountRange returns the number of
+// members of each key which SelectRange would yield without a limit.
 type Selecter interface {
-	SelectOffset(keys []string, offset, limit int) <-chan Element
+	SelectOffset(keys []string, offset, limit int, order common.Order) <-chan Element
 	SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan Element
+	CountRange(keys []string, start, stop common.Cursor) (map[string]int, error)
 }
 
 // Deleter defines the method to delete elements from a sorted set. A key-
@@ -66,90 +69,541 @@ type Scorer interface {
 // instances. If an instance is down at the time it is tried to be
 // scanned, it is skipped (no retries). See also implications of the
 // Redis SCAN command. Note that keys for which only deletes have
-// happened (and no inserts) will not be emitted.
+// happened (and no inserts) will not be emitted; see DeleteScanner.
 type Scanner interface {
 	Keys(batchSize int) <-chan []string
 }
 
-const (
-	insertSuffix = "+"
-	deleteSuffix = "-"
-)
+// DeleteScanner is an optional interface, implemented by Scanners which can
+// also emit the keys which Keys skips: DeleteOnlyKeys emits the keys which
+// have a delete set but no insert set, like Keys, e.g. so that they can be
+// expired too.
+type DeleteScanner interface {
+	DeleteOnlyKeys(batchSize int) <-chan []string
+}
 
-var (
-	genericScript = `
-		local addKey = KEYS[1] .. 'ADDSUFFIX'
-		local remKey = KEYS[1] .. 'REMSUFFIX'
-
-		local maxSize = tonumber(ARGV[3])
-		local atCapacity = tonumber(redis.call('ZCARD', addKey)) >= maxSize
-		if atCapacity then
-			local oldestTs = redis.call('ZRANGE', addKey, 0, 0, 'WITHSCORES')[2]
-			if oldestTs and tonumber(ARGV[1]) < tonumber(oldestTs) then
-				return -1
-			end
-		end
-
-		local insertTs = redis.call('ZSCORE', KEYS[1] .. 'INSERTSUFFIX', ARGV[2])
-		local deleteTs = redis.call('ZSCORE', KEYS[1] .. 'DELETESUFFIX', ARGV[2])
-		if insertTs and tonumber(ARGV[1]) < tonumber(insertTs) then
-			return -1
-		elseif deleteTs and tonumber(ARGV[1]) <= tonumber(deleteTs) then
-			return -1
-		end
-
-		redis.call('ZREM', remKey, ARGV[2])
-		local n = redis.call('ZADD', addKey, ARGV[1], ARGV[2])
-		redis.call('ZREMRANGEBYRANK', addKey, 0, -(maxSize+1))
-		return n
-	`
-	insertScript *redis.Script
-	deleteScript *redis.Script
-)
+// BatchScanner is an optional interface, implemented by Scanners which can
+// tell which instance keys come from. KeyBatches emits the same keys as Keys,
+// in KeyBatches, and marks the last batch of every instance as Complete, so
+// that consumers can tell when an instance is done, e.g. to checkpoint their
+// progress per instance.
+type BatchScanner interface {
+	KeyBatches(batchSize int) <-chan KeyBatch
+}
+
+// AllSelecter is an optional interface, implemented by Clusters which can
+// stream every member of a key, e.g. for exports. SelectAll sends the
+// members by descending score, in pages of up to window members, and closes
+// the channel after the last page, after an Element with an Error, or when
+// ctx is done.
+type AllSelecter interface {
+	SelectAll(ctx context.Context, key string, window int) <-chan Element
+}
+
+// KeyBatch is a batch of keys from a single instance, emitted by
+// BatchScanner.
+type KeyBatch struct {
+	Instance string   // ID of the instance the keys come from
+	Keys     []string // up to batchSize keys, may be empty if Complete
+	Complete bool     // true for the last batch of the instance
+}
+
+// ClockReader is an optional interface, implemented by Clusters which can
+// read the clocks of their instances. ClockOffsets returns the offset of each
+// reachable instance's clock from the local clock, keyed by instance ID. The
+// offsets are estimated, assuming symmetric network latency.
+type ClockReader interface {
+	ClockOffsets() map[string]time.Duration
+}
+
+// InfoReader is an optional interface, implemented by Clusters which can
+// report the state of their instances, e.g. for capacity monitoring. Info
+// returns the state of each instance, keyed by instance ID, with an Error
+// for instances which couldn't be asked.
+type InfoReader interface {
+	Info() map[string]InstanceInfo
+}
+
+// InstanceInfo is the state of an instance, reported by InfoReader. Info is
+// zero if Error isn't nil.
+type InstanceInfo struct {
+	pool.Info
+	Error error
+}
+
+// KeyEstimator is an optional interface, implemented by Clusters which can
+// estimate how many keys they hold, without walking all of them like
+// Scanner, e.g. for dashboards. EstimateKeys samples up to sampleSize Redis
+// keys of every instance, and returns the estimate of each instance, keyed
+// by instance ID, with an Error for instances which couldn't be asked.
+type KeyEstimator interface {
+	EstimateKeys(sampleSize int) map[string]KeyEstimate
+}
+
+// KeyEstimate is the estimated number of keys of an instance, reported by
+// KeyEstimator. The fields are zero if Error isn't nil.
+type KeyEstimate struct {
+	Keys    int64 // estimated number of keys with inserts, as emitted by Scanner
+	Sampled int   // Redis keys sampled
+	DBSize  int64 // Redis keys of the instance, from DBSIZE
+	Exact   bool  // true if the sample covered the instance, so that Keys is a count
+	Error   error
+}
+
+// Tombstoner is an optional interface, implemented by Clusters which can
+// tell deleted keys from unknown ones. Tombstoned returns true for each of
+// the passed keys which has members in its delete set, i.e. which has seen
+// deletes. It's more expensive than a Select, and intended for keys which
+// selected empty.
+type Tombstoner interface {
+	Tombstoned(keys []string) (map[string]bool, error)
+}
+
+// InsertReporter is an optional interface, implemented by Clusters which can
+// report the outcome of inserts. InsertReporting is like Insert, but also
+// returns the resulting presence of every passed key-member, as Score would
+// right after the insert. The presence has a higher score than the insert if
+// a newer write was already stored, and isn't Inserted if that write was a
+// delete.
+type InsertReporter interface {
+	InsertReporting(tuples []common.KeyScoreMember) (map[common.KeyMember]Presence, error)
+}
+
+// ContextInserter is an optional interface, implemented by Clusters which
+// can abandon inserts. InsertContext is like Insert, but gives up with
+// ctx.Err() once ctx is done. Inserts which were already sent to an instance
+// may still be applied, like inserts which timed out.
+type ContextInserter interface {
+	InsertContext(ctx context.Context, tuples []common.KeyScoreMember) error
+}
+
+// Expirer is an optional interface, implemented by Clusters which can expire
+// keys. Expire sets the time-to-live of each of the passed keys, i.e. of both
+// its insert and delete sets, replacing any previous one. Keys which don't
+// exist are left alone.
+type Expirer interface {
+	Expire(keys []string, ttl time.Duration) error
+}
+
+// TombstoneTrimmer is an optional interface, implemented by Clusters which
+// can trim tombstones. TrimTombstones removes the members of the delete set
+// of each of the passed keys whose scores are more than grace below the
+// highest score of the key, like writes do with WithTombstoneGrace. Keys
+// which don't exist are left alone.
+type TombstoneTrimmer interface {
+	TrimTombstones(keys []string, grace float64) error
+}
+
+// TombstoneScoreTrimmer is an optional interface, implemented by Clusters
+// which can trim tombstones by score. TrimTombstonesBefore removes the
+// members of the delete set of each of the passed keys with scores below
+// maxScore, regardless of the scores of the key's inserts. Like with
+// TombstoneTrimmer, a write of a trimmed member which arrives late, with a
+// lower score than the trimmed tombstone, is accepted again.
+type TombstoneScoreTrimmer interface {
+	TrimTombstonesBefore(keys []string, maxScore float64) error
+}
+
+// Cardinalizer is an optional interface, implemented by Clusters which can
+// count the members of keys without reading them, e.g. to find keys with
+// lots of tombstones. Cardinalities returns the Cardinality of each of the
+// passed keys, which is zero for keys which don't exist.
+type Cardinalizer interface {
+	Cardinalities(keys []string) (map[string]Cardinality, error)
+}
+
+// Cardinality is the number of members of the insert and delete sets of a
+// key, reported by Cardinalizer.
+type Cardinality struct {
+	Inserts int
+	Deletes int
+}
+
+// KeyCopier is an optional interface, implemented by Clusters which can copy
+// keys, e.g. to rename them. CopyKey merges the insert and delete sets of
+// src into those of dst, so that dst ends up with every member of src which
+// doesn't lose to a write dst already has, and src is left alone.
+type KeyCopier interface {
+	CopyKey(src, dst string) error
+}
+
+// FloorSelecter is an optional interface, implemented by Clusters which can
+// select the newest members of keys down to a minimum score, e.g. for "the
+// newest members, but nothing older than T". SelectOffsetFloor is
+// SelectOffset in descending order, which skips members with scores below
+// minScore, so the offset and limit only count members at or above it.
+type FloorSelecter interface {
+	SelectOffsetFloor(keys []string, offset, limit int, minScore float64) <-chan Element
+}
+
+// RangeOffsetSelecter is an optional interface, implemented by Clusters
+// which can skip members after the start cursor of a cursor-based select,
+// e.g. to jump a few pages ahead of a cursor. SelectRangeOffset is
+// SelectRange, which skips the first offset members after start, so the
+// limit only counts the members after those.
+type RangeOffsetSelecter interface {
+	SelectRangeOffset(keys []string, start, stop common.Cursor, offset, limit int) <-chan Element
+}
+
+// TopSelecter is an optional interface, implemented by Clusters which can
+// select the single newest member of each key, e.g. for leaderboards. Keys
+// without members are missing from the result.
+type TopSelecter interface {
+	SelectTop(keys []string) (map[string]common.KeyScoreMember, error)
+}
+
+// Tuner is an optional interface, implemented by Clusters whose maxSize and
+// selectGap can be changed while they're in use, e.g. to tune them under
+// load. Tuning returns the current values, and Tune replaces both at once.
+// A new maxSize only applies to writes from then on: keys are trimmed to it
+// when they're next written.
+type Tuner interface {
+	Tuning() Tuning
+	Tune(Tuning)
+}
+
+// Tuning holds the parameters of a Cluster which a Tuner can change, see New.
+type Tuning struct {
+	MaxSize   int
+	SelectGap time.Duration
+}
+
+// Pinger is an optional interface, implemented by Clusters which can check
+// the health of their instances. Ping returns the result of pinging each
+// instance, keyed by instance ID. A nil error means the instance is up.
+type Pinger interface {
+	Ping() map[string]error
+}
+
+// Warmer is an optional interface, implemented by Clusters which can prepare
+// their instances for traffic, e.g. at startup. Warm returns the result of
+// warming each instance, keyed by instance ID. A nil error means the
+// instance is warm.
+type Warmer interface {
+	Warm() map[string]error
+}
 
-func init() {
-	genericScript = strings.NewReplacer(
-		"INSERTSUFFIX", insertSuffix,
-		"DELETESUFFIX", deleteSuffix,
-	).Replace(genericScript)
+// HealthReporter is an optional interface, implemented by Clusters which
+// track the health of their instances. Reachable returns false if any of the
+// passed keys maps to an instance which failed its last health check.
+// Instances which have never been checked are assumed to be reachable.
+type HealthReporter interface {
+	Reachable(keys []string) bool
+}
 
-	insertScript = redis.NewScript(1, strings.NewReplacer(
-		"REMSUFFIX", deleteSuffix, // Insert script does ZREM from deletes key
-		"ADDSUFFIX", insertSuffix, // and ZADD to inserts key
-	).Replace(genericScript))
+// CheckInstances pings the instances of every Cluster which implements
+// Pinger, every interval, and reports each result to the passed function.
+// Changes in the state of an instance are logged. CheckInstances never
+// returns, so it should be called in a goroutine.
+func CheckInstances(clusters []Cluster, interval time.Duration, report func(id string, err error)) {
+	CheckClusters(clusters, interval, func(_ int, results map[string]error) {
+		for id, err := range results {
+			report(id, err)
+		}
+	})
+}
 
-	deleteScript = redis.NewScript(1, strings.NewReplacer(
-		"REMSUFFIX", insertSuffix, // Delete script does ZREM from inserts key
-		"ADDSUFFIX", deleteSuffix, // and ZADD to deletes key
-	).Replace(genericScript))
+// CheckClusters is like CheckInstances, but reports the results of each
+// Cluster at once, with its index in clusters, so that callers can tell
+// whether a Cluster as a whole is healthy. Clusters which don't implement
+// Pinger are never reported.
+func CheckClusters(clusters []Cluster, interval time.Duration, report func(index int, results map[string]error)) {
+	up := map[string]bool{}
+	for range time.Tick(interval) {
+		for i, c := range clusters {
+			p, ok := c.(Pinger)
+			if !ok {
+				continue
+			}
+			results := p.Ping()
+			for id, err := range results {
+				if wasUp, seen := up[id]; !seen || wasUp != (err == nil) {
+					if err != nil {
+						log.Printf("cluster: instance %s is down: %s", id, err)
+					} else if seen {
+						log.Printf("cluster: instance %s is up", id)
+					}
+				}
+				up[id] = err == nil
+			}
+			report(i, results)
+		}
+	}
 }
 
+const (
+	insertSuffix = "+"
+	deleteSuffix = "-"
+
+	maxInt = int(^uint(0) >> 1)
+)
+
 // cluster implements the Cluster interface on a concrete Redis cluster.
 type cluster struct {
 	pool            *pool.Pool
-	maxSize         int
-	selectGap       time.Duration
+	tuning          atomic.Value // Tuning, see Tune
 	instrumentation instrumentation.Instrumentation
+	trimPolicy      TrimPolicy
+	uncapped        []string // key prefixes exempt from maxSize
+	maxScoreSize    int
+	maxMemberSize   int // bytes, 0 for no limit
+	rangeAttempts   int
+	insertOnly      bool
+	members         memberCodec // see WithMemberCompression
+	tombstoneGrace  float64     // see WithTombstoneGrace
+	scripts         *scripts    // see WithScript
+	noZMScore       int32       // set to 1 once an instance rejects ZMSCORE
+	noScanType      int32       // set to 1 once an instance rejects SCAN ... TYPE
+	randMtx         sync.Mutex
+	rand            *rand.Rand // guarded by randMtx
 }
 
 // New creates and returns a new Cluster backed by a concrete Redis cluster.
 // maxSize for each key will be enforced at write time. selectGap specifies a
 // wait period between pipeline calls to individual connections within a pool
-// when performing a Select with multiple keys. Instrumentation may be nil.
-func New(pool *pool.Pool, maxSize int, selectGap time.Duration, instr instrumentation.Instrumentation) Cluster {
+// when performing a Select with multiple keys. Both may be changed later, see
+// Tuner. Instrumentation may be nil. Options may be used to change the
+// default behavior.
+func New(pool *pool.Pool, maxSize int, selectGap time.Duration, instr instrumentation.Instrumentation, options ...Option) Cluster {
 	if instr == nil {
 		instr = instrumentation.NopInstrumentation{}
 	}
-	return &cluster{
+	c := &cluster{
 		pool:            pool,
-		maxSize:         maxSize,
-		selectGap:       selectGap,
 		instrumentation: instr,
+		trimPolicy:      KeepNewest,
+		maxScoreSize:    DefaultMaxScoreKeyMembers,
+		rangeAttempts:   DefaultRangeAttempts,
+		scripts:         defaultScripts,
+		rand:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	c.tuning.Store(Tuning{MaxSize: maxSize, SelectGap: selectGap})
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Tuning implements the Tuner interface.
+func (c *cluster) Tuning() Tuning {
+	return c.tuning.Load().(Tuning)
+}
+
+// Tune implements the Tuner interface.
+func (c *cluster) Tune(t Tuning) {
+	c.tuning.Store(t)
+}
+
+// Option sets an optional parameter of a Cluster created by New.
+type Option func(*cluster)
+
+// TrimPolicy determines which members of a key are kept when maxSize is
+// enforced.
+type TrimPolicy int
+
+const (
+	// KeepNewest keeps the maxSize members with the highest scores. Once a
+	// key is at capacity, writes with a score lower than the lowest score in
+	// the set are rejected. This is the default.
+	KeepNewest TrimPolicy = iota
+
+	// KeepOldest keeps the maxSize members with the lowest scores. Once a key
+	// is at capacity, writes with a score higher than the highest score in
+	// the set are rejected.
+	KeepOldest
+)
+
+func (p TrimPolicy) scriptArg() string {
+	if p == KeepOldest {
+		return "oldest"
+	}
+	return "newest"
+}
+
+// WithTrimPolicy sets the TrimPolicy used to enforce maxSize. The policy
+// applies to the insert set and the delete set alike.
+func WithTrimPolicy(p TrimPolicy) Option {
+	return func(c *cluster) { c.trimPolicy = p }
+}
+
+// WithMaxMemberSize rejects Inserts and Deletes with members of more than n
+// bytes with a MemberSizeError, before any of their tuples is sent to Redis.
+// One huge member would otherwise fail the whole pipeline to its instance,
+// e.g. beyond the proto-max-bulk-len of Redis, with an opaque error. The size
+// is that of the uncompressed member, which is always sent, see
+// WithMemberCompression. A non-positive n, the default, disables the check.
+func WithMaxMemberSize(n int) Option {
+	return func(c *cluster) { c.maxMemberSize = n }
+}
+
+// MemberSizeError is returned by Inserts and Deletes with members larger than
+// the max member size, see WithMaxMemberSize. Key is the key of the first
+// such member. None of the tuples of the write were written.
+type MemberSizeError struct {
+	Key       string
+	Size, Max int
+}
+
+func (e MemberSizeError) Error() string {
+	return fmt.Sprintf("member of key %q has %d bytes (max %d)", e.Key, e.Size, e.Max)
+}
+
+// checkMemberSizes returns a MemberSizeError if any of the tuples has a
+// member larger than the max member size, and reports how many do.
+func (c *cluster) checkMemberSizes(keyScoreMembers []common.KeyScoreMember, report func(int)) error {
+	if c.maxMemberSize <= 0 {
+		return nil
 	}
+	var (
+		err error
+		n   int
+	)
+	for _, tuple := range keyScoreMembers {
+		if size := len(tuple.Member); size > c.maxMemberSize {
+			if err == nil {
+				err = MemberSizeError{Key: tuple.Key, Size: size, Max: c.maxMemberSize}
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		report(n)
+	}
+	return err
+}
+
+// WithUncappedPrefixes exempts keys starting with any of the prefixes from
+// maxSize. Such keys are never trimmed, and writes to them are never
+// rejected for being beyond capacity, so they grow without bounds. This
+// applies to the insert set and the delete set alike.
+//
+// Every cluster of a farm, and every process writing to it, including
+// walkers which repair keys, should be configured with the same prefixes.
+// Otherwise, writes through a process without them trim the keys again.
+func WithUncappedPrefixes(prefixes ...string) Option {
+	return func(c *cluster) { c.uncapped = prefixes }
+}
+
+// maxSizeFor returns the maxSize to enforce for the key, or 0 if the key is
+// uncapped.
+func (c *cluster) maxSizeFor(key string) int {
+	for _, prefix := range c.uncapped {
+		if strings.HasPrefix(key, prefix) {
+			return 0
+		}
+	}
+	return c.Tuning().MaxSize
+}
+
+// WithRand sets the source of randomness of the Cluster, which determines
+// e.g. the order in which Keys scans the instances. Tests may pass a rand
+// with a fixed seed, to make that deterministic. The default is seeded with
+// the current time. The Cluster takes ownership of r.
+func WithRand(r *rand.Rand) Option {
+	return func(c *cluster) { c.rand = r }
+}
+
+// WithInsertOnly disables the delete set, for append-only workloads which
+// never delete. Inserts don't check or update the deletes key, and Score
+// doesn't look it up, which roughly halves the Redis work per member. Delete
+// returns ErrInsertOnly.
+//
+// Members already in the delete set are ignored, so don't enable insert-only
+// mode on a cluster which has received deletes.
+func WithInsertOnly() Option {
+	return func(c *cluster) { c.insertOnly = true }
+}
+
+// WithTombstoneGrace bounds the growth of delete sets: every write which
+// wins trims the tombstones of its key with scores more than grace below
+// its own score. By default, tombstones are kept until maxSize trims them.
+// grace is in score units, e.g. 86400 for a day with scores which are Unix
+// timestamps in seconds. Use TrimTombstones to trim keys which aren't
+// written anymore.
+//
+// Tombstones exist so that an insert which arrives after a delete with a
+// higher score, e.g. a retry, a replayed write, or a read repair from a
+// lagging cluster, can't resurrect the deleted member. Once a tombstone is
+// trimmed, such an insert succeeds, so this trades that guarantee for memory
+// on keys with many deletes. It's only safe if no write arrives with a
+// score more than grace below the newest one of its key, so grace must
+// exceed the longest delay of retries, replication, and walkers. Every
+// process writing to the cluster should use the same grace; inserts through
+// processes without it merely keep tombstones for longer.
+func WithTombstoneGrace(grace float64) Option {
+	return func(c *cluster) { c.tombstoneGrace = grace }
+}
+
+// ErrInsertOnly is returned by Delete on an insert-only cluster.
+var ErrInsertOnly = errors.New("cluster is insert-only; deletes are disabled")
+
+// DefaultMaxScoreKeyMembers is the default maximum number of keyMembers in a
+// single Score call. It accommodates repairing a batch of 100 keys of 10000
+// members each, as when the walker repopulates an empty instance. See
+// WithMaxScoreKeyMembers.
+const DefaultMaxScoreKeyMembers = 1000000
+
+// WithMaxScoreKeyMembers limits the number of keyMembers in a single Score
+// call. Calls with more keyMembers are rejected with a
+// TooManyKeyMembersError, without contacting Redis. The default is
+// DefaultMaxScoreKeyMembers. A non-positive n removes the limit.
+//
+// Independent of the limit, Score looks up keyMembers in pipelines of a
+// bounded size.
+func WithMaxScoreKeyMembers(n int) Option {
+	return func(c *cluster) { c.maxScoreSize = n }
+}
+
+// TooManyKeyMembersError is returned by Score when a call contains more
+// keyMembers than permitted.
+type TooManyKeyMembersError struct {
+	KeyMembers, Max int
+}
+
+func (e TooManyKeyMembersError) Error() string {
+	return fmt.Sprintf("too many key-members in score (%d, max %d)", e.KeyMembers, e.Max)
+}
+
+// DefaultRangeAttempts is the number of attempts of SelectRange per key,
+// unless changed with WithRangeAttempts.
+const DefaultRangeAttempts = 4
+
+// WithRangeAttempts sets how many times SelectRange reads a key, with a
+// growing limit, to skip the members at the score of the start cursor which
+// precede it. A key which still hasn't yielded enough members after n
+// attempts, e.g. because millions of members share that score, fails with a
+// RangeAttemptsError in its Element, without failing the other keys. The
+// default is DefaultRangeAttempts, and n is at least 1.
+func WithRangeAttempts(n int) Option {
+	if n < 1 {
+		n = 1
+	}
+	return func(c *cluster) { c.rangeAttempts = n }
+}
+
+// RangeAttemptsError is the Error of the Element of a key which didn't yield
+// enough members within the attempts of SelectRange.
+type RangeAttemptsError struct {
+	Limit, Attempts int
+}
+
+func (e RangeAttemptsError) Error() string {
+	return fmt.Sprintf("failed to yield enough elements in %d attempt(s) (original limit %d)", e.Attempts, e.Limit)
 }
 
 // Insert efficiently performs ZADDs for each of the passed tuples.
 func (c *cluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
+	return c.InsertContext(context.Background(), keyScoreMembers)
+}
+
+// InsertContext implements the ContextInserter interface. Instances which
+// haven't been contacted when ctx is done are skipped, and connections still
+// waiting for replies are closed.
+func (c *cluster) InsertContext(ctx context.Context, keyScoreMembers []common.KeyScoreMember) error {
+	if err := c.checkMemberSizes(keyScoreMembers, c.instrumentation.InsertMemberTooLarge); err != nil {
+		return err
+	}
+
 	// Bucketize
 	m := map[int][]common.KeyScoreMember{}
 	for _, tuple := range keyScoreMembers {
@@ -161,11 +615,18 @@ func (c *cluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
 	errChan := make(chan error, len(m))
 	for index, keyScoreMembers := range m {
 		go func(index int, keyScoreMembers []common.KeyScoreMember) {
-
+			if err := ctx.Err(); err != nil {
+				errChan <- err
+				return
+			}
 			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
-				return pipelineInsert(conn, keyScoreMembers, c.maxSize)
+				defer closeOnDone(ctx, conn)()
+				err := pipelineInsert(conn, c.insertScript(), keyScoreMembers, c.maxSizeFor, c.trimPolicy, c.members, c.tombstoneGrace)
+				if err != nil && ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return err
 			})
-
 		}(index, keyScoreMembers)
 	}
 
@@ -178,26 +639,238 @@ func (c *cluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
 	return nil
 }
 
-// SelectOffset efficiently performs ZREVRANGEs for each of the passed keys
-// using the offset and limit for each. It pushes results to the returned chan
-// as they become available.
-func (c *cluster) SelectOffset(keys []string, offset, limit int) <-chan Element {
-	return c.selectCommon(keys, func(conn redis.Conn, myKeys []string) (map[string][]common.KeyScoreMember, error) {
-		return pipelineRange(conn, myKeys, offset, limit)
+// closeOnDone closes conn if ctx is done before the returned function is
+// called, which aborts any pending reads and writes, and returns only once
+// it's certain not to close conn anymore.
+func closeOnDone(ctx context.Context, conn redis.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {} // never done
+	}
+	var (
+		stop     = make(chan struct{})
+		finished = make(chan struct{})
+	)
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-finished
+	}
+}
+
+// InsertReporting implements the InsertReporter interface. It's as cheap as
+// Insert: the insert script reports the resulting state of each member.
+func (c *cluster) InsertReporting(keyScoreMembers []common.KeyScoreMember) (map[common.KeyMember]Presence, error) {
+	if err := c.checkMemberSizes(keyScoreMembers, c.instrumentation.InsertMemberTooLarge); err != nil {
+		return map[common.KeyMember]Presence{}, err
+	}
+	script := c.scripts.insertReporting
+	if c.insertOnly {
+		script = c.scripts.insertOnlyReporting
+	}
+
+	// Bucketize
+	m := map[int][]common.KeyScoreMember{}
+	for _, tuple := range keyScoreMembers {
+		index := c.pool.Index(tuple.Key)
+		m[index] = append(m[index], tuple)
+	}
+
+	// Scatter
+	type response struct {
+		presence map[common.KeyMember]Presence
+		err      error
+	}
+	responseChan := make(chan response, len(m))
+	for index, keyScoreMembers := range m {
+		go func(index int, keyScoreMembers []common.KeyScoreMember) {
+			presence := map[common.KeyMember]Presence{}
+			err := c.pool.WithIndex(index, func(conn redis.Conn) error {
+				return pipelineInsertReporting(conn, script, keyScoreMembers, c.maxSizeFor, c.trimPolicy, c.members, c.tombstoneGrace, presence)
+			})
+			responseChan <- response{presence, err}
+		}(index, keyScoreMembers)
+	}
+
+	// Gather
+	presence := make(map[common.KeyMember]Presence, len(keyScoreMembers))
+	for i := 0; i < cap(responseChan); i++ {
+		response := <-responseChan
+		if response.err != nil {
+			return map[common.KeyMember]Presence{}, response.err
+		}
+		for keyMember, p := range response.presence {
+			presence[keyMember] = p
+		}
+	}
+	return presence, nil
+}
+
+// SelectOffset efficiently performs ZREVRANGEs, or ZRANGEs for ascending
+// order, for each of the passed keys using the offset and limit for each. It
+// pushes results to the returned chan as they become available.
+func (c *cluster) SelectOffset(keys []string, offset, limit int, order common.Order) <-chan Element {
+	read := func(offset, limit int) func(redis.Conn, []string) ([]Element, error) {
+		return func(conn redis.Conn, myKeys []string) ([]Element, error) {
+			result, err := pipelineRange(conn, myKeys, offset, limit, order, math.Inf(-1))
+			return successElements(result), err
+		}
+	}
+	return c.selectMigrating(keys, read(offset, limit), read(0, offset+limit), order, func(a []common.KeyScoreMember) []common.KeyScoreMember {
+		return window(a, offset, limit)
+	})
+}
+
+// SelectTop implements TopSelecter. It's SelectOffset with offset 0, limit
+// 1, and descending order, i.e. a pipelined ZREVRANGE key 0 0 per key. It
+// fails if any key fails.
+func (c *cluster) SelectTop(keys []string) (map[string]common.KeyScoreMember, error) {
+	top := make(map[string]common.KeyScoreMember, len(keys))
+	var err error
+	for e := range c.SelectOffset(keys, 0, 1, common.Descending) {
+		if e.Error != nil {
+			err = e.Error // drain the rest
+			continue
+		}
+		if len(e.KeyScoreMembers) > 0 {
+			top[e.Key] = e.KeyScoreMembers[0]
+		}
+	}
+	if err != nil {
+		return map[string]common.KeyScoreMember{}, err
+	}
+	return top, nil
+}
+
+// SelectOffsetFloor implements FloorSelecter. It performs
+// ZREVRANGEBYSCOREs from +inf down to minScore, with the offset and limit as
+// their LIMIT, so that members below minScore aren't transferred at all.
+func (c *cluster) SelectOffsetFloor(keys []string, offset, limit int, minScore float64) <-chan Element {
+	read := func(offset, limit int) func(redis.Conn, []string) ([]Element, error) {
+		return func(conn redis.Conn, myKeys []string) ([]Element, error) {
+			result, err := pipelineRange(conn, myKeys, offset, limit, common.Descending, minScore)
+			return successElements(result), err
+		}
+	}
+	return c.selectMigrating(keys, read(offset, limit), read(0, offset+limit), common.Descending, func(a []common.KeyScoreMember) []common.KeyScoreMember {
+		return window(a, offset, limit)
 	})
 }
 
 // SelectRange uses ZREVRANGEBYSCORE to do a cursor-based select, similar to
 // SelectOffset.
 func (c *cluster) SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan Element {
-	return c.selectCommon(keys, func(conn redis.Conn, myKeys []string) (map[string][]common.KeyScoreMember, error) {
-		return pipelineRangeByScore(conn, myKeys, start, stop, limit)
+	return c.SelectRangeOffset(keys, start, stop, 0, limit)
+}
+
+// SelectRangeOffset implements RangeOffsetSelecter. A score-only start
+// cursor passes the offset to ZREVRANGEBYSCORE as the offset of its LIMIT,
+// otherwise the offset is skipped after the members at the start score
+// which aren't past the cursor.
+func (c *cluster) SelectRangeOffset(keys []string, start, stop common.Cursor, offset, limit int) <-chan Element {
+	start, stop = c.members.encodeCursor(start), c.members.encodeCursor(stop)
+	read := func(offset, limit int) func(redis.Conn, []string) ([]Element, error) {
+		return func(conn redis.Conn, myKeys []string) ([]Element, error) {
+			return pipelineRangeByScore(conn, myKeys, start, stop, offset, limit, c.rangeAttempts, c.instrumentation)
+		}
+	}
+	return c.selectMigrating(keys, read(offset, limit), read(0, offset+limit), common.Descending, func(a []common.KeyScoreMember) []common.KeyScoreMember {
+		return window(a, offset, limit)
 	})
 }
 
+// SelectAll implements AllSelecter. It pages through the key with
+// SelectRange, and sends every page as soon as it's read, so memory is
+// bounded by the window rather than by the size of the key.
+//
+// The pages aren't a snapshot of the key. Every page starts after the last
+// member of the previous page, so members which aren't written to during the
+// scan are sent exactly once. Members which are written to during the scan
+// may be sent with their old or their new score, twice, or not at all.
+func (c *cluster) SelectAll(ctx context.Context, key string, window int) <-chan Element {
+	ch := make(chan Element)
+	go func() {
+		defer close(ch)
+		if window <= 0 {
+			ch <- Element{Key: key, Error: fmt.Errorf("invalid window %d", window)}
+			return
+		}
+		start, stop := common.Cursor{Score: math.Inf(1)}, common.Cursor{Score: math.Inf(-1)}
+		for {
+			var page Element
+			for e := range c.SelectRange([]string{key}, start, stop, window) {
+				page = e
+			}
+			if page.Error == nil && len(page.KeyScoreMembers) <= 0 {
+				return // done
+			}
+			select {
+			case ch <- page:
+			case <-ctx.Done():
+				return
+			}
+			if page.Error != nil || len(page.KeyScoreMembers) < window {
+				return
+			}
+			start = page.KeyScoreMembers[len(page.KeyScoreMembers)-1].Cursor()
+		}
+	}()
+	return ch
+}
+
+// CountRange uses ZCOUNT to count the members between the cursors, without
+// transferring them. Members with the same score as either cursor are
+// fetched, to compare them by member like SelectRange.
+func (c *cluster) CountRange(keys []string, start, stop common.Cursor) (map[string]int, error) {
+	start, stop = c.members.encodeCursor(start), c.members.encodeCursor(stop)
+
+	// Bucketize
+	m := map[int][]string{}
+	for _, key := range keys {
+		index := c.pool.Index(key)
+		m[index] = append(m[index], key)
+	}
+
+	// Scatter
+	type response struct {
+		counts map[string]int
+		err    error
+	}
+	responseChan := make(chan response, len(m))
+	for index, keys := range m {
+		go func(index int, keys []string) {
+			var counts map[string]int
+			err := c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
+				counts, err = pipelineCountRange(conn, keys, start, stop)
+				return
+			})
+			responseChan <- response{counts, err}
+		}(index, keys)
+	}
+
+	// Gather
+	counts := make(map[string]int, len(keys))
+	for i := 0; i < cap(responseChan); i++ {
+		response := <-responseChan
+		if response.err != nil {
+			return map[string]int{}, response.err
+		}
+		for key, n := range response.counts {
+			counts[key] = n
+		}
+	}
+	return counts, nil
+}
+
 func (c *cluster) selectCommon(
 	keys []string,
-	fn func(redis.Conn, []string) (map[string][]common.KeyScoreMember, error),
+	fn func(redis.Conn, []string) ([]Element, error),
 ) <-chan Element {
 	out := make(chan Element)
 	go func() {
@@ -212,7 +885,10 @@ func (c *cluster) selectCommon(
 		// can be an error element. Client does the gathering.
 		wg := sync.WaitGroup{}
 		wg.Add(len(m))
-		delay := time.Duration(0)
+		var (
+			delay     = time.Duration(0)
+			selectGap = c.Tuning().SelectGap
+		)
 		for index, keys := range m {
 			go func(index int, keys []string, delay time.Duration) {
 				defer wg.Done()
@@ -221,21 +897,18 @@ func (c *cluster) selectCommon(
 				// Make channel sends outside of this function, to
 				// minimize our time with the redis.Conn.
 				var elements []Element
-				var result map[string][]common.KeyScoreMember
 				if err := c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
-					result, err = fn(conn, keys)
+					elements, err = fn(conn, keys)
 					return
 				}); err != nil {
 					elements = errorElements(keys, err)
-				} else {
-					elements = successElements(result)
 				}
 
 				for _, element := range elements {
 					out <- element
 				}
 			}(index, keys, delay)
-			delay += c.selectGap
+			delay += selectGap
 		}
 		wg.Wait()
 
@@ -247,6 +920,13 @@ func (c *cluster) selectCommon(
 
 // Delete efficiently performs ZREMs for each of the passed tuples.
 func (c *cluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
+	if c.insertOnly {
+		return ErrInsertOnly
+	}
+	if err := c.checkMemberSizes(keyScoreMembers, c.instrumentation.DeleteMemberTooLarge); err != nil {
+		return err
+	}
+
 	// Bucketize
 	m := map[int][]common.KeyScoreMember{}
 	for _, keyScoreMember := range keyScoreMembers {
@@ -259,7 +939,7 @@ func (c *cluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
 	for index, keyScoreMembers := range m {
 		go func(index int, keyScoreMembers []common.KeyScoreMember) {
 			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
-				return pipelineDelete(conn, keyScoreMembers, c.maxSize)
+				return pipelineDelete(conn, c.scripts.delete, keyScoreMembers, c.maxSizeFor, c.trimPolicy, c.members, c.tombstoneGrace)
 			})
 
 		}(index, keyScoreMembers)
@@ -278,6 +958,16 @@ func (c *cluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
 // That is, whether the key-member exists in this cluster, if it's in
 // an insert set, and its score.
 func (c *cluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]Presence, error) {
+	if c.maxScoreSize > 0 && len(keyMembers) > c.maxScoreSize {
+		return map[common.KeyMember]Presence{}, TooManyKeyMembersError{KeyMembers: len(keyMembers), Max: c.maxScoreSize}
+	}
+
+	// Look up both forms of members which may be stored compressed.
+	var forms map[common.KeyMember][]common.KeyMember
+	if c.members.enabled() {
+		keyMembers, forms = c.members.scoreForms(keyMembers)
+	}
+
 	// Bucketize
 	m := map[int][]common.KeyMember{}
 	for _, keyMember := range keyMembers {
@@ -285,50 +975,685 @@ func (c *cluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]Pre
 		m[index] = append(m[index], keyMember)
 	}
 
-	// Scatter
-	type response struct {
-		presenceMap map[common.KeyMember]Presence
-		err         error
+	// Scatter
+	type response struct {
+		presenceMap map[common.KeyMember]Presence
+		err         error
+	}
+	responseChan := make(chan response, len(m))
+	for index, keyMembers := range m {
+		go func(index int, keyMembers []common.KeyMember) {
+			var presenceMap map[common.KeyMember]Presence
+			err := c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
+				presenceMap, err = c.score(conn, keyMembers)
+				return
+			})
+			if err != nil {
+				log.Printf("cluster: Score: %q: %s", c.pool.ID(index), err)
+			}
+			responseChan <- response{presenceMap, err}
+		}(index, keyMembers)
+	}
+
+	// Gather
+	presenceMap := map[common.KeyMember]Presence{}
+	for i := 0; i < cap(responseChan); i++ {
+		response := <-responseChan
+		if response.err != nil {
+			continue
+		}
+		for keyMember, presence := range response.presenceMap {
+			presenceMap[keyMember] = presence
+		}
+	}
+	if forms != nil {
+		return mergeForms(forms, presenceMap), nil
+	}
+	return presenceMap, nil
+}
+
+// Tombstoned implements the Tombstoner interface, with a ZCARD of the
+// deletes key of each key. If any instance fails, Tombstoned returns an
+// error.
+func (c *cluster) Tombstoned(keys []string) (map[string]bool, error) {
+	// Bucketize
+	m := map[int][]string{}
+	for _, key := range keys {
+		index := c.pool.Index(key)
+		m[index] = append(m[index], key)
+	}
+
+	// Scatter
+	type response struct {
+		tombstoned map[string]bool
+		err        error
+	}
+	responseChan := make(chan response, len(m))
+	for index, keys := range m {
+		go func(index int, keys []string) {
+			var tombstoned map[string]bool
+			err := c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
+				tombstoned, err = pipelineTombstoned(conn, keys)
+				return
+			})
+			responseChan <- response{tombstoned, err}
+		}(index, keys)
+	}
+
+	// Gather
+	var (
+		tombstoned = make(map[string]bool, len(keys))
+		firstErr   error
+	)
+	for i := 0; i < cap(responseChan); i++ {
+		response := <-responseChan
+		if response.err != nil {
+			if firstErr == nil {
+				firstErr = response.err
+			}
+			continue
+		}
+		for key, ok := range response.tombstoned {
+			tombstoned[key] = ok
+		}
+	}
+	if firstErr != nil {
+		return map[string]bool{}, firstErr
+	}
+	return tombstoned, nil
+}
+
+func pipelineTombstoned(conn redis.Conn, keys []string) (map[string]bool, error) {
+	for _, key := range keys {
+		if err := conn.Send("ZCARD", key+deleteSuffix); err != nil {
+			return map[string]bool{}, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return map[string]bool{}, err
+	}
+
+	tombstoned := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		n, err := redis.Int(conn.Receive())
+		if err != nil {
+			return map[string]bool{}, err
+		}
+		tombstoned[key] = n > 0
+	}
+	return tombstoned, nil
+}
+
+// Expire implements the Expirer interface, with the Redis PEXPIRE command.
+func (c *cluster) Expire(keys []string, ttl time.Duration) error {
+	// Bucketize
+	m := map[int][]string{}
+	for _, key := range keys {
+		index := c.pool.Index(key)
+		m[index] = append(m[index], key)
+	}
+
+	// Scatter
+	errChan := make(chan error, len(m))
+	for index, keys := range m {
+		go func(index int, keys []string) {
+			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
+				return pipelineExpire(conn, keys, ttl)
+			})
+		}(index, keys)
+	}
+
+	// Gather
+	var firstErr error
+	for i := 0; i < cap(errChan); i++ {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func pipelineExpire(conn redis.Conn, keys []string, ttl time.Duration) error {
+	ms := int64(ttl / time.Millisecond)
+	for _, key := range keys {
+		for _, suffix := range []string{insertSuffix, deleteSuffix} {
+			if err := conn.Send("PEXPIRE", key+suffix, ms); err != nil {
+				return err
+			}
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for i := 0; i < 2*len(keys); i++ {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrimTombstones implements the TombstoneTrimmer interface.
+func (c *cluster) TrimTombstones(keys []string, grace float64) error {
+	// Bucketize
+	m := map[int][]string{}
+	for _, key := range keys {
+		index := c.pool.Index(key)
+		m[index] = append(m[index], key)
+	}
+
+	// Scatter
+	errChan := make(chan error, len(m))
+	for index, keys := range m {
+		go func(index int, keys []string) {
+			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
+				return pipelineTrimTombstones(conn, keys, grace)
+			})
+		}(index, keys)
+	}
+
+	// Gather
+	var firstErr error
+	for i := 0; i < cap(errChan); i++ {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func pipelineTrimTombstones(conn redis.Conn, keys []string, grace float64) error {
+	for _, key := range keys {
+		if err := trimTombstonesScript.Send(conn, key, grace); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for range keys {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrimTombstonesBefore implements the TombstoneScoreTrimmer interface, with
+// the Redis ZREMRANGEBYSCORE command.
+func (c *cluster) TrimTombstonesBefore(keys []string, maxScore float64) error {
+	// Bucketize
+	m := map[int][]string{}
+	for _, key := range keys {
+		index := c.pool.Index(key)
+		m[index] = append(m[index], key)
+	}
+
+	// Scatter
+	errChan := make(chan error, len(m))
+	for index, keys := range m {
+		go func(index int, keys []string) {
+			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
+				return pipelineTrimTombstonesBefore(conn, keys, maxScore)
+			})
+		}(index, keys)
+	}
+
+	// Gather
+	var firstErr error
+	for i := 0; i < cap(errChan); i++ {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func pipelineTrimTombstonesBefore(conn redis.Conn, keys []string, maxScore float64) error {
+	max := "(" + fmt.Sprint(maxScore) // exclusive
+	for _, key := range keys {
+		if err := conn.Send("ZREMRANGEBYSCORE", key+deleteSuffix, "-inf", max); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for range keys {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cardinalities implements the Cardinalizer interface, with a ZCARD of both
+// sets of each key. If any instance fails, Cardinalities returns an error.
+func (c *cluster) Cardinalities(keys []string) (map[string]Cardinality, error) {
+	// Bucketize
+	m := map[int][]string{}
+	for _, key := range keys {
+		index := c.pool.Index(key)
+		m[index] = append(m[index], key)
+	}
+
+	// Scatter
+	type response struct {
+		cardinalities map[string]Cardinality
+		err           error
+	}
+	responseChan := make(chan response, len(m))
+	for index, keys := range m {
+		go func(index int, keys []string) {
+			var cardinalities map[string]Cardinality
+			err := c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
+				cardinalities, err = pipelineCardinalities(conn, keys)
+				return
+			})
+			responseChan <- response{cardinalities, err}
+		}(index, keys)
+	}
+
+	// Gather
+	var (
+		cardinalities = make(map[string]Cardinality, len(keys))
+		firstErr      error
+	)
+	for i := 0; i < cap(responseChan); i++ {
+		response := <-responseChan
+		if response.err != nil {
+			if firstErr == nil {
+				firstErr = response.err
+			}
+			continue
+		}
+		for key, cardinality := range response.cardinalities {
+			cardinalities[key] = cardinality
+		}
+	}
+	if firstErr != nil {
+		return map[string]Cardinality{}, firstErr
+	}
+	return cardinalities, nil
+}
+
+func pipelineCardinalities(conn redis.Conn, keys []string) (map[string]Cardinality, error) {
+	for _, key := range keys {
+		for _, suffix := range []string{insertSuffix, deleteSuffix} {
+			if err := conn.Send("ZCARD", key+suffix); err != nil {
+				return map[string]Cardinality{}, err
+			}
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return map[string]Cardinality{}, err
+	}
+
+	cardinalities := make(map[string]Cardinality, len(keys))
+	for _, key := range keys {
+		inserts, err := redis.Int(conn.Receive())
+		if err != nil {
+			return map[string]Cardinality{}, err
+		}
+		deletes, err := redis.Int(conn.Receive())
+		if err != nil {
+			return map[string]Cardinality{}, err
+		}
+		cardinalities[key] = Cardinality{Inserts: inserts, Deletes: deletes}
+	}
+	return cardinalities, nil
+}
+
+// CopyKey implements the KeyCopier interface. Members are merged like
+// writes of DefaultScript, regardless of WithScript: a member of src loses
+// to a higher score in dst, and an insert loses to a delete with the same
+// score, so copying is idempotent, and commutes with writes to dst. Both
+// sets of dst are trimmed to its maxSize afterwards. Members are copied as
+// stored, i.e. compressed members stay compressed, see
+// WithMemberCompression.
+//
+// If src and dst map to the same instance, the copy is a single script, and
+// atomic. Otherwise, both sets of src are read from its instance first, and
+// then merged into dst on its instance in batches of CopyKeyBatchSize
+// members. Such a copy isn't atomic: writes to src after the read are
+// missed, and if it fails halfway, dst is left with part of src. Retrying it
+// is safe. It also holds all of src in memory, so it's expensive for large
+// uncapped keys.
+func (c *cluster) CopyKey(src, dst string) error {
+	if src == dst {
+		return nil
+	}
+	var (
+		maxSize  = c.maxSizeFor(dst)
+		policy   = c.trimPolicy.scriptArg()
+		srcIndex = c.pool.Index(src)
+		dstIndex = c.pool.Index(dst)
+	)
+	if srcIndex == dstIndex {
+		return c.pool.WithIndex(dstIndex, func(conn redis.Conn) error {
+			_, err := copyKeyScript.Do(conn, src, dst, maxSize, policy)
+			return err
+		})
+	}
+
+	var members []interface{} // suffix, score, member triples
+	if err := c.pool.WithIndex(srcIndex, func(conn redis.Conn) error {
+		sets, err := redis.Values(readKeyScript.Do(conn, src))
+		if err != nil {
+			return err
+		}
+		if len(sets) != 2 {
+			return fmt.Errorf("unexpected reply reading %q: %v", src, sets)
+		}
+		for i, suffix := range []string{insertSuffix, deleteSuffix} {
+			values, err := redis.Strings(sets[i], nil)
+			if err != nil {
+				return err
+			}
+			for j := 0; j+1 < len(values); j += 2 {
+				members = append(members, suffix, values[j+1], values[j])
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return c.pool.WithIndex(dstIndex, func(conn redis.Conn) error {
+		return pipelineMergeKey(conn, dst, members, maxSize, policy)
+	})
+}
+
+// CopyKeyBatchSize is the number of members which CopyKey merges into dst
+// per script, if src and dst map to different instances.
+const CopyKeyBatchSize = 1000
+
+func pipelineMergeKey(conn redis.Conn, dst string, members []interface{}, maxSize int, policy string) error {
+	batches := 0
+	for i := 0; i == 0 || i < len(members); i += 3 * CopyKeyBatchSize {
+		j := i + 3*CopyKeyBatchSize
+		if j > len(members) {
+			j = len(members)
+		}
+		args := append([]interface{}{dst, maxSize, policy}, members[i:j]...)
+		if err := mergeKeyScript.Send(conn, args...); err != nil {
+			return err
+		}
+		batches++
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for i := 0; i < batches; i++ {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Presence represents the state of a given key-member in a cluster.
+type Presence struct {
+	Present  bool
+	Inserted bool // false = deleted
+	Score    float64
+}
+
+// Cursor returns the cursor of the member at its score, which is keyed by
+// its KeyMember in Score results. A SelectRange starting at the cursor
+// continues with the members older than this one. ok is false if the member
+// isn't present.
+func (p Presence) Cursor(member string) (cursor common.Cursor, ok bool) {
+	if !p.Present {
+		return common.Cursor{}, false
+	}
+	return common.KeyScoreMember{Score: p.Score, Member: member}.Cursor(), true
+}
+
+// ClockOffsets implements the ClockReader interface, with the Redis TIME
+// command. Unreachable instances are logged and omitted.
+func (c *cluster) ClockOffsets() map[string]time.Duration {
+	offsets := make(map[string]time.Duration, c.pool.Size())
+	for index := 0; index < c.pool.Size(); index++ {
+		if err := c.pool.WithIndex(index, func(conn redis.Conn) error {
+			began := time.Now()
+			values, err := redis.Int64s(conn.Do("TIME"))
+			if err != nil {
+				return err
+			}
+			if n := len(values); n != 2 {
+				return fmt.Errorf("received %d values from Redis, expected exactly 2", n)
+			}
+			var (
+				elapsed = time.Since(began)
+				local   = began.Add(elapsed / 2)
+				remote  = time.Unix(values[0], values[1]*int64(time.Microsecond))
+			)
+			offsets[c.pool.ID(index)] = remote.Sub(local)
+			return nil
+		}); err != nil {
+			log.Printf("cluster: ClockOffsets: %q: %s", c.pool.ID(index), err)
+		}
+	}
+	return offsets
+}
+
+// Ping implements the Pinger interface. Instances are pinged concurrently,
+// over connections which are separate from the ones used for requests.
+func (c *cluster) Ping() map[string]error {
+	type result struct {
+		id  string
+		err error
+	}
+	results := make(chan result, c.pool.Size())
+	for index := 0; index < c.pool.Size(); index++ {
+		go func(index int) {
+			results <- result{c.pool.ID(index), c.pool.Ping(index)}
+		}(index)
+	}
+
+	m := make(map[string]error, c.pool.Size())
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		m[r.id] = r.err
+	}
+	return m
+}
+
+// Info implements the InfoReader interface, with the Redis INFO command.
+// Instances are asked concurrently. Instances which are down according to
+// Ping fail right away, so that Info doesn't wait for them.
+func (c *cluster) Info() map[string]InstanceInfo {
+	type result struct {
+		id   string
+		info InstanceInfo
+	}
+	results := make(chan result, c.pool.Size())
+	for index := 0; index < c.pool.Size(); index++ {
+		go func(index int) {
+			info, err := c.pool.Info(index)
+			results <- result{c.pool.ID(index), InstanceInfo{Info: info, Error: err}}
+		}(index)
+	}
+
+	m := make(map[string]InstanceInfo, c.pool.Size())
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		m[r.id] = r.info
+	}
+	return m
+}
+
+// Warm implements Warmer. It fills the connection pool of every instance,
+// see pool.Warm, and loads the write scripts, so that the first writes pay
+// neither for dialing nor for compiling the scripts.
+func (c *cluster) Warm() map[string]error {
+	type result struct {
+		id  string
+		err error
+	}
+	results := make(chan result, c.pool.Size())
+	for index := 0; index < c.pool.Size(); index++ {
+		go func(index int) {
+			err := c.pool.Warm(index)
+			if err == nil {
+				err = c.pool.WithIndex(index, func(conn redis.Conn) error {
+					for _, script := range c.scripts.all() {
+						if err := script.Load(conn); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			}
+			results <- result{c.pool.ID(index), err}
+		}(index)
+	}
+
+	m := make(map[string]error, c.pool.Size())
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		m[r.id] = r.err
 	}
-	responseChan := make(chan response, len(m))
-	for index, keyMembers := range m {
-		go func(index int, keyMembers []common.KeyMember) {
-			var presenceMap map[common.KeyMember]Presence
-			err := c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
-				presenceMap, err = pipelineScore(conn, keyMembers)
-				return
-			})
+	return m
+}
+
+// EstimateKeys implements KeyEstimator. It counts the keys with the insert
+// suffix among the first sampleSize keys returned by SCAN, and extrapolates
+// their share to DBSIZE, which counts every key of the instance, including
+// delete sets and keys which aren't roshi's. SCAN returns keys in hash table
+// order, which is close enough to random for an order of magnitude, but the
+// result is an estimate, not a count, unless the sample covered the whole
+// instance. Instances are asked concurrently. Instances which are down
+// according to Ping fail right away.
+func (c *cluster) EstimateKeys(sampleSize int) map[string]KeyEstimate {
+	type result struct {
+		id       string
+		estimate KeyEstimate
+	}
+	results := make(chan result, c.pool.Size())
+	for index := 0; index < c.pool.Size(); index++ {
+		go func(index int) {
+			estimate, err := c.estimateKeys(index, sampleSize)
 			if err != nil {
-				log.Printf("cluster: Score: %q: %s", c.pool.ID(index), err)
+				estimate = KeyEstimate{Error: err}
 			}
-			responseChan <- response{presenceMap, err}
-		}(index, keyMembers)
+			results <- result{c.pool.ID(index), estimate}
+		}(index)
 	}
 
-	// Gather
-	presenceMap := map[common.KeyMember]Presence{}
-	for i := 0; i < cap(responseChan); i++ {
-		response := <-responseChan
-		if response.err != nil {
-			continue
+	m := make(map[string]KeyEstimate, c.pool.Size())
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		m[r.id] = r.estimate
+	}
+	return m
+}
+
+// maxEstimateScanCount bounds the COUNT of the SCANs of EstimateKeys, so
+// that large samples don't block an instance for long.
+const maxEstimateScanCount = 1000
+
+func (c *cluster) estimateKeys(index, sampleSize int) (KeyEstimate, error) {
+	if c.pool.Down(index) {
+		return KeyEstimate{}, pool.ErrDown
+	}
+	var estimate KeyEstimate
+	err := c.pool.WithIndex(index, func(conn redis.Conn) error {
+		dbSize, err := redis.Int64(conn.Do("DBSIZE"))
+		if err != nil {
+			return err
 		}
-		for keyMember, presence := range response.presenceMap {
-			presenceMap[keyMember] = presence
+
+		// A plain SCAN, not c.scan: the sample must come from the same keys
+		// as DBSIZE, including the ones which aren't ZSETs.
+		var inserts int64
+		cursor := 0
+		for estimate.Sampled < sampleSize {
+			count := sampleSize - estimate.Sampled
+			if count > maxEstimateScanCount {
+				count = maxEstimateScanCount
+			}
+			values, err := redis.Values(conn.Do("SCAN", cursor, "COUNT", fmt.Sprint(count)))
+			if err != nil {
+				return err
+			}
+			newCursor, keys, err := parseScan(values)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if strings.HasSuffix(key, insertSuffix) {
+					inserts++
+				}
+			}
+			estimate.Sampled += len(keys)
+			if cursor = newCursor; cursor == 0 {
+				estimate.Exact = true
+				break
+			}
 		}
-	}
-	return presenceMap, nil
+
+		estimate.DBSize = dbSize
+		switch {
+		case estimate.Exact:
+			estimate.Keys = inserts
+		case estimate.Sampled > 0:
+			estimate.Keys = int64(float64(dbSize)*float64(inserts)/float64(estimate.Sampled) + 0.5)
+		}
+		return nil
+	})
+	return estimate, err
 }
 
-// Presence represents the state of a given key-member in a cluster.
-type Presence struct {
-	Present  bool
-	Inserted bool // false = deleted
-	Score    float64
+// Reachable implements the HealthReporter interface, with the results of
+// Ping.
+func (c *cluster) Reachable(keys []string) bool {
+	for _, key := range keys {
+		if c.pool.Down(c.pool.Index(key)) {
+			return false
+		}
+	}
+	return true
 }
 
 // Keys implements the Scanner interface.
 func (c *cluster) Keys(batchSize int) <-chan []string {
 	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		for batch := range c.KeyBatches(batchSize) {
+			if len(batch.Keys) > 0 {
+				ch <- batch.Keys
+			}
+		}
+	}()
+	return ch
+}
+
+// KeyBatches implements the BatchScanner interface.
+func (c *cluster) KeyBatches(batchSize int) <-chan KeyBatch {
+	return c.keyBatches(batchSize, insertSuffix, nil)
+}
+
+// DeleteOnlyKeys implements the DeleteScanner interface.
+func (c *cluster) DeleteOnlyKeys(batchSize int) <-chan []string {
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		for batch := range c.keyBatches(batchSize, deleteSuffix, withoutInserts) {
+			if len(batch.Keys) > 0 {
+				ch <- batch.Keys
+			}
+		}
+	}()
+	return ch
+}
+
+// keyBatches scans the keyspace one instance at a time, and emits the keys
+// with the given suffix, stripped of it. If filter isn't nil, only the keys
+// it returns for every page of SCAN results are emitted.
+func (c *cluster) keyBatches(batchSize int, suffix string, filter func(redis.Conn, []string) ([]string, error)) <-chan KeyBatch {
+	ch := make(chan KeyBatch)
 	go func() {
 		defer close(ch)
 
@@ -341,69 +1666,142 @@ func (c *cluster) Keys(batchSize int) <-chan []string {
 			}
 		}()
 
-		for _, index := range rand.Perm(c.pool.Size()) {
-			log.Printf("cluster: scanning keyspace of %q (batch size %d)", c.pool.ID(index), batchSize)
+		c.randMtx.Lock()
+		perm := c.rand.Perm(c.pool.Size())
+		c.randMtx.Unlock()
+
+		for _, index := range perm {
+			id := c.pool.ID(index)
+			log.Printf("cluster: scanning keyspace of %q (batch size %d)", id, batchSize)
 			cursor := 0
 			batch := make([]string, 0, batchSize)
 			for {
 				if err := c.pool.WithIndex(index, func(conn redis.Conn) error {
-					values, err := redis.Values(conn.Do("SCAN", cursor, "COUNT", fmt.Sprint(batchSize)))
+					values, err := c.scan(conn, cursor, batchSize)
 					if err != nil {
 						return err
 					}
 
-					if n := len(values); n != 2 {
-						return fmt.Errorf("received %d values from Redis, expected exactly 2", n)
-					}
-
-					newCursor, err := redis.Int(values[0], nil)
+					newCursor, scanned, err := parseScan(values)
 					if err != nil {
 						return err
 					}
 
-					keys, err := redis.Strings(values[1], nil)
-					if err != nil {
-						return err
+					// Only emit keys with the suffix - but strip the suffix.
+					keys := make([]string, 0, len(scanned))
+					for _, key := range scanned {
+						l := len(key) - len(suffix)
+						if l >= 0 && key[l:] == suffix {
+							keys = append(keys, key[:l])
+						}
+					}
+					if filter != nil && len(keys) > 0 {
+						if keys, err = filter(conn, keys); err != nil {
+							return err
+						}
 					}
 
 					for _, key := range keys {
-						// Only emit keys with insertSuffix - but strip the suffix.
-						l := len(key) - len(insertSuffix)
-						if key[l:] == insertSuffix {
-							batch = append(batch, key[:l])
-							if len(batch) >= batchSize {
-								atomic.AddUint64(&sent, uint64(len(batch)))
-								ch <- batch
-								batch = make([]string, 0, batchSize)
-							}
+						batch = append(batch, key)
+						if len(batch) >= batchSize {
+							atomic.AddUint64(&sent, uint64(len(batch)))
+							ch <- KeyBatch{Instance: id, Keys: batch}
+							batch = make([]string, 0, batchSize)
 						}
 					}
 					cursor = newCursor
 					return nil
 				}); err == nil && cursor == 0 {
-					log.Printf("cluster: Keys on %q is complete", c.pool.ID(index))
+					log.Printf("cluster: Keys on %q is complete", id)
 					break // No error, and cursor back at 0: this instance is done.
 				} else if err != nil {
-					log.Printf("cluster: during Keys on %q: %s", c.pool.ID(index), err)
+					log.Printf("cluster: during Keys on %q: %s", id, err)
 					time.Sleep(1 * time.Second) // and retry
 				}
 			}
-			if len(batch) > 0 {
-				ch <- batch
-			}
+			ch <- KeyBatch{Instance: id, Keys: batch, Complete: true}
 		}
 	}()
 	return ch
 }
 
-func pipelineInsert(conn redis.Conn, keyScoreMembers []common.KeyScoreMember, maxSize int) error {
+// withoutInserts returns the keys which have no insert set. A key's insert
+// and delete sets live on the same instance, so conn can tell.
+func withoutInserts(conn redis.Conn, keys []string) ([]string, error) {
+	for _, key := range keys {
+		if err := conn.Send("EXISTS", key+insertSuffix); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	without := make([]string, 0, len(keys))
+	for _, key := range keys {
+		exists, err := redis.Bool(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			without = append(without, key)
+		}
+	}
+	return without, nil
+}
+
+// scan issues a single SCAN. Only ZSETs can have the suffixes, so
+// scan asks Redis to skip other types of keys, which saves transferring and
+// filtering them when instances are shared. Redis before 6.0 doesn't support
+// the TYPE option, in which case scan falls back to a plain SCAN.
+func (c *cluster) scan(conn redis.Conn, cursor, count int) ([]interface{}, error) {
+	if atomic.LoadInt32(&c.noScanType) == 0 {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "COUNT", fmt.Sprint(count), "TYPE", "zset"))
+		if err == nil || !isSyntaxError(err) {
+			return values, err
+		}
+		log.Printf("cluster: SCAN TYPE unavailable (%s); falling back to SCAN", err)
+		atomic.StoreInt32(&c.noScanType, 1)
+	}
+	return redis.Values(conn.Do("SCAN", cursor, "COUNT", fmt.Sprint(count)))
+}
+
+// parseScan parses the reply of SCAN into the next cursor and the keys.
+func parseScan(values []interface{}) (int, []string, error) {
+	if n := len(values); n != 2 {
+		return 0, nil, fmt.Errorf("received %d values from Redis, expected exactly 2", n)
+	}
+
+	cursor, err := redis.Int(values[0], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	keys, err := redis.Strings(values[1], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	return cursor, keys, nil
+}
+
+func (c *cluster) insertScript() *redis.Script {
+	if c.insertOnly {
+		return c.scripts.insertOnly
+	}
+	return c.scripts.insert
+}
+
+func pipelineInsert(conn redis.Conn, script *redis.Script, keyScoreMembers []common.KeyScoreMember, maxSize func(string) int, trimPolicy TrimPolicy, members memberCodec, tombstoneGrace float64) error {
 	for _, tuple := range keyScoreMembers {
-		if err := insertScript.Send(
+		stored, alias := members.encode(tuple.Member)
+		if err := script.Send(
 			conn,
 			tuple.Key,
 			tuple.Score,
-			tuple.Member,
-			maxSize,
+			stored,
+			maxSize(tuple.Key),
+			trimPolicy.scriptArg(),
+			alias,
+			tombstoneGrace,
 		); err != nil {
 			return err
 		}
@@ -423,6 +1821,46 @@ func pipelineInsert(conn redis.Conn, keyScoreMembers []common.KeyScoreMember, ma
 	return nil
 }
 
+func pipelineInsertReporting(conn redis.Conn, script *redis.Script, keyScoreMembers []common.KeyScoreMember, maxSize func(string) int, trimPolicy TrimPolicy, members memberCodec, tombstoneGrace float64, m map[common.KeyMember]Presence) error {
+	for _, tuple := range keyScoreMembers {
+		stored, alias := members.encode(tuple.Member)
+		if err := script.Send(
+			conn,
+			tuple.Key,
+			tuple.Score,
+			stored,
+			maxSize(tuple.Key),
+			trimPolicy.scriptArg(),
+			alias,
+			tombstoneGrace,
+		); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	for _, tuple := range keyScoreMembers {
+		values, err := redis.Values(conn.Receive())
+		if err != nil {
+			return err
+		}
+		presence := Presence{}
+		if len(values) > 0 {
+			var suffix string
+			if _, err := redis.Scan(values, &suffix, &presence.Score); err != nil {
+				return err
+			}
+			presence.Present = true
+			presence.Inserted = suffix == insertSuffix
+		}
+		m[common.KeyMember{Key: tuple.Key, Member: tuple.Member}] = presence
+	}
+	return nil
+}
+
 // Element combines a submitted key with its selected score-members. If there
 // was an error while selecting a key, the error field will be populated, and
 // common.KeyScoreMembers may be empty. TODO rename.
@@ -456,18 +1894,43 @@ func successElements(m map[string][]common.KeyScoreMember) []Element {
 	return elements
 }
 
-func pipelineRange(conn redis.Conn, keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+// pipelineRange selects the members of the keys by rank, down to minScore,
+// which is -Inf for no floor. The floor only applies to descending order.
+func pipelineRange(conn redis.Conn, keys []string, offset, limit int, order common.Order, minScore float64) (map[string][]common.KeyScoreMember, error) {
 	if limit < 0 {
 		return map[string][]common.KeyScoreMember{}, fmt.Errorf("negative limit is invalid for offset-based select")
 	}
+	if offset < 0 {
+		return map[string][]common.KeyScoreMember{}, fmt.Errorf("negative offset is invalid for offset-based select")
+	}
+	if offset > maxInt-limit {
+		return map[string][]common.KeyScoreMember{}, fmt.Errorf("offset %d plus limit %d overflows", offset, limit)
+	}
+	if limit == 0 {
+		// ZREVRANGE offset offset-1 would be interpreted as a range from
+		// offset to the end of the set.
+		m := make(map[string][]common.KeyScoreMember, len(keys))
+		for _, key := range keys {
+			m[key] = []common.KeyScoreMember{}
+		}
+		return m, nil
+	}
+	var (
+		floored = !math.IsInf(minScore, -1)
+		command = "ZREVRANGE"
+	)
+	switch {
+	case floored:
+		command = "ZREVRANGEBYSCORE"
+	case order == common.Ascending:
+		command = "ZRANGE"
+	}
 	for _, key := range keys {
-		if err := conn.Send(
-			"ZREVRANGE",
-			key+insertSuffix,
-			offset,
-			offset+limit-1,
-			"WITHSCORES",
-		); err != nil {
+		args := []interface{}{key + insertSuffix, offset, offset + limit - 1, "WITHSCORES"}
+		if floored {
+			args = []interface{}{key + insertSuffix, "+inf", minScore, "WITHSCORES", "LIMIT", offset, limit}
+		}
+		if err := conn.Send(command, args...); err != nil {
 			return map[string][]common.KeyScoreMember{}, err
 		}
 	}
@@ -494,6 +1957,7 @@ func pipelineRange(conn redis.Conn, keys []string, offset, limit int) (map[strin
 				return map[string][]common.KeyScoreMember{}, err
 			}
 
+			ksm.Member = decodeMember(ksm.Member)
 			keyScoreMembers = append(keyScoreMembers, ksm)
 		}
 
@@ -503,36 +1967,99 @@ func pipelineRange(conn redis.Conn, keys []string, offset, limit int) (map[strin
 	return m, nil
 }
 
-func pipelineRangeByScore(conn redis.Conn, keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
-	if limit < 0 {
-		// TODO maybe change that
-		return map[string][]common.KeyScoreMember{}, fmt.Errorf("negative limit is invalid for cursor-based select")
+func pipelineCountRange(conn redis.Conn, keys []string, start, stop common.Cursor) (map[string]int, error) {
+	// ZCOUNT counts the members strictly between the cursor scores. The
+	// members at the cursor scores are checked individually.
+	var (
+		startScoreStr = fmt.Sprint(start.Score)
+		stopScoreStr  = fmt.Sprint(stop.Score)
+		boundaries    = []string{startScoreStr}
+	)
+	if stop.Score != start.Score {
+		boundaries = append(boundaries, stopScoreStr)
 	}
 
-	// pastStart returns true when the score+member are "past" the cursor
-	// (smaller score, larger lexicographically) and can therefore be included
-	// in the resultset.
-	pastStart := func(score float64, member string) bool {
-		if score < start.Score {
-			return true
+	for _, key := range keys {
+		if err := conn.Send("ZCOUNT", key+insertSuffix, "("+stopScoreStr, "("+startScoreStr); err != nil {
+			return map[string]int{}, err
 		}
-		if score == start.Score && member < start.Member {
-			return true
+		for _, score := range boundaries {
+			if err := conn.Send("ZRANGEBYSCORE", key+insertSuffix, score, score, "WITHSCORES"); err != nil {
+				return map[string]int{}, err
+			}
 		}
-		return false
+	}
+	if err := conn.Flush(); err != nil {
+		return map[string]int{}, err
 	}
 
-	// beforeStop returns true as long as the score+member are "before" the
-	// stop (larger score, smaller lexicographically) and can therefore
-	// be included in the resultset.
-	beforeStop := func(score float64, member string) bool {
-		if score > stop.Score {
-			return true
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		n, err := redis.Int(conn.Receive())
+		if err != nil {
+			return map[string]int{}, err
 		}
-		if score == stop.Score && member > stop.Member {
-			return true
+		for range boundaries {
+			values, err := redis.Values(conn.Receive())
+			if err != nil {
+				return map[string]int{}, err
+			}
+			var (
+				member string
+				score  float64
+			)
+			for len(values) > 0 {
+				if values, err = redis.Scan(values, &member, &score); err != nil {
+					return map[string]int{}, err
+				}
+				if pastStart(score, member, start) && beforeStop(score, member, stop) {
+					n++
+				}
+			}
 		}
-		return false
+		counts[key] = n
+	}
+	return counts, nil
+}
+
+// pastStart returns true when the score+member are "past" the cursor
+// (smaller score, larger lexicographically) and can therefore be included
+// in the resultset. Score-only cursors only compare the score.
+func pastStart(score float64, member string, start common.Cursor) bool {
+	if score < start.Score {
+		return true
+	}
+	if score == start.Score && !start.ScoreOnly && member < start.Member {
+		return true
+	}
+	return false
+}
+
+// beforeStop returns true as long as the score+member are "before" the
+// stop (larger score, smaller lexicographically) and can therefore
+// be included in the resultset. Score-only cursors only compare the score.
+func beforeStop(score float64, member string, stop common.Cursor) bool {
+	if score > stop.Score {
+		return true
+	}
+	if score == stop.Score && !stop.ScoreOnly && member > stop.Member {
+		return true
+	}
+	return false
+}
+
+// pipelineRangeByScore returns an Element for every key. Keys which don't
+// yield enough members within maxAttempts get a RangeAttemptsError. The
+// attempts of every key are reported to instr, rather than logged, as this
+// is the hot path of cursor-based selects. Many attempts hint at many
+// members with the same score.
+func pipelineRangeByScore(conn redis.Conn, keys []string, start, stop common.Cursor, offset, limit, maxAttempts int, instr instrumentation.SelectInstrumentation) ([]Element, error) {
+	if limit < 0 {
+		// TODO maybe change that
+		return nil, fmt.Errorf("negative limit is invalid for cursor-based select")
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("negative offset is invalid for cursor-based select")
 	}
 
 	// An unlimited number of members may exist at cursor.Score. Luckily,
@@ -543,12 +2070,26 @@ func pipelineRangeByScore(conn redis.Conn, keys []string, start, stop common.Cur
 	// and collect elements. If we run out of elements before collecting the
 	// user-requested limit, double the limit and try again, up to N times.
 
+	//
+	// The offset counts the members after the start cursor. If nothing needs
+	// to be skipped at the start score, Redis can skip them for us. Otherwise
+	// they're skipped here, after the members which aren't past the cursor,
+	// and every attempt has to fetch them again.
+
 	var (
 		startScoreStr = fmt.Sprint(start.Score)
-		keysToSelect  = keys  // start with all
-		selectLimit   = limit // double every time
-		maxAttempts   = 4     // up to this many times (TODO could be paramaterized)
-		results       = make(map[string][]common.KeyScoreMember, len(keys))
+		redisOffset   = 0      // skipped by Redis
+		skip          = offset // skipped here
+	)
+	if start.ScoreOnly {
+		startScoreStr = "(" + startScoreStr // nothing to skip
+		redisOffset, skip = offset, 0
+	}
+
+	var (
+		keysToSelect = keys         // start with all
+		selectLimit  = skip + limit // double every time, up to maxAttempts times
+		results      = make(map[string][]common.KeyScoreMember, len(keys))
 	)
 
 	for attempt := 0; len(keysToSelect) > 0 && attempt < maxAttempts; attempt++ {
@@ -560,22 +2101,22 @@ func pipelineRangeByScore(conn redis.Conn, keys []string, start, stop common.Cur
 				"-inf",        // min
 				"WITHSCORES",
 				"LIMIT",
-				0,
+				redisOffset,
 				selectLimit,
 			); err != nil {
-				return map[string][]common.KeyScoreMember{}, err
+				return nil, err
 			}
 		}
 
 		if err := conn.Flush(); err != nil {
-			return map[string][]common.KeyScoreMember{}, err
+			return nil, err
 		}
 
 		m := make(map[string][]common.KeyScoreMember, len(keys))
 		for _, key := range keysToSelect {
 			values, err := redis.Values(conn.Receive())
 			if err != nil {
-				return map[string][]common.KeyScoreMember{}, err
+				return nil, err
 			}
 
 			var (
@@ -587,33 +2128,31 @@ func pipelineRangeByScore(conn redis.Conn, keys []string, start, stop common.Cur
 
 			for len(values) > 0 && !hitStop {
 				if values, err = redis.Scan(values, &ksm.Member, &ksm.Score); err != nil {
-					return map[string][]common.KeyScoreMember{}, err
+					return nil, err
 				}
 
 				collected++
 
-				if !pastStart(ksm.Score, ksm.Member) {
+				if !pastStart(ksm.Score, ksm.Member, start) {
 					continue // this element is behind or at our start point
 				}
-				if !beforeStop(ksm.Score, ksm.Member) {
+				if !beforeStop(ksm.Score, ksm.Member, stop) {
 					hitStop = true
 					continue // this element is at or beyond our stop point
 				}
 
-				validated = append(validated, ksm)
+				// The cursors are compared with the stored member.
+				validated = append(validated, common.KeyScoreMember{Key: ksm.Key, Score: ksm.Score, Member: decodeMember(ksm.Member)})
 			}
 
 			// At this point, we know if we can use these elements, or need to
 			// go back for more.
 			var (
-				haveEnoughElements = len(validated) >= limit
+				haveEnoughElements = len(validated) >= skip+limit
 				exhaustedElements  = collected < selectLimit
 			)
 			if haveEnoughElements || exhaustedElements || hitStop {
-				if len(validated) > limit {
-					validated = validated[:limit]
-				}
-				m[key] = validated
+				m[key] = window(validated, skip, limit)
 			}
 		}
 
@@ -622,6 +2161,7 @@ func pipelineRangeByScore(conn redis.Conn, keys []string, start, stop common.Cur
 		for _, key := range keysToSelect {
 			if a, ok := m[key]; ok {
 				results[key] = a // use it
+				instr.SelectRangeAttempts(attempt + 1)
 			} else {
 				retryKeys = append(retryKeys, key) // try again
 			}
@@ -638,21 +2178,28 @@ func pipelineRangeByScore(conn redis.Conn, keys []string, start, stop common.Cur
 		}
 	}
 
-	if n := len(keysToSelect); n > 0 {
-		return map[string][]common.KeyScoreMember{}, fmt.Errorf("%d key(s) failed to yield enough elements (original limit %d)", n, limit)
+	// Keys left over fail on their own, rather than failing the others.
+	for range keysToSelect {
+		instr.SelectRangeAttempts(maxAttempts)
 	}
-
-	return results, nil
+	return append(
+		successElements(results),
+		errorElements(keysToSelect, RangeAttemptsError{Limit: limit, Attempts: maxAttempts})...,
+	), nil
 }
 
-func pipelineDelete(conn redis.Conn, keyScoreMembers []common.KeyScoreMember, maxSize int) error {
+func pipelineDelete(conn redis.Conn, script *redis.Script, keyScoreMembers []common.KeyScoreMember, maxSize func(string) int, trimPolicy TrimPolicy, members memberCodec, tombstoneGrace float64) error {
 	for _, keyScoreMember := range keyScoreMembers {
-		if err := deleteScript.Send(
+		stored, alias := members.encode(keyScoreMember.Member)
+		if err := script.Send(
 			conn,
 			keyScoreMember.Key,
 			keyScoreMember.Score,
-			keyScoreMember.Member,
-			maxSize,
+			stored,
+			maxSize(keyScoreMember.Key),
+			trimPolicy.scriptArg(),
+			alias,
+			tombstoneGrace,
 		); err != nil {
 			return err
 		}
@@ -672,50 +2219,183 @@ func pipelineDelete(conn redis.Conn, keyScoreMembers []common.KeyScoreMember, ma
 	return nil
 }
 
-func pipelineScore(conn redis.Conn, keyMembers []common.KeyMember) (map[common.KeyMember]Presence, error) {
+// score looks up the Presence of the keyMembers on a single instance, in
+// chunks of at most scoreChunkSize keyMembers per pipeline. ZMSCORE is used
+// when the instance supports it. Otherwise, and once any instance of the
+// cluster has rejected ZMSCORE, it falls back to ZSCORE.
+func (c *cluster) score(conn redis.Conn, keyMembers []common.KeyMember) (map[common.KeyMember]Presence, error) {
+	m := make(map[common.KeyMember]Presence, len(keyMembers))
+	for len(keyMembers) > 0 {
+		n := scoreChunkSize
+		if n > len(keyMembers) {
+			n = len(keyMembers)
+		}
+		chunk := keyMembers[:n]
+		keyMembers = keyMembers[n:]
+
+		if atomic.LoadInt32(&c.noZMScore) == 0 {
+			err := pipelineMultiScore(conn, chunk, c.insertOnly, m)
+			if err == nil {
+				continue
+			}
+			if !isUnknownCommand(err) {
+				return map[common.KeyMember]Presence{}, err
+			}
+			log.Printf("cluster: ZMSCORE unavailable (%s); falling back to ZSCORE", err)
+			atomic.StoreInt32(&c.noZMScore, 1)
+		}
+		if err := pipelineScore(conn, chunk, c.insertOnly, m); err != nil {
+			return map[common.KeyMember]Presence{}, err
+		}
+	}
+	return m, nil
+}
+
+// scoreChunkSize bounds the number of keyMembers looked up in a single
+// pipeline, so that large repairs don't build huge pipelines.
+const scoreChunkSize = 500
+
+// pipelineScore looks up the Presence of the keyMembers with two ZSCOREs per
+// keyMember, or one if insertOnly, and stores the results in m.
+func pipelineScore(conn redis.Conn, keyMembers []common.KeyMember, insertOnly bool, m map[common.KeyMember]Presence) error {
 	for _, keyMember := range keyMembers {
 		if err := conn.Send("ZSCORE", keyMember.Key+insertSuffix, keyMember.Member); err != nil {
-			return map[common.KeyMember]Presence{}, err
+			return err
+		}
+		if insertOnly {
+			continue
 		}
 		if err := conn.Send("ZSCORE", keyMember.Key+deleteSuffix, keyMember.Member); err != nil {
-			return map[common.KeyMember]Presence{}, err
+			return err
 		}
 	}
 	if err := conn.Flush(); err != nil {
-		return map[common.KeyMember]Presence{}, err
+		return err
 	}
 
-	m := map[common.KeyMember]Presence{}
 	for i := 0; i < len(keyMembers); i++ {
-		insertReply, insertErr := conn.Receive()
-		insertValue, insertErr := redis.Float64(insertReply, insertErr)
-		deleteReply, deleteErr := conn.Receive()
-		deleteValue, deleteErr := redis.Float64(deleteReply, deleteErr)
-		switch {
-		case insertErr == nil && deleteErr == redis.ErrNil:
-			m[keyMembers[i]] = Presence{
-				Present:  true,
-				Inserted: true,
-				Score:    insertValue,
-			}
-		case insertErr == redis.ErrNil && deleteErr == nil:
-			m[keyMembers[i]] = Presence{
-				Present:  true,
-				Inserted: false,
-				Score:    deleteValue,
-			}
-		case insertErr == redis.ErrNil && deleteErr == redis.ErrNil:
-			m[keyMembers[i]] = Presence{
-				Present: false,
-			}
-		default:
-			return map[common.KeyMember]Presence{}, fmt.Errorf(
-				"pipelineScore bad state for %v (%v/%v)",
-				keyMembers[i],
-				insertErr,
-				deleteErr,
-			)
+		insertValue, insertErr := redis.Float64(conn.Receive())
+		deleteValue, deleteErr := 0., redis.ErrNil
+		if !insertOnly {
+			deleteValue, deleteErr = redis.Float64(conn.Receive())
+		}
+		presence, err := makePresence(keyMembers[i], insertValue, insertErr, deleteValue, deleteErr)
+		if err != nil {
+			return err
 		}
+		m[keyMembers[i]] = presence
 	}
-	return m, nil
+	return nil
+}
+
+// pipelineMultiScore looks up the Presence of the keyMembers with two
+// ZMSCOREs per distinct key, or one if insertOnly, and stores the results in
+// m. ZMSCORE requires Redis 6.2 or later. All replies are read before an
+// error is returned, so the connection remains usable.
+func pipelineMultiScore(conn redis.Conn, keyMembers []common.KeyMember, insertOnly bool, m map[common.KeyMember]Presence) error {
+	var (
+		keys    = []string{}
+		members = map[string][]interface{}{}
+	)
+	for _, keyMember := range keyMembers {
+		if _, ok := members[keyMember.Key]; !ok {
+			keys = append(keys, keyMember.Key)
+		}
+		members[keyMember.Key] = append(members[keyMember.Key], keyMember.Member)
+	}
+
+	for _, key := range keys {
+		if err := conn.Send("ZMSCORE", append([]interface{}{key + insertSuffix}, members[key]...)...); err != nil {
+			return err
+		}
+		if insertOnly {
+			continue
+		}
+		if err := conn.Send("ZMSCORE", append([]interface{}{key + deleteSuffix}, members[key]...)...); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, key := range keys {
+		insertValues, insertErr := redis.Values(conn.Receive())
+		deleteValues, deleteErr := make([]interface{}, len(members[key])), error(nil) // all nil
+		if !insertOnly {
+			deleteValues, deleteErr = redis.Values(conn.Receive())
+		}
+		if firstErr != nil {
+			continue // drain
+		}
+		if insertErr != nil {
+			firstErr = insertErr
+			continue
+		}
+		if deleteErr != nil {
+			firstErr = deleteErr
+			continue
+		}
+		if len(insertValues) != len(members[key]) || len(deleteValues) != len(members[key]) {
+			firstErr = fmt.Errorf("pipelineMultiScore: %q: got %d/%d scores, expected %d", key, len(insertValues), len(deleteValues), len(members[key]))
+			continue
+		}
+		for i, member := range members[key] {
+			keyMember := common.KeyMember{Key: key, Member: member.(string)}
+			insertValue, insertErr := redis.Float64(insertValues[i], nil)
+			deleteValue, deleteErr := redis.Float64(deleteValues[i], nil)
+			presence, err := makePresence(keyMember, insertValue, insertErr, deleteValue, deleteErr)
+			if err != nil {
+				firstErr = err
+				break
+			}
+			m[keyMember] = presence
+		}
+	}
+	return firstErr
+}
+
+// makePresence interprets the scores of a keyMember in the insert and delete
+// set.
+func makePresence(keyMember common.KeyMember, insertValue float64, insertErr error, deleteValue float64, deleteErr error) (Presence, error) {
+	switch {
+	case insertErr == nil && deleteErr == redis.ErrNil:
+		return Presence{
+			Present:  true,
+			Inserted: true,
+			Score:    insertValue,
+		}, nil
+	case insertErr == redis.ErrNil && deleteErr == nil:
+		return Presence{
+			Present:  true,
+			Inserted: false,
+			Score:    deleteValue,
+		}, nil
+	case insertErr == redis.ErrNil && deleteErr == redis.ErrNil:
+		return Presence{
+			Present: false,
+		}, nil
+	default:
+		return Presence{}, fmt.Errorf(
+			"pipelineScore bad state for %v (%v/%v)",
+			keyMember,
+			insertErr,
+			deleteErr,
+		)
+	}
+}
+
+// isSyntaxError returns true if err is a Redis error reply complaining about
+// the syntax of a command, e.g. about an unsupported option.
+func isSyntaxError(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(strings.ToLower(string(e)), "err syntax error")
+}
+
+// isUnknownCommand returns true if err is a Redis error reply complaining
+// about an unknown command.
+func isUnknownCommand(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(strings.ToLower(string(e)), "err unknown command")
 }
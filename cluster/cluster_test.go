@@ -1,19 +1,24 @@
 package cluster_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 
 	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
 	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
 	"github.com/soundcloud/roshi/pool"
 )
 
@@ -44,7 +49,7 @@ func TestInsertSelectOffsetKeys(t *testing.T) {
 
 	// Select everything.
 	m := map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo", "bar", "baz"}, 0, 10) {
+	for e := range c.SelectOffset([]string{"foo", "bar", "baz"}, 0, 10, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -75,7 +80,7 @@ func TestInsertSelectOffsetKeys(t *testing.T) {
 
 	// Just select the first element from each key.
 	m = map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo", "bar", "baz"}, 0, 1) {
+	for e := range c.SelectOffset([]string{"foo", "bar", "baz"}, 0, 1, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -101,7 +106,7 @@ func TestInsertSelectOffsetKeys(t *testing.T) {
 
 	// Just select the second element from each key.
 	m = map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo", "bar", "baz"}, 1, 1) {
+	for e := range c.SelectOffset([]string{"foo", "bar", "baz"}, 1, 1, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -124,6 +129,35 @@ func TestInsertSelectOffsetKeys(t *testing.T) {
 		}
 		t.Logf("%s: %v OK", key, expected)
 	}
+
+	// Select the oldest two elements from each key.
+	m = map[string][]common.KeyScoreMember{}
+	for e := range c.SelectOffset([]string{"foo", "bar", "baz"}, 0, 2, common.Ascending) {
+		if e.Error != nil {
+			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
+		}
+		m[e.Key] = e.KeyScoreMembers
+	}
+	for key, expected := range map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			{"foo", 11, "delta"},
+			{"foo", 50, "alpha"},
+		},
+		"bar": []common.KeyScoreMember{
+			{"bar", 21, "kappa"},
+			{"bar", 45, "gamma"},
+		},
+		"baz": []common.KeyScoreMember{
+			{"baz", 33, "sigma"},
+			{"baz", 34, "omicron"},
+		},
+	} {
+		if got := m[key]; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: expected\n %v, got\n %v", key, expected, got)
+			continue
+		}
+		t.Logf("%s: %v OK", key, expected)
+	}
 	keysChannel := c.Keys(1)
 	keys := map[string]bool{}
 	for batch := range keysChannel {
@@ -136,6 +170,212 @@ func TestInsertSelectOffsetKeys(t *testing.T) {
 	}
 }
 
+func TestKeysOtherTypes(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys of other types, even with the insert suffix, aren't emitted. This
+	// requires SCAN ... TYPE, i.e. Redis 6.0 or later.
+	p := pool.New(strings.Split(addresses, ","), time.Second, time.Second, time.Second, 10, pool.Murmur3)
+	for _, key := range []string{"bar+", "baz"} {
+		if err := p.WithIndex(p.Index(key), func(conn redis.Conn) error {
+			_, err := conn.Do("SET", key, "x")
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := map[string]bool{}
+	for batch := range c.Keys(1) {
+		for _, key := range batch {
+			keys[key] = true
+		}
+	}
+	if got, expected := keys, map[string]bool{"foo": true}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected key set %+v, got %+v", expected, got)
+	}
+}
+
+func TestDeleteOnlyKeys(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "bar", Score: 1, Member: "a"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{
+		{Key: "foo", Score: 2, Member: "b"}, // foo has both sets
+		{Key: "bar", Score: 2, Member: "a"}, // only deletes remain for bar
+		{Key: "baz", Score: 2, Member: "a"}, // only deletes ever happened for baz
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string]bool{}
+	for batch := range c.(cluster.DeleteScanner).DeleteOnlyKeys(1) {
+		for _, key := range batch {
+			keys[key] = true
+		}
+	}
+	if got, expected := keys, map[string]bool{"bar": true, "baz": true}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected key set %+v, got %+v", expected, got)
+	}
+}
+
+func TestKeyBatches(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "bar", Score: 1, Member: "a"},
+		{Key: "baz", Score: 1, Member: "a"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		keys     = map[string]bool{}
+		complete = map[string]bool{}
+	)
+	for batch := range c.(cluster.BatchScanner).KeyBatches(2) {
+		if complete[batch.Instance] {
+			t.Errorf("%s: batch after the complete one", batch.Instance)
+		}
+		if len(batch.Keys) > 2 {
+			t.Errorf("%s: batch size %d exceeds 2", batch.Instance, len(batch.Keys))
+		}
+		for _, key := range batch.Keys {
+			keys[key] = true
+		}
+		complete[batch.Instance] = batch.Complete
+	}
+	if got, expected := keys, map[string]bool{"foo": true, "bar": true, "baz": true}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected key set %+v, got %+v", expected, got)
+	}
+	if expected, got := len(strings.Split(addresses, ",")), len(complete); expected != got {
+		t.Errorf("expected %d instance(s), got %d", expected, got)
+	}
+	for instance, ok := range complete {
+		if !ok {
+			t.Errorf("%s: no complete batch", instance)
+		}
+	}
+}
+
+func TestEstimateKeys(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	var inserts, deletes []common.KeyScoreMember
+	for i := 0; i < 100; i++ {
+		inserts = append(inserts, common.KeyScoreMember{Key: fmt.Sprintf("inserted%d", i), Score: 1, Member: "a"})
+		deletes = append(deletes, common.KeyScoreMember{Key: fmt.Sprintf("deleted%d", i), Score: 1, Member: "a"})
+	}
+	if err := c.Insert(inserts); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(deletes); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sample of every key is a count...
+	var keys, dbSize int64
+	for id, estimate := range c.(cluster.KeyEstimator).EstimateKeys(1000) {
+		if estimate.Error != nil {
+			t.Fatalf("%s: %s", id, estimate.Error)
+		}
+		if !estimate.Exact {
+			t.Errorf("%s: expected an exact estimate", id)
+		}
+		keys += estimate.Keys
+		dbSize += estimate.DBSize
+	}
+	if expected, got := int64(100), keys; expected != got {
+		t.Errorf("expected %d key(s), got %d", expected, got)
+	}
+	if expected, got := int64(200), dbSize; expected != got {
+		t.Errorf("expected a DBSIZE of %d, got %d", expected, got)
+	}
+
+	// ...and a smaller one extrapolates. SCAN takes COUNT as a hint only, so
+	// whether it's exact depends on the instance.
+	for id, estimate := range c.(cluster.KeyEstimator).EstimateKeys(10) {
+		if estimate.Error != nil {
+			t.Fatalf("%s: %s", id, estimate.Error)
+		}
+		if estimate.Sampled < 10 || estimate.Keys > estimate.DBSize {
+			t.Errorf("%s: implausible estimate %+v", id, estimate)
+		}
+	}
+}
+
+func TestSelectAll(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	expected := []common.KeyScoreMember{
+		{Key: "foo", Score: 3, Member: "e"},
+		{Key: "foo", Score: 2, Member: "d"},
+		{Key: "foo", Score: 2, Member: "c"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 1, Member: "a"},
+	}
+	if err := c.Insert(expected); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		got   = []common.KeyScoreMember{}
+		pages = []int{}
+	)
+	for e := range c.(cluster.AllSelecter).SelectAll(context.Background(), "foo", 2) {
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		got = append(got, e.KeyScoreMembers...)
+		pages = append(pages, len(e.KeyScoreMembers))
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if expected, got := []int{2, 2, 1}, pages; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected pages of %v, got %v", expected, got)
+	}
+
+	for e := range c.(cluster.AllSelecter).SelectAll(context.Background(), "nonexistent", 2) {
+		t.Errorf("nonexistent key: expected nothing, got %v", e)
+	}
+}
+
 func TestInsertIdempotency(t *testing.T) {
 	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
 	if addresses == "" {
@@ -158,7 +398,7 @@ func TestInsertIdempotency(t *testing.T) {
 	// An older insert on foo-alpha should be rejected.
 	c.Insert([]common.KeyScoreMember{{"foo", 48, "alpha"}})
 	m := map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo"}, 0, 10) {
+	for e := range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -175,7 +415,7 @@ func TestInsertIdempotency(t *testing.T) {
 	// An older delete on foo-alpha should be rejected
 	c.Delete([]common.KeyScoreMember{{"foo", 49, "alpha"}})
 	m = map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo"}, 0, 10) {
+	for e := range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -192,7 +432,7 @@ func TestInsertIdempotency(t *testing.T) {
 	// A newer insert on foo-alpha should be accepted.
 	c.Insert([]common.KeyScoreMember{{"foo", 50.2, "alpha"}})
 	m = map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo"}, 0, 10) {
+	for e := range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -209,7 +449,7 @@ func TestInsertIdempotency(t *testing.T) {
 	// A newer delete on foo-alpha should be accepted.
 	c.Delete([]common.KeyScoreMember{{"foo", 50.3, "alpha"}})
 	m = map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo"}, 0, 10) {
+	for e := range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -250,7 +490,7 @@ func TestInsertMaxSize(t *testing.T) {
 
 	// Select everything.
 	m := map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo"}, 0, 10) {
+	for e := range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -275,7 +515,7 @@ func TestInsertMaxSize(t *testing.T) {
 
 	// Should have the same output with an updated score.
 	m = map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo"}, 0, 10) {
+	for e := range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -300,7 +540,7 @@ func TestInsertMaxSize(t *testing.T) {
 
 	// Should have new output.
 	m = map[string][]common.KeyScoreMember{}
-	for e := range c.SelectOffset([]string{"foo"}, 0, 10) {
+	for e := range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
 		if e.Error != nil {
 			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
 		}
@@ -321,6 +561,122 @@ func TestInsertMaxSize(t *testing.T) {
 	}
 }
 
+func TestUncappedPrefixes(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 3, cluster.WithUncappedPrefixes("index:"))
+
+	var tuples []common.KeyScoreMember
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		tuples = append(tuples,
+			common.KeyScoreMember{Key: "foo", Score: float64(i + 1), Member: member},
+			common.KeyScoreMember{Key: "index:foo", Score: float64(i + 1), Member: member},
+		)
+	}
+	if err := c.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	// Below the lowest score, which is rejected for capped keys at capacity.
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 0.5, Member: "z"},
+		{Key: "index:foo", Score: 0.5, Member: "z"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{
+		{Key: "index:bar", Score: 1, Member: "a"},
+		{Key: "index:bar", Score: 2, Member: "b"},
+		{Key: "index:bar", Score: 3, Member: "c"},
+		{Key: "index:bar", Score: 4, Member: "d"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]int{}
+	for e := range c.SelectOffset([]string{"foo", "index:foo"}, 0, 10, common.Descending) {
+		if e.Error != nil {
+			t.Fatalf("key %q: %s", e.Key, e.Error)
+		}
+		got[e.Key] = len(e.KeyScoreMembers)
+	}
+	if expected := map[string]int{"foo": 3, "index:foo": 6}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v member(s), got %v", expected, got)
+	}
+
+	// Deletes of uncapped keys aren't trimmed either.
+	presence, err := c.Score([]common.KeyMember{{Key: "index:bar", Member: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := presence[common.KeyMember{Key: "index:bar", Member: "a"}]; !p.Present || p.Inserted {
+		t.Errorf("expected the oldest delete to be kept, got %+v", p)
+	}
+}
+
+func TestInsertTrimPolicy(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	for _, tc := range []struct {
+		name     string
+		policy   cluster.TrimPolicy
+		expected []common.KeyScoreMember
+	}{
+		{
+			name:   "KeepNewest",
+			policy: cluster.KeepNewest,
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 100, Member: "sigma"},
+				{Key: "foo", Score: 99, Member: "beta"},
+				{Key: "foo", Score: 76, Member: "iota"},
+			},
+		},
+		{
+			name:   "KeepOldest",
+			policy: cluster.KeepOldest,
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 21, Member: "kappa"},
+				{Key: "foo", Score: 11, Member: "delta"},
+				{Key: "foo", Score: 1, Member: "omega"},
+			},
+		},
+	} {
+		c := integrationCluster(t, addresses, 3, cluster.WithTrimPolicy(tc.policy))
+
+		// Fill the key past its capacity, one insert at a time, so that both
+		// the trimming and the at-capacity rejection are exercised.
+		for _, tuple := range []common.KeyScoreMember{
+			{Key: "foo", Score: 50, Member: "alpha"},
+			{Key: "foo", Score: 99, Member: "beta"},
+			{Key: "foo", Score: 11, Member: "delta"},
+			{Key: "foo", Score: 45, Member: "gamma"},
+			{Key: "foo", Score: 21, Member: "kappa"},
+			{Key: "foo", Score: 76, Member: "iota"},
+			{Key: "foo", Score: 1, Member: "omega"},
+			{Key: "foo", Score: 100, Member: "sigma"},
+		} {
+			if err := c.Insert([]common.KeyScoreMember{tuple}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		e := <-c.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+		if e.Error != nil {
+			t.Fatalf("%s: %s", tc.name, e.Error)
+		}
+		if got := e.KeyScoreMembers; !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("%s: expected\n %v, got\n %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
 func TestJSONMarshalling(t *testing.T) {
 	ksm := common.KeyScoreMember{
 		Key:    "This is incorrect UTF-8: " + string([]byte{0, 192, 0, 193}),
@@ -345,6 +701,101 @@ func TestJSONMarshalling(t *testing.T) {
 	}
 }
 
+func TestInsertContext(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000).(cluster.ContextInserter)
+	tuples := []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.InsertContext(ctx, tuples); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if err := c.InsertContext(context.Background(), []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}}
+	for e := range c.(cluster.Cluster).SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
+		if !reflect.DeepEqual(expected, e.KeyScoreMembers) {
+			t.Errorf("expected %v, got %v", expected, e.KeyScoreMembers)
+		}
+	}
+}
+
+func TestBinaryKeysMembers(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// Null bytes, invalid UTF-8, and Redis protocol framing.
+	var (
+		c      = integrationCluster(t, addresses, 1000)
+		key    = string([]byte{'k', 0, 255, '\r', '\n'})
+		tuples = []common.KeyScoreMember{
+			{Key: key, Score: 3, Member: string([]byte{0})},
+			{Key: key, Score: 2, Member: string([]byte{255, 0, 255})},
+			{Key: key, Score: 2, Member: string([]byte{0, 255})},
+			{Key: key, Score: 1, Member: "$3\r\nfoo\r\n"},
+		}
+	)
+	if err := c.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{{Key: key, Score: 4, Member: string([]byte{0})}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := tuples[1:]
+
+	for e := range c.SelectOffset([]string{key}, 0, 10, common.Descending) {
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		if !reflect.DeepEqual(expected, e.KeyScoreMembers) {
+			t.Errorf("SelectOffset: expected %v, got %v", expected, e.KeyScoreMembers)
+		}
+	}
+
+	// Page through the records with cursors, one at a time.
+	var (
+		start = common.Cursor{Score: math.MaxFloat64}
+		got   = []common.KeyScoreMember{}
+	)
+	for i := 0; i <= len(expected); i++ {
+		var page []common.KeyScoreMember
+		for e := range c.SelectRange([]string{key}, start, common.Cursor{}, 1) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			page = e.KeyScoreMembers
+		}
+		if len(page) <= 0 {
+			break
+		}
+		got = append(got, page...)
+		if err := start.Parse(page[0].Cursor().String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("SelectRange: expected %v, got %v", expected, got)
+	}
+
+	presence, err := c.Score([]common.KeyMember{{Key: key, Member: string([]byte{0})}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := (cluster.Presence{Present: true, Inserted: false, Score: 4}), presence[common.KeyMember{Key: key, Member: string([]byte{0})}]; expected != got {
+		t.Errorf("Score: expected %+v, got %+v", expected, got)
+	}
+}
+
 func TestSelectRange(t *testing.T) {
 	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
 	if addresses == "" {
@@ -556,27 +1007,267 @@ func TestSelectRange(t *testing.T) {
 	}
 }
 
-func TestCursorRetries(t *testing.T) {
+func TestSelectRangeScoreOnly(t *testing.T) {
 	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
 	if addresses == "" {
 		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
 		return
 	}
 
-	// Build a new cluster.
 	c := integrationCluster(t, addresses, 1000)
-
-	elements := []common.KeyScoreMember{}
-	for i := 0; i < 50; i++ {
-		elements = append(elements, common.KeyScoreMember{
-			Key:    "foo",
-			Score:  1.23,
-			Member: fmt.Sprintf("%03d", i)},
-		)
-	}
-
-	// Insert many elements with the same score.
-	if err := c.Insert(elements); err != nil {
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 3, Member: "a"},
+		{Key: "foo", Score: 2, Member: ""},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 2, Member: "c"},
+		{Key: "foo", Score: 1, Member: "d"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		top    = common.Cursor{Score: math.MaxFloat64}
+		bottom = common.Cursor{Score: math.Inf(-1)}
+	)
+	for _, tc := range []struct {
+		name        string
+		start, stop common.Cursor
+		expected    []common.KeyScoreMember
+	}{
+		{"score-only start", common.ScoreCursor(2), bottom, []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "d"}}},
+		{"member start", common.Cursor{Score: 2, Member: "c"}, bottom, []common.KeyScoreMember{
+			{Key: "foo", Score: 2, Member: "b"},
+			{Key: "foo", Score: 2, Member: ""},
+			{Key: "foo", Score: 1, Member: "d"},
+		}},
+		{"score-only stop", top, common.ScoreCursor(2), []common.KeyScoreMember{{Key: "foo", Score: 3, Member: "a"}}},
+		{"empty member stop", top, common.Cursor{Score: 2}, []common.KeyScoreMember{
+			{Key: "foo", Score: 3, Member: "a"},
+			{Key: "foo", Score: 2, Member: "c"},
+			{Key: "foo", Score: 2, Member: "b"},
+		}},
+		{"score-only both", common.ScoreCursor(3), common.ScoreCursor(1), []common.KeyScoreMember{
+			{Key: "foo", Score: 2, Member: "c"},
+			{Key: "foo", Score: 2, Member: "b"},
+			{Key: "foo", Score: 2, Member: ""},
+		}},
+	} {
+		e := <-c.SelectRange([]string{"foo"}, tc.start, tc.stop, 10)
+		if e.Error != nil {
+			t.Fatalf("%s: %s", tc.name, e.Error)
+		}
+		if got := e.KeyScoreMembers; !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+		counts, err := c.CountRange([]string{"foo"}, tc.start, tc.stop)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if expected, got := len(tc.expected), counts["foo"]; expected != got {
+			t.Errorf("%s: expected count %d, got %d", tc.name, expected, got)
+		}
+	}
+}
+
+func TestSelectRangeOffset(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// 30 members share the score 2, so a member cursor in their middle has
+	// to skip those before it, and then the offset.
+	tuples := []common.KeyScoreMember{
+		{Key: "foo", Score: 3, Member: "a"},
+		{Key: "foo", Score: 1, Member: "z"},
+	}
+	for i := 0; i < 30; i++ {
+		tuples = append(tuples, common.KeyScoreMember{Key: "foo", Score: 2, Member: fmt.Sprintf("m%02d", i)})
+	}
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+
+	ksms := func(members ...string) []common.KeyScoreMember {
+		a := make([]common.KeyScoreMember, 0, len(members))
+		for _, member := range members {
+			score := 2.0
+			if member == "z" {
+				score = 1
+			}
+			a = append(a, common.KeyScoreMember{Key: "foo", Score: score, Member: member})
+		}
+		return a
+	}
+
+	bottom := common.Cursor{Score: math.Inf(-1)}
+	for _, tc := range []struct {
+		name          string
+		start, stop   common.Cursor
+		offset, limit int
+		expected      []common.KeyScoreMember
+	}{
+		{"mid-list cursor", common.Cursor{Score: 2, Member: "m20"}, bottom, 5, 3, ksms("m14", "m13", "m12")},
+		{"mid-list cursor past the score", common.Cursor{Score: 2, Member: "m20"}, bottom, 18, 3, ksms("m01", "m00", "z")},
+		{"mid-list cursor with stop", common.Cursor{Score: 2, Member: "m20"}, common.ScoreCursor(1), 18, 3, ksms("m01", "m00")},
+		{"score-only cursor", common.ScoreCursor(3), bottom, 2, 2, ksms("m27", "m26")},
+		{"score-only cursor past the score", common.ScoreCursor(2), bottom, 0, 2, ksms("z")},
+		{"offset past the end", common.Cursor{Score: 2, Member: "m20"}, bottom, 21, 3, ksms()},
+	} {
+		e := <-c.(cluster.RangeOffsetSelecter).SelectRangeOffset([]string{"foo"}, tc.start, tc.stop, tc.offset, tc.limit)
+		if e.Error != nil {
+			t.Fatalf("%s: %s", tc.name, e.Error)
+		}
+		if got := e.KeyScoreMembers; !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestSelectRangeAttempts(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// With a limit of 1, skipping the 100 members of "bad" at the score of
+	// the start cursor takes 5 attempts, of 1, 25, 50, 100, and 150 members.
+	tuples := []common.KeyScoreMember{
+		{Key: "bad", Score: 0.5, Member: "last"},
+		{Key: "good", Score: 1, Member: "0"},
+		{Key: "other", Score: 0.5, Member: "x"},
+	}
+	for i := 0; i < 100; i++ {
+		tuples = append(tuples, common.KeyScoreMember{Key: "bad", Score: 1, Member: fmt.Sprintf("m%03d", i)})
+	}
+	good := map[string][]common.KeyScoreMember{
+		"good":  {{Key: "good", Score: 1, Member: "0"}},
+		"other": {{Key: "other", Score: 0.5, Member: "x"}},
+	}
+
+	for _, tc := range []struct {
+		attempts int
+		bad      []common.KeyScoreMember
+		err      error
+	}{
+		{cluster.DefaultRangeAttempts, []common.KeyScoreMember{}, cluster.RangeAttemptsError{Limit: 1, Attempts: cluster.DefaultRangeAttempts}},
+		{5, []common.KeyScoreMember{{Key: "bad", Score: 0.5, Member: "last"}}, nil},
+	} {
+		instr := &rangeAttemptsInstrumentation{}
+		c := instrumentedIntegrationCluster(t, addresses, 1000, instr, cluster.WithRangeAttempts(tc.attempts))
+		if err := c.Insert(tuples); err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string][]common.KeyScoreMember{}
+		for e := range c.SelectRange([]string{"bad", "good", "other"}, common.Cursor{Score: 1, Member: "a"}, common.Cursor{}, 1) {
+			if e.Key == "bad" {
+				if tc.err != e.Error {
+					t.Errorf("%d attempts: expected error %v, got %v", tc.attempts, tc.err, e.Error)
+				}
+			} else if e.Error != nil {
+				t.Errorf("%d attempts: key %q: %s", tc.attempts, e.Key, e.Error)
+			}
+			got[e.Key] = e.KeyScoreMembers
+		}
+		expected := map[string][]common.KeyScoreMember{"bad": tc.bad}
+		for key, a := range good {
+			expected[key] = a
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%d attempts: expected %v, got %v", tc.attempts, expected, got)
+		}
+
+		// Every key reports its attempts, including "bad" when it fails.
+		sort.Ints(instr.attempts)
+		if expected := []int{1, 1, tc.attempts}; !reflect.DeepEqual(expected, instr.attempts) {
+			t.Errorf("%d attempts: expected reported attempts %v, got %v", tc.attempts, expected, instr.attempts)
+		}
+	}
+}
+
+func TestMaxMemberSize(t *testing.T) {
+	// Nothing listens on the address, so every write which reaches Redis
+	// fails with a connection error.
+	var (
+		instr = &memberSizeInstrumentation{}
+		p     = pool.New([]string{"127.0.0.1:1"}, 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond, 1, pool.Murmur3)
+		c     = cluster.New(p, 100, 0, instr, cluster.WithMaxMemberSize(10))
+	)
+	tuples := []common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "small"},
+		{Key: "bar", Score: 1, Member: strings.Repeat("x", 11)},
+		{Key: "baz", Score: 1, Member: strings.Repeat("x", 1<<20)},
+	}
+	expected := cluster.MemberSizeError{Key: "bar", Size: 11, Max: 10}
+	if err := c.Insert(tuples); err != expected {
+		t.Errorf("Insert: expected %v, got %v", expected, err)
+	}
+	if _, err := c.(cluster.InsertReporter).InsertReporting(tuples); err != expected {
+		t.Errorf("InsertReporting: expected %v, got %v", expected, err)
+	}
+	if err := c.Delete(tuples); err != expected {
+		t.Errorf("Delete: expected %v, got %v", expected, err)
+	}
+	if expected, got := 4, instr.inserts; expected != got {
+		t.Errorf("expected %d oversized inserted members, got %d", expected, got)
+	}
+	if expected, got := 2, instr.deletes; expected != got {
+		t.Errorf("expected %d oversized deleted members, got %d", expected, got)
+	}
+
+	// Members at the limit reach Redis.
+	if err := c.Insert(tuples[:1]); err == nil {
+		t.Errorf("expected a connection error, got none")
+	} else if _, ok := err.(cluster.MemberSizeError); ok {
+		t.Errorf("expected a connection error, got %v", err)
+	}
+}
+
+type memberSizeInstrumentation struct {
+	instrumentation.NopInstrumentation
+	inserts, deletes int
+}
+
+func (i *memberSizeInstrumentation) InsertMemberTooLarge(n int) { i.inserts += n }
+func (i *memberSizeInstrumentation) DeleteMemberTooLarge(n int) { i.deletes += n }
+
+type rangeAttemptsInstrumentation struct {
+	instrumentation.NopInstrumentation
+	mtx      sync.Mutex
+	attempts []int
+}
+
+func (i *rangeAttemptsInstrumentation) SelectRangeAttempts(n int) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.attempts = append(i.attempts, n)
+}
+
+func TestCursorRetries(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// Build a new cluster.
+	c := integrationCluster(t, addresses, 1000)
+
+	elements := []common.KeyScoreMember{}
+	for i := 0; i < 50; i++ {
+		elements = append(elements, common.KeyScoreMember{
+			Key:    "foo",
+			Score:  1.23,
+			Member: fmt.Sprintf("%03d", i)},
+		)
+	}
+
+	// Insert many elements with the same score.
+	if err := c.Insert(elements); err != nil {
 		t.Fatal(err)
 	}
 
@@ -589,7 +1280,840 @@ func TestCursorRetries(t *testing.T) {
 	}
 }
 
-func integrationCluster(t *testing.T, addresses string, maxSize int) cluster.Cluster {
+func TestCountRange(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 10, Member: "a"},
+		{Key: "foo", Score: 20, Member: "b"},
+		{Key: "foo", Score: 20, Member: "c"},
+		{Key: "foo", Score: 20, Member: "d"},
+		{Key: "foo", Score: 30, Member: "e"},
+		{Key: "foo", Score: 30, Member: "f"},
+		{Key: "foo", Score: 40, Member: "g"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Counts must match SelectRange, also at the cursor scores.
+	for _, testCase := range []struct {
+		start, stop common.Cursor
+		count       int
+	}{
+		{common.Cursor{Score: math.MaxFloat64}, common.Cursor{}, 7},
+		{common.Cursor{Score: 30, Member: "f"}, common.Cursor{Score: 20, Member: "b"}, 3},
+		{common.Cursor{Score: 30, Member: "e"}, common.Cursor{Score: 20, Member: "d"}, 0},
+		{common.Cursor{Score: 20, Member: "d"}, common.Cursor{Score: 20, Member: "b"}, 1},
+		{common.Cursor{Score: 20, Member: "b"}, common.Cursor{Score: 20, Member: "d"}, 0},
+		{common.Cursor{Score: 35}, common.Cursor{Score: 15}, 5},
+		{common.Cursor{Score: 10}, common.Cursor{Score: 40}, 0},
+	} {
+		e := <-c.SelectRange([]string{"foo"}, testCase.start, testCase.stop, 100)
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		if expected, got := testCase.count, len(e.KeyScoreMembers); expected != got {
+			t.Fatalf("%v to %v: expected SelectRange to return %d, got %d", testCase.start, testCase.stop, expected, got)
+		}
+		counts, err := c.CountRange([]string{"foo", "bar"}, testCase.start, testCase.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := map[string]int{"foo": len(e.KeyScoreMembers), "bar": 0}, counts; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%v to %v: expected %v, got %v", testCase.start, testCase.stop, expected, got)
+		}
+	}
+}
+
+func TestSelectOffsetBounds(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	const maxInt = int(^uint(0) >> 1)
+	for _, tc := range []struct {
+		offset, limit int
+		valid         bool
+		expected      int
+	}{
+		{0, 0, true, 0}, // not the whole set
+		{0, 1, true, 1},
+		{1, 10, true, 1},
+		{2, 10, true, 0},
+		{maxInt - 10, 10, true, 0},
+		{-1, 10, false, 0},
+		{0, -1, false, 0},
+		{maxInt, 1, false, 0},
+	} {
+		for e := range c.SelectOffset([]string{"foo"}, tc.offset, tc.limit, common.Descending) {
+			if tc.valid && e.Error != nil {
+				t.Errorf("offset %d limit %d: %s", tc.offset, tc.limit, e.Error)
+			}
+			if !tc.valid && e.Error == nil {
+				t.Errorf("offset %d limit %d: expected error, got none", tc.offset, tc.limit)
+			}
+			if expected, got := tc.expected, len(e.KeyScoreMembers); expected != got {
+				t.Errorf("offset %d limit %d: expected %d result(s), got %d", tc.offset, tc.limit, expected, got)
+			}
+		}
+	}
+}
+
+func TestSelectTop(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 3, Member: "b"},
+		{Key: "foo", Score: 2, Member: "c"},
+		{Key: "bar", Score: 1, Member: "x"},
+		{Key: "baz", Score: 1, Member: "gone"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{
+		{Key: "foo", Score: 4, Member: "b"},
+		{Key: "baz", Score: 2, Member: "gone"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleted members aren't top, and keys without members are missing.
+	got, err := c.(cluster.TopSelecter).SelectTop([]string{"foo", "bar", "baz", "qux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]common.KeyScoreMember{
+		"foo": {Key: "foo", Score: 2, Member: "c"},
+		"bar": {Key: "bar", Score: 1, Member: "x"},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSelectOffsetFloor(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 3, Member: "c"},
+		{Key: "foo", Score: 4, Member: "d"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		four  = common.KeyScoreMember{Key: "foo", Score: 4, Member: "d"}
+		three = common.KeyScoreMember{Key: "foo", Score: 3, Member: "c"}
+		two   = common.KeyScoreMember{Key: "foo", Score: 2, Member: "b"}
+	)
+	for _, tc := range []struct {
+		offset, limit int
+		minScore      float64
+		expected      []common.KeyScoreMember
+	}{
+		{0, 10, 2, []common.KeyScoreMember{four, three, two}}, // the floor is inclusive
+		{0, 10, 2.5, []common.KeyScoreMember{four, three}},
+		{1, 10, 2, []common.KeyScoreMember{three, two}},
+		{1, 1, 2, []common.KeyScoreMember{three}},
+		{2, 10, 3, []common.KeyScoreMember{}}, // older members don't fill the window
+		{0, 10, 5, []common.KeyScoreMember{}},
+		{0, 0, 2, []common.KeyScoreMember{}},
+	} {
+		for e := range c.(cluster.FloorSelecter).SelectOffsetFloor([]string{"foo"}, tc.offset, tc.limit, tc.minScore) {
+			if e.Error != nil {
+				t.Fatalf("offset %d limit %d floor %v: %s", tc.offset, tc.limit, tc.minScore, e.Error)
+			}
+			if !reflect.DeepEqual(tc.expected, e.KeyScoreMembers) {
+				t.Errorf("offset %d limit %d floor %v: expected %v, got %v", tc.offset, tc.limit, tc.minScore, tc.expected, e.KeyScoreMembers)
+			}
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// Use more key-members than fit in a single pipeline, and let keys span
+	// multiple pipelines.
+	var (
+		c          = integrationCluster(t, addresses, 2000)
+		keyMembers = []common.KeyMember{}
+		expected   = map[common.KeyMember]cluster.Presence{}
+	)
+	for i := 0; i < 1234; i++ {
+		ksm := common.KeyScoreMember{Key: fmt.Sprintf("key%d", i%3), Score: float64(i), Member: fmt.Sprintf("member%d", i)}
+		keyMember := common.KeyMember{Key: ksm.Key, Member: ksm.Member}
+		switch i % 3 {
+		case 0:
+			if err := c.Insert([]common.KeyScoreMember{ksm}); err != nil {
+				t.Fatal(err)
+			}
+			expected[keyMember] = cluster.Presence{Present: true, Inserted: true, Score: ksm.Score}
+		case 1:
+			if err := c.Delete([]common.KeyScoreMember{ksm}); err != nil {
+				t.Fatal(err)
+			}
+			expected[keyMember] = cluster.Presence{Present: true, Inserted: false, Score: ksm.Score}
+		default:
+			expected[keyMember] = cluster.Presence{Present: false}
+		}
+		keyMembers = append(keyMembers, keyMember)
+	}
+
+	got, err := c.Score(keyMembers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %d presences, got %d (or they differ)", len(expected), len(got))
+		for keyMember, presence := range expected {
+			if got[keyMember] != presence {
+				t.Errorf("%v: expected %+v, got %+v", keyMember, presence, got[keyMember])
+			}
+		}
+	}
+}
+
+func TestWriteSemantics(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	clustertest.WriteSemantics(t, integrationCluster(t, addresses, 1000), "foo")
+}
+
+func TestInsertOnly(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// Inserts behave identically, including maxSize and score conflicts.
+	var (
+		normal     = integrationCluster(t, addresses, 3)
+		insertOnly = integrationCluster(t, addresses, 3, cluster.WithInsertOnly())
+		inserts    = []common.KeyScoreMember{
+			{Key: "KEY", Score: 5, Member: "a"},
+			{Key: "KEY", Score: 2, Member: "b"},
+			{Key: "KEY", Score: 3, Member: "a"}, // lower score, rejected
+			{Key: "KEY", Score: 7, Member: "c"},
+			{Key: "KEY", Score: 9, Member: "d"}, // trims b
+			{Key: "KEY", Score: 1, Member: "e"}, // too old, rejected
+		}
+	)
+	for _, tc := range []struct {
+		name string
+		c    cluster.Cluster
+	}{
+		{"normal", normal},
+		{"insertonly", insertOnly},
+	} {
+		for _, ksm := range inserts {
+			ksm.Key = tc.name
+			if err := tc.c.Insert([]common.KeyScoreMember{ksm}); err != nil {
+				t.Fatalf("%s: %s", tc.name, err)
+			}
+		}
+	}
+	var (
+		expected = <-normal.SelectOffset([]string{"normal"}, 0, 10, common.Descending)
+		got      = <-insertOnly.SelectOffset([]string{"insertonly"}, 0, 10, common.Descending)
+	)
+	for i := range expected.KeyScoreMembers {
+		expected.KeyScoreMembers[i].Key = "insertonly"
+	}
+	if len(got.KeyScoreMembers) != 3 || !reflect.DeepEqual(expected.KeyScoreMembers, got.KeyScoreMembers) {
+		t.Errorf("expected %v, got %v", expected.KeyScoreMembers, got.KeyScoreMembers)
+	}
+	presence, err := insertOnly.Score([]common.KeyMember{{Key: "insertonly", Member: "d"}, {Key: "insertonly", Member: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[common.KeyMember]cluster.Presence{
+		{Key: "insertonly", Member: "d"}: {Present: true, Inserted: true, Score: 9},
+		{Key: "insertonly", Member: "b"}: {Present: false},
+	}, presence; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Deletes are rejected.
+	if expected, got := cluster.ErrInsertOnly, insertOnly.Delete([]common.KeyScoreMember{{Key: "insertonly", Score: 10, Member: "d"}}); expected != got {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// The delete set is never touched: a member planted there neither blocks
+	// the Insert, nor is removed by it, nor shows up in Score.
+	conn, err := redis.Dial("tcp", strings.Split(addresses, ",")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	single := integrationCluster(t, strings.Split(addresses, ",")[0], 3, cluster.WithInsertOnly())
+	if _, err := conn.Do("ZADD", "planted-", 10, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := single.Insert([]common.KeyScoreMember{{Key: "planted", Score: 5, Member: "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if score, err := redis.Float64(conn.Do("ZSCORE", "planted-", "x")); err != nil || score != 10 {
+		t.Errorf("delete set: expected score 10, got %v (%v)", score, err)
+	}
+	presence, err = single.Score([]common.KeyMember{{Key: "planted", Member: "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := (cluster.Presence{Present: true, Inserted: true, Score: 5}), presence[common.KeyMember{Key: "planted", Member: "x"}]; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestInsertReporting(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	for _, insertOnly := range []bool{false, true} {
+		var options []cluster.Option
+		if insertOnly {
+			options = append(options, cluster.WithInsertOnly())
+		}
+		c := integrationCluster(t, addresses, 2, options...)
+		if err := c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 5, Member: "a"}}); err != nil {
+			t.Fatal(err)
+		}
+		if !insertOnly {
+			if err := c.Delete([]common.KeyScoreMember{{Key: "bar", Score: 7, Member: "b"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := c.(cluster.InsertReporter).InsertReporting([]common.KeyScoreMember{
+			{Key: "foo", Score: 3, Member: "a"}, // a newer insert is stored
+			{Key: "foo", Score: 4, Member: "c"}, // stored as is, foo is full now
+			{Key: "foo", Score: 1, Member: "d"}, // too old for the full key
+			{Key: "bar", Score: 6, Member: "b"}, // a newer delete is stored
+			{Key: "baz", Score: 2, Member: "e"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[common.KeyMember]cluster.Presence{
+			{Key: "foo", Member: "a"}: {Present: true, Inserted: true, Score: 5},
+			{Key: "foo", Member: "c"}: {Present: true, Inserted: true, Score: 4},
+			{Key: "foo", Member: "d"}: {Present: false},
+			{Key: "bar", Member: "b"}: {Present: true, Inserted: false, Score: 7},
+			{Key: "baz", Member: "e"}: {Present: true, Inserted: true, Score: 2},
+		}
+		if insertOnly {
+			expected[common.KeyMember{Key: "bar", Member: "b"}] = cluster.Presence{Present: true, Inserted: true, Score: 6}
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("insert-only %v: expected\n %+v, got\n %+v", insertOnly, expected, got)
+		}
+	}
+}
+
+func TestTombstoned(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}, {Key: "bar", Score: 1, Member: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{{Key: "bar", Score: 2, Member: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tombstoned, err := c.(cluster.Tombstoner).Tombstoned([]string{"foo", "bar", "baz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string]bool{"foo": false, "bar": true, "baz": false}, tombstoned; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(cluster.Expirer).Expire([]string{"foo", "bar"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pool.New(strings.Split(addresses, ","), time.Second, time.Second, time.Second, 10, pool.Murmur3)
+	for _, key := range []string{"foo+", "foo-"} {
+		if err := p.WithIndex(p.Index("foo"), func(conn redis.Conn) error {
+			ms, err := redis.Int64(conn.Do("PTTL", key))
+			if err != nil {
+				return err
+			}
+			if ms <= 0 || ms > int64(time.Hour/time.Millisecond) {
+				t.Errorf("%s: expected TTL of up to 1h, got %dms", key, ms)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTombstoneGrace(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// Both clusters write to the same instances.
+	var (
+		keeping  = integrationCluster(t, addresses, 1000)
+		trimming = integrationCluster(t, addresses, 1000, cluster.WithTombstoneGrace(10))
+		a        = common.KeyMember{Key: "foo", Member: "a"}
+		b        = common.KeyMember{Key: "foo", Member: "b"}
+	)
+	presence := func(c cluster.Cluster, keyMembers ...common.KeyMember) map[common.KeyMember]cluster.Presence {
+		m, err := c.Score(keyMembers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// Without a grace, tombstones are kept, and reject stale inserts.
+	if err := keeping.Delete([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := keeping.Insert([]common.KeyScoreMember{{Key: "foo", Score: 20, Member: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := (cluster.Presence{Present: true, Inserted: false, Score: 1}), presence(keeping, a)[a]; expected != got {
+		t.Errorf("without grace: expected %v, got %v", expected, got)
+	}
+
+	// A write trims the tombstones more than the grace below its score, like
+	// the one of a, but not the one it leaves itself.
+	if err := trimming.Delete([]common.KeyScoreMember{{Key: "foo", Score: 15, Member: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := trimming.Insert([]common.KeyScoreMember{{Key: "foo", Score: 21, Member: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[common.KeyMember]cluster.Presence{
+		a: {Present: false},
+		b: {Present: true, Inserted: false, Score: 15},
+	}
+	if got := presence(trimming, a, b); !reflect.DeepEqual(expected, got) {
+		t.Errorf("with grace: expected %v, got %v", expected, got)
+	}
+
+	// That's the tradeoff: a stale insert resurrects the member.
+	if err := trimming.Insert([]common.KeyScoreMember{{Key: "foo", Score: 0.5, Member: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := (cluster.Presence{Present: true, Inserted: true, Score: 0.5}), presence(trimming, a)[a]; expected != got {
+		t.Errorf("after stale insert: expected %v, got %v", expected, got)
+	}
+
+	// TrimTombstones trims relative to the newest score of each key.
+	if err := keeping.Delete([]common.KeyScoreMember{{Key: "bar", Score: 1, Member: "a"}, {Key: "bar", Score: 30, Member: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := keeping.(cluster.TombstoneTrimmer).TrimTombstones([]string{"bar", "baz"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		barA = common.KeyMember{Key: "bar", Member: "a"}
+		barB = common.KeyMember{Key: "bar", Member: "b"}
+	)
+	expected = map[common.KeyMember]cluster.Presence{
+		barA: {Present: false},
+		barB: {Present: true, Inserted: false, Score: 30},
+	}
+	if got := presence(keeping, barA, barB); !reflect.DeepEqual(expected, got) {
+		t.Errorf("TrimTombstones: expected %v, got %v", expected, got)
+	}
+}
+
+func TestCardinalitiesTrimTombstonesBefore(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 50, Member: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "b"},
+		{Key: "foo", Score: 10, Member: "c"},
+		{Key: "foo", Score: 20, Member: "d"},
+		{Key: "bar", Score: 5, Member: "a"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cardinalities := func() map[string]cluster.Cardinality {
+		m, err := c.(cluster.Cardinalizer).Cardinalities([]string{"foo", "bar", "baz"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	expected := map[string]cluster.Cardinality{
+		"foo": {Inserts: 1, Deletes: 3},
+		"bar": {Inserts: 0, Deletes: 1},
+		"baz": {},
+	}
+	if got := cardinalities(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Tombstones below the score are trimmed, regardless of the inserts.
+	if err := c.(cluster.TombstoneScoreTrimmer).TrimTombstonesBefore([]string{"foo", "bar", "baz"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]cluster.Cardinality{
+		"foo": {Inserts: 1, Deletes: 2},
+		"bar": {},
+		"baz": {},
+	}
+	if got := cardinalities(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("after trim: expected %v, got %v", expected, got)
+	}
+}
+
+func TestTune(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 2)
+	tuner, ok := c.(cluster.Tuner)
+	if !ok {
+		t.Fatal("cluster doesn't implement Tuner")
+	}
+	if expected, got := (cluster.Tuning{MaxSize: 2}), tuner.Tuning(); expected != got {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+	tuner.Tune(cluster.Tuning{MaxSize: 3, SelectGap: time.Millisecond})
+
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 3, Member: "c"},
+		{Key: "foo", Score: 4, Member: "d"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e := <-c.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+	if e.Error != nil {
+		t.Fatal(e.Error)
+	}
+	if expected, got := 3, len(e.KeyScoreMembers); expected != got {
+		t.Errorf("expected %d members, got %d", expected, got)
+	}
+}
+
+func TestScoreMaxKeyMembers(t *testing.T) {
+	// Calls over the limit are rejected before contacting Redis, so no
+	// instance needs to be reachable.
+	p := pool.New([]string{"127.0.0.1:1"}, time.Millisecond, time.Millisecond, time.Millisecond, 1, pool.Murmur3)
+	c := cluster.New(p, 1000, 0, nil, cluster.WithMaxScoreKeyMembers(2))
+
+	_, err := c.Score([]common.KeyMember{{Key: "foo", Member: "a"}, {Key: "foo", Member: "b"}, {Key: "bar", Member: "c"}})
+	if expected, got := (cluster.TooManyKeyMembersError{KeyMembers: 3, Max: 2}), err; expected != got {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestPing(t *testing.T) {
+	const unreachable = "127.0.0.1:1"
+	addresses := []string{unreachable}
+	if s := os.Getenv("TEST_REDIS_ADDRESSES"); s != "" {
+		addresses = append(addresses, strings.Split(s, ",")...)
+	} else {
+		t.Logf("To also ping reachable instances, set the TEST_REDIS_ADDRESSES environment variable")
+	}
+
+	p := pool.New(addresses, 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond, 1, pool.Murmur3)
+	c, ok := cluster.New(p, 1000, 0, nil).(cluster.Pinger)
+	if !ok {
+		t.Fatal("cluster doesn't implement Pinger")
+	}
+
+	for i := 0; i < 2; i++ { // again, to reuse the ping connections
+		results := c.Ping()
+		if expected, got := len(addresses), len(results); expected != got {
+			t.Fatalf("expected %d result(s), got %d", expected, got)
+		}
+		for id, err := range results {
+			if id == unreachable && err == nil {
+				t.Errorf("%s: expected error, got none", id)
+			}
+			if id != unreachable && err != nil {
+				t.Errorf("%s: %s", id, err)
+			}
+		}
+	}
+}
+
+func TestClockOffsets(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c, ok := integrationCluster(t, addresses, 1000).(cluster.ClockReader)
+	if !ok {
+		t.Fatal("cluster doesn't implement ClockReader")
+	}
+
+	offsets := c.ClockOffsets()
+	if expected, got := len(strings.Split(addresses, ",")), len(offsets); expected != got {
+		t.Fatalf("expected %d offset(s), got %d", expected, got)
+	}
+	for id, offset := range offsets {
+		// The test instances run locally, so their clocks should agree.
+		if offset < -time.Second || offset > time.Second {
+			t.Errorf("%s: offset %s, expected roughly 0", id, offset)
+		}
+	}
+}
+
+func TestCopyKey(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// Every instance is in the pool twice, so that some keys map to the same
+	// instance, and others to different ones, even with a single instance.
+	integrationCluster(t, addresses, 0) // flush
+	p := pool.New(strings.Split(addresses+","+addresses, ","), time.Second, time.Second, time.Second, 10, pool.Murmur3)
+	defer p.Close()
+	c := cluster.New(p, 3, 0, nil)
+
+	dstFor := func(src string, sameInstance bool) string {
+		for i := 0; ; i++ {
+			if dst := fmt.Sprintf("%s-copy-%d", src, i); (p.Index(src) == p.Index(dst)) == sameInstance {
+				return dst
+			}
+		}
+	}
+	selectAll := func(key string) []common.KeyScoreMember {
+		for e := range c.SelectOffset([]string{key}, 0, 10, common.Descending) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			return e.KeyScoreMembers
+		}
+		return nil
+	}
+
+	for _, sameInstance := range []bool{true, false} {
+		var (
+			src = fmt.Sprintf("src-%v", sameInstance)
+			dst = dstFor(src, sameInstance)
+		)
+		if err := c.Insert([]common.KeyScoreMember{
+			{Key: src, Score: 1, Member: "a"},
+			{Key: src, Score: 2, Member: "b"},
+			{Key: src, Score: 3, Member: "c"},
+			{Key: dst, Score: 5, Member: "b"},
+			{Key: dst, Score: 4, Member: "e"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Delete([]common.KeyScoreMember{
+			{Key: src, Score: 4, Member: "d"},
+			{Key: dst, Score: 3, Member: "c"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Members of src lose to newer writes in dst, and an equal delete
+		// wins. The tombstone of d is copied, and both sets are trimmed.
+		if err := c.(cluster.KeyCopier).CopyKey(src, dst); err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := []common.KeyScoreMember{
+			{Key: dst, Score: 5, Member: "b"},
+			{Key: dst, Score: 4, Member: "e"},
+			{Key: dst, Score: 1, Member: "a"},
+		}, selectAll(dst); !reflect.DeepEqual(expected, got) {
+			t.Errorf("same instance %v: expected %v, got %v", sameInstance, expected, got)
+		}
+		d := common.KeyMember{Key: dst, Member: "d"}
+		presence, err := c.Score([]common.KeyMember{d})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := (cluster.Presence{Present: true, Inserted: false, Score: 4}), presence[d]; expected != got {
+			t.Errorf("same instance %v: expected %v, got %v", sameInstance, expected, got)
+		}
+		if expected, got := 3, len(selectAll(src)); expected != got {
+			t.Errorf("same instance %v: expected %d members left in src, got %d", sameInstance, expected, got)
+		}
+	}
+}
+
+func TestMigration(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" || len(strings.Split(addresses, ",")) < 2 {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable to at least 2 addresses")
+		return
+	}
+
+	// Migrate from the first instance to all of them.
+	integrationCluster(t, addresses, 1000) // flush all instances
+	var (
+		newAddresses = strings.Split(addresses, ",")
+		oldAddresses = newAddresses[:1]
+		migrating    = func(until time.Time) (*pool.Pool, cluster.Cluster) {
+			p := pool.New(newAddresses, time.Second, time.Second, time.Second, 10, pool.Murmur3, pool.WithMigration(oldAddresses, until))
+			return p, cluster.New(p, 1000, 0, nil)
+		}
+		oldCluster = cluster.New(pool.New(oldAddresses, time.Second, time.Second, time.Second, 10, pool.Murmur3), 1000, 0, nil)
+		p, c       = migrating(time.Now().Add(time.Hour))
+		keys       = []string{}
+		moved      = map[string]bool{}
+	)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		if _, ok := p.Fallback(key); ok {
+			moved[key] = true
+		}
+		if err := oldCluster.Insert([]common.KeyScoreMember{
+			{Key: key, Score: 1, Member: "a"},
+			{Key: key, Score: 2, Member: "b"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(moved) <= 0 {
+		t.Fatal("no key moved")
+	}
+
+	check := func(name string, ch <-chan cluster.Element, expected func(key string) []common.KeyScoreMember) {
+		got := map[string][]common.KeyScoreMember{}
+		for e := range ch {
+			if e.Error != nil {
+				t.Fatalf("%s: %s: %s", name, e.Key, e.Error)
+			}
+			got[e.Key] = e.KeyScoreMembers
+		}
+		for _, key := range keys {
+			if !reflect.DeepEqual(expected(key), got[key]) {
+				t.Errorf("%s: %s (moved %v): expected %v, got %v", name, key, moved[key], expected(key), got[key])
+			}
+		}
+	}
+	var (
+		a = func(key string) common.KeyScoreMember { return common.KeyScoreMember{Key: key, Score: 1, Member: "a"} }
+		b = func(key string) common.KeyScoreMember { return common.KeyScoreMember{Key: key, Score: 2, Member: "b"} }
+		x = func(key string) common.KeyScoreMember { return common.KeyScoreMember{Key: key, Score: 3, Member: "x"} }
+	)
+
+	// No key misses its records during the window.
+	check("before writes", c.SelectOffset(keys, 0, 10, common.Descending), func(key string) []common.KeyScoreMember {
+		return []common.KeyScoreMember{b(key), a(key)}
+	})
+
+	// Writes go to the new layout, and are merged with the old one.
+	for _, key := range keys {
+		if err := c.Insert([]common.KeyScoreMember{x(key)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Delete([]common.KeyScoreMember{{Key: key, Score: 5, Member: "a"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("descending", c.SelectOffset(keys, 0, 10, common.Descending), func(key string) []common.KeyScoreMember {
+		return []common.KeyScoreMember{x(key), b(key)}
+	})
+	check("ascending", c.SelectOffset(keys, 0, 10, common.Ascending), func(key string) []common.KeyScoreMember {
+		return []common.KeyScoreMember{b(key), x(key)}
+	})
+	check("offset", c.SelectOffset(keys, 1, 1, common.Descending), func(key string) []common.KeyScoreMember {
+		return []common.KeyScoreMember{b(key)}
+	})
+	check("range", c.SelectRange(keys, common.Cursor{Score: math.Inf(1)}, common.Cursor{Score: math.Inf(-1)}, 10), func(key string) []common.KeyScoreMember {
+		return []common.KeyScoreMember{x(key), b(key)}
+	})
+
+	// Once the window is over, records which weren't copied are gone.
+	_, c = migrating(time.Now())
+	check("after the window", c.SelectOffset(keys, 0, 10, common.Descending), func(key string) []common.KeyScoreMember {
+		if moved[key] {
+			return []common.KeyScoreMember{x(key)}
+		}
+		return []common.KeyScoreMember{x(key), b(key)}
+	})
+}
+
+func integrationCluster(t testing.TB, addresses string, maxSize int, options ...cluster.Option) cluster.Cluster {
+	return instrumentedIntegrationCluster(t, addresses, maxSize, nil, options...)
+}
+
+func instrumentedIntegrationCluster(t testing.TB, addresses string, maxSize int, instr instrumentation.Instrumentation, options ...cluster.Option) cluster.Cluster {
 	p := pool.New(
 		strings.Split(addresses, ","),
 		1*time.Second, // connect timeout
@@ -609,5 +2133,50 @@ func integrationCluster(t *testing.T, addresses string, maxSize int) cluster.Clu
 		})
 	}
 
-	return cluster.New(p, maxSize, 0, nil)
+	return cluster.New(p, maxSize, 0, instr, options...)
+}
+
+func BenchmarkScore(b *testing.B) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		b.Skip("To run this benchmark, set the TEST_REDIS_ADDRESSES environment variable")
+	}
+
+	// A repair of a rebuilt node: 5000 key-members, spread over 500 keys,
+	// most of them inserted, some deleted, some unknown.
+	var (
+		c          = integrationCluster(b, addresses, 1000)
+		keyMembers = make([]common.KeyMember, 0, 5000)
+		inserts    = []common.KeyScoreMember{}
+		deletes    = []common.KeyScoreMember{}
+	)
+	for i := 0; i < 5000; i++ {
+		ksm := common.KeyScoreMember{Key: fmt.Sprintf("key%d", i%500), Score: float64(i), Member: fmt.Sprintf("member%d", i)}
+		switch i % 10 {
+		case 0:
+			deletes = append(deletes, ksm)
+		case 1:
+			// unknown
+		default:
+			inserts = append(inserts, ksm)
+		}
+		keyMembers = append(keyMembers, common.KeyMember{Key: ksm.Key, Member: ksm.Member})
+	}
+	if err := c.Insert(inserts); err != nil {
+		b.Fatal(err)
+	}
+	if err := c.Delete(deletes); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m, err := c.Score(keyMembers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(m) != len(keyMembers) {
+			b.Fatalf("expected %d presences, got %d", len(keyMembers), len(m))
+		}
+	}
 }
@@ -0,0 +1,117 @@
+// Package clustertest checks that implementations of the cluster.Cluster
+// interface honor the write semantics of the Redis scripts of package
+// cluster, see cluster.DefaultScript. In-memory clusters which pass it can
+// stand in for Redis in tests, without the tests depending on behavior that
+// production doesn't have.
+package clustertest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// WriteSemantics applies a sequence of inserts and deletes to members of the
+// given key, which c must not hold yet, and checks the state of the member
+// after every write, with Score and SelectOffset. It reports every mismatch
+// as an error of t.
+//
+// The semantics are those of last-writer-wins: a write with a higher score
+// than the stored one wins, a write with a lower score is rejected, and on
+// equal scores, a delete wins over an insert. So an insert with the stored
+// score of an inserted member is a no-op, a delete with the stored score of
+// an inserted member removes it, and an insert with the stored score of a
+// deleted member can't resurrect it. A delete of an unknown member leaves a
+// tombstone, which rejects inserts with lower or equal scores just the same.
+func WriteSemantics(t *testing.T, c cluster.Cluster, key string) {
+	for _, step := range []struct {
+		description string
+		member      string
+		insert      bool // false for delete
+		score       float64
+		want        cluster.Presence
+	}{
+		{"first insert", "alpha", true, 50, inserted(50)},
+		{"older insert", "alpha", true, 48, inserted(50)},
+		{"equal insert of an inserted member", "alpha", true, 50, inserted(50)},
+		{"older delete", "alpha", false, 49, inserted(50)},
+		{"equal delete of an inserted member", "alpha", false, 50, deleted(50)},
+		{"repeated delete", "alpha", false, 50, deleted(50)},
+		{"equal insert of a deleted member", "alpha", true, 50, deleted(50)},
+		{"older insert of a deleted member", "alpha", true, 49, deleted(50)},
+		{"newer insert of a deleted member", "alpha", true, 51, inserted(51)},
+		{"newer delete", "alpha", false, 52, deleted(52)},
+		{"newer delete of a deleted member", "alpha", false, 53, deleted(53)},
+		{"delete of an unknown member", "beta", false, 10, deleted(10)},
+		{"equal insert of a tombstone", "beta", true, 10, deleted(10)},
+		{"older insert of a tombstone", "beta", true, 9, deleted(10)},
+		{"newer insert of a tombstone", "beta", true, 11, inserted(11)},
+	} {
+		var (
+			tuples = []common.KeyScoreMember{{Key: key, Score: step.score, Member: step.member}}
+			err    error
+		)
+		if step.insert {
+			err = c.Insert(tuples)
+		} else {
+			err = c.Delete(tuples)
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", step.description, err)
+		}
+
+		have, err := presence(c, key, step.member)
+		if err != nil {
+			t.Fatalf("%s: %s", step.description, err)
+		}
+		if have != step.want {
+			t.Errorf("%s: want %+v, have %+v", step.description, step.want, have)
+		}
+
+		visible, err := selected(c, key, step.member)
+		if err != nil {
+			t.Fatalf("%s: %s", step.description, err)
+		}
+		if want, have := step.want.Inserted, visible; want != have {
+			t.Errorf("%s: want member selected %v, have %v", step.description, want, have)
+		}
+	}
+}
+
+func inserted(score float64) cluster.Presence {
+	return cluster.Presence{Present: true, Inserted: true, Score: score}
+}
+
+func deleted(score float64) cluster.Presence {
+	return cluster.Presence{Present: true, Inserted: false, Score: score}
+}
+
+func presence(c cluster.Cluster, key, member string) (cluster.Presence, error) {
+	keyMember := common.KeyMember{Key: key, Member: member}
+	m, err := c.Score([]common.KeyMember{keyMember})
+	if err != nil {
+		return cluster.Presence{}, err
+	}
+	return m[keyMember], nil
+}
+
+func selected(c cluster.Cluster, key, member string) (bool, error) {
+	var (
+		found bool
+		err   error
+	)
+	for e := range c.SelectOffset([]string{key}, 0, 100, common.Descending) {
+		if e.Error != nil {
+			err = fmt.Errorf("%s: %s", e.Key, e.Error)
+			continue
+		}
+		for _, ksm := range e.KeyScoreMembers {
+			if ksm.Member == member {
+				found = true
+			}
+		}
+	}
+	return found, err
+}
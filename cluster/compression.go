@@ -0,0 +1,151 @@
+package cluster
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"strings"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// compressedPrefix marks members which are stored compressed. Members which
+// start with it are reserved: with WithMemberCompression or
+// WithUncompressedMembers, they're always compressed.
+const compressedPrefix = "\x00\xffz"
+
+// WithMemberCompression compresses members of at least threshold bytes
+// before they're written to Redis, and decompresses them when they're read.
+// Clients only ever see the uncompressed members. Compressed members are
+// stored with a marker prefix. They're always decompressed when read, even
+// without this option, so compressed and uncompressed members coexist.
+//
+// Compression trades CPU for Redis memory and network: every write of a large
+// member compresses it, and every read of it decompresses it. It pays off for
+// large, compressible members, e.g. JSON documents of a few KB. A threshold
+// well below a few hundred bytes compresses members which barely shrink.
+//
+// A member is identified by its stored bytes, so the same member must always
+// be stored the same way. Therefore, every write replaces the other encoding
+// of the member, compressed or not, and Score looks up both. To roll
+// compression out, first configure every process writing to the cluster,
+// including walkers, with WithUncompressedMembers and the same threshold,
+// which writes uncompressed members, but replaces compressed ones. Then
+// switch them to WithMemberCompression one at a time; rolling back works the
+// other way around. Every process must be built with the same compressor,
+// i.e. the same version of compress/flate, so that it compresses members to
+// the same bytes.
+//
+// Among members with equal scores, Redis orders compressed members by their
+// compressed bytes, so Selects by offset or cursor may order such members
+// differently than by member.
+func WithMemberCompression(threshold int) Option {
+	return func(c *cluster) { c.members = memberCodec{threshold: threshold, compress: true} }
+}
+
+// WithUncompressedMembers writes members uncompressed, but replaces the
+// compressed encoding of members of at least threshold bytes, which were
+// written with WithMemberCompression and the same threshold. Use it while
+// rolling compression out or back.
+func WithUncompressedMembers(threshold int) Option {
+	return func(c *cluster) { c.members = memberCodec{threshold: threshold, compress: false} }
+}
+
+// memberCodec translates between members and the way they're stored. The
+// zero value stores every member as is.
+type memberCodec struct {
+	threshold int  // 0 to disable
+	compress  bool // store compressed, rather than uncompressed
+}
+
+func (m memberCodec) enabled() bool { return m.threshold > 0 }
+
+// encode returns the stored form of the member, and its alias, i.e. the
+// other form which the write must replace, if any.
+func (m memberCodec) encode(member string) (stored, alias string) {
+	if !m.enabled() || (len(member) < m.threshold && !strings.HasPrefix(member, compressedPrefix)) {
+		return member, ""
+	}
+	compressed := compressMember(member)
+	if m.compress {
+		return compressed, member
+	}
+	return member, compressed
+}
+
+// encodeCursor translates the member of a cursor to its stored form, so that
+// it can be compared to stored members.
+func (m memberCodec) encodeCursor(cursor common.Cursor) common.Cursor {
+	if cursor.ScoreOnly {
+		return cursor
+	}
+	cursor.Member, _ = m.encode(cursor.Member)
+	return cursor
+}
+
+// scoreForms returns the keyMembers to look up for the Presence of the
+// keyMembers: their stored forms and aliases. forms maps each keyMember to
+// its forms.
+func (m memberCodec) scoreForms(keyMembers []common.KeyMember) (lookups []common.KeyMember, forms map[common.KeyMember][]common.KeyMember) {
+	lookups = make([]common.KeyMember, 0, len(keyMembers))
+	forms = make(map[common.KeyMember][]common.KeyMember, len(keyMembers))
+	for _, keyMember := range keyMembers {
+		stored, alias := m.encode(keyMember.Member)
+		a := []common.KeyMember{{Key: keyMember.Key, Member: stored}}
+		if alias != "" {
+			a = append(a, common.KeyMember{Key: keyMember.Key, Member: alias})
+		}
+		lookups = append(lookups, a...)
+		forms[keyMember] = a
+	}
+	return lookups, forms
+}
+
+// mergeForms returns the Presence of every keyMember from the Presences of
+// its forms: the one with the highest score, or the delete if the scores
+// are equal, like the write script.
+func mergeForms(forms map[common.KeyMember][]common.KeyMember, m map[common.KeyMember]Presence) map[common.KeyMember]Presence {
+	merged := make(map[common.KeyMember]Presence, len(forms))
+	for keyMember, a := range forms {
+		var winner Presence
+		for _, form := range a {
+			p, ok := m[form]
+			if !ok {
+				continue // failed instance
+			}
+			switch {
+			case !p.Present:
+			case !winner.Present, p.Score > winner.Score, p.Score == winner.Score && !p.Inserted:
+				winner = p
+			}
+			merged[keyMember] = winner
+		}
+	}
+	return merged
+}
+
+func compressMember(member string) string {
+	var buf bytes.Buffer
+	buf.WriteString(compressedPrefix)
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		panic(err) // only for invalid levels
+	}
+	w.Write([]byte(member)) // can't fail on a bytes.Buffer
+	w.Close()
+	return buf.String()
+}
+
+// decodeMember returns the member of a stored member. Members without the
+// prefix of compressed members, or which fail to decompress, are returned as
+// is.
+func decodeMember(stored string) string {
+	if !strings.HasPrefix(stored, compressedPrefix) {
+		return stored
+	}
+	member, err := ioutil.ReadAll(flate.NewReader(strings.NewReader(stored[len(compressedPrefix):])))
+	if err != nil {
+		return stored
+	}
+	return string(member)
+}
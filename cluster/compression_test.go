@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/pool"
+)
+
+func TestCompressMemberGolden(t *testing.T) {
+	// Members are identified by their stored bytes, so a compressor which
+	// compresses them differently would duplicate every compressed member.
+	// If this fails after upgrading Go, read the rollout notes of
+	// WithMemberCompression before changing the expectation.
+	member := "roshi roshi roshi roshi roshi roshi roshi roshi"
+	expected := "\x00\xffz\x00/\x00\xd0\xffroshi roshi roshi roshi roshi roshi roshi roshi\x03\x00"
+	if got := compressMember(member); expected != got {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestMemberCodec(t *testing.T) {
+	var (
+		small      = "small"
+		large      = strings.Repeat(`{"foo":"bar"}`, 10)
+		compressed = compressMember(large)
+		prefixed   = compressedPrefix + small
+	)
+	for _, tc := range []struct {
+		name   string
+		codec  memberCodec
+		member string
+		stored string
+		alias  string
+	}{
+		{"disabled", memberCodec{}, large, large, ""},
+		{"below threshold", memberCodec{threshold: 100, compress: true}, small, small, ""},
+		{"compress", memberCodec{threshold: 100, compress: true}, large, compressed, large},
+		{"uncompressed", memberCodec{threshold: 100, compress: false}, large, large, compressed},
+		{"reserved prefix", memberCodec{threshold: 100, compress: true}, prefixed, compressMember(prefixed), prefixed},
+	} {
+		stored, alias := tc.codec.encode(tc.member)
+		if tc.stored != stored {
+			t.Errorf("%s: expected stored %q, got %q", tc.name, tc.stored, stored)
+		}
+		if tc.alias != alias {
+			t.Errorf("%s: expected alias %q, got %q", tc.name, tc.alias, alias)
+		}
+		if got := decodeMember(stored); tc.member != got {
+			t.Errorf("%s: expected %q decoded, got %q", tc.name, tc.member, got)
+		}
+	}
+
+	if len(compressed) >= len(large) {
+		t.Errorf("expected compression, got %d bytes from %d", len(compressed), len(large))
+	}
+	if corrupt := compressedPrefix + "garbage"; decodeMember(corrupt) != corrupt {
+		t.Errorf("expected a corrupt member as is, got %q", decodeMember(corrupt))
+	}
+}
+
+func TestMergeForms(t *testing.T) {
+	var (
+		a     = common.KeyMember{Key: "foo", Member: "a"}
+		az    = common.KeyMember{Key: "foo", Member: "az"}
+		b     = common.KeyMember{Key: "foo", Member: "b"}
+		bz    = common.KeyMember{Key: "foo", Member: "bz"}
+		c     = common.KeyMember{Key: "foo", Member: "c"}
+		forms = map[common.KeyMember][]common.KeyMember{a: {a, az}, b: {b, bz}, c: {c}}
+	)
+	got := mergeForms(forms, map[common.KeyMember]Presence{
+		a:  {Present: true, Inserted: true, Score: 1},
+		az: {Present: true, Inserted: true, Score: 2},
+		b:  {Present: true, Inserted: true, Score: 3},
+		bz: {Present: true, Inserted: false, Score: 3},
+		c:  {Present: false},
+	})
+	expected := map[common.KeyMember]Presence{
+		a: {Present: true, Inserted: true, Score: 2},
+		b: {Present: true, Inserted: false, Score: 3},
+		c: {Present: false},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestMemberCompression(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	p := pool.New(strings.Split(addresses, ","), time.Second, time.Second, time.Second, 10, pool.Murmur3)
+	defer p.Close()
+
+	// Every stage of a rollout writes to the same key.
+	var (
+		key        = "compression"
+		large      = strings.Repeat(`{"foo":"bar"}`, 100)
+		plain      = New(p, 1000, 0, nil)
+		rollout    = New(p, 1000, 0, nil, WithUncompressedMembers(64))
+		compressed = New(p, 1000, 0, nil, WithMemberCompression(64))
+	)
+	stored := func() map[string]float64 {
+		m := map[string]float64{}
+		if err := p.WithIndex(p.Index(key), func(conn redis.Conn) error {
+			for _, suffix := range []string{insertSuffix, deleteSuffix} {
+				values, err := redis.Strings(conn.Do("ZRANGE", key+suffix, 0, -1, "WITHSCORES"))
+				if err != nil {
+					return err
+				}
+				for i := 0; i+1 < len(values); i += 2 {
+					score, _ := strconv.ParseFloat(values[i+1], 64)
+					m[suffix+values[i]] = score
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	selectAll := func(c Cluster) []common.KeyScoreMember {
+		for e := range c.SelectOffset([]string{key}, 0, 10, common.Descending) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			return e.KeyScoreMembers
+		}
+		return nil
+	}
+	if err := p.WithIndex(p.Index(key), func(conn redis.Conn) error {
+		_, err := conn.Do("DEL", key+insertSuffix, key+deleteSuffix)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before the rollout, the member is stored uncompressed.
+	if err := plain.Insert([]common.KeyScoreMember{
+		{Key: key, Score: 1, Member: large},
+		{Key: key, Score: 2, Member: "small"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A compressing write replaces the uncompressed member.
+	if err := compressed.Insert([]common.KeyScoreMember{{Key: key, Score: 3, Member: large}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string]float64{
+		insertSuffix + compressMember(large): 3,
+		insertSuffix + "small":               2,
+	}, stored(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected stored %v, got %v", expected, got)
+	}
+	expected := []common.KeyScoreMember{
+		{Key: key, Score: 3, Member: large},
+		{Key: key, Score: 2, Member: "small"},
+	}
+	for _, c := range []Cluster{plain, rollout, compressed} {
+		if got := selectAll(c); !reflect.DeepEqual(expected, got) {
+			t.Errorf("SelectOffset: expected %v, got %v", expected, got)
+		}
+	}
+
+	// Cursors page through compressed members.
+	var (
+		start = common.Cursor{Score: math.MaxFloat64}
+		paged = []common.KeyScoreMember{}
+	)
+	for i := 0; i <= len(expected); i++ {
+		var page []common.KeyScoreMember
+		for e := range compressed.SelectRange([]string{key}, start, common.Cursor{}, 1) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			page = e.KeyScoreMembers
+		}
+		if len(page) <= 0 {
+			break
+		}
+		paged = append(paged, page...)
+		start = page[0].Cursor()
+	}
+	if !reflect.DeepEqual(expected, paged) {
+		t.Errorf("SelectRange: expected %v, got %v", expected, paged)
+	}
+
+	// An uncompressed delete replaces the compressed member, and a stale
+	// compressed insert doesn't bring it back.
+	if err := rollout.Delete([]common.KeyScoreMember{{Key: key, Score: 4, Member: large}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressed.Insert([]common.KeyScoreMember{{Key: key, Score: 4, Member: large}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string]float64{
+		deleteSuffix + large:   4,
+		insertSuffix + "small": 2,
+	}, stored(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected stored %v, got %v", expected, got)
+	}
+	keyMember := common.KeyMember{Key: key, Member: large}
+	for _, c := range []Cluster{rollout, compressed} {
+		presence, err := c.Score([]common.KeyMember{keyMember})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := (Presence{Present: true, Inserted: false, Score: 4}), presence[keyMember]; expected != got {
+			t.Errorf("Score: expected %v, got %v", expected, got)
+		}
+	}
+}
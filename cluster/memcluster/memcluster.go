@@ -0,0 +1,422 @@
+// Package memcluster provides an in-memory implementation of the
+// cluster.Cluster interface. It's intended as a drop-in for unit tests and
+// local development, when a set of Redis instances isn't available.
+package memcluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// memCluster implements the cluster.Cluster interface in memory. It mirrors
+// the semantics of the Redis scripts used by package cluster: every logical
+// key is represented by an insert set and a delete set, writes are only
+// accepted if their score is high enough, and the insert set is trimmed to
+// maxSize. Unlike the mock clusters found in some tests, deletes with a score
+// equal to the stored score are rejected, exactly like in production.
+//
+// memCluster is safe for concurrent use.
+type memCluster struct {
+	mtx     sync.RWMutex
+	inserts map[string]map[string]float64 // key: member: score
+	deletes map[string]map[string]float64 // key: member: score
+	maxSize int
+}
+
+// New returns a new, empty in-memory Cluster. maxSize for each key will be
+// enforced at write time, like it is in package cluster.
+func New(maxSize int) cluster.Cluster {
+	return &memCluster{
+		inserts: map[string]map[string]float64{},
+		deletes: map[string]map[string]float64{},
+		maxSize: maxSize,
+	}
+}
+
+// Insert implements cluster.Inserter.
+func (c *memCluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, tuple := range keyScoreMembers {
+		c.write(c.inserts, c.deletes, tuple)
+	}
+	return nil
+}
+
+// InsertReporting implements cluster.InsertReporter.
+func (c *memCluster) InsertReporting(keyScoreMembers []common.KeyScoreMember) (map[common.KeyMember]cluster.Presence, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	m := make(map[common.KeyMember]cluster.Presence, len(keyScoreMembers))
+	for _, tuple := range keyScoreMembers {
+		c.write(c.inserts, c.deletes, tuple)
+		keyMember := common.KeyMember{Key: tuple.Key, Member: tuple.Member}
+		m[keyMember] = c.presence(keyMember)
+	}
+	return m, nil
+}
+
+// Delete implements cluster.Deleter.
+func (c *memCluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, tuple := range keyScoreMembers {
+		c.write(c.deletes, c.inserts, tuple)
+	}
+	return nil
+}
+
+// write is the equivalent of the cluster package's generic Lua script. The
+// tuple is added to the add set and removed from the rem set, if its score is
+// valid.
+func (c *memCluster) write(add, rem map[string]map[string]float64, tuple common.KeyScoreMember) {
+	if members := add[tuple.Key]; len(members) > 0 && len(members) >= c.maxSize {
+		if lowest := sortedDescending(tuple.Key, members)[len(members)-1]; tuple.Score < lowest.Score {
+			return
+		}
+	}
+
+	insertScore, insertOK := c.inserts[tuple.Key][tuple.Member]
+	deleteScore, deleteOK := c.deletes[tuple.Key][tuple.Member]
+	if insertOK && tuple.Score < insertScore {
+		return
+	} else if deleteOK && tuple.Score <= deleteScore {
+		return
+	}
+
+	if members, ok := rem[tuple.Key]; ok {
+		delete(members, tuple.Member)
+		if len(members) <= 0 {
+			delete(rem, tuple.Key) // Redis removes empty ZSETs
+		}
+	}
+
+	members, ok := add[tuple.Key]
+	if !ok {
+		members = map[string]float64{}
+		add[tuple.Key] = members
+	}
+	members[tuple.Member] = tuple.Score
+
+	if len(members) > c.maxSize {
+		for _, ksm := range sortedDescending(tuple.Key, members)[c.maxSize:] {
+			delete(members, ksm.Member)
+		}
+		if len(members) <= 0 {
+			delete(add, tuple.Key)
+		}
+	}
+}
+
+// SelectOffset implements cluster.Selecter.
+func (c *memCluster) SelectOffset(keys []string, offset, limit int, order common.Order) <-chan cluster.Element {
+	return c.selectCommon(keys, func(a []common.KeyScoreMember) ([]common.KeyScoreMember, error) {
+		if limit < 0 {
+			return []common.KeyScoreMember{}, fmt.Errorf("negative limit is invalid for offset-based select")
+		}
+		if offset < 0 {
+			return []common.KeyScoreMember{}, fmt.Errorf("negative offset is invalid for offset-based select")
+		}
+		if offset >= len(a) {
+			return []common.KeyScoreMember{}, nil
+		}
+		if order == common.Ascending {
+			a = reversed(a)
+		}
+		a = a[offset:]
+		if len(a) > limit {
+			a = a[:limit]
+		}
+		return a, nil
+	})
+}
+
+// SelectOffsetFloor implements cluster.FloorSelecter.
+func (c *memCluster) SelectOffsetFloor(keys []string, offset, limit int, minScore float64) <-chan cluster.Element {
+	return c.selectCommon(keys, func(a []common.KeyScoreMember) ([]common.KeyScoreMember, error) {
+		if limit < 0 {
+			return []common.KeyScoreMember{}, fmt.Errorf("negative limit is invalid for offset-based select")
+		}
+		if offset < 0 {
+			return []common.KeyScoreMember{}, fmt.Errorf("negative offset is invalid for offset-based select")
+		}
+		n := 0
+		for n < len(a) && a[n].Score >= minScore {
+			n++
+		}
+		a = a[:n]
+		if offset >= len(a) {
+			return []common.KeyScoreMember{}, nil
+		}
+		a = a[offset:]
+		if len(a) > limit {
+			a = a[:limit]
+		}
+		return a, nil
+	})
+}
+
+// SelectRange implements cluster.Selecter.
+func (c *memCluster) SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan cluster.Element {
+	return c.SelectRangeOffset(keys, start, stop, 0, limit)
+}
+
+// SelectRangeOffset implements cluster.RangeOffsetSelecter.
+func (c *memCluster) SelectRangeOffset(keys []string, start, stop common.Cursor, offset, limit int) <-chan cluster.Element {
+	return c.selectCommon(keys, func(a []common.KeyScoreMember) ([]common.KeyScoreMember, error) {
+		if limit < 0 {
+			return []common.KeyScoreMember{}, fmt.Errorf("negative limit is invalid for cursor-based select")
+		}
+		if offset < 0 {
+			return []common.KeyScoreMember{}, fmt.Errorf("negative offset is invalid for cursor-based select")
+		}
+		var (
+			result  = make([]common.KeyScoreMember, 0, limit)
+			skipped = 0
+		)
+		for _, ksm := range a {
+			if len(result) >= limit {
+				break
+			}
+			if !pastStart(ksm, start) {
+				continue
+			}
+			if !beforeStop(ksm, stop) {
+				break
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			result = append(result, ksm)
+		}
+		return result, nil
+	})
+}
+
+// CountRange implements cluster.Selecter.
+func (c *memCluster) CountRange(keys []string, start, stop common.Cursor) (map[string]int, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		n := 0
+		for member, score := range c.inserts[key] {
+			ksm := common.KeyScoreMember{Key: key, Score: score, Member: member}
+			if pastStart(ksm, start) && beforeStop(ksm, stop) {
+				n++
+			}
+		}
+		counts[key] = n
+	}
+	return counts, nil
+}
+
+func (c *memCluster) selectCommon(
+	keys []string,
+	fn func([]common.KeyScoreMember) ([]common.KeyScoreMember, error),
+) <-chan cluster.Element {
+	// Take the snapshot synchronously, so that writes made after the
+	// Select call returns can't influence the result.
+	c.mtx.RLock()
+	elements := make([]cluster.Element, len(keys))
+	for i, key := range keys {
+		keyScoreMembers, err := fn(sortedDescending(key, c.inserts[key]))
+		elements[i] = cluster.Element{Key: key, KeyScoreMembers: keyScoreMembers, Error: err}
+	}
+	c.mtx.RUnlock()
+
+	out := make(chan cluster.Element)
+	go func() {
+		defer close(out)
+		for _, element := range elements {
+			out <- element
+		}
+	}()
+	return out
+}
+
+// SelectAll implements cluster.AllSelecter. Unlike the Redis
+// implementation, the pages come from a snapshot of the key, taken when
+// SelectAll is called.
+func (c *memCluster) SelectAll(ctx context.Context, key string, window int) <-chan cluster.Element {
+	c.mtx.RLock()
+	a := sortedDescending(key, c.inserts[key])
+	c.mtx.RUnlock()
+
+	out := make(chan cluster.Element)
+	go func() {
+		defer close(out)
+		if window <= 0 {
+			out <- cluster.Element{Key: key, Error: fmt.Errorf("invalid window %d", window)}
+			return
+		}
+		for len(a) > 0 {
+			n := window
+			if n > len(a) {
+				n = len(a)
+			}
+			select {
+			case out <- cluster.Element{Key: key, KeyScoreMembers: a[:n]}:
+			case <-ctx.Done():
+				return
+			}
+			a = a[n:]
+		}
+	}()
+	return out
+}
+
+// Tombstoned implements cluster.Tombstoner.
+func (c *memCluster) Tombstoned(keys []string) (map[string]bool, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	tombstoned := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		tombstoned[key] = len(c.deletes[key]) > 0
+	}
+	return tombstoned, nil
+}
+
+// CopyKey implements cluster.KeyCopier. Like the Redis implementation, it
+// merges the members of src into dst as if they were written to it.
+func (c *memCluster) CopyKey(src, dst string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if src == dst {
+		return nil
+	}
+	for member, score := range c.inserts[src] {
+		c.write(c.inserts, c.deletes, common.KeyScoreMember{Key: dst, Score: score, Member: member})
+	}
+	for member, score := range c.deletes[src] {
+		c.write(c.deletes, c.inserts, common.KeyScoreMember{Key: dst, Score: score, Member: member})
+	}
+	return nil
+}
+
+// Score implements cluster.Scorer.
+func (c *memCluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	m := make(map[common.KeyMember]cluster.Presence, len(keyMembers))
+	for _, keyMember := range keyMembers {
+		m[keyMember] = c.presence(keyMember)
+	}
+	return m, nil
+}
+
+// presence returns the presence of a key-member. The caller must hold the
+// lock.
+func (c *memCluster) presence(keyMember common.KeyMember) cluster.Presence {
+	if score, ok := c.inserts[keyMember.Key][keyMember.Member]; ok {
+		return cluster.Presence{Present: true, Inserted: true, Score: score}
+	} else if score, ok := c.deletes[keyMember.Key][keyMember.Member]; ok {
+		return cluster.Presence{Present: true, Inserted: false, Score: score}
+	}
+	return cluster.Presence{Present: false}
+}
+
+// Keys implements cluster.Scanner. Like the Redis implementation, only keys
+// with a non-empty insert set are emitted.
+func (c *memCluster) Keys(batchSize int) <-chan []string {
+	c.mtx.RLock()
+	keys := make([]string, 0, len(c.inserts))
+	for key := range c.inserts {
+		keys = append(keys, key)
+	}
+	c.mtx.RUnlock()
+	return batches(keys, batchSize)
+}
+
+// DeleteOnlyKeys implements cluster.DeleteScanner.
+func (c *memCluster) DeleteOnlyKeys(batchSize int) <-chan []string {
+	c.mtx.RLock()
+	keys := []string{}
+	for key := range c.deletes {
+		if _, ok := c.inserts[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	c.mtx.RUnlock()
+	return batches(keys, batchSize)
+}
+
+// batches sends keys over the returned channel, in batches of up to
+// batchSize keys.
+func batches(keys []string, batchSize int) <-chan []string {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		for len(keys) > 0 {
+			n := batchSize
+			if n > len(keys) {
+				n = len(keys)
+			}
+			ch <- keys[:n]
+			keys = keys[n:]
+		}
+	}()
+	return ch
+}
+
+// sortedDescending returns the members of a set in the order of a Redis
+// ZREVRANGE: by descending score, and by descending member for equal scores.
+func sortedDescending(key string, members map[string]float64) []common.KeyScoreMember {
+	a := make([]common.KeyScoreMember, 0, len(members))
+	for member, score := range members {
+		a = append(a, common.KeyScoreMember{Key: key, Score: score, Member: member})
+	}
+	sort.Sort(keyScoreMembers(a))
+	return a
+}
+
+// reversed returns a reversed copy of a. The reverse of the ZREVRANGE order
+// is the ZRANGE order.
+func reversed(a []common.KeyScoreMember) []common.KeyScoreMember {
+	r := make([]common.KeyScoreMember, len(a))
+	for i := range a {
+		r[len(a)-1-i] = a[i]
+	}
+	return r
+}
+
+// pastStart returns true when the score+member are "past" the cursor
+// (smaller score, smaller lexicographically), matching package cluster.
+func pastStart(ksm common.KeyScoreMember, start common.Cursor) bool {
+	return ksm.Score < start.Score || (ksm.Score == start.Score && !start.ScoreOnly && ksm.Member < start.Member)
+}
+
+// beforeStop returns true as long as the score+member are "before" the stop
+// (larger score, larger lexicographically), matching package cluster.
+func beforeStop(ksm common.KeyScoreMember, stop common.Cursor) bool {
+	return ksm.Score > stop.Score || (ksm.Score == stop.Score && !stop.ScoreOnly && ksm.Member > stop.Member)
+}
+
+type keyScoreMembers []common.KeyScoreMember
+
+func (a keyScoreMembers) Len() int      { return len(a) }
+func (a keyScoreMembers) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a keyScoreMembers) Less(i, j int) bool {
+	if a[i].Score != a[j].Score {
+		return a[i].Score > a[j].Score
+	}
+	return bytes.Compare([]byte(a[i].Member), []byte(a[j].Member)) > 0
+}
@@ -0,0 +1,497 @@
+package memcluster_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/cluster/memcluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestInsertSelectOffset(t *testing.T) {
+	c := memcluster.New(1000)
+
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 50, Member: "alpha"},
+		{Key: "foo", Score: 99, Member: "beta"},
+		{Key: "foo", Score: 11, Member: "delta"},
+		{Key: "bar", Score: 45, Member: "gamma"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		offset, limit int
+		order         common.Order
+		expected      map[string][]common.KeyScoreMember
+	}{
+		{0, 10, common.Descending, map[string][]common.KeyScoreMember{
+			"foo": {
+				{Key: "foo", Score: 99, Member: "beta"},
+				{Key: "foo", Score: 50, Member: "alpha"},
+				{Key: "foo", Score: 11, Member: "delta"},
+			},
+			"bar": {{Key: "bar", Score: 45, Member: "gamma"}},
+			"baz": {},
+		}},
+		{1, 1, common.Descending, map[string][]common.KeyScoreMember{
+			"foo": {{Key: "foo", Score: 50, Member: "alpha"}},
+			"bar": {},
+			"baz": {},
+		}},
+		{0, 2, common.Ascending, map[string][]common.KeyScoreMember{
+			"foo": {
+				{Key: "foo", Score: 11, Member: "delta"},
+				{Key: "foo", Score: 50, Member: "alpha"},
+			},
+			"bar": {{Key: "bar", Score: 45, Member: "gamma"}},
+			"baz": {},
+		}},
+		{2, 10, common.Ascending, map[string][]common.KeyScoreMember{
+			"foo": {{Key: "foo", Score: 99, Member: "beta"}},
+			"bar": {},
+			"baz": {},
+		}},
+	} {
+		if want, have := tc.expected, selectOffset(t, c, []string{"foo", "bar", "baz"}, tc.offset, tc.limit, tc.order); !reflect.DeepEqual(want, have) {
+			t.Errorf("offset %d limit %d order %d: want %v, have %v", tc.offset, tc.limit, tc.order, want, have)
+		}
+	}
+}
+
+func TestSelectOffsetFloor(t *testing.T) {
+	c := memcluster.New(1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 50, Member: "alpha"},
+		{Key: "foo", Score: 99, Member: "beta"},
+		{Key: "foo", Score: 11, Member: "delta"},
+		{Key: "bar", Score: 45, Member: "gamma"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		offset, limit int
+		minScore      float64
+		expected      map[string][]common.KeyScoreMember
+	}{
+		{0, 10, 45, map[string][]common.KeyScoreMember{
+			"foo": {
+				{Key: "foo", Score: 99, Member: "beta"},
+				{Key: "foo", Score: 50, Member: "alpha"},
+			},
+			"bar": {{Key: "bar", Score: 45, Member: "gamma"}},
+		}},
+		{1, 10, 50, map[string][]common.KeyScoreMember{
+			"foo": {{Key: "foo", Score: 50, Member: "alpha"}},
+			"bar": {},
+		}},
+		{2, 10, 11, map[string][]common.KeyScoreMember{
+			"foo": {{Key: "foo", Score: 11, Member: "delta"}},
+			"bar": {},
+		}},
+		{0, 10, 100, map[string][]common.KeyScoreMember{
+			"foo": {},
+			"bar": {},
+		}},
+	} {
+		have := map[string][]common.KeyScoreMember{}
+		for e := range c.(cluster.FloorSelecter).SelectOffsetFloor([]string{"foo", "bar"}, tc.offset, tc.limit, tc.minScore) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			have[e.Key] = e.KeyScoreMembers
+		}
+		if want := tc.expected; !reflect.DeepEqual(want, have) {
+			t.Errorf("offset %d limit %d floor %v: want %v, have %v", tc.offset, tc.limit, tc.minScore, want, have)
+		}
+	}
+}
+
+func TestInsertDeleteSemantics(t *testing.T) {
+	c := memcluster.New(1000)
+	c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 50, Member: "alpha"}})
+
+	// An older insert is rejected.
+	c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 48, Member: "alpha"}})
+	if want, have := presence(true, true, 50), score(t, c, "foo", "alpha"); want != have {
+		t.Fatalf("after older insert: want %+v, have %+v", want, have)
+	}
+
+	// An older delete is rejected.
+	c.Delete([]common.KeyScoreMember{{Key: "foo", Score: 49, Member: "alpha"}})
+	if want, have := presence(true, true, 50), score(t, c, "foo", "alpha"); want != have {
+		t.Fatalf("after older delete: want %+v, have %+v", want, have)
+	}
+
+	// A delete with an equal score is accepted, because the stored score is
+	// in the insert set.
+	c.Delete([]common.KeyScoreMember{{Key: "foo", Score: 50, Member: "alpha"}})
+	if want, have := presence(true, false, 50), score(t, c, "foo", "alpha"); want != have {
+		t.Fatalf("after equal delete: want %+v, have %+v", want, have)
+	}
+
+	// Repeating that delete is rejected, like in production.
+	c.Delete([]common.KeyScoreMember{{Key: "foo", Score: 50, Member: "alpha"}})
+	if want, have := presence(true, false, 50), score(t, c, "foo", "alpha"); want != have {
+		t.Fatalf("after repeated delete: want %+v, have %+v", want, have)
+	}
+
+	// An insert with an equal score can't resurrect the member.
+	c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 50, Member: "alpha"}})
+	if want, have := presence(true, false, 50), score(t, c, "foo", "alpha"); want != have {
+		t.Fatalf("after equal insert: want %+v, have %+v", want, have)
+	}
+
+	// A newer insert can.
+	c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 51, Member: "alpha"}})
+	if want, have := presence(true, true, 51), score(t, c, "foo", "alpha"); want != have {
+		t.Fatalf("after newer insert: want %+v, have %+v", want, have)
+	}
+
+	// Unknown members aren't present.
+	if want, have := (cluster.Presence{}), score(t, c, "foo", "beta"); want != have {
+		t.Fatalf("unknown member: want %+v, have %+v", want, have)
+	}
+}
+
+func TestWriteSemantics(t *testing.T) {
+	clustertest.WriteSemantics(t, memcluster.New(1000), "foo")
+}
+
+func TestInsertMaxSize(t *testing.T) {
+	c := memcluster.New(3)
+	c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 50, Member: "alpha"},
+		{Key: "foo", Score: 99, Member: "beta"},
+		{Key: "foo", Score: 11, Member: "delta"},
+		{Key: "foo", Score: 45, Member: "gamma"},
+		{Key: "foo", Score: 76, Member: "iota"},
+	})
+
+	// At capacity, an insert older than the oldest member is rejected.
+	c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "kappa"}})
+
+	if want, have := []common.KeyScoreMember{
+		{Key: "foo", Score: 99, Member: "beta"},
+		{Key: "foo", Score: 76, Member: "iota"},
+		{Key: "foo", Score: 50, Member: "alpha"},
+	}, selectOffset(t, c, []string{"foo"}, 0, 10, common.Descending)["foo"]; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestSelectRange(t *testing.T) {
+	c := memcluster.New(1000)
+	c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 50.1, Member: "alpha"},
+		{Key: "foo", Score: 40.2, Member: "beta"},
+		{Key: "foo", Score: 40.2, Member: "gamma"},
+		{Key: "foo", Score: 30.3, Member: "delta"},
+	})
+
+	for _, tc := range []struct {
+		start, stop common.Cursor
+		limit       int
+		expected    []common.KeyScoreMember
+	}{
+		{
+			start: common.Cursor{Score: 100},
+			limit: 2,
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 50.1, Member: "alpha"},
+				{Key: "foo", Score: 40.2, Member: "gamma"},
+			},
+		},
+		{
+			start: common.Cursor{Score: 40.2, Member: "gamma"},
+			limit: 10,
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 40.2, Member: "beta"},
+				{Key: "foo", Score: 30.3, Member: "delta"},
+			},
+		},
+		{
+			start: common.Cursor{Score: 100},
+			stop:  common.Cursor{Score: 40.2, Member: "beta"},
+			limit: 10,
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 50.1, Member: "alpha"},
+				{Key: "foo", Score: 40.2, Member: "gamma"},
+			},
+		},
+		{
+			start: common.ScoreCursor(40.2),
+			limit: 10,
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 30.3, Member: "delta"},
+			},
+		},
+		{
+			start: common.Cursor{Score: 100},
+			stop:  common.ScoreCursor(40.2),
+			limit: 10,
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 50.1, Member: "alpha"},
+			},
+		},
+		{
+			start: common.Cursor{Score: 100},
+			stop:  common.Cursor{Score: 40.2},
+			limit: 10,
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 50.1, Member: "alpha"},
+				{Key: "foo", Score: 40.2, Member: "gamma"},
+				{Key: "foo", Score: 40.2, Member: "beta"},
+			},
+		},
+	} {
+		e := <-c.SelectRange([]string{"foo"}, tc.start, tc.stop, tc.limit)
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		if want, have := tc.expected, e.KeyScoreMembers; !reflect.DeepEqual(want, have) {
+			t.Errorf("start %v stop %v limit %d: want %v, have %v", tc.start, tc.stop, tc.limit, want, have)
+		}
+	}
+}
+
+func TestSelectRangeOffset(t *testing.T) {
+	c := memcluster.New(1000)
+	c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 50.1, Member: "alpha"},
+		{Key: "foo", Score: 40.2, Member: "beta"},
+		{Key: "foo", Score: 40.2, Member: "gamma"},
+		{Key: "foo", Score: 40.2, Member: "epsilon"},
+		{Key: "foo", Score: 30.3, Member: "delta"},
+		{Key: "bar", Score: 40.2, Member: "zeta"},
+		{Key: "bar", Score: 30.3, Member: "eta"},
+	})
+
+	for _, tc := range []struct {
+		start, stop   common.Cursor
+		offset, limit int
+		expected      map[string][]common.KeyScoreMember
+	}{
+		{
+			start:  common.Cursor{Score: 40.2, Member: "gamma"},
+			offset: 1,
+			limit:  2,
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 40.2, Member: "beta"}, {Key: "foo", Score: 30.3, Member: "delta"}},
+				"bar": {},
+			},
+		},
+		{
+			start:  common.ScoreCursor(50.1),
+			stop:   common.ScoreCursor(30.3),
+			offset: 1,
+			limit:  10,
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 40.2, Member: "epsilon"}, {Key: "foo", Score: 40.2, Member: "beta"}},
+				"bar": {},
+			},
+		},
+	} {
+		have := map[string][]common.KeyScoreMember{}
+		for e := range c.(cluster.RangeOffsetSelecter).SelectRangeOffset([]string{"foo", "bar"}, tc.start, tc.stop, tc.offset, tc.limit) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			have[e.Key] = e.KeyScoreMembers
+		}
+		if want := tc.expected; !reflect.DeepEqual(want, have) {
+			t.Errorf("start %v stop %v offset %d limit %d: want %v, have %v", tc.start, tc.stop, tc.offset, tc.limit, want, have)
+		}
+	}
+}
+
+func TestCountRange(t *testing.T) {
+	c := memcluster.New(1000)
+	c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 50.1, Member: "alpha"},
+		{Key: "foo", Score: 40.2, Member: "beta"},
+		{Key: "foo", Score: 40.2, Member: "gamma"},
+		{Key: "foo", Score: 30.3, Member: "delta"},
+	})
+
+	for _, tc := range []struct {
+		start, stop common.Cursor
+		expected    int
+	}{
+		{start: common.Cursor{Score: 100}, expected: 4},
+		{start: common.Cursor{Score: 40.2, Member: "gamma"}, expected: 2},
+		{start: common.Cursor{Score: 100}, stop: common.Cursor{Score: 40.2, Member: "beta"}, expected: 2},
+		{start: common.Cursor{Score: 40.2, Member: "gamma"}, stop: common.Cursor{Score: 40.2, Member: "beta"}, expected: 0},
+		{start: common.ScoreCursor(40.2), expected: 1},
+		{start: common.ScoreCursor(50.1), stop: common.ScoreCursor(30.3), expected: 2},
+	} {
+		counts, err := c.CountRange([]string{"foo", "bar"}, tc.start, tc.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := map[string]int{"foo": tc.expected, "bar": 0}, counts; !reflect.DeepEqual(want, have) {
+			t.Errorf("start %v stop %v: want %v, have %v", tc.start, tc.stop, want, have)
+		}
+	}
+}
+
+func TestScoreCursor(t *testing.T) {
+	c := memcluster.New(1000)
+	c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 50.1, Member: "alpha"},
+		{Key: "foo", Score: 40.2, Member: "beta"},
+		{Key: "foo", Score: 40.2, Member: "gamma"},
+		{Key: "foo", Score: 30.3, Member: "delta"},
+	})
+
+	// The cursor of a present member, encoded and decoded as by clients,
+	// resumes a SelectRange right after it.
+	cursor, ok := score(t, c, "foo", "gamma").Cursor("gamma")
+	if !ok {
+		t.Fatal("gamma: no cursor")
+	}
+	var start common.Cursor
+	if err := start.Parse(cursor.String()); err != nil {
+		t.Fatal(err)
+	}
+	e := <-c.SelectRange([]string{"foo"}, start, common.Cursor{Score: 0}, 10)
+	if want, have := []common.KeyScoreMember{
+		{Key: "foo", Score: 40.2, Member: "beta"},
+		{Key: "foo", Score: 30.3, Member: "delta"},
+	}, e.KeyScoreMembers; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	// Members which aren't present have no cursor.
+	if _, ok := score(t, c, "foo", "epsilon").Cursor("epsilon"); ok {
+		t.Error("epsilon: want no cursor, have one")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	c := memcluster.New(1000)
+	c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "bar", Score: 1, Member: "a"},
+		{Key: "baz", Score: 1, Member: "a"},
+	})
+	c.Delete([]common.KeyScoreMember{
+		{Key: "baz", Score: 2, Member: "a"}, // only deletes remain for baz
+		{Key: "qux", Score: 2, Member: "a"}, // only deletes ever happened for qux
+	})
+
+	var keys []string
+	for batch := range c.Keys(2) {
+		if len(batch) > 2 {
+			t.Errorf("batch size %d exceeds 2", len(batch))
+		}
+		keys = append(keys, batch...)
+	}
+	sort.Strings(keys)
+	if want, have := []string{"bar", "foo"}, keys; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestDeleteOnlyKeys(t *testing.T) {
+	c := memcluster.New(1000)
+	c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "baz", Score: 1, Member: "a"},
+	})
+	c.Delete([]common.KeyScoreMember{
+		{Key: "foo", Score: 2, Member: "b"}, // foo has both sets
+		{Key: "baz", Score: 2, Member: "a"}, // only deletes remain for baz
+		{Key: "qux", Score: 2, Member: "a"}, // only deletes ever happened for qux
+	})
+
+	var keys []string
+	for batch := range c.(cluster.DeleteScanner).DeleteOnlyKeys(1) {
+		if len(batch) > 1 {
+			t.Errorf("batch size %d exceeds 1", len(batch))
+		}
+		keys = append(keys, batch...)
+	}
+	sort.Strings(keys)
+	if want, have := []string{"baz", "qux"}, keys; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestSelectAll(t *testing.T) {
+	c := memcluster.New(1000)
+	expected := []common.KeyScoreMember{
+		{Key: "foo", Score: 3, Member: "c"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 1, Member: "a"},
+	}
+	c.Insert(expected)
+
+	var (
+		got   = []common.KeyScoreMember{}
+		pages = 0
+	)
+	for e := range c.(cluster.AllSelecter).SelectAll(context.Background(), "foo", 2) {
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		got = append(got, e.KeyScoreMembers...)
+		pages++
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if expected, got := 2, pages; expected != got {
+		t.Errorf("expected %d pages, got %d", expected, got)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	var (
+		c  = memcluster.New(10)
+		wg = sync.WaitGroup{}
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ksm := common.KeyScoreMember{Key: "foo", Score: float64(i), Member: "member"}
+			c.Insert([]common.KeyScoreMember{ksm})
+			c.Delete([]common.KeyScoreMember{ksm})
+			for range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
+			}
+			c.Score([]common.KeyMember{{Key: "foo", Member: "member"}})
+		}(i)
+	}
+	wg.Wait()
+}
+
+func selectOffset(t *testing.T, c cluster.Cluster, keys []string, offset, limit int, order common.Order) map[string][]common.KeyScoreMember {
+	m := map[string][]common.KeyScoreMember{}
+	for e := range c.SelectOffset(keys, offset, limit, order) {
+		if e.Error != nil {
+			t.Errorf("during Select: key %q: %s", e.Key, e.Error)
+		}
+		m[e.Key] = e.KeyScoreMembers
+	}
+	return m
+}
+
+func score(t *testing.T, c cluster.Cluster, key, member string) cluster.Presence {
+	keyMember := common.KeyMember{Key: key, Member: member}
+	m, err := c.Score([]common.KeyMember{keyMember})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m[keyMember]
+}
+
+func presence(present, inserted bool, score float64) cluster.Presence {
+	return cluster.Presence{Present: present, Inserted: inserted, Score: score}
+}
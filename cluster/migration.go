@@ -0,0 +1,195 @@
+package cluster
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/soundcloud/roshi/common"
+)
+
+// Selects of a cluster over a migrating pool, see pool.WithMigration, read
+// keys which moved from both their instance in the new layout, the primary,
+// and their instance in the old layout, the fallback. Writes only go to the
+// primary, so the fallback only has records which were written before the
+// migration. A record of the fallback is returned unless the primary has the
+// member at a higher or equal score, inserted or deleted. Every read of a
+// moved key costs a read on both instances, plus a Score on the primary for
+// the records which only the fallback has.
+//
+// An offset-based select can't be merged from the offsets of two instances,
+// so both instances are read from the first member up to offset+limit, and
+// the merged records are cut to the offset and limit. Deep offsets of moved
+// keys are therefore expensive. If the primary shadows records of the
+// fallback, the result may have fewer records than limit.
+
+// selectMigrating is selectCommon for selects which may have to read the old
+// layout of a migrating pool. Keys with a fallback are read from both
+// instances with widened, which reads the records of the select from the
+// first one on, merged in order, and cut by trim.
+func (c *cluster) selectMigrating(
+	keys []string,
+	fn, widened func(redis.Conn, []string) ([]Element, error),
+	order common.Order,
+	trim func([]common.KeyScoreMember) []common.KeyScoreMember,
+) <-chan Element {
+	var (
+		plain     = make([]string, 0, len(keys))
+		migrating = map[[2]int][]string{} // primary, fallback: keys
+	)
+	for _, key := range keys {
+		fallback, ok := c.pool.Fallback(key)
+		if !ok {
+			plain = append(plain, key)
+			continue
+		}
+		indexes := [2]int{c.pool.Index(key), fallback}
+		migrating[indexes] = append(migrating[indexes], key)
+	}
+	if len(migrating) <= 0 {
+		return c.selectCommon(keys, fn)
+	}
+
+	out := make(chan Element)
+	go func() {
+		wg := sync.WaitGroup{}
+		wg.Add(1 + len(migrating))
+		go func() {
+			defer wg.Done()
+			for e := range c.selectCommon(plain, fn) {
+				out <- e
+			}
+		}()
+		for indexes, keys := range migrating {
+			go func(primary, fallback int, keys []string) {
+				defer wg.Done()
+				for _, e := range c.selectFallback(primary, fallback, keys, widened, order, trim) {
+					out <- e
+				}
+			}(indexes[0], indexes[1], keys)
+		}
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// selectFallback reads the keys from the primary and fallback instances with
+// widened, and merges the records.
+func (c *cluster) selectFallback(
+	primary, fallback int,
+	keys []string,
+	widened func(redis.Conn, []string) ([]Element, error),
+	order common.Order,
+	trim func([]common.KeyScoreMember) []common.KeyScoreMember,
+) []Element {
+	// Scatter
+	var (
+		responses [2]map[string]Element // primary, fallback
+		errs      [2]error
+		wg        = sync.WaitGroup{}
+	)
+	wg.Add(2)
+	for i, index := range []int{primary, fallback} {
+		go func(i, index int) {
+			defer wg.Done()
+			var elements []Element
+			errs[i] = c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
+				elements, err = widened(conn, keys)
+				return
+			})
+			responses[i] = make(map[string]Element, len(elements))
+			for _, e := range elements {
+				responses[i][e.Key] = e
+			}
+		}(i, index)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return errorElements(keys, err)
+		}
+	}
+
+	// Merge the records of the primary with the records of the fallback
+	// which the primary doesn't have at a higher or equal score.
+	var (
+		elements   = make([]Element, 0, len(keys))
+		merged     = make(map[string]map[string]float64, len(keys)) // key: member: score
+		candidates = map[common.KeyMember]float64{}                 // of the fallback
+	)
+	for _, key := range keys {
+		p, f := responses[0][key], responses[1][key]
+		if p.Error != nil || f.Error != nil {
+			err := p.Error
+			if err == nil {
+				err = f.Error
+			}
+			elements = append(elements, Element{Key: key, Error: err})
+			continue
+		}
+		members := make(map[string]float64, len(p.KeyScoreMembers)+len(f.KeyScoreMembers))
+		for _, ksm := range p.KeyScoreMembers {
+			members[ksm.Member] = ksm.Score
+		}
+		for _, ksm := range f.KeyScoreMembers {
+			if score, ok := members[ksm.Member]; ok && score >= ksm.Score {
+				continue
+			}
+			candidates[common.KeyMember{Key: key, Member: ksm.Member}] = ksm.Score
+		}
+		merged[key] = members
+	}
+	if len(candidates) > 0 {
+		keyMembers := make([]common.KeyMember, 0, len(candidates))
+		for keyMember := range candidates {
+			keyMembers = append(keyMembers, keyMember)
+		}
+		presence, err := c.Score(keyMembers) // of the primary
+		if err != nil {
+			return errorElements(keys, err)
+		}
+		for keyMember, score := range candidates {
+			if p := presence[keyMember]; p.Present && p.Score >= score {
+				continue // inserted or deleted since
+			}
+			merged[keyMember.Key][keyMember.Member] = score
+		}
+	}
+
+	for key, members := range merged {
+		records := make([]common.KeyScoreMember, 0, len(members))
+		for member, score := range members {
+			records = append(records, common.KeyScoreMember{Key: key, Score: score, Member: member})
+		}
+		sortRecords(records, order)
+		elements = append(elements, Element{Key: key, KeyScoreMembers: trim(records)})
+	}
+	return elements
+}
+
+// sortRecords sorts the records like Redis: by score, and members with equal
+// scores by member, in the same direction.
+func sortRecords(a []common.KeyScoreMember, order common.Order) {
+	sort.Slice(a, func(i, j int) bool {
+		if order == common.Ascending {
+			i, j = j, i
+		}
+		if a[i].Score != a[j].Score {
+			return a[i].Score > a[j].Score
+		}
+		return a[i].Member > a[j].Member
+	})
+}
+
+// window returns the records from offset, up to limit.
+func window(a []common.KeyScoreMember, offset, limit int) []common.KeyScoreMember {
+	if offset >= len(a) {
+		return []common.KeyScoreMember{}
+	}
+	a = a[offset:]
+	if limit < len(a) {
+		a = a[:limit]
+	}
+	return a
+}
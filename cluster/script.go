@@ -0,0 +1,358 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DefaultScript is the Lua script which Clusters use for inserts and
+// deletes, unless WithScript replaces it. It implements last-writer-wins:
+// the write with the highest score wins, and a delete wins over an insert
+// with the same score.
+//
+// The script is a template. Before it's loaded, the following placeholders
+// are replaced, to build one script for inserts, deletes, and their variants:
+//
+//	ADDSUFFIX     suffix of the set written to: + for inserts, - for deletes
+//	REMSUFFIX     suffix of the other set, which the member is removed from
+//	INSERTSUFFIX  + (the insert set)
+//	DELETESUFFIX  - (the delete set)
+//	INSERTONLY    true with WithInsertOnly, which ignores the delete set
+//	REPORT        true for InsertReporting, which changes the return value
+//
+// KEYS[1] is the key, without suffix. ARGV[1] is the score, ARGV[2] is the
+// member as stored, ARGV[3] is the maxSize of the key, 0 if it's uncapped,
+// ARGV[4] is 'oldest' with KeepOldest, ARGV[5], if not empty, is the other
+// encoding of the member, see WithMemberCompression, which the write must
+// treat as the same member and replace, and ARGV[6] is the tombstone grace,
+// see WithTombstoneGrace, 0 if it's disabled.
+//
+// A custom script must honor the same contract:
+//
+//   - A write that wins ZADDs the member to ADDSUFFIX and ZREMs it from
+//     REMSUFFIX, unless INSERTONLY. A member is never in both sets.
+//   - A write that loses changes nothing. Writes must be idempotent and
+//     commutative, so that repairs and retries converge: applying the same
+//     writes in any order must lead to the same state.
+//   - If maxSize is positive, the insert set is trimmed to maxSize members
+//     after a write, according to ARGV[4], and writes which would be trimmed
+//     right away are rejected.
+//   - If the tombstone grace is positive, a write that wins removes the
+//     members of DELETESUFFIX with scores more than the grace below its own.
+//     Scripts which ignore ARGV[6] keep tombstones, which is always safe.
+//   - Without REPORT, the return value is the number of changed members, or
+//     -1 for a losing write; it's only checked for errors. With REPORT, it's
+//     the resulting state of the member: {'+', score} or {'-', score}, or an
+//     empty table if it's in neither set.
+//
+// Package farm resolves inconsistencies between clusters by score, see
+// AllRepairs, so a custom script which lets lower scores win on some
+// condition makes repairs fight it. Such scripts should reject the writes
+// rather than re-score them.
+const DefaultScript = `
+		local addKey = KEYS[1] .. 'ADDSUFFIX'
+		local remKey = KEYS[1] .. 'REMSUFFIX'
+
+		-- ARGV[5], if given, is the other encoding of the member, see
+		-- WithMemberCompression. Its scores count as the member's, and a
+		-- write replaces it.
+		local alias = ARGV[5] or ''
+
+		-- The score of the member, or of its alias if higher, in a set.
+		local function score(set)
+			local ts = redis.call('ZSCORE', set, ARGV[2])
+			if alias ~= '' then
+				local aliasTs = redis.call('ZSCORE', set, alias)
+				if aliasTs and (not ts or tonumber(aliasTs) > tonumber(ts)) then
+					ts = aliasTs
+				end
+			end
+			return ts
+		end
+
+		-- When reporting, return the resulting state of the member instead
+		-- of the ZADD count: the suffix of its set and its score, or nothing.
+		local function result(n)
+			if not REPORT then
+				return n
+			end
+			local ts = score(KEYS[1] .. 'INSERTSUFFIX')
+			if ts then
+				return {'INSERTSUFFIX', ts}
+			end
+			if not INSERTONLY then
+				ts = score(KEYS[1] .. 'DELETESUFFIX')
+				if ts then
+					return {'DELETESUFFIX', ts}
+				end
+			end
+			return {}
+		end
+
+		-- A maxSize of 0 means the key is uncapped.
+		local maxSize = tonumber(ARGV[3])
+		local keepOldest = ARGV[4] == 'oldest'
+		local atCapacity = maxSize > 0 and tonumber(redis.call('ZCARD', addKey)) >= maxSize
+		if atCapacity then
+			if keepOldest then
+				local newestTs = redis.call('ZRANGE', addKey, -1, -1, 'WITHSCORES')[2]
+				if newestTs and tonumber(ARGV[1]) > tonumber(newestTs) then
+					return result(-1)
+				end
+			else
+				local oldestTs = redis.call('ZRANGE', addKey, 0, 0, 'WITHSCORES')[2]
+				if oldestTs and tonumber(ARGV[1]) < tonumber(oldestTs) then
+					return result(-1)
+				end
+			end
+		end
+
+		local insertTs = score(KEYS[1] .. 'INSERTSUFFIX')
+		local deleteTs = nil
+		if not INSERTONLY then
+			deleteTs = score(KEYS[1] .. 'DELETESUFFIX')
+		end
+		if insertTs and tonumber(ARGV[1]) < tonumber(insertTs) then
+			return result(-1)
+		elseif deleteTs and tonumber(ARGV[1]) <= tonumber(deleteTs) then
+			return result(-1)
+		end
+
+		if alias ~= '' then
+			redis.call('ZREM', addKey, alias)
+			if not INSERTONLY then
+				redis.call('ZREM', remKey, alias)
+			end
+		end
+		if not INSERTONLY then
+			redis.call('ZREM', remKey, ARGV[2])
+		end
+		-- With CH, n counts updated scores as well as new members, so it's 0
+		-- only if nothing changed, and -1 above means the write was stale.
+		local n = redis.call('ZADD', addKey, 'CH', ARGV[1], ARGV[2])
+		if maxSize > 0 then
+			if keepOldest then
+				redis.call('ZREMRANGEBYRANK', addKey, maxSize, -1)
+			else
+				redis.call('ZREMRANGEBYRANK', addKey, 0, -(maxSize+1))
+			end
+		end
+		-- Tombstones well below the score of this write are unlikely to
+		-- meet a write they'd reject anymore, see WithTombstoneGrace.
+		local grace = tonumber(ARGV[6] or '0') or 0
+		if grace > 0 and not INSERTONLY then
+			local cutoff = tonumber(ARGV[1]) - grace
+			redis.call('ZREMRANGEBYSCORE', KEYS[1] .. 'DELETESUFFIX', '-inf', string.format('(%.17g', cutoff))
+		end
+		return result(n)
+	`
+
+// requiredScriptTokens are the placeholders and arguments which every
+// script must use, see DefaultScript.
+var requiredScriptTokens = []string{
+	"KEYS[1]", "ARGV[1]", "ARGV[2]", "ARGV[3]",
+	"ADDSUFFIX", "REMSUFFIX", "INSERTONLY", "REPORT",
+}
+
+// ValidateScript checks that a custom script uses the placeholders and
+// arguments of the contract documented with DefaultScript. It can't check
+// the semantics of the script, nor its syntax, which Redis checks when the
+// script is first run.
+func ValidateScript(script string) error {
+	missing := []string{}
+	for _, token := range requiredScriptTokens {
+		if !strings.Contains(script, token) {
+			missing = append(missing, token)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("script doesn't use %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// WithScript replaces DefaultScript with a custom script, which must honor
+// the contract documented with DefaultScript, e.g. to resolve conflicts by
+// a version embedded in the member. It's an advanced option: every process
+// writing to the cluster, including walkers, must use the same script.
+// WithScript returns the error of ValidateScript for an invalid script.
+// Syntax errors are only reported by Redis, when the script is loaded, e.g.
+// by Warm.
+func WithScript(script string) (Option, error) {
+	if err := ValidateScript(script); err != nil {
+		return nil, err
+	}
+	s := newScripts(script)
+	return func(c *cluster) { c.scripts = s }, nil
+}
+
+// scripts are the variants of a script template, see DefaultScript.
+type scripts struct {
+	insert              *redis.Script
+	insertOnly          *redis.Script // ignores the deletes key, see WithInsertOnly
+	insertReporting     *redis.Script // returns the resulting state, see InsertReporting
+	insertOnlyReporting *redis.Script
+	delete              *redis.Script
+}
+
+var defaultScripts = newScripts(DefaultScript)
+
+// all returns every variant, e.g. to load them.
+func (s *scripts) all() []*redis.Script {
+	return []*redis.Script{s.insert, s.insertOnly, s.insertReporting, s.insertOnlyReporting, s.delete}
+}
+
+func newScripts(template string) *scripts {
+	template = strings.NewReplacer(
+		"INSERTSUFFIX", insertSuffix,
+		"DELETESUFFIX", deleteSuffix,
+	).Replace(template)
+
+	return &scripts{
+		insert: redis.NewScript(1, strings.NewReplacer(
+			"REMSUFFIX", deleteSuffix, // Insert script does ZREM from deletes key
+			"ADDSUFFIX", insertSuffix, // and ZADD to inserts key
+			"INSERTONLY", "false",
+			"REPORT", "false",
+		).Replace(template)),
+
+		insertOnly: redis.NewScript(1, strings.NewReplacer(
+			"REMSUFFIX", deleteSuffix, // never used
+			"ADDSUFFIX", insertSuffix, // Insert-only script only does ZADD to inserts key
+			"INSERTONLY", "true",
+			"REPORT", "false",
+		).Replace(template)),
+
+		insertReporting: redis.NewScript(1, strings.NewReplacer(
+			"REMSUFFIX", deleteSuffix,
+			"ADDSUFFIX", insertSuffix,
+			"INSERTONLY", "false",
+			"REPORT", "true",
+		).Replace(template)),
+
+		insertOnlyReporting: redis.NewScript(1, strings.NewReplacer(
+			"REMSUFFIX", deleteSuffix,
+			"ADDSUFFIX", insertSuffix,
+			"INSERTONLY", "true",
+			"REPORT", "true",
+		).Replace(template)),
+
+		delete: redis.NewScript(1, strings.NewReplacer(
+			"REMSUFFIX", insertSuffix, // Delete script does ZREM from inserts key
+			"ADDSUFFIX", deleteSuffix, // and ZADD to deletes key
+			"INSERTONLY", "false",
+			"REPORT", "false",
+		).Replace(template)),
+	}
+}
+
+// trimTombstonesScript removes the tombstones of KEYS[1] with scores more
+// than ARGV[1] below the highest score of the key, in either set, see
+// TrimTombstones, and returns how many it removed.
+var trimTombstonesScript = redis.NewScript(1, strings.NewReplacer(
+	"INSERTSUFFIX", insertSuffix,
+	"DELETESUFFIX", deleteSuffix,
+).Replace(`
+		local newest = nil
+		for _, suffix in ipairs({'INSERTSUFFIX', 'DELETESUFFIX'}) do
+			local ts = redis.call('ZRANGE', KEYS[1] .. suffix, -1, -1, 'WITHSCORES')[2]
+			if ts and (not newest or tonumber(ts) > newest) then
+				newest = tonumber(ts)
+			end
+		end
+		if not newest then
+			return 0
+		end
+		local cutoff = newest - tonumber(ARGV[1])
+		return redis.call('ZREMRANGEBYSCORE', KEYS[1] .. 'DELETESUFFIX', '-inf', string.format('(%.17g', cutoff))
+	`))
+
+// mergeKeyFunction defines merge(dst, suffix, score, member), which writes a
+// member of a copied key to dst like DefaultScript: it loses to a higher
+// score in the insert set of dst, or to a higher or equal score in its
+// delete set. It returns 1 if the member was written, and 0 if it lost.
+const mergeKeyFunction = `
+	local function merge(dst, suffix, score, member)
+		local insertTs = redis.call('ZSCORE', dst .. 'INSERTSUFFIX', member)
+		local deleteTs = redis.call('ZSCORE', dst .. 'DELETESUFFIX', member)
+		if insertTs and tonumber(score) < tonumber(insertTs) then
+			return 0
+		elseif deleteTs and tonumber(score) <= tonumber(deleteTs) then
+			return 0
+		end
+		local other = 'DELETESUFFIX'
+		if suffix == 'DELETESUFFIX' then
+			other = 'INSERTSUFFIX'
+		end
+		redis.call('ZREM', dst .. other, member)
+		redis.call('ZADD', dst .. suffix, score, member)
+		return 1
+	end
+`
+
+// trimKeyFunction defines trim(dst, maxSize, policy), which trims both sets
+// of a copied key to maxSize, like writes trim the set they write to.
+const trimKeyFunction = `
+	local function trim(dst, maxSize, policy)
+		if maxSize <= 0 then
+			return
+		end
+		for _, suffix in ipairs({'INSERTSUFFIX', 'DELETESUFFIX'}) do
+			if policy == 'oldest' then
+				redis.call('ZREMRANGEBYRANK', dst .. suffix, maxSize, -1)
+			else
+				redis.call('ZREMRANGEBYRANK', dst .. suffix, 0, -(maxSize+1))
+			end
+		end
+	end
+`
+
+// copyKeyScript merges both sets of KEYS[1] into those of KEYS[2], which
+// must be on the same instance, see CopyKey. ARGV[1] is the maxSize of
+// KEYS[2], 0 if it's uncapped, and ARGV[2] is 'oldest' with KeepOldest. It
+// returns how many members it wrote.
+var copyKeyScript = redis.NewScript(2, strings.NewReplacer(
+	"INSERTSUFFIX", insertSuffix,
+	"DELETESUFFIX", deleteSuffix,
+).Replace(mergeKeyFunction+trimKeyFunction+`
+		local n = 0
+		for _, suffix in ipairs({'INSERTSUFFIX', 'DELETESUFFIX'}) do
+			local values = redis.call('ZRANGE', KEYS[1] .. suffix, 0, -1, 'WITHSCORES')
+			for i = 1, #values, 2 do
+				n = n + merge(KEYS[2], suffix, values[i+1], values[i])
+			end
+		end
+		trim(KEYS[2], tonumber(ARGV[1]), ARGV[2])
+		return n
+	`))
+
+// mergeKeyScript merges members read from another instance into both sets
+// of KEYS[1], see CopyKey. ARGV[1] and ARGV[2] are as with copyKeyScript,
+// and every following triple of arguments is the suffix of the set a member
+// was read from, its score, and the member as stored. It returns how many
+// members it wrote.
+var mergeKeyScript = redis.NewScript(1, strings.NewReplacer(
+	"INSERTSUFFIX", insertSuffix,
+	"DELETESUFFIX", deleteSuffix,
+).Replace(mergeKeyFunction+trimKeyFunction+`
+		local n = 0
+		for i = 3, #ARGV, 3 do
+			n = n + merge(KEYS[1], ARGV[i], ARGV[i+1], ARGV[i+2])
+		end
+		trim(KEYS[1], tonumber(ARGV[1]), ARGV[2])
+		return n
+	`))
+
+// readKeyScript returns both sets of KEYS[1] with scores, as one snapshot:
+// the insert set, then the delete set, see CopyKey.
+var readKeyScript = redis.NewScript(1, strings.NewReplacer(
+	"INSERTSUFFIX", insertSuffix,
+	"DELETESUFFIX", deleteSuffix,
+).Replace(`
+		return {
+			redis.call('ZRANGE', KEYS[1] .. 'INSERTSUFFIX', 0, -1, 'WITHSCORES'),
+			redis.call('ZRANGE', KEYS[1] .. 'DELETESUFFIX', 0, -1, 'WITHSCORES'),
+		}
+	`))
@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/pool"
+)
+
+func TestInsertScriptChanges(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	p := pool.New(strings.Split(addresses, ","), time.Second, time.Second, time.Second, 1, pool.Murmur3)
+	defer p.Close()
+
+	// The script returns how many members changed, or -1 for stale writes.
+	if err := p.WithIndex(p.Index("foo"), func(conn redis.Conn) error {
+		if _, err := conn.Do("DEL", "foo"+insertSuffix, "foo"+deleteSuffix); err != nil {
+			return err
+		}
+		for _, tc := range []struct {
+			name     string
+			script   *redis.Script
+			score    float64
+			expected int
+		}{
+			{"new member", defaultScripts.insert, 2, 1},
+			{"score bump", defaultScripts.insert, 3, 1},
+			{"same score", defaultScripts.insert, 3, 0},
+			{"stale insert", defaultScripts.insert, 1, -1},
+			{"delete", defaultScripts.delete, 4, 1},
+			{"stale delete", defaultScripts.delete, 4, -1},
+		} {
+			n, err := redis.Int(tc.script.Do(conn, "foo", tc.score, "a", 100, KeepNewest.scriptArg()))
+			if err != nil {
+				return err
+			}
+			if n != tc.expected {
+				t.Errorf("%s: expected %d, got %d", tc.name, tc.expected, n)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateScript(t *testing.T) {
+	if err := ValidateScript(DefaultScript); err != nil {
+		t.Errorf("DefaultScript: %s", err)
+	}
+	err := ValidateScript(strings.Replace(DefaultScript, "REPORT", "false", -1))
+	if err == nil || !strings.Contains(err.Error(), "REPORT") {
+		t.Errorf("expected an error about REPORT, got %v", err)
+	}
+
+	if option, err := WithScript("return 1"); option != nil || err == nil {
+		t.Error("expected WithScript to fail on an invalid script")
+	}
+}
+
+func TestWithScript(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	p := pool.New(strings.Split(addresses, ","), time.Second, time.Second, time.Second, 1, pool.Murmur3)
+	defer p.Close()
+
+	// A script where the first insert of a member wins.
+	stale := "if insertTs and tonumber(ARGV[1]) < tonumber(insertTs) then"
+	if !strings.Contains(DefaultScript, stale) {
+		t.Fatal("DefaultScript changed, update the test")
+	}
+	firstWins, err := WithScript(strings.Replace(DefaultScript, stale, "if insertTs then", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyMember := common.KeyMember{Key: "foo", Member: "a"}
+	for _, tc := range []struct {
+		name     string
+		options  []Option
+		expected float64
+	}{
+		{"default", nil, 2},
+		{"first insert wins", []Option{firstWins}, 1},
+	} {
+		if err := p.WithIndex(p.Index(keyMember.Key), func(conn redis.Conn) error {
+			_, err := conn.Do("DEL", keyMember.Key+insertSuffix, keyMember.Key+deleteSuffix)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		c := New(p, 100, 0, nil, tc.options...)
+		for _, score := range []float64{1, 2} {
+			if err := c.Insert([]common.KeyScoreMember{{Key: keyMember.Key, Score: score, Member: keyMember.Member}}); err != nil {
+				t.Fatalf("%s: %s", tc.name, err)
+			}
+		}
+		presence, err := c.Score([]common.KeyMember{keyMember})
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if expected, got := (Presence{Present: true, Inserted: true, Score: tc.expected}), presence[keyMember]; expected != got {
+			t.Errorf("%s: expected %v, got %v", tc.name, expected, got)
+		}
+	}
+}
+
+func TestWarm(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	p := pool.New(strings.Split(addresses, ","), time.Second, time.Second, time.Second, 2, pool.Murmur3)
+	defer p.Close()
+
+	for index := 0; index < p.Size(); index++ {
+		if err := p.WithIndex(index, func(conn redis.Conn) error {
+			_, err := conn.Do("SCRIPT", "FLUSH")
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := New(p, 100, 0, nil)
+	for id, err := range c.(Warmer).Warm() {
+		if err != nil {
+			t.Errorf("%s: %s", id, err)
+		}
+	}
+
+	for index := 0; index < p.Size(); index++ {
+		if err := p.WithIndex(index, func(conn redis.Conn) error {
+			args := []interface{}{"EXISTS"}
+			for _, script := range defaultScripts.all() {
+				args = append(args, script.Hash())
+			}
+			exists, err := redis.Ints(conn.Do("SCRIPT", args...))
+			if err != nil {
+				return err
+			}
+			for i, e := range exists {
+				if e != 1 {
+					t.Errorf("%s: script %d isn't loaded", p.ID(index), i)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Errorf("%s: %s", p.ID(index), err)
+		}
+	}
+}
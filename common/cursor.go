@@ -3,6 +3,7 @@ package common
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,9 +16,30 @@ import (
 type Cursor struct {
 	Score  float64
 	Member string
+
+	// ScoreOnly makes the cursor a bound by Score alone, and Member is
+	// ignored: a start cursor selects members with lower scores, and a stop
+	// cursor members with higher scores. Members at Score are excluded
+	// either way, like the member at a cursor with a member.
+	ScoreOnly bool
+}
+
+// ScoreCursor returns a score-only cursor, see Cursor.ScoreOnly.
+func ScoreCursor(score float64) Cursor {
+	return Cursor{Score: score, ScoreOnly: true}
 }
 
-const cursorFormat = `%dA%s` // uint64(float64bits(score)) "A" string(base64(member))
+// Cursors with a member are encoded compactly, as the prefix "B", followed
+// by the unpadded base64url of the big-endian uint64(float64bits(score)),
+// followed by the bytes of the member. The member is the rest of the
+// encoding, so it needs no length.
+const compactPrefix = "B"
+
+// Score-only cursors are just the decimal uint64(float64bits(score)).
+
+// Cursors with a member used to be encoded as the decimal
+// uint64(float64bits(score)), followed by "A", followed by the padded base64url
+// of the member. Parse still accepts them, as clients may have stored them.
 
 // The letter "A" was chosen as a field delimiter from among all characters
 // enumerated in IETF RFC 3986 section 2.2 after an exhaustive series of
@@ -27,33 +49,36 @@ const cursorFormat = `%dA%s` // uint64(float64bits(score)) "A" string(base64(mem
 // String returns a string representation of the cursor, suitable for
 // returning in responses.
 func (c Cursor) String() string {
-	var (
-		buf = bytes.Buffer{}
-		enc = base64.NewEncoder(base64.URLEncoding, &buf)
-	)
-
-	if _, err := enc.Write([]byte(c.Member)); err != nil {
-		panic(err)
-	}
-
-	if err := enc.Close(); err != nil {
-		panic(err)
-	}
-
-	return fmt.Sprintf(cursorFormat, math.Float64bits(c.Score), buf.String())
+	buf := bytes.Buffer{}
+	c.Encode(&buf)
+	return buf.String()
 }
 
+// Encode writes the string representation of the cursor to w.
 func (c Cursor) Encode(w io.Writer) {
-	fmt.Fprintf(w, "%dA", math.Float64bits(c.Score))
-	enc := base64.NewEncoder(base64.URLEncoding, w)
+	if c.ScoreOnly {
+		fmt.Fprintf(w, "%d", math.Float64bits(c.Score))
+		return
+	}
+	var score [8]byte
+	binary.BigEndian.PutUint64(score[:], math.Float64bits(c.Score))
+	io.WriteString(w, compactPrefix)
+	enc := base64.NewEncoder(base64.RawURLEncoding, w)
+	enc.Write(score[:])
 	enc.Write([]byte(c.Member))
 	enc.Close()
 }
 
-// Parse parses the cursor string into the Cursor object.
+// Parse parses the cursor string into the Cursor object. A string without
+// a member, i.e. just the score, is a score-only cursor. Both the compact
+// and the legacy encoding of cursors with a member are accepted.
 func (c *Cursor) Parse(s string) error {
+	if strings.HasPrefix(s, compactPrefix) {
+		return c.parseCompact(s)
+	}
+
 	fields := strings.SplitN(s, "A", 2)
-	if len(fields) != 2 {
+	if fields[0] == "" {
 		return fmt.Errorf("invalid cursor string (%s)", s)
 	}
 
@@ -62,13 +87,42 @@ func (c *Cursor) Parse(s string) error {
 		return fmt.Errorf("invalid score in cursor string (%s)", err)
 	}
 
-	decoded, err := ioutil.ReadAll(base64.NewDecoder(base64.URLEncoding, bytes.NewReader([]byte(fields[1]))))
-	if err != nil {
-		return fmt.Errorf("invalid member in cursor string (%s)", err)
+	var decoded []byte
+	if len(fields) == 2 {
+		decoded, err = ioutil.ReadAll(base64.NewDecoder(base64.URLEncoding, bytes.NewReader([]byte(fields[1]))))
+		if err != nil {
+			return fmt.Errorf("invalid member in cursor string (%s)", err)
+		}
+	}
+
+	if err := CheckScore(math.Float64frombits(score)); err != nil {
+		return fmt.Errorf("invalid score in cursor string (%s)", err)
 	}
 
 	c.Score = math.Float64frombits(score)
 	c.Member = string(decoded)
+	c.ScoreOnly = len(fields) == 1
+
+	return nil
+}
+
+func (c *Cursor) parseCompact(s string) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, compactPrefix))
+	if err != nil {
+		return fmt.Errorf("invalid cursor string (%s)", err)
+	}
+	if len(decoded) < 8 {
+		return fmt.Errorf("invalid cursor string (%s)", s)
+	}
+
+	score := math.Float64frombits(binary.BigEndian.Uint64(decoded[:8]))
+	if err := CheckScore(score); err != nil {
+		return fmt.Errorf("invalid score in cursor string (%s)", err)
+	}
+
+	c.Score = score
+	c.Member = string(decoded[8:])
+	c.ScoreOnly = false
 
 	return nil
 }
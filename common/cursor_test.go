@@ -2,8 +2,11 @@ package common
 
 import (
 	"bytes"
+	"encoding/base64"
+	"fmt"
 	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -14,6 +17,8 @@ func TestCursorSerialization(t *testing.T) {
 		Cursor{Score: 1.1, Member: `%20`},
 		Cursor{Score: 123.456, Member: "abc"},
 		Cursor{Score: 0.00001, Member: "foo\x00bar"}, // catch missing enc.Close()
+		Cursor{Score: 42, ScoreOnly: true},
+		Cursor{Score: -1.5, ScoreOnly: true},
 	} {
 		var (
 			s   = cursor.String()
@@ -106,3 +111,88 @@ func BenchmarkCursorEncode(b *testing.B) {
 
 	b.ReportAllocs()
 }
+
+func TestCursorParseScoreOnly(t *testing.T) {
+	c := Cursor{Score: 1, Member: "foo"}
+	if err := c.Parse("4631107791820423168"); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := ScoreCursor(42), c; want != have {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+
+	// A cursor with an empty member isn't score-only.
+	if err := c.Parse("4631107791820423168A"); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (Cursor{Score: 42}), c; want != have {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+
+	var buf bytes.Buffer
+	ScoreCursor(42).Encode(&buf)
+	if want, have := ScoreCursor(42).String(), buf.String(); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	for _, s := range []string{"", "A", "fooA"} {
+		if err := c.Parse(s); err == nil {
+			t.Errorf("%q: expected error, got %+v", s, c)
+		}
+	}
+}
+
+func TestCursorParseNonFinite(t *testing.T) {
+	for _, score := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		var c Cursor
+		if err := c.Parse(Cursor{Score: score, Member: "foo"}.String()); err == nil {
+			t.Errorf("%v: expected error, got %+v", score, c)
+		}
+	}
+}
+
+func TestCursorCompact(t *testing.T) {
+	for _, cursor := range []Cursor{
+		{Score: math.MaxFloat64, Member: "foo"},
+		{Score: -math.MaxFloat64, Member: "foo"},
+		{Score: math.SmallestNonzeroFloat64, Member: "foo"},
+		{Score: 1.4e9, Member: ""},
+		{Score: 0, Member: "\x00\xff\xfe B"},
+		{Score: 1.23, Member: "A"},
+	} {
+		s := cursor.String()
+		if !strings.HasPrefix(s, "B") {
+			t.Errorf("%#+v: expected the compact encoding, got %q", cursor, s)
+		}
+		var got Cursor
+		if err := got.Parse(s); err != nil {
+			t.Errorf("%#+v: serialized to %q, parse failed: %s", cursor, s, err)
+			continue
+		}
+		if got != cursor {
+			t.Errorf("%#+v: serialized to %q, parsed back to %#+v", cursor, s, got)
+		}
+
+		// The legacy encoding is still parsed. It's longer for all but tiny
+		// scores, whose bits have few decimal digits.
+		legacy := fmt.Sprintf("%dA%s", math.Float64bits(cursor.Score), base64.URLEncoding.EncodeToString([]byte(cursor.Member)))
+		if math.Abs(cursor.Score) >= 1 && len(s) >= len(legacy) {
+			t.Errorf("%#+v: compact %q isn't shorter than legacy %q", cursor, s, legacy)
+		}
+		got = Cursor{}
+		if err := got.Parse(legacy); err != nil {
+			t.Errorf("%#+v: legacy %q, parse failed: %s", cursor, legacy, err)
+			continue
+		}
+		if got != cursor {
+			t.Errorf("%#+v: legacy %q, parsed back to %#+v", cursor, legacy, got)
+		}
+	}
+
+	for _, s := range []string{"B", "BAAAA", "B!!!", "B" + base64.RawURLEncoding.EncodeToString([]byte{0x7f, 0xf8, 0, 0, 0, 0, 0, 1})} {
+		var c Cursor
+		if err := c.Parse(s); err == nil {
+			t.Errorf("%q: expected an error, got %#+v", s, c)
+		}
+	}
+}
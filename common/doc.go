@@ -1,2 +1,7 @@
 // Package common holds data structures shared between different packages.
+//
+// Keys and members are arbitrary byte sequences, including null bytes and
+// invalid UTF-8, and round-trip exactly through every layer: the Redis
+// commands and scripts of package cluster, the JSON encoding of
+// KeyScoreMember and Keys, and the encoding of Cursor.
 package common
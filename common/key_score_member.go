@@ -2,10 +2,12 @@ package common
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 )
 
-// KeyMember is used by the Score method, and other places internally. It's
-// probably not useful to external users.
+// KeyMember identifies a member of a key. It's used by the Score method, and
+// other places internally.
 type KeyMember struct {
 	Key    string
 	Member string
@@ -26,6 +28,25 @@ func (ksm KeyScoreMember) Cursor() Cursor {
 	}
 }
 
+// ScoreError is returned for scores which can't be stored: NaN, which Redis
+// rejects, and positive or negative infinity, which would sort beyond every
+// range and cursor a client can express.
+type ScoreError struct {
+	Score float64
+}
+
+func (e ScoreError) Error() string {
+	return fmt.Sprintf("invalid score %v (must be finite)", e.Score)
+}
+
+// CheckScore returns a ScoreError if the score is NaN or infinite.
+func CheckScore(score float64) error {
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return ScoreError{Score: score}
+	}
+	return nil
+}
+
 // jsonKeyScoreMember is used internally by MarshalJSON and UnmarshalJSON.
 type jsonKeyScoreMember struct {
 	Key    []byte  `json:"key"`
@@ -59,3 +80,62 @@ func (ksm *KeyScoreMember) UnmarshalJSON(data []byte) error {
 	}
 	return err
 }
+
+// jsonKeyMember is used internally by MarshalJSON and UnmarshalJSON.
+type jsonKeyMember struct {
+	Key    []byte `json:"key"`
+	Member []byte `json:"member"`
+}
+
+// MarshalJSON marshals the key and member as byte sequences, like those of
+// KeyScoreMember.
+func (km KeyMember) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&jsonKeyMember{
+		Key:    []byte(km.Key),
+		Member: []byte(km.Member),
+	})
+}
+
+// UnmarshalJSON unmarshals the key and member from byte sequences, like
+// those of KeyScoreMember.
+func (km *KeyMember) UnmarshalJSON(data []byte) error {
+	var jsonKM jsonKeyMember
+	err := json.Unmarshal(data, &jsonKM)
+	if err == nil {
+		km.Key = string(jsonKM.Key)
+		km.Member = string(jsonKM.Member)
+	}
+	return err
+}
+
+// Keys is a list of keys. Like the keys of KeyScoreMember, they are
+// marshalled to JSON as byte sequences, i.e. base64 encoded strings. It's
+// the JSON body of a Select.
+type Keys []string
+
+// MarshalJSON marshals the keys as an array of byte sequences.
+func (k Keys) MarshalJSON() ([]byte, error) {
+	a := make([][]byte, len(k))
+	for i, key := range k {
+		a[i] = []byte(key)
+	}
+	return json.Marshal(a)
+}
+
+// UnmarshalJSON unmarshals the keys from an array of byte sequences.
+func (k *Keys) UnmarshalJSON(data []byte) error {
+	var a [][]byte
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	if a == nil {
+		*k = nil
+		return nil
+	}
+	keys := make(Keys, len(a))
+	for i, key := range a {
+		keys[i] = string(key)
+	}
+	*k = keys
+	return nil
+}
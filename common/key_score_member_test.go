@@ -1,6 +1,8 @@
 package common
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -11,3 +13,41 @@ func TestMarshal(t *testing.T) {
 func TestUnmarshal(t *testing.T) {
 	// TODO
 }
+
+func TestKeysJSON(t *testing.T) {
+	keys := Keys{"foo", "", string([]byte{0, 255, 0xc0, '\r', '\n'})}
+	data, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := `["Zm9v","","AP/ADQo="]`, string(data); expected != got {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	var got Keys
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, got) {
+		t.Errorf("expected %q, got %q", keys, got)
+	}
+}
+
+func TestKeyMemberJSON(t *testing.T) {
+	keyMember := KeyMember{Key: "foo", Member: string([]byte{0, 255})}
+	data, err := json.Marshal(keyMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := `{"key":"Zm9v","member":"AP8="}`, string(data); expected != got {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	var got KeyMember
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if keyMember != got {
+		t.Errorf("expected %q, got %q", keyMember, got)
+	}
+}
@@ -0,0 +1,15 @@
+package common
+
+// Order is the direction in which an offset-based select walks the members
+// of a key.
+type Order int
+
+const (
+	// Descending selects members by descending score, i.e. newest first, as
+	// ZREVRANGE does. It's the zero value.
+	Descending Order = iota
+
+	// Ascending selects members by ascending score, i.e. oldest first, as
+	// ZRANGE does.
+	Ascending
+)
@@ -2,28 +2,287 @@
 package farm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/soundcloud/roshi/cluster"
 	"github.com/soundcloud/roshi/common"
 	"github.com/soundcloud/roshi/instrumentation"
+	"github.com/soundcloud/roshi/pool"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 // Farm implements CRDT-semantic ZSET methods over many clusters.
 type Farm struct {
 	clusters        []cluster.Cluster
 	writeQuorum     int
+	prefixQuorums   map[string]int // key prefix: write quorum
 	selecter        Selecter
 	repairStrategy  coreRepairStrategy
 	instrumentation instrumentation.Instrumentation
+	maxSelectKeys   int
+	failFast        bool
+	randMtx         sync.Mutex
+	rand            *rand.Rand // guarded by randMtx
+	selectCache     *selectCache
+	maxLinger       time.Duration
+	grace           time.Duration // after the first responses, 0 to disable
+	maxRepairs      int           // per Select, 0 for no limit
+	tolerance       scoreTolerance
+	timestampUnit   time.Duration // of scores, for RepairKeys, 0 if unknown
+	weights         []float64     // per cluster, nil for uniform picks
+	cumWeights      []float64     // running sums of weights
+	readOnly        bool
+	repairObserver  func(key string, diverged int) // nil to disable
+	readErrors      float64                        // tolerated fraction of failed clusters per key
+}
+
+// DefaultMaxSelectKeys is the default maximum number of keys in a single
+// Select request. See WithMaxSelectKeys.
+const DefaultMaxSelectKeys = 10000
+
+// Option changes the default behavior of a Farm.
+type Option func(*Farm)
+
+// WithMaxSelectKeys limits the number of keys in a single Select request.
+// Requests with more keys are rejected with a TooManyKeysError, without
+// being sent to any cluster. The default is DefaultMaxSelectKeys. A
+// non-positive n removes the limit.
+func WithMaxSelectKeys(n int) Option {
+	return func(f *Farm) { f.maxSelectKeys = n }
+}
+
+// WithFailFast makes Inserts and Deletes fail immediately, without
+// contacting any cluster, when fewer than write quorum clusters are
+// reachable for the written keys, according to their last health checks.
+// Clusters which don't implement cluster.HealthReporter are assumed to be
+// reachable, so the default behavior of attempting every cluster applies
+// until the clusters are health checked. See cluster.CheckInstances.
+func WithFailFast() Option {
+	return func(f *Farm) { f.failFast = true }
+}
+
+// WithRand sets the source of randomness of the Farm, which read strategies
+// use to pick clusters, e.g. SendOneReadOne. Tests may pass a rand with a
+// fixed seed, to make the picks deterministic. The default is seeded with
+// the current time. The Farm takes ownership of r.
+func WithRand(r *rand.Rand) Option {
+	return func(f *Farm) { f.rand = r }
+}
+
+// WithSelectCache enables an in-process cache of SelectOffset results, to
+// take load off the clusters for hot keys. Results are cached per key,
+// offset, limit and order, for up to ttl, and at most size results are
+// kept. Only complete responses are cached. Inserts and Deletes through
+// this Farm drop the cached results of their keys, but writes through other
+// Farms, e.g. other roshi-server instances, or repairs, do not. That is,
+// SelectOffset may return results up to ttl old, and doesn't necessarily
+// reflect earlier writes. SelectRange isn't cached. The cache is disabled
+// by default, and if size or ttl is non-positive.
+func WithSelectCache(size int, ttl time.Duration) Option {
+	return func(f *Farm) {
+		if size <= 0 || ttl <= 0 {
+			f.selectCache = nil
+			return
+		}
+		f.selectCache = newSelectCache(size, ttl)
+	}
+}
+
+// WithMaxLinger bounds how long the linger phase of SendAllReadFirstLinger
+// and SendVarReadFirstLinger waits for slow clusters after a read returned.
+// When d has passed, the lingering goroutine stops collecting responses, and
+// issues repairs with what it got so far. Responses arriving later are
+// discarded. This bounds the number of lingering goroutines when clusters
+// are slow, e.g. during a partial outage. The default, and a non-positive d,
+// means lingering until every cluster responded.
+func WithMaxLinger(d time.Duration) Option {
+	return func(f *Farm) { f.maxLinger = d }
+}
+
+// WithFirstResponseGrace makes SendAllReadFirstLinger and
+// SendVarReadFirstLinger wait up to d longer for the other clusters, once
+// every key got its first response, if the read went to all clusters. The
+// responses which arrive within d are merged into the result, as with
+// SendAllReadAll. Without it, the fastest cluster alone answers the read,
+// which returns stale data if that cluster is behind, but responds quickly.
+// The grace trades up to d of latency for fresher results; it ends early if
+// every cluster responded. Reads which went to a single cluster return right
+// away. The default, and a non-positive d, means no grace.
+func WithFirstResponseGrace(d time.Duration) Option {
+	return func(f *Farm) { f.grace = d }
+}
+
+// WithMaxRepairsPerSelect caps the number of keyMembers a single Select
+// requests to repair. A Select of a large key on an empty or far behind
+// cluster finds every member of the key inconsistent, and would enqueue all
+// of them at once, which may overflow the buffer of a Nonblocking repair
+// strategy. With the cap, the rest of the keyMembers are left to the walker,
+// or to later Selects, and counted as SelectRepairTruncated. Which
+// keyMembers are kept is arbitrary. The default, and a non-positive n, means
+// no cap.
+func WithMaxRepairsPerSelect(n int) Option {
+	return func(f *Farm) { f.maxRepairs = n }
+}
+
+// WithScoreTolerance makes the Farm treat scores as equal when they differ
+// by at most absolute, or by at most relative times the larger magnitude of
+// the two. Use it when clients write fractional timestamps, which clusters
+// may store with slightly different floats, e.g. after a round trip through
+// another serialization. Without tolerance, such scores are inconsistent,
+// and read repairs of them may never settle.
+//
+// The tolerance applies to finding inconsistencies during Selects and
+// RepairKeys, and to deciding which clusters RepairKeys writes to. Configure
+// read repairs identically, with RepairScoreTolerance. Selects still return
+// the highest score, and it doesn't change which write wins in a cluster.
+// The default, and zero for both, means exact comparison.
+func WithScoreTolerance(absolute, relative float64) Option {
+	return func(f *Farm) { f.tolerance.absolute, f.tolerance.relative = absolute, relative }
+}
+
+// WithTimestampScores declares that scores are Unix timestamps in units of
+// unit, e.g. time.Millisecond, so that RepairKeys reports how stale the
+// clusters it writes to were, like RepairTimestampScores does for read
+// repairs. By default, or with a unit of zero or less, scores aren't
+// interpreted.
+func WithTimestampScores(unit time.Duration) Option {
+	return func(f *Farm) { f.timestampUnit = unit }
+}
+
+// WithClusterWeights biases the random picks of read strategies which read
+// from some clusters only, i.e. SendOneReadOne, SendKReadAll, and
+// SendVarReadFirstLinger without a permit to send to all clusters, toward
+// clusters with higher weights, e.g. to send more reads to clusters with
+// more capacity. Each cluster is picked with a probability proportional to
+// its weight. There must be a weight for every cluster, in the order of the
+// clusters passed to New, and none may be negative. A cluster with weight 0
+// is never picked, but still written to, and read by strategies which read
+// all clusters, or fall back to them. New panics if the weights don't match
+// the clusters, or are all 0, see Validate. By default, clusters are picked
+// uniformly.
+func WithClusterWeights(weights ...float64) Option {
+	return func(f *Farm) { f.weights = weights }
+}
+
+// WithRepairObserver calls observe with every key for which a Select
+// requests repairs, and the number of its members which were inconsistent,
+// e.g. so that the walker can log the keys it repaired. It's called before
+// WithMaxRepairsPerSelect truncates the repairs, regardless of the repair
+// strategy, from the goroutine which requests them, which may be lingering
+// after the Select returned. Concurrent Selects call it concurrently, so
+// observe must be safe for concurrent use, and should be fast. By default,
+// repairs aren't observed.
+func WithRepairObserver(observe func(key string, diverged int)) Option {
+	return func(f *Farm) { f.repairObserver = observe }
+}
+
+// WithPrefixQuorums overrides the write quorum passed to New for keys with the
+// given prefixes, e.g. to require every cluster for billing events, but only
+// one for feeds. If several prefixes match a key, the longest wins. Inserts
+// and Deletes which mix keys with different quorums wait until every one of
+// them is reached, or every cluster replied, and fail with a QuorumError if
+// any is lost, even though the tuples of keys with lower quorums may have
+// reached theirs; retrying them is safe. CopyKey uses the quorum of the destination key. Every
+// quorum must be between 1 and the number of clusters, see Validate.
+func WithPrefixQuorums(quorums map[string]int) Option {
+	return func(f *Farm) { f.prefixQuorums = quorums }
+}
+
+// WithReadErrorTolerance makes SendAllReadAll and SendKReadAll reads fail
+// with a TooDegradedError if, for any key, more than fraction of the
+// clusters they read failed or were skipped as unreachable, even though the
+// others answered, e.g. to fail rather than serve a read backed by a single
+// cluster. Repairs are still requested from the clusters which answered. The
+// other read strategies return as soon as one cluster answered for every
+// key, and aren't affected. The default, 1, tolerates any number of failed
+// clusters, as long as one answered for every key, and marks the response
+// as incomplete. The fraction must be between 0 and 1, see Validate.
+func WithReadErrorTolerance(fraction float64) Option {
+	return func(f *Farm) { f.readErrors = fraction }
+}
+
+// WithReadOnly guarantees that the farm never writes to its clusters, e.g.
+// for an analytics deployment reading a replica: the repair strategy passed
+// to New is replaced by NoRepairs, and Inserts, Deletes, CopyKey, and
+// RepairKeys fail with ErrReadOnly.
+func WithReadOnly() Option {
+	return func(f *Farm) { f.readOnly = true }
+}
+
+// TooManyKeysError is returned by Select methods when a request contains
+// more keys than permitted.
+type TooManyKeysError struct {
+	Keys, Max int
+}
+
+func (e TooManyKeysError) Error() string {
+	return fmt.Sprintf("too many keys in select (%d, max %d)", e.Keys, e.Max)
+}
+
+// TooDegradedError is returned by Select methods when more clusters failed
+// for a key than tolerated, see WithReadErrorTolerance.
+type TooDegradedError struct {
+	Key              string
+	Failed, Clusters int
+}
+
+func (e TooDegradedError) Error() string {
+	return fmt.Sprintf("too degraded: %d of %d cluster(s) failed for key %q", e.Failed, e.Clusters, e.Key)
+}
+
+var (
+	// ErrNoClusters is returned by Validate, and reads of a Farm, if it has
+	// no clusters.
+	ErrNoClusters = errors.New("no clusters")
+
+	// ErrNoReadClusters is returned by Validate if no cluster can serve
+	// single-cluster reads, i.e. every cluster weight is 0.
+	ErrNoReadClusters = errors.New("no cluster can serve single-cluster reads: all cluster weights are 0")
+
+	// ErrReadOnly is returned by writes of a read-only Farm, see
+	// WithReadOnly.
+	ErrReadOnly = errors.New("farm is read-only; writes are disabled")
+)
+
+// Validate checks that New accepts the clusters and options: there must be
+// at least one cluster, with WithClusterWeights, a valid weight for every
+// cluster, not all 0, with WithPrefixQuorums, quorums which the clusters can
+// reach, and with WithReadErrorTolerance, a fraction between 0 and 1. Use it
+// to report a misconfiguration as an error, rather than the panic of New.
+func Validate(clusters []cluster.Cluster, options ...Option) error {
+	f := &Farm{}
+	for _, option := range options {
+		option(f)
+	}
+	_, err := f.validate(clusters)
+	return err
+}
+
+// validate checks the clusters against the options applied to f, and
+// returns the cumulative weights, if any.
+func (f *Farm) validate(clusters []cluster.Cluster) ([]float64, error) {
+	if len(clusters) <= 0 {
+		return nil, ErrNoClusters
+	}
+	for prefix, quorum := range f.prefixQuorums {
+		if quorum <= 0 || quorum > len(clusters) {
+			return nil, fmt.Errorf("write quorum %d of key prefix %q must be between 1 and %d", quorum, prefix, len(clusters))
+		}
+	}
+	if !(f.readErrors >= 0 && f.readErrors <= 1) {
+		return nil, fmt.Errorf("read error tolerance %v must be between 0 and 1", f.readErrors)
+	}
+	if f.weights == nil {
+		return nil, nil
+	}
+	return cumulativeWeights(f.weights, len(clusters))
 }
 
 // New creates and returns a new Farm.
@@ -35,13 +294,15 @@ type Farm struct {
 //
 // The repair strategy will only issue repairs against the read clusters.
 //
-// Instrumentation may be nil; all other parameters are required.
+// Instrumentation may be nil; all other parameters are required. Options may
+// be used to change the default behavior. New panics if Validate fails.
 func New(
 	clusters []cluster.Cluster,
 	writeQuorum int,
 	readStrategy ReadStrategy,
 	repairStrategy RepairStrategy,
 	instr instrumentation.Instrumentation,
+	options ...Option,
 ) *Farm {
 	if instr == nil {
 		instr = instrumentation.NopInstrumentation{}
@@ -49,67 +310,428 @@ func New(
 	farm := &Farm{
 		clusters:        clusters,
 		writeQuorum:     writeQuorum,
-		repairStrategy:  repairStrategy(clusters, instr),
 		instrumentation: instr,
+		maxSelectKeys:   DefaultMaxSelectKeys,
+		readErrors:      1,
+		rand:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, option := range options {
+		option(farm)
+	}
+	cumWeights, err := farm.validate(clusters)
+	if err != nil {
+		panic(err)
 	}
+	farm.cumWeights = cumWeights
+	if farm.readOnly {
+		repairStrategy = NoRepairs
+	}
+	farm.repairStrategy = repairStrategy(clusters, instr)
 	farm.selecter = readStrategy(farm)
 	return farm
 }
 
 // Insert adds each tuple into each underlying cluster, if the scores are
 // greater than the already-stored scores. As long as over half of the clusters
-// succeed to write all tuples, the overall write succeeds.
+// succeed to write all tuples, the overall write succeeds. If any score is
+// NaN or infinite, nothing is written, and a common.ScoreError is returned.
 func (f *Farm) Insert(tuples []common.KeyScoreMember) error {
+	return f.InsertContext(context.Background(), tuples)
+}
+
+// InsertContext satisfies cluster.ContextInserter. It inserts like Insert,
+// but returns ctx.Err() if ctx is done before the outcome is known, i.e.
+// before write quorum is reached, or, if it's lost, before every cluster
+// replied. In that case, the writes which are
+// still running are abandoned, in clusters which implement
+// cluster.ContextInserter, so that they don't hold on to connections and
+// goroutines. Once the outcome is known, the remaining writes run to
+// completion regardless of ctx, like with Insert.
+func (f *Farm) InsertContext(ctx context.Context, tuples []common.KeyScoreMember) error {
 	return f.write(
+		ctx,
 		tuples,
-		func(c cluster.Cluster, a []common.KeyScoreMember) error { return c.Insert(a) },
+		func(ctx context.Context, c cluster.Cluster, a []common.KeyScoreMember) error {
+			if ci, ok := c.(cluster.ContextInserter); ok {
+				return ci.InsertContext(ctx, a)
+			}
+			return c.Insert(a)
+		},
 		insertInstrumentation{f.instrumentation},
 	)
 }
 
+// InsertReporting satisfies cluster.InsertReporter. It writes like Insert,
+// and returns the resulting presence of each key-member, according to the
+// clusters which succeeded before quorum was reached. Like a repair, it takes
+// the highest score, and a delete wins over an insert with the same score.
+// Every cluster must implement cluster.InsertReporter.
+func (f *Farm) InsertReporting(tuples []common.KeyScoreMember) (map[common.KeyMember]cluster.Presence, error) {
+	for i, c := range f.clusters {
+		if _, ok := c.(cluster.InsertReporter); !ok {
+			return map[common.KeyMember]cluster.Presence{}, fmt.Errorf("cluster %d doesn't support insert reporting", i)
+		}
+	}
+
+	var (
+		mtx      sync.Mutex
+		presence = make(map[common.KeyMember]cluster.Presence, len(tuples))
+	)
+	if err := f.write(
+		context.Background(),
+		tuples,
+		func(_ context.Context, c cluster.Cluster, a []common.KeyScoreMember) error {
+			m, err := c.(cluster.InsertReporter).InsertReporting(a)
+			if err != nil {
+				return err
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			for keyMember, p := range m {
+				presence[keyMember] = mergePresence(presence[keyMember], p)
+			}
+			return nil
+		},
+		insertInstrumentation{f.instrumentation},
+	); err != nil {
+		return map[common.KeyMember]cluster.Presence{}, err
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	result := make(map[common.KeyMember]cluster.Presence, len(presence))
+	for keyMember, p := range presence {
+		result[keyMember] = p
+	}
+	return result, nil
+}
+
+// mergePresence returns the winning presence of a key-member in two clusters:
+// the one with the higher score, or the delete if the scores are equal.
+func mergePresence(a, b cluster.Presence) cluster.Presence {
+	switch {
+	case !a.Present:
+		return b
+	case !b.Present:
+		return a
+	case a.Score != b.Score:
+		if a.Score > b.Score {
+			return a
+		}
+		return b
+	case !a.Inserted:
+		return a
+	default:
+		return b
+	}
+}
+
 // Selecter defines a synchronous Select API, implemented by Farm.
 type Selecter interface {
-	SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error)
+	SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error)
 	SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error)
 }
 
+// CompletenessSelecter is a Selecter which can additionally report whether a
+// response is complete, i.e. whether all targeted clusters responded without
+// error. Incomplete responses are still valid, but may be stale or partial.
+// All built-in ReadStrategies yield a CompletenessSelecter.
+type CompletenessSelecter interface {
+	Selecter
+	SelectOffsetComplete(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error)
+	SelectRangeComplete(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error)
+}
+
 // SelectOffset satisfies Selecter and invokes the ReadStrategy of the farm.
-func (f *Farm) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+func (f *Farm) SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := f.SelectOffsetComplete(keys, offset, limit, order)
+	return response, err
+}
+
+// SelectRange satisfies Selecter and invokes the ReadStrategy of the farm.
+func (f *Farm) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := f.SelectRangeComplete(keys, start, stop, limit)
+	return response, err
+}
+
+// SelectOffsetComplete satisfies CompletenessSelecter and invokes the
+// ReadStrategy of the farm. If the ReadStrategy can't report completeness,
+// responses are assumed to be complete.
+func (f *Farm) SelectOffsetComplete(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
 	// High performance optimization.
 	if len(keys) <= 0 {
-		return map[string][]common.KeyScoreMember{}, nil
+		return map[string][]common.KeyScoreMember{}, true, nil
 	}
-	return f.selecter.SelectOffset(keys, offset, limit)
+	if f.maxSelectKeys > 0 && len(keys) > f.maxSelectKeys {
+		return map[string][]common.KeyScoreMember{}, false, TooManyKeysError{Keys: len(keys), Max: f.maxSelectKeys}
+	}
+	if f.selectCache == nil {
+		response, complete, err := selectOffsetComplete(f.selecter, keys, offset, limit, order)
+		if err == nil {
+			f.reportOffsetPastEnd(keys, offset, response)
+		}
+		return response, complete, err
+	}
+
+	q := cacheQuery{offset: offset, limit: limit, order: order}
+	hits, misses, seq := f.selectCache.get(keys, q, time.Now())
+	f.instrumentation.SelectCacheHit(len(keys) - len(misses))
+	f.instrumentation.SelectCacheMiss(len(misses))
+	if len(misses) <= 0 {
+		f.reportOffsetPastEnd(keys, offset, hits)
+		return hits, true, nil
+	}
+
+	response, complete, err := selectOffsetComplete(f.selecter, misses, offset, limit, order)
+	if err != nil || !complete {
+		f.selectCache.put(misses, q, seq, nil, time.Now())
+	} else {
+		f.selectCache.put(misses, q, seq, response, time.Now())
+	}
+	if err != nil {
+		return response, complete, err
+	}
+	for key, ksms := range hits {
+		response[key] = ksms
+	}
+	f.reportOffsetPastEnd(keys, offset, response)
+	return response, complete, nil
 }
 
-// SelectRange satisfies Selecter and invokes the ReadStrategy of the farm.
-func (f *Farm) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+// reportOffsetPastEnd instruments how many keys of an offset-based select
+// with a positive offset came back without members, i.e. were paged at or
+// past their end. It's purely diagnostic: offset 0 is never counted, as an
+// empty key there is merely empty.
+func (f *Farm) reportOffsetPastEnd(keys []string, offset int, response map[string][]common.KeyScoreMember) {
+	if offset <= 0 {
+		return
+	}
+	n := 0
+	for _, key := range keys {
+		if len(response[key]) <= 0 {
+			n++
+		}
+	}
+	if n > 0 {
+		f.instrumentation.SelectOffsetPastEnd(n)
+	}
+}
+
+// selectOffsetComplete invokes the selecter of a ReadStrategy, assuming
+// responses to be complete if it can't report completeness.
+func selectOffsetComplete(selecter Selecter, keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	if s, ok := selecter.(CompletenessSelecter); ok {
+		return s.SelectOffsetComplete(keys, offset, limit, order)
+	}
+	response, err := selecter.SelectOffset(keys, offset, limit, order)
+	return response, err == nil, err
+}
+
+// SelectRangeComplete satisfies CompletenessSelecter and invokes the
+// ReadStrategy of the farm. If the ReadStrategy can't report completeness,
+// responses are assumed to be complete.
+func (f *Farm) SelectRangeComplete(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
 	// High performance optimization.
 	if len(keys) <= 0 {
-		return map[string][]common.KeyScoreMember{}, nil
+		return map[string][]common.KeyScoreMember{}, true, nil
+	}
+	if f.maxSelectKeys > 0 && len(keys) > f.maxSelectKeys {
+		return map[string][]common.KeyScoreMember{}, false, TooManyKeysError{Keys: len(keys), Max: f.maxSelectKeys}
 	}
-	return f.selecter.SelectRange(keys, start, stop, limit)
+	return selectRangeComplete(f.selecter, keys, start, stop, limit)
+}
+
+// selectRangeComplete is the SelectRange counterpart of
+// selectOffsetComplete.
+func selectRangeComplete(selecter Selecter, keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	if s, ok := selecter.(CompletenessSelecter); ok {
+		return s.SelectRangeComplete(keys, start, stop, limit)
+	}
+	response, err := selecter.SelectRange(keys, start, stop, limit)
+	return response, err == nil, err
+}
+
+// Tombstoned satisfies cluster.Tombstoner, by asking every cluster which
+// implements it. A key is tombstoned if any cluster has seen deletes for it,
+// so that a cluster which is missing the deletes can't hide them. Clusters
+// which fail are ignored, unless all of them fail.
+func (f *Farm) Tombstoned(keys []string) (map[string]bool, error) {
+	if len(keys) <= 0 {
+		return map[string]bool{}, nil
+	}
+	if f.maxSelectKeys > 0 && len(keys) > f.maxSelectKeys {
+		return map[string]bool{}, TooManyKeysError{Keys: len(keys), Max: f.maxSelectKeys}
+	}
+
+	// Scatter
+	type response struct {
+		tombstoned map[string]bool
+		err        error
+	}
+	responses := make(chan response, len(f.clusters))
+	asked := 0
+	for _, c := range f.clusters {
+		t, ok := c.(cluster.Tombstoner)
+		if !ok {
+			continue
+		}
+		asked++
+		go func() {
+			tombstoned, err := t.Tombstoned(keys)
+			responses <- response{tombstoned, err}
+		}()
+	}
+	if asked <= 0 {
+		return map[string]bool{}, fmt.Errorf("no cluster supports tombstone lookups")
+	}
+
+	// Gather
+	var (
+		tombstoned = make(map[string]bool, len(keys))
+		errors     = []string{}
+	)
+	for _, key := range keys {
+		tombstoned[key] = false
+	}
+	for i := 0; i < asked; i++ {
+		response := <-responses
+		if response.err != nil {
+			errors = append(errors, response.err.Error())
+			continue
+		}
+		for key, ok := range response.tombstoned {
+			tombstoned[key] = tombstoned[key] || ok
+		}
+	}
+	if len(errors) >= asked {
+		return map[string]bool{}, fmt.Errorf("all clusters failed (%s)", strings.Join(errors, "; "))
+	}
+	return tombstoned, nil
+}
+
+// CountRange returns the number of records of each key between the cursors,
+// with the same semantics as SelectRange, but without transferring the
+// records. All clusters are asked, and the highest count of each key is
+// returned. Unlike Selects, CountRange doesn't trigger repairs, so counts of
+// inconsistent keys may be approximate. Clusters which fail are ignored,
+// unless all of them fail.
+func (f *Farm) CountRange(keys []string, start, stop common.Cursor) (map[string]int, error) {
+	if len(keys) <= 0 {
+		return map[string]int{}, nil
+	}
+	if f.maxSelectKeys > 0 && len(keys) > f.maxSelectKeys {
+		return map[string]int{}, TooManyKeysError{Keys: len(keys), Max: f.maxSelectKeys}
+	}
+
+	// Scatter
+	type response struct {
+		counts map[string]int
+		err    error
+	}
+	responses := make(chan response, len(f.clusters))
+	for _, c := range f.clusters {
+		go func(c cluster.Cluster) {
+			counts, err := c.CountRange(keys, start, stop)
+			responses <- response{counts, err}
+		}(c)
+	}
+
+	// Gather
+	var (
+		counts = make(map[string]int, len(keys))
+		errors = []string{}
+	)
+	for _, key := range keys {
+		counts[key] = 0
+	}
+	for i := 0; i < cap(responses); i++ {
+		response := <-responses
+		if response.err != nil {
+			errors = append(errors, response.err.Error())
+			continue
+		}
+		for key, n := range response.counts {
+			if n > counts[key] {
+				counts[key] = n
+			}
+		}
+	}
+	if len(errors) >= len(f.clusters) {
+		return map[string]int{}, fmt.Errorf("all clusters failed (%s)", strings.Join(errors, "; "))
+	}
+	return counts, nil
+}
+
+// SelectAll satisfies cluster.AllSelecter, by streaming every member of the
+// key from a single cluster, e.g. for exports and backups. The cluster is
+// picked at random among those which implement cluster.AllSelecter, and
+// preferably among those reachable for the key, according to their last
+// health checks. Members which only other clusters have, because they
+// haven't been repaired yet, are missing, and SelectAll doesn't make
+// repairs. If no cluster implements cluster.AllSelecter, the channel sends a
+// single Element with an error.
+func (f *Farm) SelectAll(ctx context.Context, key string, window int) <-chan cluster.Element {
+	var candidates, reachable []cluster.AllSelecter
+	for _, c := range f.clusters {
+		a, ok := c.(cluster.AllSelecter)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, a)
+		if r, ok := c.(cluster.HealthReporter); !ok || r.Reachable([]string{key}) {
+			reachable = append(reachable, a)
+		}
+	}
+	if len(reachable) > 0 {
+		candidates = reachable
+	}
+	if len(candidates) <= 0 {
+		ch := make(chan cluster.Element, 1)
+		ch <- cluster.Element{Key: key, Error: fmt.Errorf("no cluster supports streaming selects")}
+		close(ch)
+		return ch
+	}
+
+	f.randMtx.Lock()
+	i := f.rand.Intn(len(candidates))
+	f.randMtx.Unlock()
+	return candidates[i].SelectAll(ctx, key, window)
 }
 
 // Delete removes each tuple from the underlying clusters, if the score is
-// greater than the already-stored scores.
+// greater than the already-stored scores. Like Insert, it rejects scores which
+// are NaN or infinite.
 func (f *Farm) Delete(tuples []common.KeyScoreMember) error {
 	return f.write(
+		context.Background(),
 		tuples,
-		func(c cluster.Cluster, a []common.KeyScoreMember) error { return c.Delete(a) },
+		func(_ context.Context, c cluster.Cluster, a []common.KeyScoreMember) error { return c.Delete(a) },
 		deleteInstrumentation{f.instrumentation},
 	)
 }
 
 func (f *Farm) write(
+	ctx context.Context,
 	tuples []common.KeyScoreMember,
-	action func(cluster.Cluster, []common.KeyScoreMember) error,
+	action func(context.Context, cluster.Cluster, []common.KeyScoreMember) error,
 	instr writeInstrumentation,
 ) error {
+	if f.readOnly {
+		return ErrReadOnly
+	}
+
 	// High performance optimization.
 	if len(tuples) <= 0 {
 		return nil
 	}
+	for _, tuple := range tuples {
+		if err := common.CheckScore(tuple.Score); err != nil {
+			return err
+		}
+	}
+	if f.selectCache != nil {
+		defer f.selectCache.invalidate(tuples)
+	}
 	instr.call()
 	instr.recordCount(len(tuples))
 	defer func(began time.Time) {
@@ -118,45 +740,323 @@ func (f *Farm) write(
 		instr.recordDuration(d / time.Duration(len(tuples)))
 	}(time.Now())
 
-	// Scatter
+	// Fail fast, if we know we can't make it
+	buckets, needs := f.quorums(tuples)
+	if f.failFast {
+		for _, need := range needs {
+			if reachable := f.reachable(buckets[need]); reachable < need {
+				instr.quorumFailure()
+				return fmt.Errorf("no quorum (%d of %d cluster(s) reachable, need %d)", reachable, len(f.clusters), need)
+			}
+		}
+	}
+
+	// Scatter. The clusters only see ctx expire before the outcome is
+	// known, so that writes still running afterwards aren't abandoned when
+	// the caller cancels ctx, e.g. at the end of an HTTP request.
+	writeCtx := context.Background()
+	if ctx.Done() != nil {
+		var abandon context.CancelFunc
+		writeCtx, abandon = context.WithCancel(writeCtx)
+		decided := make(chan struct{})
+		defer close(decided)
+		go func() {
+			select {
+			case <-ctx.Done():
+				abandon()
+			case <-decided:
+			}
+		}()
+	}
 	errChan := make(chan error, len(f.clusters))
 	for _, c := range f.clusters {
 		go func(c cluster.Cluster) {
-			errChan <- action(c, tuples)
+			errChan <- action(writeCtx, c, tuples)
 		}(c)
 	}
 
-	// Gather
+	// Gather. Stop as soon as enough clusters succeeded for the quorum of
+	// every key. Once too many failed for the rest to make up for it, the
+	// outcome is known, but the other replies are still gathered, until ctx
+	// is done, so that the QuorumError, e.g. whether it's out of memory,
+	// doesn't depend on which clusters replied first. Writes to the
+	// remaining clusters still run to completion, so that they stay
+	// consistent; errChan is buffered, so they don't block.
 	var (
-		errors     = []string{}
+		errors     = []error{}
 		got        = 0
-		need       = f.writeQuorum
-		haveQuorum = func() bool { return (got - len(errors)) >= need }
+		haveQuorum = func(need int) bool { return (got - len(errors)) >= need }
 	)
 	for i := 0; i < cap(errChan); i++ {
-		err := <-errChan
+		var err error
+		select {
+		case err = <-errChan:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 		if err != nil {
-			errors = append(errors, err.Error())
+			errors = append(errors, err)
 		}
 		got++
-		if haveQuorum() {
+		if haveQuorum(needs[len(needs)-1]) {
 			break
 		}
 	}
 
-	// Report
-	if !haveQuorum() {
+	// Report. The highest quorum is the hardest to reach. Clusters which
+	// rejected oversized members wrote nothing, and the write can't succeed
+	// on retry, so that's reported as such, rather than as a lack of quorum.
+	if !haveQuorum(needs[len(needs)-1]) {
+		for _, err := range errors {
+			if e, ok := err.(cluster.MemberSizeError); ok {
+				return e
+			}
+		}
 		instr.quorumFailure()
-		return fmt.Errorf("no quorum (%s)", strings.Join(errors, "; "))
+		return QuorumError{Errors: errors}
 	}
 	return nil
 }
 
+// quorums buckets the tuples by the write quorum of their keys, and returns
+// the buckets, and their quorums in ascending order.
+func (f *Farm) quorums(tuples []common.KeyScoreMember) (map[int][]common.KeyScoreMember, []int) {
+	if len(f.prefixQuorums) <= 0 {
+		return map[int][]common.KeyScoreMember{f.writeQuorum: tuples}, []int{f.writeQuorum}
+	}
+	var (
+		buckets = map[int][]common.KeyScoreMember{}
+		needs   = []int{}
+	)
+	for _, tuple := range tuples {
+		need := f.quorum(tuple.Key)
+		if _, ok := buckets[need]; !ok {
+			needs = append(needs, need)
+		}
+		buckets[need] = append(buckets[need], tuple)
+	}
+	sort.Ints(needs)
+	return buckets, needs
+}
+
+// quorum returns the write quorum of the key, see WithPrefixQuorums.
+func (f *Farm) quorum(key string) int {
+	var (
+		quorum  = f.writeQuorum
+		longest = -1
+	)
+	for prefix, n := range f.prefixQuorums {
+		if len(prefix) > longest && strings.HasPrefix(key, prefix) {
+			quorum, longest = n, len(prefix)
+		}
+	}
+	return quorum
+}
+
+// CopyKey satisfies cluster.KeyCopier, by copying the key in every cluster
+// which implements it. Like writes, it succeeds if at least the write quorum
+// of dst clusters succeed, and fails with a QuorumError otherwise; clusters which
+// don't implement cluster.KeyCopier count as failed. Unlike writes, it waits
+// for every cluster, as copies are rare and may be large. Clusters which
+// missed the copy are repaired like clusters which missed writes, but
+// retrying the copy is cheaper, and safe.
+func (f *Farm) CopyKey(src, dst string) error {
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if f.selectCache != nil {
+		defer f.selectCache.invalidate([]common.KeyScoreMember{{Key: dst}})
+	}
+
+	// Scatter
+	errChan := make(chan error, len(f.clusters))
+	for _, c := range f.clusters {
+		go func(c cluster.Cluster) {
+			k, ok := c.(cluster.KeyCopier)
+			if !ok {
+				errChan <- fmt.Errorf("cluster doesn't support copying keys")
+				return
+			}
+			errChan <- k.CopyKey(src, dst)
+		}(c)
+	}
+
+	// Gather
+	errors := []error{}
+	for i := 0; i < cap(errChan); i++ {
+		if err := <-errChan; err != nil {
+			errors = append(errors, err)
+		}
+	}
+	if len(f.clusters)-len(errors) < f.quorum(dst) {
+		return QuorumError{Errors: errors}
+	}
+	return nil
+}
+
+// QuorumError is returned by writes which failed in too many clusters to
+// reach write quorum. Errors contains the error of every failed cluster.
+type QuorumError struct {
+	Errors []error
+}
+
+func (e QuorumError) Error() string {
+	a := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		a[i] = err.Error()
+	}
+	return fmt.Sprintf("no quorum (%s)", strings.Join(a, "; "))
+}
+
+// OutOfMemory returns true if any cluster failed because a Redis instance
+// rejected the write for reaching its maxmemory limit, which is a capacity
+// problem rather than a connectivity problem. See pool.LogicalError.
+func (e QuorumError) OutOfMemory() bool {
+	for _, err := range e.Errors {
+		if pool.IsOutOfMemory(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// randomCluster returns the index of a random cluster, picked with a
+// probability proportional to its weight, see WithClusterWeights. The farm must
+// have at least one cluster.
+func (f *Farm) randomCluster() int {
+	f.randMtx.Lock()
+	defer f.randMtx.Unlock()
+	if f.cumWeights == nil {
+		return f.rand.Intn(len(f.clusters))
+	}
+	x := f.rand.Float64() * f.cumWeights[len(f.cumWeights)-1]
+	return sort.Search(len(f.cumWeights), func(i int) bool { return f.cumWeights[i] > x })
+}
+
+// randomClusters picks up to k distinct clusters, with the same odds as
+// randomCluster for each pick, and returns their indexes, followed by the
+// indexes of the other clusters, in random order. Clusters with weight 0 are
+// never picked, so fewer than k may be.
+func (f *Farm) randomClusters(k int) (picked, others []int) {
+	f.randMtx.Lock()
+	defer f.randMtx.Unlock()
+	others = f.rand.Perm(len(f.clusters))
+	for len(picked) < k && len(others) > 0 {
+		i := 0 // others are in random order already
+		if f.weights != nil {
+			var total float64
+			for _, index := range others {
+				total += f.weights[index]
+			}
+			if total <= 0 {
+				break // only clusters with weight 0 are left
+			}
+			x, last := f.rand.Float64()*total, -1
+			for i = 0; i < len(others); i++ {
+				if w := f.weights[others[i]]; w > 0 {
+					if x -= w; x < 0 {
+						break
+					}
+					last = i
+				}
+			}
+			if i >= len(others) {
+				i = last // rounding
+			}
+		}
+		picked = append(picked, others[i])
+		others = append(others[:i:i], others[i+1:]...)
+	}
+	return picked, others
+}
+
+// cumulativeWeights validates the weights of n clusters, and returns their
+// running sums.
+func cumulativeWeights(weights []float64, n int) ([]float64, error) {
+	if len(weights) != n {
+		return nil, fmt.Errorf("%d cluster weight(s) for %d cluster(s)", len(weights), n)
+	}
+	var (
+		cumWeights = make([]float64, n)
+		sum        = 0.0
+	)
+	for i, w := range weights {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return nil, fmt.Errorf("invalid weight %v of cluster %d", w, i+1)
+		}
+		sum += w
+		cumWeights[i] = sum
+	}
+	if sum <= 0 {
+		return nil, ErrNoReadClusters
+	}
+	return cumWeights, nil
+}
+
+// clusterIndexes returns the indexes of all clusters.
+func (f *Farm) clusterIndexes() []int {
+	indexes := make([]int, len(f.clusters))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
+
+// reachableKeys returns the keys which each of the clusters with the given
+// indexes is reachable for, according to its last health check, in the
+// order of keys, indexed like the clusters. Keys for which none of them is
+// reachable are returned for all of them, as the health checks may be out of
+// date.
+func (f *Farm) reachableKeys(indexes []int, keys []string) [][]string {
+	a := make([][]string, len(f.clusters))
+	for _, key := range keys {
+		reachable := 0
+		for _, index := range indexes {
+			if r, ok := f.clusters[index].(cluster.HealthReporter); ok && !r.Reachable([]string{key}) {
+				continue
+			}
+			a[index] = append(a[index], key)
+			reachable++
+		}
+		if reachable == 0 {
+			for _, index := range indexes {
+				a[index] = append(a[index], key)
+			}
+		}
+	}
+	return a
+}
+
+// reachable returns how many clusters are reachable for all keys of the
+// tuples, according to their health checks.
+func (f *Farm) reachable(tuples []common.KeyScoreMember) int {
+	var (
+		seen = map[string]bool{}
+		keys = []string{}
+	)
+	for _, tuple := range tuples {
+		if !seen[tuple.Key] {
+			seen[tuple.Key] = true
+			keys = append(keys, tuple.Key)
+		}
+	}
+
+	n := 0
+	for _, c := range f.clusters {
+		if r, ok := c.(cluster.HealthReporter); ok && !r.Reachable(keys) {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
 // unionDifference computes two sets of keys from the input sets. Union is
 // defined to be every key-member and its best (highest) score. Difference is
 // defined to be those key-members with imperfect agreement across all input
-// sets.
-func unionDifference(tupleSets []tupleSet) (tupleSet, keyMemberSet) {
+// sets, i.e. missing from some, or with a score which isn't equal to the
+// best within tolerance.
+func unionDifference(tupleSets []tupleSet, tolerance scoreTolerance) (tupleSet, keyMemberSet) {
 	var (
 		expectedCount = len(tupleSets)
 		scores        = make(map[common.KeyMember]float64, len(tupleSets)*10)
@@ -179,6 +1079,7 @@ func unionDifference(tupleSets []tupleSet) (tupleSet, keyMemberSet) {
 	var (
 		union      = make(tupleSet, len(scores))
 		difference = make(keyMemberSet, len(counts))
+		agreeing   = make(map[common.KeyMember]int, len(scores))
 	)
 
 	for keyMember, bestScore := range scores {
@@ -190,17 +1091,49 @@ func unionDifference(tupleSets []tupleSet) (tupleSet, keyMemberSet) {
 	}
 
 	for keyScoreMember, count := range counts {
-		if count < expectedCount {
-			difference.add(common.KeyMember{
-				Key:    keyScoreMember.Key,
-				Member: keyScoreMember.Member,
-			})
+		keyMember := common.KeyMember{Key: keyScoreMember.Key, Member: keyScoreMember.Member}
+		if tolerance.equal(keyScoreMember.Score, scores[keyMember]) {
+			agreeing[keyMember] += count
+		}
+	}
+	for keyMember := range scores {
+		if agreeing[keyMember] < expectedCount {
+			difference.add(keyMember)
 		}
 	}
 
 	return union, difference
 }
 
+// scoreTolerance decides whether two scores are equal, see
+// WithScoreTolerance. The zero value compares exactly.
+type scoreTolerance struct {
+	absolute, relative float64
+}
+
+func (t scoreTolerance) equal(a, b float64) bool {
+	if a == b {
+		return true
+	}
+	diff := math.Abs(a - b)
+	return diff <= t.absolute || diff <= t.relative*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// scoreAge returns how long ago score was, as a Unix timestamp in units of
+// unit, and false if unit is zero or less, i.e. scores aren't timestamps, or
+// score is in the future, e.g. because of clock skew, or out of range.
+func scoreAge(score float64, unit time.Duration, now time.Time) (time.Duration, bool) {
+	if unit <= 0 {
+		return 0, false
+	}
+	ns := score * float64(unit)
+	if math.IsNaN(ns) || math.Abs(ns) >= math.MaxInt64 {
+		return 0, false // not a timestamp in that unit
+	}
+	d := now.Sub(time.Unix(0, int64(ns)))
+	return d, d >= 0
+}
+
 type tupleSet map[common.KeyScoreMember]struct{}
 
 func makeSet(a []common.KeyScoreMember) tupleSet {
@@ -234,9 +1167,13 @@ func (s tupleSet) slice() []common.KeyScoreMember {
 	return a
 }
 
-func (s tupleSet) orderedLimitedSlice(limit int) []common.KeyScoreMember {
+func (s tupleSet) orderedLimitedSlice(limit int, order common.Order) []common.KeyScoreMember {
 	a := s.slice()
-	sort.Sort(keyScoreMembers(a))
+	if order == common.Ascending {
+		sort.Sort(sort.Reverse(keyScoreMembers(a)))
+	} else {
+		sort.Sort(keyScoreMembers(a))
+	}
 	if len(a) > limit {
 		a = a[:limit]
 	}
@@ -269,6 +1206,30 @@ func (s keyMemberSet) slice() []common.KeyMember {
 	return a
 }
 
+// requestRepairs passes the keyMembers, which a Select found inconsistent,
+// to the repair strategy, up to the max repairs per Select.
+func (f *Farm) requestRepairs(repairs keyMemberSet) {
+	if len(repairs) <= 0 {
+		return
+	}
+	if f.repairObserver != nil {
+		diverged := map[string]int{}
+		for keyMember := range repairs {
+			diverged[keyMember.Key]++
+		}
+		for key, n := range diverged {
+			f.repairObserver(key, n)
+		}
+	}
+	f.instrumentation.SelectRepairNeeded(len(repairs))
+	a := repairs.slice()
+	if f.maxRepairs > 0 && len(a) > f.maxRepairs {
+		f.instrumentation.SelectRepairTruncated(len(a) - f.maxRepairs)
+		a = a[:f.maxRepairs]
+	}
+	f.repairStrategy(a)
+}
+
 type writeInstrumentation interface {
 	call()
 	recordCount(int)
@@ -1,10 +1,17 @@
 package farm
 
 import (
+	"context"
+	"math"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/garyburd/redigo/redis"
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/memcluster"
 	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/pool"
 )
 
 func TestInsertSelect(t *testing.T) {
@@ -21,7 +28,7 @@ func TestInsertSelect(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got, err := farm.SelectOffset([]string{"foo", "bar", "invalid"}, 0, 10)
+	got, err := farm.SelectOffset([]string{"foo", "bar", "invalid"}, 0, 10, common.Descending)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -60,7 +67,7 @@ func TestOffsetLimit(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got, err := f.SelectOffset([]string{"foo", "bar", "baz", "invalid"}, 1, 1)
+	got, err := f.SelectOffset([]string{"foo", "bar", "baz", "invalid"}, 1, 1, common.Descending)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -81,6 +88,189 @@ func TestOffsetLimit(t *testing.T) {
 	}
 }
 
+func TestSelectOffsetOrder(t *testing.T) {
+	var (
+		one   = common.KeyScoreMember{Key: "foo", Score: 1, Member: "one"}
+		two   = common.KeyScoreMember{Key: "foo", Score: 2, Member: "two"}
+		three = common.KeyScoreMember{Key: "foo", Score: 3, Member: "three"}
+		four  = common.KeyScoreMember{Key: "foo", Score: 4, Member: "four"}
+	)
+
+	// The clusters diverge at both ends of the key, so the oldest and newest
+	// members are each only known to one cluster.
+	clusters := newMockClusters(2)
+	clusters[0].Insert([]common.KeyScoreMember{one, two, three})
+	clusters[1].Insert([]common.KeyScoreMember{two, three, four})
+	f := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil)
+
+	for _, tc := range []struct {
+		offset, limit int
+		order         common.Order
+		expected      []common.KeyScoreMember
+	}{
+		{0, 2, common.Descending, []common.KeyScoreMember{four, three}},
+		{0, 2, common.Ascending, []common.KeyScoreMember{one, two}},
+		{1, 2, common.Ascending, []common.KeyScoreMember{two, three}},
+		{0, 10, common.Ascending, []common.KeyScoreMember{one, two, three, four}},
+	} {
+		got, err := f.SelectOffset([]string{"foo"}, tc.offset, tc.limit, tc.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := tc.expected; !reflect.DeepEqual(expected, got["foo"]) {
+			t.Errorf("offset %d limit %d order %d: expected\n %+v, got\n %+v", tc.offset, tc.limit, tc.order, expected, got["foo"])
+		}
+	}
+}
+
+func TestInsertReporting(t *testing.T) {
+	var (
+		a = common.KeyMember{Key: "foo", Member: "a"}
+		b = common.KeyMember{Key: "foo", Member: "b"}
+		c = common.KeyMember{Key: "foo", Member: "c"}
+	)
+	clusters := []cluster.Cluster{memcluster.New(10), memcluster.New(10)}
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 5, Member: "a"}})
+	clusters[1].Delete([]common.KeyScoreMember{{Key: "foo", Score: 7, Member: "b"}})
+	f := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil)
+
+	got, err := f.InsertReporting([]common.KeyScoreMember{
+		{Key: "foo", Score: 3, Member: "a"}, // a newer insert is stored
+		{Key: "foo", Score: 7, Member: "b"}, // a delete with the same score wins
+		{Key: "foo", Score: 1, Member: "c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[common.KeyMember]cluster.Presence{
+		a: {Present: true, Inserted: true, Score: 5},
+		b: {Present: true, Inserted: false, Score: 7},
+		c: {Present: true, Inserted: true, Score: 1},
+	}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected\n %+v, got\n %+v", expected, got)
+	}
+
+	// Clusters which can't report are rejected.
+	f = New(newMockClusters(2), 2, SendAllReadAll, NoRepairs, nil)
+	if _, err := f.InsertReporting([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}); err == nil {
+		t.Error("expected error, got none")
+	}
+}
+
+func TestCountRange(t *testing.T) {
+	clusters := newMockClusters(3)
+	farm := New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+
+	// Only the second cluster has "c", and the last one goes down.
+	clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 3, Member: "c"}})
+	if err := farm.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	clusters[2] = newFailingMockCluster()
+
+	counts, err := farm.CountRange([]string{"foo", "bar"}, common.Cursor{Score: 10}, common.Cursor{Score: 1, Member: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string]int{"foo": 2, "bar": 0}, counts; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for i := range clusters {
+		clusters[i] = newFailingMockCluster()
+	}
+	if _, err := farm.CountRange([]string{"foo"}, common.Cursor{Score: 10}, common.Cursor{}); err == nil {
+		t.Error("expected an error when all clusters fail")
+	}
+}
+
+func TestSelectAll(t *testing.T) {
+	var (
+		up       = memcluster.New(10)
+		down     = memcluster.New(10)
+		clusters = []cluster.Cluster{up, unreachableAllSelecter{down}, newMockCluster()}
+		farm     = New(clusters, 1, SendAllReadAll, NoRepairs, nil)
+		expected = []common.KeyScoreMember{
+			{Key: "foo", Score: 3, Member: "c"},
+			{Key: "foo", Score: 2, Member: "b"},
+			{Key: "foo", Score: 1, Member: "a"},
+		}
+	)
+	up.Insert(expected)
+	down.Insert([]common.KeyScoreMember{{Key: "foo", Score: 4, Member: "stale"}})
+
+	// Only the reachable cluster which supports it is picked.
+	for i := 0; i < 10; i++ {
+		got := []common.KeyScoreMember{}
+		for e := range farm.SelectAll(context.Background(), "foo", 2) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			got = append(got, e.KeyScoreMembers...)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+
+	var err error
+	for e := range New(newMockClusters(2), 1, SendAllReadAll, NoRepairs, nil).SelectAll(context.Background(), "foo", 2) {
+		err = e.Error
+	}
+	if err == nil {
+		t.Error("expected an error without cluster.AllSelecter")
+	}
+}
+
+func TestCopyKey(t *testing.T) {
+	var (
+		a        = memcluster.New(10)
+		b        = memcluster.New(10)
+		clusters = []cluster.Cluster{a, b, newMockCluster()}
+		farm     = New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+	)
+	for _, c := range []cluster.Cluster{a, b} {
+		c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+		c.Delete([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}})
+	}
+
+	// The mock cluster can't copy keys, but two of three is quorum.
+	if err := farm.CopyKey("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range []cluster.Cluster{a, b} {
+		presence, err := c.Score([]common.KeyMember{{Key: "bar", Member: "a"}, {Key: "bar", Member: "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[common.KeyMember]cluster.Presence{
+			{Key: "bar", Member: "a"}: {Present: true, Inserted: true, Score: 1},
+			{Key: "bar", Member: "b"}: {Present: true, Inserted: false, Score: 2},
+		}; !reflect.DeepEqual(expected, presence) {
+			t.Errorf("cluster %d: expected %v, got %v", i, expected, presence)
+		}
+	}
+
+	farm = New(clusters, 3, SendAllReadAll, NoRepairs, nil)
+	err := farm.CopyKey("foo", "bar")
+	if e, ok := err.(QuorumError); !ok || len(e.Errors) != 1 {
+		t.Errorf("expected a QuorumError with 1 error, got %#v", err)
+	}
+}
+
+// unreachableAllSelecter reports itself unreachable for every key, but
+// still streams members.
+type unreachableAllSelecter struct{ cluster.Cluster }
+
+func (c unreachableAllSelecter) Reachable([]string) bool { return false }
+
+func (c unreachableAllSelecter) SelectAll(ctx context.Context, key string, window int) <-chan cluster.Element {
+	return c.Cluster.(cluster.AllSelecter).SelectAll(ctx, key, window)
+}
+
 func TestSendAllReadAllSelectAfterNoQuorum(t *testing.T) {
 	// Build a farm of 3 clusters: 2 failing, 1 successful
 	clusters := newFailingMockClusters(2)
@@ -98,7 +288,7 @@ func TestSendAllReadAllSelectAfterNoQuorum(t *testing.T) {
 	// But because we have optimistic set-union semantics, Select should return
 	// the written data.
 	expected := map[string][]common.KeyScoreMember{"foo": []common.KeyScoreMember{foo}}
-	got, err := f.SelectOffset([]string{"foo"}, 0, 10)
+	got, err := f.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
 	if err != nil {
 		t.Fatalf("expected successful read, but got: %s", err)
 	}
@@ -106,3 +296,346 @@ func TestSendAllReadAllSelectAfterNoQuorum(t *testing.T) {
 		t.Errorf("expected %+v, got %+v", expected, got)
 	}
 }
+
+func TestInsertOutOfMemory(t *testing.T) {
+	oom := pool.LogicalError{
+		Address: "localhost:6379",
+		Reply:   redis.Error("OOM command not allowed when used memory > 'maxmemory'."),
+	}
+	clusters := newMockClusters(3)
+	clusters[0] = rejectingCluster{clusters[0], oom}
+	clusters[1] = newFailingMockCluster()
+	f := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil)
+
+	err := f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}})
+	e, ok := err.(QuorumError)
+	if !ok {
+		t.Fatalf("expected a QuorumError, got %#v", err)
+	}
+	if expected, got := 2, len(e.Errors); expected != got {
+		t.Errorf("expected %d error(s), got %d", expected, got)
+	}
+	if !e.OutOfMemory() {
+		t.Errorf("expected %q to be out of memory", e)
+	}
+
+	// Connectivity problems alone are not out of memory.
+	clusters[0] = newFailingMockCluster()
+	err = f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}})
+	if e, ok := err.(QuorumError); !ok || e.OutOfMemory() {
+		t.Errorf("expected a QuorumError other than out of memory, got %#v", err)
+	}
+}
+
+func TestWriteMemberSize(t *testing.T) {
+	tooLarge := cluster.MemberSizeError{Key: "foo", Size: 2, Max: 1}
+	clusters := newMockClusters(3)
+	clusters[0] = rejectingCluster{clusters[0], tooLarge}
+	clusters[1] = rejectingCluster{clusters[1], tooLarge}
+	f := New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+
+	// Oversized members fail the write as such, rather than as a lack of
+	// quorum, which would suggest a retry.
+	if err := f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "ab"}}); err != tooLarge {
+		t.Errorf("expected %v, got %#v", tooLarge, err)
+	}
+
+	// If the quorum is reached regardless, the write succeeds.
+	f = New(clusters, 1, SendAllReadAll, NoRepairs, nil)
+	if err := f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "ab"}}); err != nil {
+		t.Errorf("with quorum 1: %s", err)
+	}
+}
+
+func TestWriteNonFiniteScore(t *testing.T) {
+	clusters := newMockClusters(2)
+	f := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil)
+
+	for _, score := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		tuples := []common.KeyScoreMember{
+			{Key: "foo", Score: 1, Member: "a"},
+			{Key: "foo", Score: score, Member: "b"},
+		}
+		if err := f.Insert(tuples); !isScoreError(err) {
+			t.Errorf("Insert %v: expected a ScoreError, got %#v", score, err)
+		}
+		if err := f.Delete(tuples); !isScoreError(err) {
+			t.Errorf("Delete %v: expected a ScoreError, got %#v", score, err)
+		}
+	}
+
+	// Nothing was written, not even the valid tuples.
+	for i, c := range clusters {
+		for e := range c.SelectOffset([]string{"foo"}, 0, 10, common.Descending) {
+			if len(e.KeyScoreMembers) > 0 {
+				t.Errorf("cluster %d: expected no records, got %v", i, e.KeyScoreMembers)
+			}
+		}
+	}
+}
+
+func isScoreError(err error) bool {
+	_, ok := err.(common.ScoreError)
+	return ok
+}
+
+func TestWriteReturnsEarly(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	for _, tc := range []struct {
+		name     string
+		clusters []cluster.Cluster
+		errors   int
+	}{
+		{"quorum reached", []cluster.Cluster{newMockCluster(), blockingCluster{newMockCluster(), release}, newMockCluster()}, 0},
+	} {
+		f := New(tc.clusters, 2, SendAllReadAll, NoRepairs, nil)
+		done := make(chan error)
+		go func() { done <- f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}}) }()
+
+		select {
+		case err := <-done:
+			e, _ := err.(QuorumError)
+			if tc.errors == 0 && err != nil || tc.errors != len(e.Errors) {
+				t.Errorf("%s: expected %d error(s), got %#v", tc.name, tc.errors, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: the write waited for the blocked cluster", tc.name)
+		}
+	}
+}
+
+func TestWriteLostQuorumGathersErrors(t *testing.T) {
+	// A lost quorum waits for every cluster, so that its error doesn't
+	// depend on which clusters replied first: a late out of memory is
+	// still reported.
+	oom := pool.LogicalError{
+		Address: "localhost:6379",
+		Reply:   redis.Error("OOM command not allowed when used memory > 'maxmemory'."),
+	}
+	release := make(chan struct{})
+	clusters := []cluster.Cluster{newFailingMockCluster(), blockingCluster{rejectingCluster{newMockCluster(), oom}, release}, newFailingMockCluster()}
+	f := New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+	done := make(chan error)
+	go func() { done <- f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}}) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("returned before the blocked cluster replied: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	err := <-done
+	if e, ok := err.(QuorumError); !ok || len(e.Errors) != 3 || !e.OutOfMemory() {
+		t.Errorf("expected a QuorumError with 3 errors, out of memory, got %#v", err)
+	}
+
+	// A ctx still bounds the wait.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	hang := make(chan struct{})
+	defer close(hang)
+	blocked := []cluster.Cluster{newFailingMockCluster(), blockingCluster{newMockCluster(), hang}, newFailingMockCluster()}
+	if err := New(blocked, 2, SendAllReadAll, NoRepairs, nil).InsertContext(ctx, []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}}); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+// blockingCluster blocks every Insert until release is closed.
+type blockingCluster struct {
+	cluster.Cluster
+	release chan struct{}
+}
+
+func (c blockingCluster) Insert(tuples []common.KeyScoreMember) error {
+	<-c.release
+	return c.Cluster.Insert(tuples)
+}
+
+func TestInsertContext(t *testing.T) {
+	tuples := []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}}
+
+	// At quorum, InsertContext returns without waiting for the slow cluster,
+	// whose write isn't abandoned when ctx is canceled afterwards.
+	slow := abandonableCluster{newMockCluster(), make(chan struct{}), make(chan error, 1)}
+	f := New([]cluster.Cluster{newMockCluster(), slow, newMockCluster()}, 2, SendAllReadAll, NoRepairs, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	began := time.Now()
+	if err := f.InsertContext(ctx, tuples); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Errorf("expected InsertContext to return at quorum, took %s", elapsed)
+	}
+	cancel()
+	close(slow.release)
+	if err := <-slow.ended; err != nil {
+		t.Errorf("expected the slow write to complete, got %v", err)
+	}
+
+	// Without quorum, InsertContext returns when ctx expires, and abandons
+	// the slow write.
+	slow = abandonableCluster{newMockCluster(), make(chan struct{}), make(chan error, 1)}
+	f = New([]cluster.Cluster{newMockCluster(), slow, newMockCluster()}, 3, SendAllReadAll, NoRepairs, nil)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.InsertContext(ctx, tuples); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	select {
+	case err := <-slow.ended:
+		if err != context.Canceled {
+			t.Errorf("expected the slow write to be abandoned, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("the slow write wasn't abandoned")
+	}
+}
+
+// abandonableCluster blocks every InsertContext until release is closed or ctx is
+// done, and reports how it ended to ended.
+type abandonableCluster struct {
+	cluster.Cluster
+	release chan struct{}
+	ended   chan error
+}
+
+func (c abandonableCluster) InsertContext(ctx context.Context, tuples []common.KeyScoreMember) error {
+	var err error
+	select {
+	case <-c.release:
+		err = c.Cluster.Insert(tuples)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	c.ended <- err
+	return err
+}
+
+// rejectingCluster fails every Insert with err.
+type rejectingCluster struct {
+	cluster.Cluster
+	err error
+}
+
+func (c rejectingCluster) Insert([]common.KeyScoreMember) error { return c.err }
+
+func TestMaxSelectKeys(t *testing.T) {
+	clusters := newMockClusters(3)
+	farm := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil, WithMaxSelectKeys(2))
+
+	if _, err := farm.SelectOffset([]string{"foo", "bar"}, 0, 10, common.Descending); err != nil {
+		t.Errorf("at the limit: %s", err)
+	}
+
+	_, err := farm.SelectOffset([]string{"foo", "bar", "baz"}, 0, 10, common.Descending)
+	if expected, got := (TooManyKeysError{Keys: 3, Max: 2}), err; expected != got {
+		t.Errorf("SelectOffset: expected %v, got %v", expected, got)
+	}
+	_, err = farm.SelectRange([]string{"foo", "bar", "baz"}, common.Cursor{Score: 100}, common.Cursor{}, 10)
+	if expected, got := (TooManyKeysError{Keys: 3, Max: 2}), err; expected != got {
+		t.Errorf("SelectRange: expected %v, got %v", expected, got)
+	}
+	if expected, got := 1, totalSelectCount(clusters)/len(clusters); expected != got {
+		t.Errorf("expected %d select(s) per cluster, got %d", expected, got)
+	}
+
+	// A non-positive limit removes the limit.
+	farm = New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil, WithMaxSelectKeys(0))
+	if _, err := farm.SelectOffset(make([]string, DefaultMaxSelectKeys+1), 0, 10, common.Descending); err != nil {
+		t.Errorf("without limit: %s", err)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	var (
+		up       = newMockCluster()
+		down1    = newMockCluster()
+		down2    = newMockCluster()
+		clusters = []cluster.Cluster{up, unreachableCluster{down1}, unreachableCluster{down2}}
+		tuples   = []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}
+	)
+
+	// By default, every cluster is attempted, regardless of health. (The
+	// mocks are actually fine.) With a quorum of all clusters, the write
+	// waits for every one.
+	if err := New(clusters, 3, SendAllReadAll, NoRepairs, nil).Insert(tuples); err != nil {
+		t.Fatalf("without fail fast: %s", err)
+	}
+
+	// With fail fast, no cluster is attempted.
+	if err := New(clusters, 2, SendAllReadAll, NoRepairs, nil, WithFailFast()).Insert(tuples); err == nil {
+		t.Fatal("with fail fast: expected error, got none")
+	}
+	for i, c := range []*mockCluster{up, down1, down2} {
+		if expected, got := int32(1), c.countInsert; expected != got {
+			t.Errorf("with fail fast: cluster %d: expected %d Insert(s), got %d", i, expected, got)
+		}
+	}
+
+	// Fail fast still writes if enough clusters are reachable.
+	if err := New(clusters, 1, SendAllReadAll, NoRepairs, nil, WithFailFast()).Delete(tuples); err != nil {
+		t.Fatalf("with fail fast and quorum 1: %s", err)
+	}
+}
+
+func TestPrefixQuorums(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{newMockCluster(), newMockCluster(), newFailingMockCluster()}
+		farm     = New(clusters, 2, SendAllReadAll, NoRepairs, nil, WithPrefixQuorums(map[string]int{
+			"feed:":         1,
+			"billing:":      3,
+			"billing:test:": 2, // the longest prefix wins
+		}))
+	)
+	for _, tc := range []struct {
+		keys []string
+		ok   bool
+	}{
+		{[]string{"feed:a"}, true},
+		{[]string{"other"}, true},
+		{[]string{"billing:a"}, false},
+		{[]string{"billing:test:a"}, true},
+		{[]string{"feed:a", "other", "billing:test:a"}, true},
+		{[]string{"feed:a", "billing:a"}, false},
+	} {
+		tuples := []common.KeyScoreMember{}
+		for _, key := range tc.keys {
+			tuples = append(tuples, common.KeyScoreMember{Key: key, Score: 1, Member: "a"})
+		}
+		err := farm.Insert(tuples)
+		if tc.ok && err != nil {
+			t.Errorf("%v: expected success, got %s", tc.keys, err)
+		}
+		if _, isQuorumError := err.(QuorumError); !tc.ok && !isQuorumError {
+			t.Errorf("%v: expected a QuorumError, got %v", tc.keys, err)
+		}
+	}
+
+	// With a default quorum of 1, and only one of the clusters up, deletes
+	// of feeds succeed alone, but not mixed with billing events.
+	var (
+		mixed  = New([]cluster.Cluster{newMockCluster(), newFailingMockCluster(), newFailingMockCluster()}, 1, SendAllReadAll, NoRepairs, nil, WithPrefixQuorums(map[string]int{"billing:": 3}))
+		tuples = []common.KeyScoreMember{{Key: "feed:a", Score: 1, Member: "a"}, {Key: "billing:a", Score: 1, Member: "a"}}
+	)
+	if err := mixed.Delete(tuples); err == nil {
+		t.Errorf("mixed: expected error, got none")
+	}
+	if err := mixed.Delete(tuples[:1]); err != nil {
+		t.Errorf("feed only: %s", err)
+	}
+
+	// Quorums the clusters can't reach are rejected.
+	if err := Validate(clusters, WithPrefixQuorums(map[string]int{"billing:": 4})); err == nil {
+		t.Errorf("expected a quorum of 4 of 3 clusters to be invalid")
+	}
+	if err := Validate(clusters, WithPrefixQuorums(map[string]int{"feed:": 0})); err == nil {
+		t.Errorf("expected a quorum of 0 to be invalid")
+	}
+}
+
+// unreachableCluster reports itself unreachable for every key.
+type unreachableCluster struct{ *mockCluster }
+
+func (c unreachableCluster) Reachable([]string) bool { return false }
@@ -14,7 +14,7 @@ func TestUnionDifferenceOfOne(t *testing.T) {
 		common.KeyScoreMember{Key: "b", Score: 3, Member: "b"}: struct{}{},
 		common.KeyScoreMember{Key: "c", Score: 1, Member: "c"}: struct{}{},
 	}
-	union, difference := unionDifference([]tupleSet{inputSet})
+	union, difference := unionDifference([]tupleSet{inputSet}, scoreTolerance{})
 	if expected, got := inputSet, union; !reflect.DeepEqual(expected, got) {
 		t.Errorf("union: expected %v, got %v", expected, got)
 	}
@@ -44,7 +44,7 @@ func TestUnionDifferenceFullResponse(t *testing.T) {
 	} {
 		inputSets := s2tupleSets(t, input)
 		expectedSets := s2pair(t, expected)
-		union, difference := unionDifference(inputSets)
+		union, difference := unionDifference(inputSets, scoreTolerance{})
 		if !reflect.DeepEqual(union, expectedSets.union) {
 			t.Errorf("%s: union: expected %v, got %v", input, expectedSets.union, union)
 		}
@@ -72,7 +72,7 @@ func TestUnionDifferenceMismatchedScores(t *testing.T) {
 	} {
 		inputSets := s2tupleSets(t, input)
 		expectedSets := s2pair(t, expected)
-		union, difference := unionDifference(inputSets)
+		union, difference := unionDifference(inputSets, scoreTolerance{})
 		if !reflect.DeepEqual(union, expectedSets.union) {
 			t.Errorf("%s: union: expected %v, got %v", input, expectedSets.union, union)
 		}
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
 	"github.com/soundcloud/roshi/common"
 )
 
@@ -30,7 +31,7 @@ func TestMockCluster(t *testing.T) {
 			common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"},
 		},
 	}
-	ch := c.SelectOffset([]string{"foo"}, 0, 10)
+	ch := c.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
 	got := map[string][]common.KeyScoreMember{}
 	for e := range ch {
 		if e.Error != nil {
@@ -55,7 +56,7 @@ func TestMockCluster(t *testing.T) {
 			common.KeyScoreMember{Key: "foo", Score: 2, Member: "baz"},
 		},
 	}
-	ch = c.SelectOffset([]string{"foo"}, 0, 10)
+	ch = c.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
 	got = map[string][]common.KeyScoreMember{}
 	for e := range ch {
 		if e.Error != nil {
@@ -68,10 +69,16 @@ func TestMockCluster(t *testing.T) {
 	}
 }
 
-// mockCluster is *not* safe for concurrent use.
+func TestMockClusterWriteSemantics(t *testing.T) {
+	clustertest.WriteSemantics(t, newMockCluster(), "foo")
+}
+
+// mockCluster implements the write semantics of the Redis scripts, see
+// clustertest.WriteSemantics, but not maxSize.
 type mockCluster struct {
 	id                int32
 	m                 map[string]map[string]float64 // key: member: score
+	d                 map[string]map[string]float64 // key: member: score of tombstones
 	failing           bool
 	countInsert       int32
 	countSelect       int32
@@ -88,6 +95,7 @@ func newMockCluster() *mockCluster {
 	return &mockCluster{
 		id:    atomic.AddInt32(&mockClusterIDs, 1),
 		m:     map[string]map[string]float64{},
+		d:     map[string]map[string]float64{},
 		mutex: &sync.Mutex{},
 	}
 }
@@ -95,6 +103,7 @@ func newMockCluster() *mockCluster {
 func newFailingMockCluster() *mockCluster {
 	return &mockCluster{
 		m:       map[string]map[string]float64{},
+		d:       map[string]map[string]float64{},
 		failing: true,
 		mutex:   &sync.Mutex{},
 	}
@@ -110,24 +119,34 @@ func (c *mockCluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
 	}
 
 	for _, keyScoreMember := range keyScoreMembers {
-		members, ok := c.m[keyScoreMember.Key]
-		if !ok {
-			// first insert for this key
-			c.m[keyScoreMember.Key] = map[string]float64{keyScoreMember.Member: keyScoreMember.Score}
-			continue
-		}
-		score, ok := members[keyScoreMember.Member]
-		if ok && keyScoreMember.Score <= score {
-			// existing member has a better score
-			continue
-		}
-		// existing member doesn't exist or has a lower score
-		c.m[keyScoreMember.Key][keyScoreMember.Member] = keyScoreMember.Score
+		c.write(c.m, c.d, keyScoreMember)
 	}
 	return nil
 }
 
-func (c *mockCluster) SelectOffset(keys []string, offset, limit int) <-chan cluster.Element {
+// write is the equivalent of the Lua script: the tuple is added to the add
+// set and removed from the rem set, unless the member has a higher score in
+// the inserts, or a higher or equal one in the tombstones.
+func (c *mockCluster) write(add, rem map[string]map[string]float64, tuple common.KeyScoreMember) {
+	if score, ok := c.m[tuple.Key][tuple.Member]; ok && tuple.Score < score {
+		return
+	}
+	if score, ok := c.d[tuple.Key][tuple.Member]; ok && tuple.Score <= score {
+		return
+	}
+	if members, ok := rem[tuple.Key]; ok {
+		delete(members, tuple.Member)
+		if len(members) <= 0 {
+			delete(rem, tuple.Key)
+		}
+	}
+	if _, ok := add[tuple.Key]; !ok {
+		add[tuple.Key] = map[string]float64{}
+	}
+	add[tuple.Key][tuple.Member] = tuple.Score
+}
+
+func (c *mockCluster) SelectOffset(keys []string, offset, limit int, order common.Order) <-chan cluster.Element {
 	atomic.AddInt32(&c.countSelect, 1)
 	ch := make(chan cluster.Element)
 	if c.failing {
@@ -152,6 +171,11 @@ func (c *mockCluster) SelectOffset(keys []string, offset, limit int) <-chan clus
 			}
 
 			slice := members2slice(key, members)
+			if order == common.Ascending {
+				for i, j := 0, len(slice)-1; i < j; i, j = i+1, j-1 {
+					slice[i], slice[j] = slice[j], slice[i]
+				}
+			}
 			if len(slice) <= offset {
 				ch <- cluster.Element{Key: key, KeyScoreMembers: []common.KeyScoreMember{}}
 				continue
@@ -173,6 +197,26 @@ func (c *mockCluster) SelectRange(keys []string, start, stop common.Cursor, limi
 	return ch
 }
 
+func (c *mockCluster) CountRange(keys []string, start, stop common.Cursor) (map[string]int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.failing {
+		return map[string]int{}, errors.New("failtown, population you")
+	}
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		for member, score := range c.m[key] {
+			pastStart := score < start.Score || (score == start.Score && !start.ScoreOnly && member < start.Member)
+			beforeStop := score > stop.Score || (score == stop.Score && !stop.ScoreOnly && member > stop.Member)
+			if pastStart && beforeStop {
+				counts[key]++
+			}
+		}
+	}
+	return counts, nil
+}
+
 func members2slice(key string, members map[string]float64) []common.KeyScoreMember {
 	a := scoreMemberSlice{}
 	for member, score := range members {
@@ -211,30 +255,12 @@ func (c *mockCluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
 		return errors.New("failtown, population you")
 	}
 
-	for _, toDelete := range keyScoreMembers {
-		members, ok := c.m[toDelete.Key]
-		if !ok {
-			// key doesn't exist
-			continue
-		}
-		score, ok := members[toDelete.Member]
-		if !ok {
-			// member doesn't exist in key
-			continue
-		}
-		// Mock cluster allows deletes with same score!
-		// This is different than production to ease testing!
-		if toDelete.Score < score {
-			// incoming member has insufficient score
-			continue
-		}
-		delete(c.m[toDelete.Key], toDelete.Member)
+	for _, keyScoreMember := range keyScoreMembers {
+		c.write(c.d, c.m, keyScoreMember)
 	}
 	return nil
 }
 
-// Score in this mock implementation will never return a score for
-// deleted entries.
 func (c *mockCluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -247,20 +273,12 @@ func (c *mockCluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember
 	m := map[common.KeyMember]cluster.Presence{}
 
 	for _, keyMember := range keyMembers {
-		members, ok := c.m[keyMember.Key]
-		if !ok {
+		if score, ok := c.m[keyMember.Key][keyMember.Member]; ok {
+			m[keyMember] = cluster.Presence{Present: true, Inserted: true, Score: score}
+		} else if score, ok := c.d[keyMember.Key][keyMember.Member]; ok {
+			m[keyMember] = cluster.Presence{Present: true, Inserted: false, Score: score}
+		} else {
 			m[keyMember] = cluster.Presence{Present: false}
-			continue
-		}
-		score, ok := members[keyMember.Member]
-		if !ok {
-			m[keyMember] = cluster.Presence{Present: false}
-			continue
-		}
-		m[keyMember] = cluster.Presence{
-			Present:  true,
-			Inserted: true,
-			Score:    score,
 		}
 	}
 	return m, nil
@@ -303,6 +321,7 @@ func (c *mockCluster) clear() {
 	defer c.mutex.Unlock()
 
 	c.m = map[string]map[string]float64{}
+	c.d = map[string]map[string]float64{}
 }
 
 func newMockClusters(n int) []cluster.Cluster {
@@ -3,6 +3,8 @@ package farm
 import (
 	"fmt"
 	"log"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -21,6 +23,25 @@ import (
 //
 //  "foo1:6379, foo2:6379; bar1:6379, bar2:6379, bar3:6379, bar4:6379"
 //
+// The passed timeouts apply to every cluster by default. They may be
+// overridden per cluster by adding key=value tokens to the cluster string.
+// Valid keys are connect.timeout, read.timeout and write.timeout, and values
+// are parsed with time.ParseDuration. For example, to give a remote cluster
+// more generous timeouts:
+//
+//  "foo1:6379, foo2:6379; bar1:6379, bar2:6379, read.timeout=500ms, connect.timeout=1s"
+//
+// A weight=N token sets the weight of the cluster for single-cluster reads,
+// see ParseClusterWeights. ParseFarmString itself ignores it.
+//
+// An instance may appear only once per cluster. By default, it may also
+// appear in only one cluster, as a cluster sharing an instance with another
+// doesn't provide any redundancy. Set allowDuplicates to only log instances
+// which appear in multiple clusters, e.g. to temporarily represent the same
+// Redis in two clusters during a migration.
+//
+// The passed pool options are applied to the pool of every cluster, e.g.
+// pool.WithAuth, and the options to every cluster.
 func ParseFarmString(
 	farmString string,
 	connectTimeout, readTimeout, writeTimeout time.Duration,
@@ -28,38 +49,44 @@ func ParseFarmString(
 	hash func(string) uint32,
 	maxSize int,
 	selectGap time.Duration,
+	allowDuplicates bool,
+	poolOptions []pool.Option,
 	instr instrumentation.Instrumentation,
+	options ...cluster.Option,
 ) ([]cluster.Cluster, error) {
+	poolOptions = append([]pool.Option{pool.WithInstrumentation(instr)}, poolOptions...)
 	var (
 		seen     = map[string]int{}
 		clusters = []cluster.Cluster{}
 	)
 	for i, clusterString := range strings.Split(stripWhitespace(farmString), ";") {
-		hostPorts := []string{}
-		for _, hostPort := range strings.Split(clusterString, ",") {
-			if hostPort == "" {
-				continue
-			}
-			toks := strings.Split(hostPort, ":")
-			if len(toks) != 2 {
-				return []cluster.Cluster{}, fmt.Errorf("invalid host-port %q", hostPort)
-			}
-			if _, err := strconv.ParseUint(toks[1], 10, 16); err != nil {
-				return []cluster.Cluster{}, fmt.Errorf("invalid port %q in host-port %q (%s)", toks[1], hostPort, err)
-			}
-			seen[hostPort]++
-			hostPorts = append(hostPorts, hostPort)
+		cfg, err := parseClusterString(clusterString, clusterConfig{
+			connectTimeout: connectTimeout,
+			readTimeout:    readTimeout,
+			writeTimeout:   writeTimeout,
+		})
+		if err != nil {
+			return []cluster.Cluster{}, err
 		}
-		if len(hostPorts) <= 0 {
+		if len(cfg.hostPorts) <= 0 {
 			return []cluster.Cluster{}, fmt.Errorf("empty cluster %d (%q)", i+1, clusterString)
 		}
+		inCluster := map[string]bool{}
+		for _, hostPort := range cfg.hostPorts {
+			if inCluster[hostPort] {
+				return []cluster.Cluster{}, fmt.Errorf("duplicate instance %s in cluster %d", hostPort, i+1)
+			}
+			inCluster[hostPort] = true
+			seen[hostPort]++
+		}
 		clusters = append(clusters, cluster.New(
-			pool.New(hostPorts, connectTimeout, readTimeout, writeTimeout, redisMCPI, hash),
+			pool.New(cfg.hostPorts, cfg.connectTimeout, cfg.readTimeout, cfg.writeTimeout, redisMCPI, hash, poolOptions...),
 			maxSize,
 			selectGap,
 			instr,
+			options...,
 		))
-		log.Printf("cluster %d: %d instance(s)", i+1, len(hostPorts))
+		log.Printf("cluster %d: %d instance(s)", i+1, len(cfg.hostPorts))
 	}
 
 	if len(clusters) <= 0 {
@@ -73,12 +100,116 @@ func ParseFarmString(
 		}
 	}
 	if len(duplicates) > 0 {
-		return []cluster.Cluster{}, fmt.Errorf("duplicate instances found: %s", strings.Join(duplicates, ", "))
+		if !allowDuplicates {
+			return []cluster.Cluster{}, fmt.Errorf("duplicate instances found: %s", strings.Join(duplicates, ", "))
+		}
+		sort.Strings(duplicates)
+		log.Printf("warning: instance(s) in multiple clusters: %s", strings.Join(duplicates, ", "))
 	}
 
 	return clusters, nil
 }
 
+// ParseClusterWeights returns the weight of every cluster in the farm string,
+// given by a weight=N token in its cluster string, for WithClusterWeights.
+// Clusters without the token weigh 1. If no cluster has a weight, it returns
+// nil, for the default uniform picks. For example, to send two thirds of the
+// single-cluster reads to the first cluster:
+//
+//  "foo1:6379, foo2:6379, weight=2; bar1:6379, bar2:6379"
+func ParseClusterWeights(farmString string) ([]float64, error) {
+	var (
+		weights  = []float64{}
+		weighted = false
+	)
+	for _, clusterString := range strings.Split(stripWhitespace(farmString), ";") {
+		cfg, err := parseClusterString(clusterString, clusterConfig{})
+		if err != nil {
+			return nil, err
+		}
+		weights = append(weights, cfg.weight)
+		weighted = weighted || cfg.weighted
+	}
+	if !weighted {
+		return nil, nil
+	}
+	return weights, nil
+}
+
+// ParseClusterInstances returns the Redis instances of every cluster in the
+// farm string, in order, without the key=value tokens. It's used to identify
+// the clusters in responses and logs.
+func ParseClusterInstances(farmString string) ([][]string, error) {
+	instances := [][]string{}
+	for _, clusterString := range strings.Split(stripWhitespace(farmString), ";") {
+		cfg, err := parseClusterString(clusterString, clusterConfig{})
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, cfg.hostPorts)
+	}
+	return instances, nil
+}
+
+// clusterConfig is the result of parsing a single cluster string.
+type clusterConfig struct {
+	hostPorts                                 []string
+	connectTimeout, readTimeout, writeTimeout time.Duration
+	weight                                    float64
+	weighted                                  bool // weight was given
+}
+
+// parseClusterString parses a whitespace-stripped cluster string. Timeouts
+// not overridden in the cluster string are taken from defaults. The weight
+// is 1 unless given.
+func parseClusterString(clusterString string, defaults clusterConfig) (clusterConfig, error) {
+	cfg := defaults
+	cfg.hostPorts = []string{}
+	cfg.weight, cfg.weighted = 1, false
+	for _, tok := range strings.Split(clusterString, ",") {
+		if tok == "" {
+			continue
+		}
+		if kv := strings.SplitN(tok, "=", 2); len(kv) == 2 {
+			if kv[0] == "weight" {
+				w, err := strconv.ParseFloat(kv[1], 64)
+				if err != nil || w < 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+					return clusterConfig{}, fmt.Errorf("invalid weight in %q", tok)
+				}
+				cfg.weight, cfg.weighted = w, true
+				continue
+			}
+			d, err := time.ParseDuration(kv[1])
+			if err != nil {
+				return clusterConfig{}, fmt.Errorf("invalid duration %q in %q (%s)", kv[1], tok, err)
+			}
+			if d <= 0 {
+				return clusterConfig{}, fmt.Errorf("non-positive duration in %q", tok)
+			}
+			switch kv[0] {
+			case "connect.timeout":
+				cfg.connectTimeout = d
+			case "read.timeout":
+				cfg.readTimeout = d
+			case "write.timeout":
+				cfg.writeTimeout = d
+			default:
+				return clusterConfig{}, fmt.Errorf("invalid option %q", tok)
+			}
+			continue
+		}
+		toks := strings.Split(tok, ":")
+		if len(toks) != 2 {
+			return clusterConfig{}, fmt.Errorf("invalid host-port %q", tok)
+		}
+		if _, err := strconv.ParseUint(toks[1], 10, 16); err != nil {
+			return clusterConfig{}, fmt.Errorf("invalid port %q in host-port %q (%s)", toks[1], tok, err)
+		}
+		cfg.hostPorts = append(cfg.hostPorts, tok)
+	}
+	return cfg, nil
+}
+
 func stripWhitespace(src string) string {
 	var dst []rune
 	for _, c := range src {
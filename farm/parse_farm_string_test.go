@@ -3,6 +3,7 @@ package farm
 import (
 	"io/ioutil"
 	"log"
+	"reflect"
 	"testing"
 	"time"
 
@@ -41,40 +42,155 @@ func TestParseFarmString(t *testing.T) {
 	for farmString, expected := range map[string]struct {
 		success     bool
 		numClusters int
+		duplicates  bool // succeeds only if duplicates are allowed
 	}{
-		"":                                                {false, 0}, // no entries
-		";;;":                                             {false, 0}, // no entries
-		"foo1:1234":                                       {true, 1},
-		"foo1:1234;bar1:1234":                             {true, 2},
-		"foo1:1234;;bar1:1234":                            {false, 0}, // empty middle cluster
-		"foo1,writeonly":                                  {false, 0}, // writeonly is an invalid token now
-		"a1:1234,a2:1234;b1:1234,b2:1234":                 {true, 2},
-		"a1:1234,a2:1234; b1:1234,b2:1234 ":               {true, 2},
-		"a1:1234,a2:1234; b1:1234,b2:1234; ":              {false, 0}, // empty last cluster
-		"a1:1234,a2:1234;b1:1234,b2:1234,writeonly":       {false, 0}, // writeonly is an invalid token now
-		"a1:1234,a2:1234,a3:1234;b1:1234,b2:1234,b3:1234": {true, 2},
-		"a1:1234,a2:1234 ; b1:1234,b2:1234 ; c1:1234":     {true, 3},
-		"a1:1234,a2:1234 ; a1:1234,b2:1234 ; c1:1234":     {false, 0}, // duplicates
+		"":                                   {false, 0, false}, // no entries
+		";;;":                                {false, 0, false}, // no entries
+		"foo1:1234":                          {true, 1, false},
+		"foo1:1234;bar1:1234":                {true, 2, false},
+		"foo1:1234;;bar1:1234":               {false, 0, false}, // empty middle cluster
+		"foo1,writeonly":                     {false, 0, false}, // writeonly is an invalid token now
+		"a1:1234,a2:1234;b1:1234,b2:1234":    {true, 2, false},
+		"a1:1234,a2:1234; b1:1234,b2:1234 ":  {true, 2, false},
+		"a1:1234,a2:1234; b1:1234,b2:1234; ": {false, 0, false}, // empty last cluster
+		"a1:1234,a2:1234;b1:1234,b2:1234,writeonly":       {false, 0, false}, // writeonly is an invalid token now
+		"a1:1234,a2:1234,a3:1234;b1:1234,b2:1234,b3:1234": {true, 2, false},
+		"a1:1234,a2:1234 ; b1:1234,b2:1234 ; c1:1234":     {true, 3, false},
+		"a1:1234,a2:1234 ; a1:1234,b2:1234 ; c1:1234":     {true, 3, true}, // duplicates across clusters
+		"a1:1234;a1:1234":                    {true, 2, true},   // duplicates across clusters
+		"a1:1234,a1:1234;b1:1234":            {false, 0, false}, // duplicates within a cluster
+		"a1:1234;b1:1234,read.timeout=500ms": {true, 2, false},
+		"a1:1234;read.timeout=500ms":         {false, 0, false}, // options but no instances
+		"a1:1234;b1:1234,read.timeout=abc":   {false, 0, false}, // invalid duration
+		"a1:1234;b1:1234,foo.timeout=1s":     {false, 0, false}, // invalid option
 	} {
-		clusters, err := ParseFarmString(
-			farmString,
-			1*time.Second, 1*time.Second, 1*time.Second,
-			1,
-			pool.Murmur3,
-			100,
-			0*time.Millisecond,
-			instrumentation.NopInstrumentation{},
-		)
+		for _, allowDuplicates := range []bool{false, true} {
+			var (
+				success     = expected.success && (allowDuplicates || !expected.duplicates)
+				numClusters = expected.numClusters
+			)
+			if !success {
+				numClusters = 0
+			}
+			clusters, err := ParseFarmString(
+				farmString,
+				1*time.Second, 1*time.Second, 1*time.Second,
+				1,
+				pool.Murmur3,
+				100,
+				0*time.Millisecond,
+				allowDuplicates,
+				nil,
+				instrumentation.NopInstrumentation{},
+			)
+			if success && err != nil {
+				t.Errorf("%q (allow duplicates %v): %s", farmString, allowDuplicates, err)
+				continue
+			}
+			if !success && err == nil {
+				t.Errorf("%q (allow duplicates %v): expected error, got none", farmString, allowDuplicates)
+				continue
+			}
+			if expected, got := numClusters, len(clusters); expected != got {
+				t.Errorf("%q (allow duplicates %v): expected %d cluster(s), got %d", farmString, allowDuplicates, expected, got)
+			}
+		}
+	}
+}
+
+func TestParseClusterString(t *testing.T) {
+	defaults := clusterConfig{
+		connectTimeout: 1 * time.Second,
+		readTimeout:    2 * time.Second,
+		writeTimeout:   3 * time.Second,
+	}
+	for clusterString, expected := range map[string]struct {
+		success bool
+		cfg     clusterConfig
+	}{
+		"a1:1234,a2:1234": {true, clusterConfig{
+			hostPorts:      []string{"a1:1234", "a2:1234"},
+			connectTimeout: 1 * time.Second,
+			readTimeout:    2 * time.Second,
+			writeTimeout:   3 * time.Second,
+			weight:         1,
+		}},
+		"a1:1234,read.timeout=500ms,a2:1234": {true, clusterConfig{
+			hostPorts:      []string{"a1:1234", "a2:1234"},
+			connectTimeout: 1 * time.Second,
+			readTimeout:    500 * time.Millisecond,
+			writeTimeout:   3 * time.Second,
+			weight:         1,
+		}},
+		"a1:1234,connect.timeout=5s,write.timeout=10ms": {true, clusterConfig{
+			hostPorts:      []string{"a1:1234"},
+			connectTimeout: 5 * time.Second,
+			readTimeout:    2 * time.Second,
+			writeTimeout:   10 * time.Millisecond,
+			weight:         1,
+		}},
+		"a1:1234,weight=2.5": {true, clusterConfig{
+			hostPorts:      []string{"a1:1234"},
+			connectTimeout: 1 * time.Second,
+			readTimeout:    2 * time.Second,
+			writeTimeout:   3 * time.Second,
+			weight:         2.5,
+			weighted:       true,
+		}},
+		"a1:1234,read.timeout=0s":  {false, clusterConfig{}},
+		"a1:1234,read.timeout=":    {false, clusterConfig{}},
+		"a1:1234,timeout=1s":       {false, clusterConfig{}},
+		"a1:1234,read.timeout=1s=": {false, clusterConfig{}},
+		"a1:1234,weight=-1":        {false, clusterConfig{}},
+		"a1:1234,weight=x":         {false, clusterConfig{}},
+	} {
+		cfg, err := parseClusterString(clusterString, defaults)
 		if expected.success && err != nil {
+			t.Errorf("%q: %s", clusterString, err)
+			continue
+		}
+		if !expected.success {
+			if err == nil {
+				t.Errorf("%q: expected error, got none", clusterString)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(expected.cfg, cfg) {
+			t.Errorf("%q: expected %+v, got %+v", clusterString, expected.cfg, cfg)
+		}
+	}
+}
+
+func TestParseClusterWeights(t *testing.T) {
+	for farmString, expected := range map[string][]float64{
+		"a1:1234; b1:1234":                     nil,
+		"a1:1234, weight=2; b1:1234":           {2, 1},
+		"a1:1234, weight=0; b1:1234, weight=3": {0, 3},
+	} {
+		got, err := ParseClusterWeights(farmString)
+		if err != nil {
 			t.Errorf("%q: %s", farmString, err)
 			continue
 		}
-		if !expected.success && err == nil {
-			t.Errorf("%q: expected error, got none", farmString)
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%q: expected %v, got %v", farmString, expected, got)
+		}
+	}
+}
+
+func TestParseClusterInstances(t *testing.T) {
+	for farmString, expected := range map[string][][]string{
+		"a1:1234":                   {{"a1:1234"}},
+		"a1:1234, a2:1234; b1:1234": {{"a1:1234", "a2:1234"}, {"b1:1234"}},
+		"a1:1234, weight=2; b1:1234, read.timeout=1s": {{"a1:1234"}, {"b1:1234"}},
+	} {
+		got, err := ParseClusterInstances(farmString)
+		if err != nil {
+			t.Errorf("%q: %s", farmString, err)
 			continue
 		}
-		if expected, got := expected.numClusters, len(clusters); expected != got {
-			t.Errorf("expected %d cluster(s), got %d", expected, got)
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%q: expected %v, got %v", farmString, expected, got)
 		}
 	}
 }
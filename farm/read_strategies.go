@@ -3,13 +3,10 @@ package farm
 import (
 	"fmt"
 	"log"
-	"math/rand"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/tsenart/tb"
-
 	"github.com/soundcloud/roshi/cluster"
 	"github.com/soundcloud/roshi/common"
 )
@@ -27,20 +24,49 @@ func SendOneReadOne(farm *Farm) Selecter { return sendOneReadOne{farm} }
 type sendOneReadOne struct{ *Farm }
 
 // SelectOffset implements farm.Selecter.
-func (s sendOneReadOne) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
-	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
-		return c.SelectOffset(keys, offset, limit)
-	})
+func (s sendOneReadOne) SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := s.SelectOffsetComplete(keys, offset, limit, order)
+	return response, err
 }
 
 // SelectRange implements farm.Selecter.
 func (s sendOneReadOne) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := s.SelectRangeComplete(keys, start, stop, limit)
+	return response, err
+}
+
+// SelectOffsetComplete implements farm.CompletenessSelecter.
+func (s sendOneReadOne) SelectOffsetComplete(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
+		return c.SelectOffset(keys, offset, limit, order)
+	})
+}
+
+// SelectRangeComplete implements farm.CompletenessSelecter.
+func (s sendOneReadOne) SelectRangeComplete(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
 	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
 		return c.SelectRange(keys, start, stop, limit)
 	})
 }
 
-func (s sendOneReadOne) read(numKeys int, fn func(cluster.Cluster) <-chan cluster.Element) (map[string][]common.KeyScoreMember, error) {
+// SelectOffsetFloorComplete implements farm.FloorSelecter.
+func (s sendOneReadOne) SelectOffsetFloorComplete(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
+		return selectOffsetFloor(c, keys, offset, limit, minScore)
+	})
+}
+
+// SelectRangeOffsetComplete implements farm.RangeOffsetSelecter.
+func (s sendOneReadOne) SelectRangeOffsetComplete(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
+		return selectRangeOffset(c, keys, start, stop, offset, limit)
+	})
+}
+
+func (s sendOneReadOne) read(numKeys int, fn func(cluster.Cluster) <-chan cluster.Element) (map[string][]common.KeyScoreMember, bool, error) {
+	if len(s.Farm.clusters) <= 0 {
+		return map[string][]common.KeyScoreMember{}, false, ErrNoClusters
+	}
 	began := time.Now()
 	go func() {
 		s.Farm.instrumentation.SelectCall()
@@ -54,15 +80,19 @@ func (s sendOneReadOne) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 
 		blockingBegan = time.Now()
 		retrieved     = 0
+		succeeded     = 0
 		response      = map[string][]common.KeyScoreMember{}
 		errors        = []string{}
+		index         = s.Farm.randomCluster()
 	)
-	for e := range fn(s.Farm.clusters[rand.Intn(len(s.Farm.clusters))]) {
+	for e := range fn(s.Farm.clusters[index]) {
 		if firstResponseDuration == 0 {
 			firstResponseDuration = time.Since(blockingBegan)
 		}
 		if e.Error != nil {
 			errors = append(errors, e.Error.Error())
+		} else {
+			succeeded++
 		}
 		retrieved += len(e.KeyScoreMembers)
 		response[e.Key] = e.KeyScoreMembers // partial response OK
@@ -71,6 +101,8 @@ func (s sendOneReadOne) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 
 	go func(d time.Duration) {
 		s.Farm.instrumentation.SelectFirstResponseDuration(firstResponseDuration)
+		s.Farm.instrumentation.SelectClusterFirstResponseDuration(index, firstResponseDuration)
+		s.Farm.instrumentation.SelectClusterDuration(index, blockingDuration)
 		s.Farm.instrumentation.SelectBlockingDuration(blockingDuration)
 		s.Farm.instrumentation.SelectOverheadDuration(d - blockingDuration)
 		s.Farm.instrumentation.SelectRetrieved(retrieved)
@@ -78,53 +110,92 @@ func (s sendOneReadOne) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 	}(time.Since(began))
 
 	if len(errors) >= numKeys {
-		return map[string][]common.KeyScoreMember{}, fmt.Errorf("complete failure (%s)", strings.Join(errors, "; "))
+		return map[string][]common.KeyScoreMember{}, false, fmt.Errorf("complete failure (%s)", strings.Join(errors, "; "))
 	}
-	return response, nil // partial results are preferred
+	return response, succeeded >= numKeys, nil // partial results are preferred
 }
 
 // SendAllReadAll is a ReadStrategy that broadcasts the read request to all
 // clusters, waits for all responses, and performs set union/difference on the
 // result sets. It's a simple read strategy, which has the greatest impact on
 // the network, but is also the most resilient to stale data.
+//
+// Clusters which implement cluster.HealthReporter aren't asked for the keys
+// on instances which failed their last health check, so that a dead cluster
+// doesn't cost every read a connect timeout. Their missing responses make
+// the response incomplete, like errors do. Until the instances pass a health
+// check again, conflicts between the remaining clusters are still found, but
+// those involving the skipped cluster aren't. Keys for which no cluster is
+// reachable are sent to every cluster, in case the health checks are out of
+// date.
 func SendAllReadAll(farm *Farm) Selecter { return sendAllReadAll{farm} }
 
 type sendAllReadAll struct{ *Farm }
 
 // SelectOffset implements farm.Selecter.
-func (s sendAllReadAll) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
-	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
-		return c.SelectOffset(keys, offset, limit)
-	}, limit)
+func (s sendAllReadAll) SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := s.SelectOffsetComplete(keys, offset, limit, order)
+	return response, err
 }
 
 // SelectRange implements farm.Selecter.
 func (s sendAllReadAll) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
-	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
+	response, _, err := s.SelectRangeComplete(keys, start, stop, limit)
+	return response, err
+}
+
+// SelectOffsetComplete implements farm.CompletenessSelecter.
+func (s sendAllReadAll) SelectOffsetComplete(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return c.SelectOffset(keys, offset, limit, order)
+	}, limit, order)
+}
+
+// SelectRangeComplete implements farm.CompletenessSelecter.
+func (s sendAllReadAll) SelectRangeComplete(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
 		return c.SelectRange(keys, start, stop, limit)
-	}, limit)
+	}, limit, common.Descending)
 }
 
-func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluster.Element, limit int) (map[string][]common.KeyScoreMember, error) {
-	began := time.Now()
+// SelectOffsetFloorComplete implements farm.FloorSelecter.
+func (s sendAllReadAll) SelectOffsetFloorComplete(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return selectOffsetFloor(c, keys, offset, limit, minScore)
+	}, limit, common.Descending)
+}
+
+// SelectRangeOffsetComplete implements farm.RangeOffsetSelecter.
+func (s sendAllReadAll) SelectRangeOffsetComplete(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return selectRangeOffset(c, keys, start, stop, offset, limit)
+	}, limit, common.Descending)
+}
+
+func (s sendAllReadAll) read(keys []string, fn func(cluster.Cluster, []string) <-chan cluster.Element, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.readFrom("SendAllReadAll", s.Farm.clusterIndexes(), nil, keys, fn, limit, order)
+}
+
+// readFrom reads the keys from the clusters with the given indexes, except
+// those which are unreachable for a key, see reachableKeys, and returns the
+// union of their responses. Keys for which none of them responded are then
+// read from the fallback clusters, the same way. The response is complete if
+// every cluster of indexes responded for every key. name is the read
+// strategy, for logging.
+func (s sendAllReadAll) readFrom(name string, indexes, fallback []int, keys []string, fn func(cluster.Cluster, []string) <-chan cluster.Element, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	if len(s.Farm.clusters) <= 0 {
+		return map[string][]common.KeyScoreMember{}, false, ErrNoClusters
+	}
+	var (
+		began   = time.Now()
+		numKeys = len(keys)
+	)
 	go func() {
 		s.Farm.instrumentation.SelectCall()
 		s.Farm.instrumentation.SelectKeys(numKeys)
-		s.Farm.instrumentation.SelectSendTo(len(s.Farm.clusters))
 	}()
 	defer func() { go s.Farm.instrumentation.SelectDuration(time.Since(began)) }()
 
-	// We'll combine all response elements into a single channel. When all
-	// clusters have finished sending elements there, close it, so we can
-	// have nice range semantics in our gather phase.
-	elements := make(chan cluster.Element)
-	wg := sync.WaitGroup{}
-	wg.Add(len(s.Farm.clusters))
-	go func() { wg.Wait(); close(elements) }()
-
-	blockingBegan := time.Now()
-	scatterSelects(s.Farm.clusters, fn, &wg, elements)
-
 	// Gather all elements. An error implies some problem with the Redis
 	// instance or the underlying cluster, and shouldn't trigger read
 	// repair, so we don't include those elements in our responses map.
@@ -132,32 +203,80 @@ func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 	// responses with inconsistent data.)
 	var (
 		firstResponseDuration time.Duration
-		responses             = map[string][]tupleSet{}
-		retrieved             = 0
+
+		blockingBegan = time.Now()
+		responses     = map[string][]tupleSet{}
+		retrieved     = 0
 	)
-	for e := range elements {
-		if e.Error != nil {
-			log.Printf("SendAllReadAll partial error: %s", e.Error)
-			go s.Farm.instrumentation.SelectPartialError()
-			continue
+	gather := func(indexes []int, keys []string) {
+		var (
+			clusterKeys  = s.Farm.reachableKeys(indexes, keys)
+			clustersUsed = []int{}
+		)
+		for index, keys := range clusterKeys {
+			if len(keys) > 0 {
+				clustersUsed = append(clustersUsed, index)
+			}
 		}
-		if firstResponseDuration == 0 {
-			firstResponseDuration = time.Since(blockingBegan)
+		go s.Farm.instrumentation.SelectSendTo(len(clustersUsed))
+
+		// We'll combine all response elements into a single channel. When
+		// all clusters have finished sending elements there, close it, so
+		// we can have nice range semantics.
+		elements := make(chan cluster.Element)
+		wg := sync.WaitGroup{}
+		wg.Add(len(clustersUsed))
+		go func() { wg.Wait(); close(elements) }()
+		for _, index := range clustersUsed {
+			keys := clusterKeys[index]
+			scatterSelects(s.Farm, []int{index}, func(c cluster.Cluster) <-chan cluster.Element { return fn(c, keys) }, &wg, elements, nil)
+		}
+
+		for e := range elements {
+			if e.Error != nil {
+				log.Printf("%s partial error: %s", name, e.Error)
+				go s.Farm.instrumentation.SelectPartialError()
+				continue
+			}
+			if firstResponseDuration == 0 {
+				firstResponseDuration = time.Since(blockingBegan)
+			}
+			responses[e.Key] = append(responses[e.Key], makeSet(e.KeyScoreMembers))
+			retrieved += len(e.KeyScoreMembers)
 		}
-		responses[e.Key] = append(responses[e.Key], makeSet(e.KeyScoreMembers))
-		retrieved += len(e.KeyScoreMembers)
+	}
+	gather(indexes, keys)
+
+	// Send the keys which every cluster failed to the fallback clusters.
+	failedKeys := []string{}
+	for _, key := range keys {
+		if len(responses[key]) <= 0 {
+			failedKeys = append(failedKeys, key)
+		}
+	}
+	if len(failedKeys) > 0 && len(fallback) > 0 {
+		go func() {
+			s.Farm.instrumentation.SelectSendAllPromotion()
+			s.Farm.instrumentation.SelectPromotionError()
+		}()
+		gather(fallback, failedKeys)
 	}
 	blockingDuration := time.Since(blockingBegan)
 
-	// Compute union and difference sets for each key.
+	// Compute union and difference sets for each key. The response is only
+	// complete if every cluster answered for every key.
 	var (
 		response = map[string][]common.KeyScoreMember{}
 		repairs  = keyMemberSet{}
 		returned = 0
+		complete = len(failedKeys) <= 0
 	)
 	for key, tupleSets := range responses {
-		union, difference := unionDifference(tupleSets)
-		response[key] = union.orderedLimitedSlice(limit)
+		if len(tupleSets) < len(indexes) {
+			complete = false
+		}
+		union, difference := unionDifference(tupleSets, s.Farm.tolerance)
+		response[key] = union.orderedLimitedSlice(limit, order)
 		returned += len(response[key])
 		repairs.addMany(difference)
 	}
@@ -166,10 +285,7 @@ func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 	// default blocking. If you want nonblocking repairs, as you probably
 	// do in a production server, be sure to wrap your RepairStrategy in
 	// Nonblocking!
-	if len(repairs) > 0 {
-		s.Farm.instrumentation.SelectRepairNeeded(len(repairs))
-		s.Farm.repairStrategy(repairs.slice())
-	}
+	s.Farm.requestRepairs(repairs)
 
 	// Kapow!
 	go func() {
@@ -179,7 +295,102 @@ func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 		s.Farm.instrumentation.SelectRetrieved(retrieved)
 		s.Farm.instrumentation.SelectReturned(returned)
 	}()
-	return response, nil
+	if err := s.Farm.checkReadErrors(keys, responses, len(indexes)); err != nil {
+		return map[string][]common.KeyScoreMember{}, false, err
+	}
+	return response, complete, nil
+}
+
+// SendKReadAll is a ReadStrategy that sends the read request to k random
+// clusters, waits for all their responses, and performs set union/difference
+// on the result sets, like SendAllReadAll does with all clusters. Keys for
+// which all k clusters returned errors are sent to the other clusters. It
+// bounds the cost of reads between SendOneReadOne (k = 1) and SendAllReadAll
+// (k = the number of clusters), and finds and repairs conflicts between the
+// clusters it reads. The response is complete if all k clusters responded
+// for every key. Like SendAllReadAll, it skips the picked clusters which are
+// unreachable for a key.
+//
+// A k of zero or less, or more than the number of clusters, reads all
+// clusters. Clusters are picked like by SendOneReadOne, see
+// WithClusterWeights.
+func SendKReadAll(k int) func(*Farm) Selecter {
+	return func(farm *Farm) Selecter { return sendKReadAll{farm, k} }
+}
+
+type sendKReadAll struct {
+	*Farm
+	k int
+}
+
+// SelectOffset implements farm.Selecter.
+func (s sendKReadAll) SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := s.SelectOffsetComplete(keys, offset, limit, order)
+	return response, err
+}
+
+// SelectRange implements farm.Selecter.
+func (s sendKReadAll) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := s.SelectRangeComplete(keys, start, stop, limit)
+	return response, err
+}
+
+// SelectOffsetComplete implements farm.CompletenessSelecter.
+func (s sendKReadAll) SelectOffsetComplete(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return c.SelectOffset(keys, offset, limit, order)
+	}, limit, order)
+}
+
+// SelectRangeComplete implements farm.CompletenessSelecter.
+func (s sendKReadAll) SelectRangeComplete(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return c.SelectRange(keys, start, stop, limit)
+	}, limit, common.Descending)
+}
+
+// SelectOffsetFloorComplete implements farm.FloorSelecter.
+func (s sendKReadAll) SelectOffsetFloorComplete(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return selectOffsetFloor(c, keys, offset, limit, minScore)
+	}, limit, common.Descending)
+}
+
+// SelectRangeOffsetComplete implements farm.RangeOffsetSelecter.
+func (s sendKReadAll) SelectRangeOffsetComplete(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return selectRangeOffset(c, keys, start, stop, offset, limit)
+	}, limit, common.Descending)
+}
+
+func (s sendKReadAll) read(keys []string, fn func(cluster.Cluster, []string) <-chan cluster.Element, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	k := s.k
+	if k <= 0 {
+		k = len(s.Farm.clusters)
+	}
+	picked, others := s.Farm.randomClusters(k)
+	response, complete, err := sendAllReadAll{s.Farm}.readFrom("SendKReadAll", picked, others, keys, fn, limit, order)
+	if err == nil && len(response) <= 0 && len(keys) > 0 {
+		return response, false, fmt.Errorf("complete failure")
+	}
+	return response, complete, err
+}
+
+// checkReadErrors returns a TooDegradedError for the first of the keys for
+// which more of the clusters read than tolerated didn't respond, see
+// WithReadErrorTolerance. Responses of clusters the keys were sent to in
+// addition to those, e.g. by SendKReadAll, make up for failed ones.
+func (f *Farm) checkReadErrors(keys []string, responses map[string][]tupleSet, clusters int) error {
+	if f.readErrors >= 1 {
+		return nil
+	}
+	for _, key := range keys {
+		failed := clusters - len(responses[key])
+		if failed > 0 && float64(failed) > f.readErrors*float64(clusters) {
+			return TooDegradedError{Key: key, Failed: failed, Clusters: clusters}
+		}
+	}
+	return nil
 }
 
 // SendAllReadFirstLinger is a ReadStrategy that broadcasts the read request
@@ -189,8 +400,10 @@ func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 // Before returning, SendAllReadFirstLinger spawns a goroutine to linger and
 // collect responses from all the clusters. When all responses have been
 // collected, SendAllReadFirstLinger will determine which keys should be sent
-// to the repairer.
-func SendAllReadFirstLinger(farm *Farm) Selecter { return SendVarReadFirstLinger(-1, -1)(farm) }
+// to the repairer. Use WithMaxLinger to bound how long it lingers.
+func SendAllReadFirstLinger(farm *Farm) Selecter {
+	return sendVarReadFirstLinger{Farm: farm, permitter: allowAllPermitter{}, thresholdLatency: -1}
+}
 
 // SendVarReadFirstLinger is a refined version of SendAllReadFirstLinger. It
 // works in the same way but reduces the requests to all clusters under
@@ -200,17 +413,22 @@ func SendAllReadFirstLinger(farm *Farm) Selecter { return SendVarReadFirstLinger
 // slow or unusable, the read will be delayed or fail. The first issue can be
 // ignored, because the baseline SendAll reads provide a basis for repairs. To
 // solve the second issue, we promote any SendOne to a SendAll if  no results
-// are returned by thresholdLatency.
+// are returned by thresholdLatency. The repairs of a promoted read come
+// from the responses of the clusters which responded, but AllRepairs checks
+// every key-member in every cluster, including the one which failed, so
+// they're as authoritative as those of any other read.
 //
 // To never perform an initial SendAll, set maxKeysPerSecond to 0. To always
 // perform an initial SendAll, set maxKeysPerSecond to a negative value.
+//
+// The permits which are available, and the share of reads which were sent to
+// all clusters, are reported periodically. SendAllPermits and
+// SetMaxKeysPerSecond of the Farm inspect and change maxKeysPerSecond while
+// it's in use, see SendAllTuner.
 func SendVarReadFirstLinger(maxKeysPerSecond int, thresholdLatency time.Duration) func(*Farm) Selecter {
-	permitter := permitter(allowAllPermitter{})
-	if maxKeysPerSecond >= 0 {
-		permitter = tokenBucketPermitter{tb.NewBucket(int64(maxKeysPerSecond), -1)}
-	}
-	permitter.canHas(0)
+	permitter := newSendAllPermitter(maxKeysPerSecond)
 	return func(farm *Farm) Selecter {
+		permitter.reportTo(farm.instrumentation, sendAllPermitsInterval)
 		return sendVarReadFirstLinger{
 			Farm:             farm,
 			permitter:        permitter,
@@ -226,20 +444,54 @@ type sendVarReadFirstLinger struct {
 }
 
 // SelectOffset implements farm.Selecter.
-func (s sendVarReadFirstLinger) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
-	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
-		return c.SelectOffset(keys, offset, limit)
-	}, limit)
+func (s sendVarReadFirstLinger) SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := s.SelectOffsetComplete(keys, offset, limit, order)
+	return response, err
 }
 
 // SelectRange implements farm.Selecter.
 func (s sendVarReadFirstLinger) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := s.SelectRangeComplete(keys, start, stop, limit)
+	return response, err
+}
+
+// SelectOffsetComplete implements farm.CompletenessSelecter. Since this
+// strategy returns as soon as it has one response per key, the response is
+// considered complete when every key got at least one successful response.
+func (s sendVarReadFirstLinger) SelectOffsetComplete(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return c.SelectOffset(keys, offset, limit, order)
+	}, limit, order)
+}
+
+// SelectRangeComplete implements farm.CompletenessSelecter, with the same
+// notion of completeness as SelectOffsetComplete.
+func (s sendVarReadFirstLinger) SelectRangeComplete(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
 	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
 		return c.SelectRange(keys, start, stop, limit)
-	}, limit)
+	}, limit, common.Descending)
+}
+
+// SelectOffsetFloorComplete implements farm.FloorSelecter, with the same
+// notion of completeness as SelectOffsetComplete.
+func (s sendVarReadFirstLinger) SelectOffsetFloorComplete(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return selectOffsetFloor(c, keys, offset, limit, minScore)
+	}, limit, common.Descending)
+}
+
+// SelectRangeOffsetComplete implements farm.RangeOffsetSelecter, with the same
+// notion of completeness as SelectOffsetComplete.
+func (s sendVarReadFirstLinger) SelectRangeOffsetComplete(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return selectRangeOffset(c, keys, start, stop, offset, limit)
+	}, limit, common.Descending)
 }
 
-func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []string) <-chan cluster.Element, limit int) (map[string][]common.KeyScoreMember, error) {
+func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []string) <-chan cluster.Element, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	if len(s.Farm.clusters) <= 0 {
+		return map[string][]common.KeyScoreMember{}, false, ErrNoClusters
+	}
 	began := time.Now()
 	go func() {
 		s.Farm.instrumentation.SelectCall()
@@ -248,9 +500,14 @@ func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []s
 
 	// We'll combine all response elements into a single channel. When all
 	// clusters have finished sending elements there, close it, so we can have
-	// nice range semantics in our linger phase.
-	elements := make(chan cluster.Element)
-	wg := sync.WaitGroup{}
+	// nice range semantics in our linger phase. If the linger phase is
+	// abandoned (see WithMaxLinger), close abandon instead, so that the
+	// remaining elements are discarded.
+	var (
+		elements = make(chan cluster.Element)
+		abandon  = make(chan struct{})
+		wg       = sync.WaitGroup{}
+	)
 	wg.Add(len(s.Farm.clusters))
 	go func() {
 		// Note that we need a wg.Done signal for every cluster, even if we
@@ -260,27 +517,31 @@ func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []s
 	}()
 
 	// Depending on maySendAll, pick either one random cluster or all of them.
+	// Clusters are identified by their index in s.Farm.clusters.
 	var (
-		clustersUsed    = []cluster.Cluster{}
-		clustersNotUsed = []cluster.Cluster{}
+		clustersUsed    = []int{}
+		clustersNotUsed = []int{}
 		maySendAll      = s.permitter.canHas(int64(len(keys)))
 	)
 	if maySendAll {
 		go s.Farm.instrumentation.SelectSendAllPermitGranted()
-		clustersUsed = s.Farm.clusters
-		clustersNotUsed = []cluster.Cluster{}
+		clustersUsed = s.Farm.clusterIndexes()
+		clustersNotUsed = []int{}
 	} else {
 		go s.Farm.instrumentation.SelectSendAllPermitRejected()
-		i := rand.Intn(len(s.Farm.clusters))
-		clustersUsed = s.Farm.clusters[i : i+1]
-		clustersNotUsed = make([]cluster.Cluster, 0, len(s.Farm.clusters)-1)
-		clustersNotUsed = append(clustersNotUsed, s.Farm.clusters[:i]...)
-		clustersNotUsed = append(clustersNotUsed, s.Farm.clusters[i+1:]...)
+		i := s.Farm.randomCluster()
+		clustersUsed = []int{i}
+		clustersNotUsed = make([]int, 0, len(s.Farm.clusters)-1)
+		for j := range s.Farm.clusters {
+			if j != i {
+				clustersNotUsed = append(clustersNotUsed, j)
+			}
+		}
 	}
 
 	blockingBegan := time.Now()
 	go s.Farm.instrumentation.SelectSendTo(len(clustersUsed))
-	scatterSelects(clustersUsed, func(c cluster.Cluster) <-chan cluster.Element { return fn(c, keys) }, &wg, elements)
+	scatterSelects(s.Farm, clustersUsed, func(c cluster.Cluster) <-chan cluster.Element { return fn(c, keys) }, &wg, elements, abandon)
 
 	// remainingKeys keeps track of all keys for which we haven't received any
 	// non-error responses yet.
@@ -296,9 +557,14 @@ func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []s
 		timeout = time.After(s.thresholdLatency)
 	}
 
+	// Once every key got a response from a read sent to all clusters, wait
+	// up to the grace for the other clusters (see WithFirstResponseGrace).
+	var grace <-chan time.Time // initially nil
+
 	var (
 		firstResponseDuration time.Duration
 		responses             = map[string][]tupleSet{}
+		erroredKeys           = map[string]bool{}
 		retrieved             = 0
 	)
 
@@ -313,6 +579,7 @@ loop:
 			if e.Error != nil {
 				log.Printf("SendVarReadFirstLinger initial read partial error: %s", e.Error)
 				go s.Farm.instrumentation.SelectPartialError()
+				erroredKeys[e.Key] = true
 				continue
 				// It might appear tempting to immediately send a Select to
 				// the unusedClusters once we run into an error. However, it's
@@ -329,22 +596,40 @@ loop:
 			delete(remainingKeys, e.Key)
 
 		case <-timeout:
-			// Promote to SendAll for remaining keys.
+			// Promote to SendAll for remaining keys. If any of them got an
+			// error, rather than no response yet, blame the errors.
 			go s.Farm.instrumentation.SelectSendAllPromotion()
 			maySendAll = true
+			errored := false
 			remainingKeysSlice := make([]string, 0, len(remainingKeys))
 			for k := range remainingKeys {
 				remainingKeysSlice = append(remainingKeysSlice, k)
+				errored = errored || erroredKeys[k]
+			}
+			if errored {
+				go s.Farm.instrumentation.SelectPromotionError()
+			} else {
+				go s.Farm.instrumentation.SelectPromotionLatency()
 			}
 			go s.Farm.instrumentation.SelectSendTo(len(clustersNotUsed))
-			scatterSelects(clustersNotUsed, func(c cluster.Cluster) <-chan cluster.Element { return fn(c, remainingKeysSlice) }, &wg, elements)
-			clustersUsed = s.Farm.clusters
-			clustersNotUsed = []cluster.Cluster{}
+			scatterSelects(s.Farm, clustersNotUsed, func(c cluster.Cluster) <-chan cluster.Element { return fn(c, remainingKeysSlice) }, &wg, elements, abandon)
+			clustersUsed = s.Farm.clusterIndexes()
+			clustersNotUsed = []int{}
+
+		case <-grace:
+			break loop // the other clusters are too slow, return what we have
 		}
 
 		if len(remainingKeys) == 0 {
-			// We got enough results to return our results.
-			break loop
+			if !maySendAll || s.Farm.grace <= 0 {
+				// We got enough results to return our results.
+				break loop
+			}
+			if grace == nil {
+				timer := time.NewTimer(s.Farm.grace)
+				defer timer.Stop()
+				grace = timer.C
+			}
 		}
 	}
 
@@ -369,7 +654,7 @@ loop:
 	// for each key. In either case, it's time to return results.
 	if len(responses) == 0 && len(remainingKeys) > 0 {
 		// All Selects returned an error.
-		return map[string][]common.KeyScoreMember{}, fmt.Errorf("complete failure")
+		return map[string][]common.KeyScoreMember{}, false, fmt.Errorf("complete failure")
 	}
 
 	var (
@@ -377,8 +662,8 @@ loop:
 		repairs  = keyMemberSet{}
 	)
 	for key, tupleSets := range responses {
-		union, difference := unionDifference(tupleSets)
-		a := union.orderedLimitedSlice(limit)
+		union, difference := unionDifference(tupleSets, s.Farm.tolerance)
+		a := union.orderedLimitedSlice(limit, order)
 		response[key] = a
 		returned += len(a)
 		repairs.addMany(difference)
@@ -393,9 +678,9 @@ loop:
 		// of errors. Partial results are still better than nothing,
 		// so issue repairs as needed and return the partial results.
 		if len(repairs) > 0 {
-			go s.Farm.repairStrategy(repairs.slice())
+			go s.Farm.requestRepairs(repairs)
 		}
-		return response, nil
+		return response, false, nil
 	}
 	if sentOneGotEverything {
 		// The WaitGroup expects len(s.Farm.clusters) Done signals,
@@ -403,50 +688,86 @@ loop:
 		for _ = range clustersNotUsed {
 			wg.Done()
 		}
-		return response, nil
+		return response, true, nil
 	}
 
 	// If we are here, we *might* still have Selects running. So start
 	// a goroutine to "linger" and collect the remaining responses for
 	// repairs before returning the results we have so far.
 	go func() {
+		var deadline <-chan time.Time // initially nil
+		if s.Farm.maxLinger > 0 {
+			timer := time.NewTimer(s.Farm.maxLinger)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
 		lingeringRetrievals := 0
-		for e := range elements {
-			lingeringRetrievals += len(e.KeyScoreMembers)
-			if e.Error != nil {
-				log.Printf("SendVarReadFirstLinger lingering retrieval partial error: %s", e.Error)
-				go s.Farm.instrumentation.SelectPartialError()
-				continue
+	linger:
+		for {
+			select {
+			case e, ok := <-elements:
+				if !ok {
+					break linger // all Selects done
+				}
+				lingeringRetrievals += len(e.KeyScoreMembers)
+				if e.Error != nil {
+					log.Printf("SendVarReadFirstLinger lingering retrieval partial error: %s", e.Error)
+					go s.Farm.instrumentation.SelectPartialError()
+					continue
+				}
+				responses[e.Key] = append(responses[e.Key], makeSet(e.KeyScoreMembers))
+
+			case <-deadline:
+				// Give up on the slow clusters, and repair with what we
+				// have. Their Selects still signal the WaitGroup when done.
+				log.Printf("SendVarReadFirstLinger lingering abandoned after %s", s.Farm.maxLinger)
+				close(abandon)
+				break linger
 			}
-			responses[e.Key] = append(responses[e.Key], makeSet(e.KeyScoreMembers))
 		}
 		for _, tupleSets := range responses {
-			_, difference := unionDifference(tupleSets)
+			_, difference := unionDifference(tupleSets, s.Farm.tolerance)
 			repairs.addMany(difference)
 		}
 		if len(repairs) > 0 {
-			go func() {
-				s.Farm.instrumentation.SelectRepairNeeded(len(repairs))
-				s.Farm.repairStrategy(repairs.slice())
-			}()
+			go s.Farm.requestRepairs(repairs)
 		}
 		s.Farm.instrumentation.SelectRetrieved(lingeringRetrievals) // additive
 	}()
-	return response, nil
+	return response, true, nil
 }
 
+// scatterSelects calls fn for the clusters of the farm with the given
+// indexes, and forwards their elements to dst, until abandon is closed. It
+// reports how long every cluster took to send its first and last element,
+// to tell apart the clusters which are consistently slow.
 func scatterSelects(
-	clusters []cluster.Cluster,
+	f *Farm,
+	indexes []int,
 	fn func(cluster.Cluster) <-chan cluster.Element,
 	wg *sync.WaitGroup,
 	dst chan cluster.Element,
+	abandon <-chan struct{},
 ) {
-	for _, c := range clusters {
-		go func(c cluster.Cluster) {
+	for _, index := range indexes {
+		go func(index int) {
 			defer wg.Done()
-			for e := range fn(c) {
-				dst <- e
+			var (
+				began         = time.Now()
+				firstResponse time.Duration
+			)
+			for e := range fn(f.clusters[index]) {
+				if firstResponse == 0 {
+					firstResponse = time.Since(began)
+				}
+				select {
+				case dst <- e:
+				case <-abandon: // nobody's reading dst anymore
+				}
 			}
-		}(c)
+			f.instrumentation.SelectClusterFirstResponseDuration(index, firstResponse)
+			f.instrumentation.SelectClusterDuration(index, time.Since(began))
+		}(index)
 	}
 }
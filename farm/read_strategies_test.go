@@ -1,7 +1,12 @@
 package farm
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -63,7 +68,7 @@ func TestSendOneReadOne(t *testing.T) {
 	farm := New(clusters, len(clusters), SendOneReadOne, MockRepairs(&repairs), nil)
 	farm.Insert([]common.KeyScoreMember{testingKeyScoreMember})
 
-	result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	if err := checkResult(result, err); err != nil {
 		t.Error(err)
 	}
@@ -78,13 +83,104 @@ func TestSendOneReadOne(t *testing.T) {
 	}
 }
 
+func TestSendOneReadOneWithRand(t *testing.T) {
+	picks := func() []int32 {
+		clusters := newMockClusters(5)
+		farm := New(clusters, len(clusters), SendOneReadOne, NoRepairs, nil, WithRand(rand.New(rand.NewSource(42))))
+		for i := 0; i < 10; i++ {
+			farm.SelectOffset([]string{"key"}, 0, 10, common.Descending)
+		}
+		counts := make([]int32, len(clusters))
+		for i, c := range clusters {
+			counts[i] = atomic.LoadInt32(&c.(*mockCluster).countSelect)
+		}
+		return counts
+	}
+
+	first, second := picks(), picks()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("same seed, different picks: %v vs. %v", first, second)
+		}
+	}
+}
+
+func TestSendOneReadOneWithClusterWeights(t *testing.T) {
+	var (
+		weights     = []float64{1, 3, 0, 4}
+		clusters    = newMockClusters(len(weights))
+		farm        = New(clusters, len(clusters), SendOneReadOne, NoRepairs, nil, WithClusterWeights(weights...), WithRand(rand.New(rand.NewSource(42))))
+		selects     = 20000
+		tolerance   = 0.02
+		totalWeight = 8.0
+	)
+	for i := 0; i < selects; i++ {
+		farm.SelectOffset([]string{"key"}, 0, 10, common.Descending)
+	}
+	for i, c := range clusters {
+		var (
+			expected = weights[i] / totalWeight
+			got      = float64(atomic.LoadInt32(&c.(*mockCluster).countSelect)) / float64(selects)
+		)
+		if math.Abs(expected-got) > tolerance {
+			t.Errorf("cluster %d: expected %.3f of the selects, got %.3f", i, expected, got)
+		}
+	}
+	if n := atomic.LoadInt32(&clusters[2].(*mockCluster).countSelect); n != 0 {
+		t.Errorf("expected no selects of the cluster with weight 0, got %d", n)
+	}
+
+	for _, weights := range [][]float64{{1, 1}, {0, 0, 0, 0}, {1, -1, 1, 1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected a panic", weights)
+				}
+			}()
+			New(clusters, len(clusters), SendOneReadOne, NoRepairs, nil, WithClusterWeights(weights...))
+		}()
+	}
+}
+
+func TestNoClusters(t *testing.T) {
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected a panic")
+			}
+		}()
+		New(nil, 0, SendOneReadOne, NoRepairs, nil)
+	}()
+
+	if expected, got := ErrNoClusters, Validate(nil); expected != got {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if expected, got := ErrNoReadClusters, Validate(newMockClusters(3), WithClusterWeights(0, 0, 0)); expected != got {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if err := Validate(newMockClusters(3), WithClusterWeights(0, 1, 0)); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	for name, readStrategy := range map[string]ReadStrategy{
+		"SendOneReadOne":         SendOneReadOne,
+		"SendAllReadAll":         SendAllReadAll,
+		"SendVarReadFirstLinger": SendVarReadFirstLinger(-1, -1),
+	} {
+		farm := &Farm{instrumentation: instrumentation.NopInstrumentation{}}
+		if _, err := readStrategy(farm).SelectOffset([]string{"key"}, 0, 10, common.Descending); err != ErrNoClusters {
+			t.Errorf("%s: expected %v, got %v", name, ErrNoClusters, err)
+		}
+	}
+}
+
 func TestSendAllReadAll(t *testing.T) {
 	clusters := newMockClusters(3)
 	repairs := int32(0)
 	farm := New(clusters, len(clusters), SendAllReadAll, MockRepairs(&repairs), nil)
 	farm.Insert([]common.KeyScoreMember{testingKeyScoreMember})
 
-	result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	if err := checkResult(result, err); err != nil {
 		t.Fatal(err)
 	}
@@ -98,7 +194,7 @@ func TestSendAllReadAll(t *testing.T) {
 	// Now delete the ksm from one cluster and then read it again,
 	// triggering a repair.
 	clusters[0].Delete([]common.KeyScoreMember{testingKeyScoreMember})
-	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	if err := checkResult(result, err); err != nil {
 		t.Fatal(err)
 	}
@@ -112,7 +208,7 @@ func TestSendAllReadAll(t *testing.T) {
 	// Now replace cluster 0 with a failing one. No repairs should
 	// happen. Result should still be returned as normal.
 	clusters[0] = newFailingMockCluster()
-	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	if err := checkResult(result, err); err != nil {
 		t.Fatal(err)
 	}
@@ -129,16 +225,13 @@ func TestSendAllReadAll(t *testing.T) {
 	// be requested.
 	//
 	// We have to "clear" the data in the cluster first, because otherwise the
-	// insert will behave as a no-op, since its score is too low. That
-	// behavior is introduced since writing this test and is relied on for
-	// another test (the repair strategies). The best thing to do would be to
-	// make mockCluster behave exactly like a Redis ZSET, rather than playing
-	// games like this in our tests. TODO.
+	// insert will behave as a no-op, since its score is too low, just like in
+	// production.
 	clusters[1].(*mockCluster).clear()
 	clusters[1].Insert([]common.KeyScoreMember{
 		common.KeyScoreMember{Key: "key", Score: 3.1, Member: "member"},
 	})
-	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	if err := checkResult(result, err); err != nil {
 		t.Fatal(err)
 	}
@@ -153,13 +246,185 @@ func TestSendAllReadAll(t *testing.T) {
 	}
 }
 
+func TestSendAllReadAllSkipsUnreachable(t *testing.T) {
+	var (
+		up1, up2 = newMockCluster(), newMockCluster()
+		tripped  = newMockCluster()
+		clusters = []cluster.Cluster{up1, up2, unreachableCluster{tripped}}
+		repairs  = int32(0)
+		farm     = New(clusters, len(clusters), SendAllReadAll, MockRepairs(&repairs), nil)
+	)
+	for _, c := range []*mockCluster{up1, up2, tripped} {
+		c.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	}
+
+	// The tripped cluster is skipped, so the response is incomplete, but
+	// the others agree.
+	result, complete, err := farm.SelectOffsetComplete([]string{"key", "nokey"}, 0, 10, common.Descending)
+	if err := checkResult(result, err); err != nil {
+		t.Fatal(err)
+	}
+	if complete {
+		t.Error("expected an incomplete response")
+	}
+	for i, c := range []*mockCluster{up1, up2, tripped} {
+		expected := int32(1)
+		if c == tripped {
+			expected = 0
+		}
+		if got := atomic.LoadInt32(&c.countSelect); expected != got {
+			t.Errorf("cluster %d: expected %d select call(s), got %d", i, expected, got)
+		}
+	}
+	if expected, got := 0, int(atomic.LoadInt32(&repairs)); expected != got {
+		t.Errorf("expected %d repairs, got %d", expected, got)
+	}
+
+	// If every cluster is tripped, they're all asked anyway.
+	var (
+		down1, down2 = newMockCluster(), newMockCluster()
+		allDown      = New([]cluster.Cluster{unreachableCluster{down1}, unreachableCluster{down2}}, 2, SendAllReadAll, NoRepairs, nil)
+	)
+	if _, err := allDown.SelectOffset([]string{"key"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range []*mockCluster{down1, down2} {
+		if expected, got := int32(1), atomic.LoadInt32(&c.countSelect); expected != got {
+			t.Errorf("all down, cluster %d: expected %d select call(s), got %d", i, expected, got)
+		}
+	}
+}
+
+func TestSendKReadAll(t *testing.T) {
+	clusters := newMockClusters(5)
+	repairs := int32(0)
+	farm := New(clusters, len(clusters), SendKReadAll(2), MockRepairs(&repairs), nil, WithRand(rand.New(rand.NewSource(42))))
+	for _, c := range clusters {
+		c.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	}
+
+	// Every read goes to exactly k clusters, spread across all of them.
+	const reads = 100
+	for i := 0; i < reads; i++ {
+		result, complete, err := farm.SelectOffsetComplete([]string{"key", "nokey"}, 0, 10, common.Descending)
+		if err := checkResult(result, err); err != nil {
+			t.Fatal(err)
+		}
+		if !complete {
+			t.Error("expected a complete response")
+		}
+	}
+	if expected, got := 2*reads, totalSelectCount(clusters); expected != got {
+		t.Errorf("expected %d select calls, got %d", expected, got)
+	}
+	for i, c := range clusters {
+		if n := atomic.LoadInt32(&c.(*mockCluster).countSelect); n == 0 {
+			t.Errorf("cluster %d: no select calls", i)
+		}
+	}
+	if expected, got := 0, int(atomic.LoadInt32(&repairs)); expected != got {
+		t.Errorf("expected %d repairs, got %d", expected, got)
+	}
+
+	// Conflicts between the clusters which were read are repaired.
+	for _, c := range clusters {
+		c.Insert([]common.KeyScoreMember{{Key: "key", Score: 1, Member: "other"}})
+	}
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "key", Score: 2, Member: "other"}})
+	two := New(clusters[:2], 2, SendKReadAll(2), MockRepairs(&repairs), nil)
+	if _, err := two.SelectOffset([]string{"key"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, int(atomic.LoadInt32(&repairs)); expected != got {
+		t.Errorf("expected %d repairs, got %d", expected, got)
+	}
+
+	if totalOpenChannelCount(clusters) > 0 {
+		t.Error("not all channels closed")
+	}
+}
+
+func TestSendKReadAllEscalates(t *testing.T) {
+	// The weights make SendKReadAll pick the failing clusters.
+	clusters := []cluster.Cluster{newFailingMockCluster(), newFailingMockCluster(), newMockCluster(), newMockCluster()}
+	for _, c := range clusters[2:] {
+		c.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	}
+	farm := New(clusters, len(clusters), SendKReadAll(2), NoRepairs, nil, WithClusterWeights(1, 1, 0, 0))
+
+	result, complete, err := farm.SelectOffsetComplete([]string{"key", "nokey"}, 0, 10, common.Descending)
+	if err := checkResult(result, err); err != nil {
+		t.Fatal(err)
+	}
+	if complete {
+		t.Error("expected an incomplete response")
+	}
+	for i, c := range clusters {
+		if expected, got := int32(1), atomic.LoadInt32(&c.(*mockCluster).countSelect); expected != got {
+			t.Errorf("cluster %d: expected %d select call(s), got %d", i, expected, got)
+		}
+	}
+
+	// If every cluster fails, so does the read.
+	for i := range clusters {
+		clusters[i] = newFailingMockCluster()
+	}
+	if _, err := farm.SelectOffset([]string{"key"}, 0, 10, common.Descending); err == nil {
+		t.Error("expected an error")
+	}
+	if expected, got := 4, totalSelectCount(clusters); expected != got {
+		t.Errorf("expected %d select calls, got %d", expected, got)
+	}
+}
+
+func TestSendKReadAllSkipsUnreachable(t *testing.T) {
+	// The weights make SendKReadAll pick the tripped cluster.
+	var (
+		up1, up2 = newMockCluster(), newMockCluster()
+		tripped  = newMockCluster()
+		clusters = []cluster.Cluster{up1, unreachableCluster{tripped}, up2}
+		farm     = New(clusters, len(clusters), SendKReadAll(2), NoRepairs, nil, WithClusterWeights(1, 1, 0))
+	)
+	for _, c := range []*mockCluster{up1, up2, tripped} {
+		c.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	}
+
+	result, complete, err := farm.SelectOffsetComplete([]string{"key", "nokey"}, 0, 10, common.Descending)
+	if err := checkResult(result, err); err != nil {
+		t.Fatal(err)
+	}
+	if complete {
+		t.Error("expected an incomplete response")
+	}
+	for i, c := range []*mockCluster{up1, tripped, up2} {
+		expected := int32(0)
+		if c == up1 {
+			expected = 1
+		}
+		if got := atomic.LoadInt32(&c.countSelect); expected != got {
+			t.Errorf("cluster %d: expected %d select call(s), got %d", i, expected, got)
+		}
+	}
+}
+
+func TestSendKReadAllAllClusters(t *testing.T) {
+	for _, k := range []int{0, 3, 4} {
+		clusters := newMockClusters(3)
+		farm := New(clusters, len(clusters), SendKReadAll(k), NoRepairs, nil)
+		farm.SelectOffset([]string{"key"}, 0, 10, common.Descending)
+		if expected, got := 3, totalSelectCount(clusters); expected != got {
+			t.Errorf("k=%d: expected %d select calls, got %d", k, expected, got)
+		}
+	}
+}
+
 func TestSendAllReadFirstLinger(t *testing.T) {
 	clusters := newMockClusters(3)
 	repairs := int32(0)
 	farm := New(clusters, len(clusters), SendAllReadFirstLinger, MockRepairs(&repairs), nil)
 	farm.Insert([]common.KeyScoreMember{testingKeyScoreMember})
 
-	result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	// Sleep to give the "lingering" goroutine a chance to run.
 	time.Sleep(time.Millisecond)
 	if err := checkResult(result, err); err != nil {
@@ -177,7 +442,7 @@ func TestSendAllReadFirstLinger(t *testing.T) {
 	// randomly come from cluster 0 or another one (that still has
 	// the ksm).
 	clusters[0].Delete([]common.KeyScoreMember{testingKeyScoreMember})
-	_, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	_, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	if err != nil {
 		t.Error(err)
 	}
@@ -193,7 +458,7 @@ func TestSendAllReadFirstLinger(t *testing.T) {
 	// Now replace cluster 0 with a failing one. No repairs should
 	// happen. Result should again be returned reproducibly.
 	clusters[0] = newFailingMockCluster()
-	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	if err != nil {
 		t.Error(err)
 	}
@@ -223,7 +488,7 @@ func TestSendAllReadFirstLinger(t *testing.T) {
 	clusters[1].Insert([]common.KeyScoreMember{
 		common.KeyScoreMember{Key: "key", Score: 3.1, Member: "member"},
 	})
-	_, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	_, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	// Sleep to give the "lingering" goroutine a chance to run.
 	time.Sleep(time.Millisecond)
 	if err != nil {
@@ -253,7 +518,7 @@ func TestSendVarReadFirstLinger(t *testing.T) {
 	)
 	farm.Insert([]common.KeyScoreMember{testingKeyScoreMember})
 
-	result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	// Sleep to give the "lingering" goroutine a chance to run.
 	time.Sleep(time.Millisecond)
 	if err := checkResult(result, err); err != nil {
@@ -267,7 +532,7 @@ func TestSendVarReadFirstLinger(t *testing.T) {
 	}
 
 	// Do the same again (within 1s). This time, it should do SendOne only.
-	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	// Sleep to give the "lingering" goroutine a chance to run.
 	time.Sleep(time.Millisecond)
 	if err := checkResult(result, err); err != nil {
@@ -288,7 +553,7 @@ func TestSendVarReadFirstLinger(t *testing.T) {
 	for i := range clusters {
 		clusters[i] = newFailingMockCluster()
 	}
-	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10, common.Descending)
 	// Sleep to give the "lingering" goroutine a chance to run.
 	time.Sleep(time.Millisecond)
 	if err == nil {
@@ -305,3 +570,505 @@ func TestSendVarReadFirstLinger(t *testing.T) {
 		t.Error("not all channels closed")
 	}
 }
+
+func TestSendVarReadFirstLingerPromotionReasons(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		wrap           func(cluster.Cluster) cluster.Cluster
+		latency, error int32
+	}{
+		{"slow", func(c cluster.Cluster) cluster.Cluster { return slowCluster{c, 25 * time.Millisecond} }, 1, 0},
+		{"erroring", func(c cluster.Cluster) cluster.Cluster { return erroringCluster{c} }, 0, 1},
+	} {
+		clusters := newMockClusters(3)
+		for i := range clusters {
+			clusters[i] = tc.wrap(clusters[i])
+		}
+		instr := &promotionCountingInstrumentation{}
+		farm := New(clusters, len(clusters), SendVarReadFirstLinger(0, time.Millisecond), NoRepairs, instr)
+		farm.SelectOffset([]string{"key"}, 0, 10, common.Descending)
+
+		// Instrumentation is called asynchronously.
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&instr.latency)+atomic.LoadInt32(&instr.error) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if expected, got := tc.latency, atomic.LoadInt32(&instr.latency); expected != got {
+			t.Errorf("%s: expected %d latency promotion(s), got %d", tc.name, expected, got)
+		}
+		if expected, got := tc.error, atomic.LoadInt32(&instr.error); expected != got {
+			t.Errorf("%s: expected %d error promotion(s), got %d", tc.name, expected, got)
+		}
+	}
+}
+
+func TestSendVarReadFirstLingerPromotedRepairs(t *testing.T) {
+	// SendOne always picks the first cluster, which fails the read, and is
+	// empty. The promoted read hears from the others only, one of them
+	// stale, yet the repairs reach every cluster.
+	clusters := newMockClusters(3)
+	clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	clusters[2].Insert([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "a"}, {Key: "foo", Score: 1, Member: "b"}})
+	clusters[0] = erroringCluster{clusters[0]}
+	farm := New(
+		clusters,
+		len(clusters),
+		SendVarReadFirstLinger(0, time.Millisecond),
+		AllRepairs,
+		nil,
+		WithClusterWeights(1, 0, 0),
+	)
+
+	// The response may come from the stale cluster, but the repairs, which
+	// are asynchronous, fix every cluster.
+	if _, err := farm.SelectOffset([]string{"foo"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+	keyMembers := []common.KeyMember{{Key: "foo", Member: "a"}, {Key: "foo", Member: "b"}}
+	repaired := func(c cluster.Cluster) bool {
+		presence, err := c.Score(keyMembers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return presence[keyMembers[0]].Score == 2 && presence[keyMembers[1]].Present
+	}
+	deadline := time.Now().Add(time.Second)
+	for i, c := range clusters {
+		for !repaired(c) {
+			if time.Now().After(deadline) {
+				t.Fatalf("cluster %d wasn't repaired", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestSendAllReadFirstLingerMaxLinger(t *testing.T) {
+	clusters := newMockClusters(3)
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}})
+	stuck := stuckCluster{clusters[2], make(chan struct{}), make(chan struct{})}
+	clusters[2] = stuck
+
+	repairs := make(chan []common.KeyMember, 1)
+	repairStrategy := func([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRepairStrategy {
+		return func(kms []common.KeyMember) { repairs <- kms }
+	}
+	farm := New(clusters, len(clusters), SendAllReadFirstLinger, repairStrategy, nil, WithMaxLinger(10*time.Millisecond))
+	if _, err := farm.SelectOffset([]string{"foo"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stuck cluster never responds, but lingering is abandoned, and
+	// repairs are issued with the responses of the others.
+	select {
+	case kms := <-repairs:
+		if expected, got := 2, len(kms); expected != got {
+			t.Errorf("expected %d repair(s), got %d (%v)", expected, got, kms)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lingering wasn't abandoned")
+	}
+
+	// Late responses are discarded, rather than blocking the Select.
+	close(stuck.release)
+	select {
+	case <-stuck.done:
+	case <-time.After(time.Second):
+		t.Fatal("late responses weren't discarded")
+	}
+}
+
+func TestSendAllReadFirstLingerFirstResponseGrace(t *testing.T) {
+	// The fastest cluster is stale, the others are 20ms behind with a write
+	// it missed.
+	clusters := newMockClusters(3)
+	stale := []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}
+	fresh := []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}, {Key: "foo", Score: 1, Member: "a"}}
+	clusters[0].Insert(stale)
+	for i := 1; i < len(clusters); i++ {
+		clusters[i].Insert(fresh)
+		clusters[i] = slowCluster{clusters[i], 20 * time.Millisecond}
+	}
+
+	for _, tc := range []struct {
+		options  []Option
+		expected []common.KeyScoreMember
+	}{
+		{nil, stale},
+		{[]Option{WithFirstResponseGrace(time.Second)}, fresh},
+	} {
+		farm := New(clusters, len(clusters), SendAllReadFirstLinger, NoRepairs, nil, tc.options...)
+		began := time.Now()
+		result, err := farm.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := tc.expected, result["foo"]; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%d option(s): expected %v, got %v", len(tc.options), expected, got)
+		}
+
+		// Once every cluster responded, the grace ends early.
+		if took := time.Since(began); took > 500*time.Millisecond {
+			t.Errorf("%d option(s): Select took %s", len(tc.options), took)
+		}
+	}
+}
+
+func TestSelectClusterDurations(t *testing.T) {
+	clusters := newMockClusters(3)
+	clusters[1] = slowCluster{clusters[1], 50 * time.Millisecond}
+	instr := &clusterTimingInstrumentation{
+		firstResponse: map[int]time.Duration{},
+		duration:      map[int]time.Duration{},
+	}
+	farm := New(clusters, len(clusters), SendAllReadAll, NoRepairs, instr)
+	if _, err := farm.SelectOffset([]string{"foo", "bar"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+
+	// SendAllReadAll waits for every cluster, so all of them reported.
+	instr.Lock()
+	defer instr.Unlock()
+	if expected, got := len(clusters), len(instr.duration); expected != got {
+		t.Fatalf("expected durations of %d cluster(s), got %v", expected, instr.duration)
+	}
+	for index := range clusters {
+		first, last := instr.firstResponse[index], instr.duration[index]
+		if slow := index == 1; slow != (first >= 50*time.Millisecond) || slow != (last >= 50*time.Millisecond) {
+			t.Errorf("cluster %d: unexpected first response after %s, last after %s", index, first, last)
+		}
+		if first > last {
+			t.Errorf("cluster %d: first response after %s, but last after %s", index, first, last)
+		}
+	}
+}
+
+// clusterTimingInstrumentation records the Select durations by cluster.
+type clusterTimingInstrumentation struct {
+	instrumentation.NopInstrumentation
+	sync.Mutex
+	firstResponse map[int]time.Duration
+	duration      map[int]time.Duration
+}
+
+func (i *clusterTimingInstrumentation) SelectClusterFirstResponseDuration(index int, d time.Duration) {
+	i.Lock()
+	defer i.Unlock()
+	i.firstResponse[index] = d
+}
+
+func (i *clusterTimingInstrumentation) SelectClusterDuration(index int, d time.Duration) {
+	i.Lock()
+	defer i.Unlock()
+	i.duration[index] = d
+}
+
+// stuckCluster doesn't respond to SelectOffset until release is closed.
+// Then, it sends the wrapped cluster's elements twice, and closes done.
+type stuckCluster struct {
+	cluster.Cluster
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (c stuckCluster) SelectOffset(keys []string, offset, limit int, order common.Order) <-chan cluster.Element {
+	out := make(chan cluster.Element)
+	go func() {
+		defer close(out)
+		<-c.release
+		for i := 0; i < 2; i++ {
+			for e := range c.Cluster.SelectOffset(keys, offset, limit, order) {
+				out <- e
+			}
+		}
+		close(c.done)
+	}()
+	return out
+}
+
+// slowCluster delays every SelectOffset of the wrapped cluster.
+type slowCluster struct {
+	cluster.Cluster
+	delay time.Duration
+}
+
+func (c slowCluster) SelectOffset(keys []string, offset, limit int, order common.Order) <-chan cluster.Element {
+	out := make(chan cluster.Element)
+	go func() {
+		defer close(out)
+		time.Sleep(c.delay)
+		for e := range c.Cluster.SelectOffset(keys, offset, limit, order) {
+			out <- e
+		}
+	}()
+	return out
+}
+
+// erroringCluster returns an error element for every key in SelectOffset.
+type erroringCluster struct {
+	cluster.Cluster
+}
+
+func (c erroringCluster) SelectOffset(keys []string, offset, limit int, order common.Order) <-chan cluster.Element {
+	out := make(chan cluster.Element, len(keys))
+	for _, key := range keys {
+		out <- cluster.Element{Key: key, Error: errors.New("failtown, population you")}
+	}
+	close(out)
+	return out
+}
+
+// promotionCountingInstrumentation counts SendAll promotions by reason.
+type promotionCountingInstrumentation struct {
+	instrumentation.NopInstrumentation
+	latency, error int32
+}
+
+func (i *promotionCountingInstrumentation) SelectPromotionLatency() { atomic.AddInt32(&i.latency, 1) }
+func (i *promotionCountingInstrumentation) SelectPromotionError()   { atomic.AddInt32(&i.error, 1) }
+
+func TestSelectComplete(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		readStrategy ReadStrategy
+	}{
+		{"SendOneReadOne", SendOneReadOne},
+		{"SendAllReadAll", SendAllReadAll},
+		{"SendAllReadFirstLinger", SendAllReadFirstLinger},
+	} {
+		clusters := newMockClusters(3)
+		farm := New(clusters, len(clusters), tc.readStrategy, NoRepairs, nil)
+		farm.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+
+		// All clusters healthy: the response is complete.
+		result, complete, err := farm.SelectOffsetComplete([]string{"key", "nokey"}, 0, 10, common.Descending)
+		if err := checkResult(result, err); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+		if !complete {
+			t.Errorf("%s: expected complete response, got incomplete", tc.name)
+		}
+
+		// No cluster healthy: any response is incomplete.
+		for i := range clusters {
+			clusters[i] = newFailingMockCluster()
+		}
+		if _, complete, _ := farm.SelectOffsetComplete([]string{"key", "nokey"}, 0, 10, common.Descending); complete {
+			t.Errorf("%s: expected incomplete response, got complete", tc.name)
+		}
+	}
+
+	// SendAllReadAll waits for all clusters, so one failing cluster makes
+	// the response incomplete, even though the results are correct.
+	clusters := newMockClusters(3)
+	farm := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil)
+	farm.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	clusters[0] = newFailingMockCluster()
+	result, complete, err := farm.SelectOffsetComplete([]string{"key", "nokey"}, 0, 10, common.Descending)
+	if err := checkResult(result, err); err != nil {
+		t.Error(err)
+	}
+	if complete {
+		t.Error("expected incomplete response, got complete")
+	}
+}
+
+func TestReadErrorTolerance(t *testing.T) {
+	for name, readStrategy := range map[string]ReadStrategy{
+		"SendAllReadAll": SendAllReadAll,
+		"SendKReadAll":   SendKReadAll(0),
+	} {
+		for _, tc := range []struct {
+			tolerance float64
+			failing   int
+			degraded  bool
+		}{
+			{1, 3, false},
+			{0.5, 0, false},
+			{0.5, 1, false},
+			{0.5, 2, false},
+			{0.5, 3, true},
+			{0.25, 2, true},
+			{0, 1, true},
+		} {
+			clusters := newMockClusters(4)
+			farm := New(clusters, 1, readStrategy, NoRepairs, nil, WithReadErrorTolerance(tc.tolerance))
+			farm.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+			for i := 0; i < tc.failing; i++ {
+				clusters[i] = newFailingMockCluster()
+			}
+
+			result, _, err := farm.SelectOffsetComplete([]string{"key"}, 0, 10, common.Descending)
+			if !tc.degraded {
+				if err := checkResult(result, err); err != nil {
+					t.Errorf("%s: tolerance %v, %d failing: %s", name, tc.tolerance, tc.failing, err)
+				}
+				continue
+			}
+			e, ok := err.(TooDegradedError)
+			if !ok {
+				t.Errorf("%s: tolerance %v, %d failing: expected TooDegradedError, got %v", name, tc.tolerance, tc.failing, err)
+				continue
+			}
+			if expected, got := (TooDegradedError{Key: "key", Failed: tc.failing, Clusters: 4}), e; expected != got {
+				t.Errorf("%s: tolerance %v, %d failing: expected %+v, got %+v", name, tc.tolerance, tc.failing, expected, got)
+			}
+		}
+	}
+
+	if err := Validate(newMockClusters(1), WithReadErrorTolerance(1.5)); err == nil {
+		t.Errorf("expected an invalid tolerance to fail validation")
+	}
+}
+
+func TestRepairObserver(t *testing.T) {
+	// Only the first cluster has the members, so every member diverged.
+	var (
+		clusters = newMockClusters(3)
+		mtx      sync.Mutex
+		observed = map[string]int{}
+		observe  = func(key string, diverged int) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			observed[key] += diverged
+		}
+		farm = New(clusters, 1, SendAllReadAll, NoRepairs, nil, WithMaxRepairsPerSelect(1), WithRepairObserver(observe))
+	)
+	if err := clusters[0].Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "bar", Score: 3, Member: "c"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := farm.SelectOffset([]string{"foo", "bar", "baz"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if expected, got := map[string]int{"foo": 2, "bar": 1}, observed; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestMaxRepairsPerSelect(t *testing.T) {
+	// Only the first cluster has the key, so every member needs a repair.
+	var (
+		clusters  = newMockClusters(3)
+		instr     = &repairTruncationInstrumentation{}
+		requested = []common.KeyMember{}
+		recording = func([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRepairStrategy {
+			return func(kms []common.KeyMember) { requested = append(requested, kms...) }
+		}
+		farm   = New(clusters, 1, SendAllReadAll, recording, instr, WithMaxRepairsPerSelect(10))
+		tuples = []common.KeyScoreMember{}
+	)
+	for i := 0; i < 100; i++ {
+		tuples = append(tuples, common.KeyScoreMember{Key: "foo", Score: float64(i), Member: fmt.Sprint(i)})
+	}
+	if err := clusters[0].Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := farm.SelectOffset([]string{"foo"}, 0, 100, common.Descending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 100, len(response["foo"]); expected != got {
+		t.Errorf("expected %d records, got %d", expected, got)
+	}
+	if expected, got := 10, len(requested); expected != got {
+		t.Errorf("expected %d repairs, got %d", expected, got)
+	}
+	if expected, got := 100, instr.needed; expected != got {
+		t.Errorf("expected %d repairs needed, got %d", expected, got)
+	}
+	if expected, got := 90, instr.truncated; expected != got {
+		t.Errorf("expected %d repairs truncated, got %d", expected, got)
+	}
+}
+
+func TestScoreTolerance(t *testing.T) {
+	for _, tc := range []struct {
+		options []Option
+		repairs int
+	}{
+		{nil, 1},
+		{[]Option{WithScoreTolerance(1e-6, 0)}, 0},
+		{[]Option{WithScoreTolerance(0, 1e-9)}, 0},
+		{[]Option{WithScoreTolerance(1e-12, 1e-12)}, 1},
+	} {
+		var (
+			clusters = newMockClusters(2)
+			repairs  = int32(0)
+			farm     = New(clusters, len(clusters), SendAllReadAll, MockRepairs(&repairs), nil, tc.options...)
+		)
+		clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1.0, Member: "a"}})
+		clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1.0000000001, Member: "a"}})
+
+		response, err := farm.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := []common.KeyScoreMember{{Key: "foo", Score: 1.0000000001, Member: "a"}}, response["foo"]; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%d option(s): expected %v, got %v", len(tc.options), expected, got)
+		}
+		if expected, got := tc.repairs, int(atomic.LoadInt32(&repairs)); expected != got {
+			t.Errorf("%v: expected %d repair(s), got %d", farm.tolerance, expected, got)
+		}
+	}
+}
+
+// repairTruncationInstrumentation counts the repairs Selects needed and
+// truncated, which SendAllReadAll reports synchronously.
+type repairTruncationInstrumentation struct {
+	instrumentation.NopInstrumentation
+	needed, truncated int
+}
+
+func (i *repairTruncationInstrumentation) SelectRepairNeeded(n int)    { i.needed += n }
+func (i *repairTruncationInstrumentation) SelectRepairTruncated(n int) { i.truncated += n }
+
+func TestSelectOffsetPastEnd(t *testing.T) {
+	clusters := newMockClusters(2)
+	instr := &offsetPastEndInstrumentation{}
+	farm := New(clusters, len(clusters), SendAllReadAll, NoRepairs, instr, WithSelectCache(10, time.Minute))
+	if err := farm.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		read     Selecter
+		offset   int
+		expected int32 // cumulatively
+	}{
+		{farm, 0, 0},                             // offset 0 is never counted
+		{farm, 1, 1},                             // only the missing key
+		{farm, 2, 3},                             // both keys
+		{farm, 2, 5},                             // cache hits count, too
+		{farm.ReadingWith(SendOneReadOne), 5, 7}, // and reads bypassing the farm
+	} {
+		if _, err := tc.read.SelectOffset([]string{"foo", "bar"}, tc.offset, 10, common.Descending); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt32(&instr.count); tc.expected != got {
+			t.Errorf("%d: offset %d: expected %d, got %d", i, tc.offset, tc.expected, got)
+		}
+	}
+}
+
+// offsetPastEndInstrumentation counts keys paged past their end.
+type offsetPastEndInstrumentation struct {
+	instrumentation.NopInstrumentation
+	count int32
+}
+
+func (i *offsetPastEndInstrumentation) SelectOffsetPastEnd(n int) {
+	atomic.AddInt32(&i.count, int32(n))
+}
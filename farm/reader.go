@@ -0,0 +1,90 @@
+package farm
+
+import (
+	"github.com/soundcloud/roshi/common"
+)
+
+// ReadStrategyOverrider is a Selecter which can read with another
+// ReadStrategy for some calls. Farm implements it.
+type ReadStrategyOverrider interface {
+	Selecter
+	ReadingWith(readStrategy ReadStrategy) *Reader
+}
+
+// Reader is a view of a Farm which reads with another ReadStrategy. It
+// implements CompletenessSelecter, MergingSelecter, FloorSelecter, and
+// cluster.Tombstoner, like the Farm itself, and is safe for concurrent use.
+type Reader struct {
+	farm     *Farm
+	selecter Selecter
+}
+
+// ReadingWith returns a view of the farm which reads with readStrategy,
+// rather than the ReadStrategy the farm was created with. Reads through the
+// view bypass the select cache, and don't fill it.
+//
+// Use it for calls which need another tradeoff than most. For example, after
+// a quorum write, SendOneReadOne may read from a cluster which hasn't seen
+// the write yet. Reading with SendAllReadAll instead returns the union of
+// all clusters, which includes the write as long as its clusters respond,
+// at the cost of waiting for the slowest cluster, and loading every cluster
+// with the read. The view is cheap, and may be kept around.
+func (f *Farm) ReadingWith(readStrategy ReadStrategy) *Reader {
+	return &Reader{farm: f, selecter: readStrategy(f)}
+}
+
+// SelectOffset satisfies Selecter.
+func (r *Reader) SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := r.SelectOffsetComplete(keys, offset, limit, order)
+	return response, err
+}
+
+// SelectRange satisfies Selecter.
+func (r *Reader) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := r.SelectRangeComplete(keys, start, stop, limit)
+	return response, err
+}
+
+// SelectOffsetComplete satisfies CompletenessSelecter.
+func (r *Reader) SelectOffsetComplete(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	if len(keys) <= 0 {
+		return map[string][]common.KeyScoreMember{}, true, nil
+	}
+	if max := r.farm.maxSelectKeys; max > 0 && len(keys) > max {
+		return map[string][]common.KeyScoreMember{}, false, TooManyKeysError{Keys: len(keys), Max: max}
+	}
+	response, complete, err := selectOffsetComplete(r.selecter, keys, offset, limit, order)
+	if err == nil {
+		r.farm.reportOffsetPastEnd(keys, offset, response)
+	}
+	return response, complete, err
+}
+
+// SelectRangeComplete satisfies CompletenessSelecter.
+func (r *Reader) SelectRangeComplete(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	if len(keys) <= 0 {
+		return map[string][]common.KeyScoreMember{}, true, nil
+	}
+	if max := r.farm.maxSelectKeys; max > 0 && len(keys) > max {
+		return map[string][]common.KeyScoreMember{}, false, TooManyKeysError{Keys: len(keys), Max: max}
+	}
+	return selectRangeComplete(r.selecter, keys, start, stop, limit)
+}
+
+// SelectMerged is Farm.SelectMerged, reading with the ReadStrategy of the
+// view.
+func (r *Reader) SelectMerged(keys []string, offset, limit int, order common.Order) ([]common.KeyScoreMember, error) {
+	records, _, err := r.SelectMergedComplete(keys, offset, limit, order)
+	return records, err
+}
+
+// SelectMergedComplete satisfies MergingSelecter.
+func (r *Reader) SelectMergedComplete(keys []string, offset, limit int, order common.Order) ([]common.KeyScoreMember, bool, error) {
+	return selectMergedComplete(r, r.farm.maxSelectKeys, keys, offset, limit, order)
+}
+
+// Tombstoned satisfies cluster.Tombstoner. It asks every cluster regardless
+// of the ReadStrategy, like Farm.Tombstoned.
+func (r *Reader) Tombstoned(keys []string) (map[string]bool, error) {
+	return r.farm.Tombstoned(keys)
+}
@@ -0,0 +1,63 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+var (
+	_ MergingSelecter       = &Reader{}
+	_ cluster.Tombstoner    = &Reader{}
+	_ ReadStrategyOverrider = &Farm{}
+)
+
+func TestReadingWith(t *testing.T) {
+	// The write only reached one of the clusters, so SendOneReadOne misses it
+	// half of the time, and the cached empty response hides it for a minute.
+	var (
+		clusters = newMockClusters(2)
+		farm     = New(clusters, 1, SendOneReadOne, NoRepairs, nil, WithSelectCache(10, time.Minute))
+		strong   = farm.ReadingWith(SendAllReadAll)
+		tuple    = common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"}
+		expected = map[string][]common.KeyScoreMember{"foo": {tuple}}
+	)
+	if _, err := farm.SelectOffset([]string{"foo"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+	if err := clusters[1].Insert([]common.KeyScoreMember{tuple}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		got, complete, err := strong.SelectOffsetComplete([]string{"foo"}, 0, 10, common.Descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !complete {
+			t.Errorf("expected a complete response")
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("SelectOffset: expected %v, got %v", expected, got)
+		}
+		merged, err := strong.SelectMerged([]string{"foo"}, 0, 10, common.Descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected["foo"], merged) {
+			t.Fatalf("SelectMerged: expected %v, got %v", expected["foo"], merged)
+		}
+	}
+
+	// The cache of the farm is untouched.
+	got, err := farm.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (map[string][]common.KeyScoreMember{"foo": {}}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("cached: expected %v, got %v", expected, got)
+	}
+}
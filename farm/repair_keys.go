@@ -0,0 +1,109 @@
+package farm
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// KeyRepairer can repair keys on demand, and report the outcome. Farm
+// implements it.
+type KeyRepairer interface {
+	RepairKeys(keys []string, limit int) (RepairReport, error)
+}
+
+// RepairReport is the outcome of RepairKeys.
+type RepairReport struct {
+	Inconsistent int   // key-members which differed between clusters
+	Written      []int // per cluster, key-members repaired by writing to it
+	Failed       []int // per cluster, key-members which failed to be written
+	Complete     bool  // true if every cluster was read for every key
+}
+
+// RepairKeys repairs the keys, and waits for the repairs, e.g. to fix keys
+// which clients saw differ, and confirm that they converged. Like
+// SendAllReadAll, it reads up to limit members of every key from every
+// cluster, and finds the key-members which differ. Unlike it, it repairs
+// them right away, like AllRepairs, regardless of the RepairStrategy of the
+// farm, and its buffers and rate limits. Clusters which fail the read, or
+// are unreachable according to their health checks, aren't compared, so
+// they aren't repaired; the report isn't Complete then.
+//
+// RepairKeys reads every member of the keys, up to limit, from every
+// cluster, and checks every inconsistent key-member in every cluster, so
+// it's expensive for large keys. It's meant for a few keys at a time; walking
+// the keyspace is the job of roshi-walker. A read-only farm returns
+// ErrReadOnly.
+func (f *Farm) RepairKeys(keys []string, limit int) (RepairReport, error) {
+	report := RepairReport{
+		Written: make([]int, len(f.clusters)),
+		Failed:  make([]int, len(f.clusters)),
+	}
+	if f.readOnly {
+		return report, ErrReadOnly
+	}
+	if len(keys) <= 0 {
+		report.Complete = true
+		return report, nil
+	}
+	if max := f.maxSelectKeys; max > 0 && len(keys) > max {
+		return report, TooManyKeysError{Keys: len(keys), Max: max}
+	}
+
+	// Scatter
+	var (
+		elements = make(chan cluster.Element)
+		wg       = sync.WaitGroup{}
+	)
+	for index, keys := range f.reachableKeys(f.clusterIndexes(), keys) {
+		if len(keys) <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(c cluster.Cluster, keys []string) {
+			defer wg.Done()
+			for e := range c.SelectOffset(keys, 0, limit, common.Descending) {
+				elements <- e
+			}
+		}(f.clusters[index], keys)
+	}
+	go func() { wg.Wait(); close(elements) }()
+
+	// Gather
+	responses := map[string][]tupleSet{}
+	for e := range elements {
+		if e.Error != nil {
+			log.Printf("RepairKeys: %s", e.Error)
+			continue
+		}
+		responses[e.Key] = append(responses[e.Key], makeSet(e.KeyScoreMembers))
+	}
+	if len(responses) <= 0 {
+		return report, fmt.Errorf("all clusters failed")
+	}
+
+	// Compare
+	var (
+		repairs  = keyMemberSet{}
+		complete = len(responses) >= len(keys)
+	)
+	for _, tupleSets := range responses {
+		if len(tupleSets) < len(f.clusters) {
+			complete = false
+		}
+		_, difference := unionDifference(tupleSets, f.tolerance)
+		repairs.addMany(difference)
+	}
+	report.Inconsistent, report.Complete = len(repairs), complete
+	if len(repairs) <= 0 {
+		return report, nil
+	}
+
+	// Repair
+	written, failed, err := repairKeyMembers(f.clusters, repairOptions{tolerance: f.tolerance, timestampUnit: f.timestampUnit}, f.instrumentation, nil, 0, repairs.slice())
+	report.Written, report.Failed = written, failed
+	return report, err
+}
@@ -0,0 +1,71 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/memcluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestRepairKeys(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{memcluster.New(10), memcluster.New(10), memcluster.New(10)}
+		farm     = New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+	)
+	farm.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}})
+	clusters[2].Delete([]common.KeyScoreMember{{Key: "foo", Score: 3, Member: "a"}})
+
+	// The delete of a is written to the first two clusters, and b to the
+	// last two.
+	report, err := farm.RepairKeys([]string{"foo", "bar"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := RepairReport{Inconsistent: 2, Written: []int{1, 2, 1}, Failed: []int{0, 0, 0}, Complete: true}
+	if !reflect.DeepEqual(expected, report) {
+		t.Errorf("expected %+v, got %+v", expected, report)
+	}
+
+	// Afterwards, the clusters agree.
+	report, err = farm.RepairKeys([]string{"foo", "bar"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = RepairReport{Inconsistent: 0, Written: []int{0, 0, 0}, Failed: []int{0, 0, 0}, Complete: true}
+	if !reflect.DeepEqual(expected, report) {
+		t.Errorf("expected %+v, got %+v", expected, report)
+	}
+	for i, c := range clusters {
+		if got := <-c.SelectOffset([]string{"foo"}, 0, 10, common.Descending); !reflect.DeepEqual([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}}, got.KeyScoreMembers) {
+			t.Errorf("cluster %d: got %v", i, got.KeyScoreMembers)
+		}
+	}
+
+	if _, err := New(newFailingMockClusters(2), 1, SendAllReadAll, NoRepairs, nil).RepairKeys([]string{"foo"}, 10); err == nil {
+		t.Error("expected an error when all clusters fail")
+	}
+}
+
+func TestRepairKeysStaleness(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{memcluster.New(10), memcluster.New(10)}
+		instr    = &stalenessRecordingInstrumentation{}
+		farm     = New(clusters, 1, SendAllReadAll, NoRepairs, instr, WithTimestampScores(time.Millisecond))
+		score    = float64(time.Now().Add(-time.Minute).UnixNano() / 1e6) // milliseconds
+	)
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: score, Member: "a"}})
+
+	if _, err := farm.RepairKeys([]string{"foo"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, len(instr.staleness); expected != got {
+		t.Fatalf("expected %d staleness report(s), got %d", expected, got)
+	}
+	if d := instr.staleness[0]; d < time.Minute || d > time.Minute+10*time.Second {
+		t.Errorf("expected a staleness of about a minute, got %s", d)
+	}
+}
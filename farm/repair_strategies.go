@@ -1,7 +1,10 @@
 package farm
 
 import (
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/soundcloud/roshi/cluster"
 	"github.com/soundcloud/roshi/common"
@@ -14,31 +17,74 @@ import (
 // Clusters.
 type RepairStrategy func([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRepairStrategy
 
-// coreRepairStrategy encodes one way of performing repair requests.
+// coreRepairStrategy encodes one way of performing repair requests. Repair
+// requests only carry key-members, not the responses which found them, so
+// that a read which heard from some clusters only, e.g. a SendOne promoted
+// after an error, can't skew the repair: AllRepairs checks every cluster.
 type coreRepairStrategy func(kms []common.KeyMember)
 
 // Nonblocking wraps a RepairStrategy with a buffer of the given size. Repair
-// requests are queued until the buffer is full, and then dropped.
+// requests are queued until the buffer is full, and then dropped. The number
+// of queued requests is reported periodically, so that a backlog can be
+// noticed before requests are dropped.
 //
 // Nonblocking keeps read strategies responsive, while bounding process memory
 // usage.
 func Nonblocking(bufferSize int, repairStrategy RepairStrategy) RepairStrategy {
 	return func(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation) coreRepairStrategy {
-		c := make(chan []common.KeyMember, bufferSize)
-		go func() {
-			for kms := range c {
-				repairStrategy(clusters, instr)(kms)
+		var (
+			b = nonblockingBuffer{
+				c:     make(chan []common.KeyMember, bufferSize),
+				instr: instr,
 			}
+			drained = make(chan struct{})
+		)
+		go func() {
+			defer close(drained)
+			b.drain(clusters, repairStrategy)
 		}()
+		go b.reportDepth(bufferDepthInterval, drained)
+		return b.enqueue
+	}
+}
 
-		return func(kms []common.KeyMember) {
-			select {
-			case c <- kms:
-				break
-			default:
-				log.Printf("Nonblocking repairs: request buffer full; repair request discarded")
-				go instr.RepairDiscarded(len(kms))
-			}
+// bufferDepthInterval is how often Nonblocking reports the number of queued
+// repair requests.
+var bufferDepthInterval = 10 * time.Second
+
+// nonblockingBuffer queues repair requests for Nonblocking.
+type nonblockingBuffer struct {
+	c     chan []common.KeyMember
+	instr instrumentation.RepairInstrumentation
+}
+
+func (b nonblockingBuffer) enqueue(kms []common.KeyMember) {
+	select {
+	case b.c <- kms:
+		break
+	default:
+		log.Printf("Nonblocking repairs: request buffer full; repair request discarded")
+		go b.instr.RepairDiscarded(len(kms))
+	}
+}
+
+func (b nonblockingBuffer) drain(clusters []cluster.Cluster, repairStrategy RepairStrategy) {
+	for kms := range b.c {
+		repairStrategy(clusters, b.instr)(kms)
+	}
+}
+
+// reportDepth reports the number of queued requests every interval, until
+// done is closed, i.e. the buffer is no longer drained.
+func (b nonblockingBuffer) reportDepth(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.instr.RepairBufferDepth(len(b.c))
+		case <-done:
+			return
 		}
 	}
 }
@@ -67,6 +113,32 @@ func RateLimited(maxElementsPerSecond int, repairStrategy RepairStrategy) Repair
 	}
 }
 
+// Bounded wraps a repair strategy with a limit on concurrent repairs. Repair
+// requests beyond the limit wait until a repair completes, so callers block;
+// they are queued, not dropped. The limit is shared by every instantiation of
+// the returned RepairStrategy, e.g. by the Farm and its Readers. A limit of
+// zero or less disables it.
+//
+// Blocking repair strategies run in the goroutine of the Select which found
+// the inconsistencies, so under a repair backlog, every concurrent Select
+// would check and write its repairs concurrently. Bounded caps the Score and
+// write calls in flight at the limit, per cluster. Nonblocking already drains
+// its buffer in a single goroutine.
+func Bounded(maxConcurrent int, repairStrategy RepairStrategy) RepairStrategy {
+	if maxConcurrent <= 0 {
+		return repairStrategy
+	}
+	semaphore := make(chan struct{}, maxConcurrent) // shared by every instantiation
+	return func(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation) coreRepairStrategy {
+		repair := repairStrategy(clusters, instr)
+		return func(kms []common.KeyMember) {
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			repair(kms)
+		}
+	}
+}
+
 // NoRepairs is a no-op repair strategy.
 func NoRepairs([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRepairStrategy {
 	return func([]common.KeyMember) {}
@@ -75,110 +147,287 @@ func NoRepairs([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRep
 // AllRepairs is repair strategy that does what you expect: actually issue
 // repairs with 100% probability.
 //
+// If clusters hold a key-member with the same score in different sets, the
+// delete wins, regardless of the order of the clusters. Clusters which hold
+// it in the right set, with the highest score, aren't written to. Scores are
+// compared exactly; see AllRepairsWith for a tolerance.
+//
 // You may want to wrap AllRepairs with Nonblocking and/or RateLimited to
 // control memory pressure in your process and/or load against your
 // infrastructure, respectively.
 func AllRepairs(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation) coreRepairStrategy {
+	return allRepairs(clusters, repairOptions{}, instr, nil, 0)
+}
+
+// AllRepairsWith is AllRepairs, configured by the options.
+func AllRepairsWith(options ...RepairOption) RepairStrategy {
+	o := newRepairOptions(options)
+	return func(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation) coreRepairStrategy {
+		return allRepairs(clusters, o, instr, nil, 0)
+	}
+}
+
+// RepairOption configures AllRepairsWith and ClusterRateLimitedRepairs.
+type RepairOption func(*repairOptions)
+
+// RepairScoreTolerance makes repairs skip the clusters which hold a
+// key-member in the right set, with a score within the tolerance of the
+// highest score, like WithScoreTolerance does for Selects. Configure both
+// identically, or repairs may write scores which Selects consider equal.
+func RepairScoreTolerance(absolute, relative float64) RepairOption {
+	return func(o *repairOptions) { o.tolerance = scoreTolerance{absolute: absolute, relative: relative} }
+}
+
+// RepairTimestampScores declares that scores are Unix timestamps in units of
+// unit, e.g. time.Millisecond, so that repairs report how stale the
+// clusters they write to were, see RepairStaleness of
+// instrumentation.RepairInstrumentation: for every key-member written, how
+// long ago its score was. By default, or with a unit of zero or less, scores
+// aren't interpreted, and staleness isn't reported.
+func RepairTimestampScores(unit time.Duration) RepairOption {
+	return func(o *repairOptions) { o.timestampUnit = unit }
+}
+
+// repairOptions are the settings of AllRepairs, see RepairOption. The zero
+// value compares scores exactly, and doesn't report staleness.
+type repairOptions struct {
+	tolerance     scoreTolerance
+	timestampUnit time.Duration
+}
+
+func newRepairOptions(options []RepairOption) repairOptions {
+	var o repairOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// ClusterRateLimitedRepairs is AllRepairs, with a separate rate limit on the
+// repair writes to each cluster. The writes to a cluster are split into
+// batches of at most the passed limit of elements (score-members). Batches
+// which would exceed the limit per second are dropped, not delayed, and
+// reported as throttled; their key-members are repaired the next time
+// they're read. Batches before them are still written, so even a key larger
+// than the limit makes progress.
+//
+// ClusterRateLimitedRepairs lets a cluster which rejoins empty be rebuilt at
+// a controlled pace, without holding back the repairs of other clusters,
+// which waiting for the limit would. Unlike RateLimited, it doesn't bound
+// the load of the repair checks.
+//
+// A limit of zero or less disables it, which makes ClusterRateLimitedRepairs
+// the same as AllRepairsWith the options.
+func ClusterRateLimitedRepairs(maxElementsPerSecond int, options ...RepairOption) RepairStrategy {
+	if maxElementsPerSecond <= 0 {
+		return AllRepairsWith(options...)
+	}
+	o := newRepairOptions(options)
+	var (
+		mtx     sync.Mutex
+		permits []permitter // shared by every instantiation
+	)
+	return func(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation) coreRepairStrategy {
+		mtx.Lock()
+		for len(permits) < len(clusters) {
+			permits = append(permits, tokenBucketPermitter{tb.NewBucket(int64(maxElementsPerSecond), 0)})
+		}
+		p := permits[:len(clusters)]
+		mtx.Unlock()
+		return allRepairs(clusters, o, instr, p, maxElementsPerSecond)
+	}
+}
+
+// allRepairs implements AllRepairs. If permits is non-nil, it contains a
+// permitter per cluster, which gates the repair writes to that cluster in
+// batches of at most batch elements.
+func allRepairs(clusters []cluster.Cluster, o repairOptions, instr instrumentation.RepairInstrumentation, permits []permitter, batch int) coreRepairStrategy {
 	return func(keyMembers []common.KeyMember) {
-		go func() {
-			instr.RepairCall()
-			instr.RepairRequest(len(keyMembers))
-		}()
+		repairKeyMembers(clusters, o, instr, permits, batch, keyMembers)
+	}
+}
 
-		// Every KeyMember has a presence in every cluster. Even if the
-		// cluster errors during Score, we keep a default (empty) presence.
-		// That means we may re-issue unnecessary writes, but that's OK!
-		presenceMap := map[common.KeyMember][]cluster.Presence{}
-		for _, keyMember := range keyMembers {
-			presenceMap[keyMember] = make([]cluster.Presence, len(clusters))
-		}
-
-		// Make Score requests sequentially. If a key is totally missing from
-		// a cluster, like when a node comes online empty and needs to be
-		// rebuilt, you'll end up asking about maxSize KeyMembers, which is
-		// probably a lot.
-		for index := range clusters {
-			// Make single request for this cluster.
-			scoreResponse, err := clusters[index].Score(keyMembers)
-			if err != nil {
-				log.Printf("AllRepairs: cluster %d: %s", index, err)
-				continue
-			}
+// repairKeyMembers performs the repairs of AllRepairs, and returns the number
+// of key-members written to each cluster, and the number of key-members
+// which failed to be written to each cluster. It fails if every cluster
+// failed the Score check.
+func repairKeyMembers(clusters []cluster.Cluster, o repairOptions, instr instrumentation.RepairInstrumentation, permits []permitter, batch int, keyMembers []common.KeyMember) (written, failed []int, err error) {
+	written, failed = make([]int, len(clusters)), make([]int, len(clusters))
+	go func() {
+		instr.RepairCall()
+		instr.RepairRequest(len(keyMembers))
+	}()
 
-			// Copy this cluster's presence information into our map.
-			for keyMember, presence := range scoreResponse {
-				presenceMap[keyMember][index] = presence
-			}
+	// Every KeyMember has a presence in every cluster. Even if the
+	// cluster errors during Score, we keep a default (empty) presence.
+	// That means we may re-issue unnecessary writes, but that's OK!
+	presenceMap := map[common.KeyMember][]cluster.Presence{}
+	for _, keyMember := range keyMembers {
+		presenceMap[keyMember] = make([]cluster.Presence, len(clusters))
+	}
+
+	// Make Score requests sequentially. If a key is totally missing from
+	// a cluster, like when a node comes online empty and needs to be
+	// rebuilt, you'll end up asking about maxSize KeyMembers, which is
+	// probably a lot.
+	var (
+		checkBegan = time.Now()
+		failures   = 0
+	)
+	for index := range clusters {
+		// Make single request for this cluster.
+		scoreResponse, err := clusters[index].Score(keyMembers)
+		if err != nil {
+			log.Printf("AllRepairs: cluster %d: %s", index, err)
+			failures++
+			instr.RepairCheckPartialFailure()
+			continue
+		}
+
+		// Copy this cluster's presence information into our map.
+		for keyMember, presence := range scoreResponse {
+			presenceMap[keyMember][index] = presence
 		}
+	}
+	instr.RepairCheckDuration(time.Since(checkBegan))
+	if failures >= len(clusters) {
+		log.Printf("AllRepairs: all %d cluster(s) failed; %d repair(s) abandoned", len(clusters), len(keyMembers))
+		instr.RepairCheckCompleteFailure()
+		return written, failed, fmt.Errorf("all %d cluster(s) failed", len(clusters))
+	}
 
-		// With the collected responses, determine the correct state, and
-		// schedule write operations.
-		inserts := map[int][]common.KeyScoreMember{}
-		deletes := map[int][]common.KeyScoreMember{}
-		for keyMember, presenceSlice := range presenceMap {
-			// Walk once, to determine the correct state.
+	// With the collected responses, determine the correct state, and
+	// schedule write operations.
+	var (
+		inserts   = map[int][]common.KeyScoreMember{}
+		deletes   = map[int][]common.KeyScoreMember{}
+		redundant = 0
+	)
+	for keyMember, presenceSlice := range presenceMap {
+		// Walk once, to determine the correct state.
+		winner := resolvePresence(presenceSlice)
+		if !winner.Present {
+			// This is indeed a strange situation, but it can arise if we
+			// get errors from every cluster during Score requests, for
+			// example. We don't want to confuse that with presence in the
+			// remove set.
+			log.Printf("AllRepairs: %q not found anywhere, skipping", keyMember)
+			continue
+		}
+		highestScore, wasInserted := winner.Score, winner.Inserted
+
+		// We now know the correct element.
+		keyScoreMember := common.KeyScoreMember{
+			Key:    keyMember.Key,
+			Score:  highestScore,
+			Member: keyMember.Member,
+		}
+
+		// Walk again, to schedule write operations.
+		scheduled := false
+		for index, presence := range presenceSlice {
 			var (
-				found        = false
-				highestScore = 0.
-				wasInserted  = false
+				notThere = !presence.Present
+				lowScore = presence.Score < highestScore && !o.tolerance.equal(presence.Score, highestScore)
+				wrongSet = presence.Inserted != wasInserted
 			)
 
-			for _, presence := range presenceSlice {
-				if presence.Present && presence.Score >= highestScore {
-					found = true
-					highestScore = presence.Score
-					wasInserted = wasInserted || presence.Inserted // https://github.com/soundcloud/roshi/issues/24
+			if notThere || lowScore || wrongSet {
+				if wasInserted {
+					inserts[index] = append(inserts[index], keyScoreMember)
+				} else {
+					deletes[index] = append(deletes[index], keyScoreMember)
 				}
+				scheduled = true
 			}
+		}
+		if !scheduled {
+			redundant++
+		}
+	}
+	if redundant > 0 {
+		instr.RepairCheckRedundant(redundant)
+	}
 
-			if !found {
-				// This is indeed a strange situation, but it can arise if we
-				// get errors from every cluster during Score requests, for
-				// example. We don't want to confuse that with presence in the
-				// remove set.
-				log.Printf("AllRepairs: %v not found anywhere, skipping", keyMember)
-				continue
+	// Drop write operations beyond the rate limit of their cluster.
+	if permits != nil {
+		throttled := map[int]int{}
+		for _, writes := range []map[int][]common.KeyScoreMember{inserts, deletes} {
+			for index, keyScoreMembers := range writes {
+				permitted := permitBatches(permits[index], batch, keyScoreMembers)
+				if n := len(keyScoreMembers) - len(permitted); n > 0 {
+					throttled[index] += n
+				}
+				if len(permitted) <= 0 {
+					delete(writes, index)
+					continue
+				}
+				writes[index] = permitted
 			}
+		}
+		for index, n := range throttled {
+			log.Printf("AllRepairs: cluster %d: write rate exceeded; %d repair write(s) discarded", index, n)
+			instr.RepairWriteThrottled(index, n)
+		}
+	}
 
-			// We now know the correct element.
-			keyScoreMember := common.KeyScoreMember{
-				Key:    keyMember.Key,
-				Score:  highestScore,
-				Member: keyMember.Member,
-			}
+	// Make write operations.
 
-			// Walk again, to schedule write operations.
-			for index, presence := range presenceSlice {
-				var (
-					notThere = !presence.Present
-					lowScore = presence.Score < highestScore
-					wrongSet = presence.Inserted != wasInserted
-				)
-
-				if notThere || lowScore || wrongSet {
-					if wasInserted {
-						inserts[index] = append(inserts[index], keyScoreMember)
-					} else {
-						deletes[index] = append(deletes[index], keyScoreMember)
-					}
-				}
-			}
+	instr.RepairWriteCount(len(inserts) + len(deletes))
+
+	for index, keyScoreMembers := range inserts {
+		if err := clusters[index].Insert(keyScoreMembers); err != nil {
+			log.Printf("AllRepairs: cluster %d: during Insert: %s", index, err)
+			instr.RepairWriteFailure(len(keyScoreMembers))
+			failed[index] += len(keyScoreMembers)
+			continue
 		}
+		instr.RepairWriteSuccess(len(keyScoreMembers))
+		reportStaleness(keyScoreMembers, o.timestampUnit, instr)
+		written[index] += len(keyScoreMembers)
+	}
 
-		// Make write operations.
+	for index, keyScoreMembers := range deletes {
+		if err := clusters[index].Delete(keyScoreMembers); err != nil {
+			log.Printf("AllRepairs: cluster %d: during Delete: %s", index, err)
+			instr.RepairWriteFailure(len(keyScoreMembers))
+			failed[index] += len(keyScoreMembers)
+			continue
+		}
+		instr.RepairWriteSuccess(len(keyScoreMembers))
+		reportStaleness(keyScoreMembers, o.timestampUnit, instr)
+		written[index] += len(keyScoreMembers)
+	}
+	return written, failed, nil
+}
 
-		for index, keyScoreMembers := range inserts {
-			if err := clusters[index].Insert(keyScoreMembers); err != nil {
-				log.Printf("AllRepairs: cluster %d: during Insert: %s", index, err)
-			}
+// reportStaleness reports how long ago the scores of the repaired
+// keyScoreMembers were, if scores are timestamps in units of unit, see
+// RepairTimestampScores. That's how far behind the cluster they were written
+// to was, at most.
+func reportStaleness(keyScoreMembers []common.KeyScoreMember, unit time.Duration, instr instrumentation.RepairInstrumentation) {
+	now := time.Now()
+	for _, keyScoreMember := range keyScoreMembers {
+		if d, ok := scoreAge(keyScoreMember.Score, unit, now); ok {
+			instr.RepairStaleness(d)
 		}
+	}
+}
 
-		for index, keyScoreMembers := range deletes {
-			if err := clusters[index].Delete(keyScoreMembers); err != nil {
-				log.Printf("AllRepairs: cluster %d: during Delete: %s", index, err)
-			}
+// permitBatches returns the leading keyScoreMembers which p permits, asking
+// for batches of at most batch elements, so that no batch can exceed the
+// capacity of p. The rest are dropped.
+func permitBatches(p permitter, batch int, keyScoreMembers []common.KeyScoreMember) []common.KeyScoreMember {
+	for i := 0; i < len(keyScoreMembers); i += batch {
+		n := batch
+		if remaining := len(keyScoreMembers) - i; n > remaining {
+			n = remaining
+		}
+		if !p.canHas(int64(n)) {
+			return keyScoreMembers[:i]
 		}
 	}
+	return keyScoreMembers
 }
 
 type permitter interface {
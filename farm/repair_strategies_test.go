@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/soundcloud/roshi/cluster"
 	"github.com/soundcloud/roshi/common"
 	"github.com/soundcloud/roshi/instrumentation"
 )
@@ -30,7 +33,7 @@ func TestAllRepairs(t *testing.T) {
 		if i == 0 {
 			expected = second
 		}
-		got := <-clusters[i].SelectOffset([]string{"foo"}, 0, 10)
+		got := <-clusters[i].SelectOffset([]string{"foo"}, 0, 10, common.Descending)
 		if len(got.KeyScoreMembers) <= 0 {
 			t.Errorf("pre-repair: cluster %d: only got %d responses", i, len(got.KeyScoreMembers))
 			continue
@@ -48,7 +51,7 @@ func TestAllRepairs(t *testing.T) {
 	expected := second
 	for i := 0; i < n; i++ {
 		//t.Logf("post-repair: cluster %d: %+v", i, clusters[i].(*mockCluster).m)
-		if got := <-clusters[i].SelectOffset([]string{"foo"}, 0, 10); !reflect.DeepEqual(expected, got.KeyScoreMembers[0]) {
+		if got := <-clusters[i].SelectOffset([]string{"foo"}, 0, 10, common.Descending); !reflect.DeepEqual(expected, got.KeyScoreMembers[0]) {
 			t.Errorf("post-repair: cluster %d: expected %+v, got %+v", i, expected, got.KeyScoreMembers[0])
 		}
 	}
@@ -84,7 +87,7 @@ func TestRateLimitedRepairs(t *testing.T) {
 	// Make post-repair checks. We only care about clusters 1 and above.
 	expected := []common.KeyScoreMember{e, d, c}
 	for i := 0; i < n; i++ {
-		got := <-clusters[i].SelectOffset([]string{"foo"}, 0, 10)
+		got := <-clusters[i].SelectOffset([]string{"foo"}, 0, 10, common.Descending)
 		t.Logf("post-repair: cluster %d: has %+v", i, got.KeyScoreMembers)
 		if i == 0 {
 			continue // assume clusters[0] has everything correctly
@@ -111,7 +114,7 @@ func TestExplodingGoroutines(t *testing.T) {
 
 	// Issue repair by making a Select.
 	before := runtime.NumGoroutine()
-	farm.SelectOffset([]string{key}, 0, maxSize)
+	farm.SelectOffset([]string{key}, 0, maxSize, common.Descending)
 	runtime.Gosched()
 	after := runtime.NumGoroutine()
 
@@ -131,3 +134,467 @@ func makeBigKey(key string, n int) []common.KeyScoreMember {
 	}
 	return tuples
 }
+
+func TestAllRepairsInstrumentation(t *testing.T) {
+	var (
+		clusters   = newMockClusters(3)
+		consistent = common.KeyScoreMember{Key: "foo", Score: 1., Member: "a"}
+		divergent  = common.KeyScoreMember{Key: "foo", Score: 2., Member: "b"}
+		instr      = &repairCountingInstrumentation{}
+	)
+	for _, c := range clusters {
+		c.Insert([]common.KeyScoreMember{consistent})
+	}
+	clusters[0].Insert([]common.KeyScoreMember{divergent})
+
+	AllRepairs(clusters, instr)([]common.KeyMember{
+		{Key: consistent.Key, Member: consistent.Member},
+		{Key: divergent.Key, Member: divergent.Member},
+	})
+	if expected, got := (repairCountingInstrumentation{
+		checkRedundant: 1, // consistent
+		writeCount:     2, // divergent, to clusters 1 and 2
+		writeSuccess:   2,
+	}), *instr; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	// With one failing cluster, the check partially fails.
+	clusters[2] = newFailingMockCluster()
+	instr = &repairCountingInstrumentation{}
+	AllRepairs(clusters, instr)([]common.KeyMember{{Key: consistent.Key, Member: consistent.Member}})
+	if expected, got := (repairCountingInstrumentation{
+		checkPartialFailure: 1,
+		writeCount:          1, // to the failing cluster
+		writeFailure:        1,
+	}), *instr; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	// With only failing clusters, the check fails completely.
+	instr = &repairCountingInstrumentation{}
+	AllRepairs(newFailingMockClusters(3), instr)([]common.KeyMember{{Key: consistent.Key, Member: consistent.Member}})
+	if expected, got := (repairCountingInstrumentation{
+		checkPartialFailure:  3,
+		checkCompleteFailure: 1,
+	}), *instr; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestAllRepairsResolution(t *testing.T) {
+	var (
+		keyMember = common.KeyMember{Key: "foo", Member: "a"}
+		inserted  = func(score float64) cluster.Presence {
+			return cluster.Presence{Present: true, Inserted: true, Score: score}
+		}
+		deleted = func(score float64) cluster.Presence {
+			return cluster.Presence{Present: true, Inserted: false, Score: score}
+		}
+		missing = cluster.Presence{}
+	)
+	for i, tc := range []struct {
+		presences []cluster.Presence
+		inserts   []int // indices of clusters expected to receive an Insert
+		deletes   []int // indices of clusters expected to receive a Delete
+	}{
+		{[]cluster.Presence{inserted(1), missing}, []int{1}, nil},
+		{[]cluster.Presence{inserted(1), deleted(2)}, nil, []int{0}},
+		{[]cluster.Presence{deleted(1), inserted(2)}, []int{0}, nil},
+		{[]cluster.Presence{inserted(-2), inserted(-1)}, []int{0}, nil},
+		{[]cluster.Presence{inserted(1), deleted(1)}, nil, []int{0}}, // equal scores: delete wins
+		{[]cluster.Presence{deleted(1), inserted(1)}, nil, []int{1}}, // regardless of order
+		{[]cluster.Presence{inserted(1), deleted(1), inserted(1)}, nil, []int{0, 2}},
+		{[]cluster.Presence{inserted(1), inserted(1)}, nil, nil},
+	} {
+		var (
+			clusters = make([]cluster.Cluster, len(tc.presences))
+			recorded = make([]*presenceCluster, len(tc.presences))
+		)
+		for index, presence := range tc.presences {
+			recorded[index] = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{keyMember: presence}}
+			clusters[index] = recorded[index]
+		}
+
+		AllRepairs(clusters, instrumentation.NopInstrumentation{})([]common.KeyMember{keyMember})
+
+		var inserts, deletes []int
+		for index, c := range recorded {
+			if c.inserts > 0 {
+				inserts = append(inserts, index)
+			}
+			if c.deletes > 0 {
+				deletes = append(deletes, index)
+			}
+		}
+		if expected, got := tc.inserts, inserts; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%d: expected Inserts to %v, got %v", i, expected, got)
+		}
+		if expected, got := tc.deletes, deletes; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%d: expected Deletes to %v, got %v", i, expected, got)
+		}
+	}
+}
+
+func TestAllRepairsScoreTolerance(t *testing.T) {
+	var (
+		keyMember = common.KeyMember{Key: "foo", Member: "a"}
+		high      = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{keyMember: {Present: true, Inserted: true, Score: 1.0000000001}}}
+		near      = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{keyMember: {Present: true, Inserted: true, Score: 1.0}}}
+		deleted   = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{keyMember: {Present: true, Inserted: false, Score: 1.0}}}
+		empty     = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{}}
+		clusters  = []cluster.Cluster{high, near, deleted, empty}
+	)
+	AllRepairsWith(RepairScoreTolerance(1e-6, 0))(clusters, instrumentation.NopInstrumentation{})([]common.KeyMember{keyMember})
+
+	// The cluster with the near score is left alone, but the one with the
+	// wrong set, and the empty one, are repaired.
+	for name, tc := range map[string]struct {
+		c       *presenceCluster
+		inserts int
+	}{
+		"high":    {high, 0},
+		"near":    {near, 0},
+		"deleted": {deleted, 1},
+		"empty":   {empty, 1},
+	} {
+		if expected, got := tc.inserts, tc.c.inserts; expected != got {
+			t.Errorf("%s: expected %d insert(s), got %d", name, expected, got)
+		}
+	}
+}
+
+func TestClusterRateLimitedRepairs(t *testing.T) {
+	var (
+		a, b, c   = common.KeyMember{Key: "foo", Member: "a"}, common.KeyMember{Key: "foo", Member: "b"}, common.KeyMember{Key: "foo", Member: "c"}
+		inserted  = cluster.Presence{Present: true, Inserted: true, Score: 1}
+		full      = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{a: inserted, b: inserted, c: inserted}}
+		empty     = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{}}
+		partial   = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{b: inserted, c: inserted}}
+		clusters  = []cluster.Cluster{full, empty, partial}
+		instr     = &throttleCountingInstrumentation{throttled: map[int]int{}}
+		strategy  = ClusterRateLimitedRepairs(4)
+		repairAll = func() { strategy(clusters, instr)([]common.KeyMember{a, b, c}) }
+	)
+
+	repairAll()
+	if expected, got := 3, empty.inserts; expected != got {
+		t.Errorf("empty cluster: expected %d insert(s), got %d", expected, got)
+	}
+	if expected, got := 1, partial.inserts; expected != got {
+		t.Errorf("partial cluster: expected %d insert(s), got %d", expected, got)
+	}
+
+	// The empty cluster has exhausted its limit, but the partial one hasn't.
+	// The limits hold across instantiations of the strategy, as made by e.g.
+	// Nonblocking.
+	repairAll()
+	if expected, got := 3, empty.inserts; expected != got {
+		t.Errorf("empty cluster: expected %d insert(s), got %d", expected, got)
+	}
+	if expected, got := 2, partial.inserts; expected != got {
+		t.Errorf("partial cluster: expected %d insert(s), got %d", expected, got)
+	}
+	if expected, got := map[int]int{1: 3}, instr.throttled; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected throttled %v, got %v", expected, got)
+	}
+}
+
+func TestClusterRateLimitedRepairsBatches(t *testing.T) {
+	var (
+		keyMembers = []common.KeyMember{}
+		full       = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{}}
+		empty      = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{}}
+		instr      = &throttleCountingInstrumentation{throttled: map[int]int{}}
+	)
+	for i := 0; i < 5; i++ {
+		keyMember := common.KeyMember{Key: "foo", Member: fmt.Sprint(i)}
+		keyMembers = append(keyMembers, keyMember)
+		full.presence[keyMember] = cluster.Presence{Present: true, Inserted: true, Score: 1}
+	}
+
+	// A key larger than the limit is written a batch at a time, rather than
+	// dropped as a whole.
+	ClusterRateLimitedRepairs(2)([]cluster.Cluster{full, empty}, instr)(keyMembers)
+	if expected, got := 2, empty.inserts; expected != got {
+		t.Errorf("expected %d insert(s), got %d", expected, got)
+	}
+	if expected, got := map[int]int{1: 3}, instr.throttled; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected throttled %v, got %v", expected, got)
+	}
+}
+
+func TestNonblockingBufferDepth(t *testing.T) {
+	defer func(d time.Duration) { bufferDepthInterval = d }(bufferDepthInterval)
+	bufferDepthInterval = time.Millisecond
+
+	// The first request blocks the repairs, and the others are queued.
+	var (
+		release = make(chan struct{})
+		blocked = func([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRepairStrategy {
+			return func([]common.KeyMember) { <-release }
+		}
+		instr  = &depthRecordingInstrumentation{}
+		repair = Nonblocking(5, blocked)(newMockClusters(1), instr)
+	)
+	defer close(release)
+	for i := 0; i < 3; i++ {
+		repair([]common.KeyMember{{Key: "foo", Member: fmt.Sprint(i)}})
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&instr.depth) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if expected, got := int32(2), atomic.LoadInt32(&instr.depth); expected != got {
+		t.Errorf("expected buffer depth %d, got %d", expected, got)
+	}
+}
+
+func TestNonblockingBufferDepthStops(t *testing.T) {
+	var (
+		b = nonblockingBuffer{
+			c:     make(chan []common.KeyMember, 1),
+			instr: &depthRecordingInstrumentation{},
+		}
+		done     = make(chan struct{})
+		reported = make(chan struct{})
+	)
+	go func() {
+		defer close(reported)
+		b.reportDepth(time.Millisecond, done)
+	}()
+	close(done)
+	select {
+	case <-reported:
+	case <-time.After(time.Second):
+		t.Fatal("reportDepth didn't return after done was closed")
+	}
+}
+
+// depthRecordingInstrumentation records the last reported repair buffer
+// depth.
+type depthRecordingInstrumentation struct {
+	instrumentation.NopInstrumentation
+	depth int32
+}
+
+func (i *depthRecordingInstrumentation) RepairBufferDepth(n int) {
+	atomic.StoreInt32(&i.depth, int32(n))
+}
+
+// throttleCountingInstrumentation counts throttled repair writes by cluster.
+type throttleCountingInstrumentation struct {
+	instrumentation.NopInstrumentation
+	throttled map[int]int
+}
+
+func (i *throttleCountingInstrumentation) RepairWriteThrottled(index, n int) { i.throttled[index] += n }
+
+// presenceCluster reports fixed presences on Score, and counts the writes it
+// receives. Other methods are not implemented.
+type presenceCluster struct {
+	cluster.Cluster
+	presence         map[common.KeyMember]cluster.Presence
+	inserts, deletes int
+}
+
+func (c *presenceCluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
+	m := map[common.KeyMember]cluster.Presence{}
+	for _, keyMember := range keyMembers {
+		m[keyMember] = c.presence[keyMember]
+	}
+	return m, nil
+}
+
+func (c *presenceCluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
+	c.inserts += len(keyScoreMembers)
+	return nil
+}
+
+func (c *presenceCluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
+	c.deletes += len(keyScoreMembers)
+	return nil
+}
+
+// repairCountingInstrumentation counts the repair check and write outcomes
+// reported by AllRepairs, which reports them synchronously.
+type repairCountingInstrumentation struct {
+	instrumentation.NopInstrumentation
+	checkPartialFailure  int
+	checkCompleteFailure int
+	checkRedundant       int
+	writeCount           int
+	writeSuccess         int
+	writeFailure         int
+}
+
+func (i *repairCountingInstrumentation) RepairCheckPartialFailure()  { i.checkPartialFailure++ }
+func (i *repairCountingInstrumentation) RepairCheckCompleteFailure() { i.checkCompleteFailure++ }
+func (i *repairCountingInstrumentation) RepairCheckRedundant(n int)  { i.checkRedundant += n }
+func (i *repairCountingInstrumentation) RepairWriteCount(n int)      { i.writeCount += n }
+func (i *repairCountingInstrumentation) RepairWriteSuccess(n int)    { i.writeSuccess += n }
+func (i *repairCountingInstrumentation) RepairWriteFailure(n int)    { i.writeFailure += n }
+
+func TestReadOnly(t *testing.T) {
+	var (
+		clusters  = newMockClusters(3)
+		divergent = common.KeyScoreMember{Key: "foo", Score: 1., Member: "a"}
+		keyMember = common.KeyMember{Key: divergent.Key, Member: divergent.Member}
+		farm      = New(clusters, 2, SendAllReadAll, AllRepairs, nil, WithReadOnly())
+	)
+	clusters[0].Insert([]common.KeyScoreMember{divergent})
+
+	// Selects find the divergence, but don't repair it.
+	got, err := farm.SelectOffset([]string{divergent.Key}, 0, 10, common.Descending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []common.KeyScoreMember{divergent}; !reflect.DeepEqual(expected, got[divergent.Key]) {
+		t.Errorf("expected %v, got %v", expected, got[divergent.Key])
+	}
+
+	// Writes fail, without reaching any cluster.
+	for name, write := range map[string]func() error{
+		"Insert":  func() error { return farm.Insert([]common.KeyScoreMember{{Key: "bar", Score: 1., Member: "b"}}) },
+		"Delete":  func() error { return farm.Delete([]common.KeyScoreMember{{Key: "bar", Score: 2., Member: "b"}}) },
+		"CopyKey": func() error { return farm.CopyKey(divergent.Key, "bar") },
+		"RepairKeys": func() error {
+			_, err := farm.RepairKeys([]string{divergent.Key}, 10)
+			return err
+		},
+	} {
+		if err := write(); err != ErrReadOnly {
+			t.Errorf("%s: expected %v, got %v", name, ErrReadOnly, err)
+		}
+	}
+
+	for i, c := range clusters {
+		presence, err := c.Score([]common.KeyMember{keyMember, {Key: "bar", Member: "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := i == 0, presence[keyMember].Present; expected != got {
+			t.Errorf("cluster %d: %v: expected present %v, got %v", i, keyMember, expected, got)
+		}
+		if presence[common.KeyMember{Key: "bar", Member: "b"}].Present {
+			t.Errorf("cluster %d: bar was written", i)
+		}
+	}
+}
+
+func TestBoundedRepairs(t *testing.T) {
+	var (
+		n        = 3
+		bound    = 2
+		clusters = make([]cluster.Cluster, n)
+		counters = make([]*concurrencyCountingCluster, n)
+	)
+	for i, c := range newMockClusters(n) {
+		counters[i] = &concurrencyCountingCluster{Cluster: c}
+		clusters[i] = counters[i]
+	}
+	farm := New(clusters, n, SendAllReadAll, Bounded(bound, AllRepairs), nil)
+
+	// Many simultaneous Selects find a divergent key each, and repair it.
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		clusters[0].Insert([]common.KeyScoreMember{{Key: keys[i], Score: 1., Member: "a"}})
+	}
+	done := make(chan struct{})
+	for _, key := range keys {
+		go func(key string) {
+			defer func() { done <- struct{}{} }()
+			farm.SelectOffset([]string{key}, 0, 10, common.Descending)
+		}(key)
+	}
+	for range keys {
+		<-done
+	}
+
+	for i, c := range counters {
+		if max := atomic.LoadInt32(&c.max); max > int32(bound) {
+			t.Errorf("cluster %d: %d concurrent repair calls, expected at most %d", i, max, bound)
+		}
+	}
+	for _, key := range keys {
+		keyMember := common.KeyMember{Key: key, Member: "a"}
+		for i, c := range clusters {
+			if presence, _ := c.Score([]common.KeyMember{keyMember}); !presence[keyMember].Present {
+				t.Errorf("cluster %d: %v wasn't repaired", i, keyMember)
+			}
+		}
+	}
+}
+
+// concurrencyCountingCluster tracks the max number of concurrent Score,
+// Insert, and Delete calls, which are made by repairs.
+type concurrencyCountingCluster struct {
+	cluster.Cluster
+	current, max int32
+}
+
+func (c *concurrencyCountingCluster) enter() func() {
+	current := atomic.AddInt32(&c.current, 1)
+	for {
+		max := atomic.LoadInt32(&c.max)
+		if current <= max || atomic.CompareAndSwapInt32(&c.max, max, current) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond) // let concurrent calls overlap
+	return func() { atomic.AddInt32(&c.current, -1) }
+}
+
+func (c *concurrencyCountingCluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
+	defer c.enter()()
+	return c.Cluster.Score(keyMembers)
+}
+
+func (c *concurrencyCountingCluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
+	defer c.enter()()
+	return c.Cluster.Insert(keyScoreMembers)
+}
+
+func (c *concurrencyCountingCluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
+	defer c.enter()()
+	return c.Cluster.Delete(keyScoreMembers)
+}
+
+func TestAllRepairsStaleness(t *testing.T) {
+	var (
+		keyMember = common.KeyMember{Key: "foo", Member: "a"}
+		score     = float64(time.Now().Add(-time.Minute).UnixNano() / 1e6) // milliseconds
+		newer     = &presenceCluster{presence: map[common.KeyMember]cluster.Presence{keyMember: {Present: true, Inserted: true, Score: score}}}
+		clusters  = []cluster.Cluster{newer, &presenceCluster{presence: map[common.KeyMember]cluster.Presence{}}}
+	)
+	for _, tc := range []struct {
+		name    string
+		options []RepairOption
+		reports int
+	}{
+		{"default", nil, 0},
+		{"timestamps", []RepairOption{RepairTimestampScores(time.Millisecond), RepairScoreTolerance(1, 0)}, 1},
+	} {
+		instr := &stalenessRecordingInstrumentation{}
+		AllRepairsWith(tc.options...)(clusters, instr)([]common.KeyMember{keyMember})
+		if expected, got := tc.reports, len(instr.staleness); expected != got {
+			t.Fatalf("%s: expected %d staleness report(s), got %d", tc.name, expected, got)
+		}
+		for _, d := range instr.staleness {
+			if d < time.Minute || d > time.Minute+10*time.Second {
+				t.Errorf("%s: expected a staleness of about a minute, got %s", tc.name, d)
+			}
+		}
+	}
+}
+
+type stalenessRecordingInstrumentation struct {
+	instrumentation.NopInstrumentation
+	staleness []time.Duration
+}
+
+func (i *stalenessRecordingInstrumentation) RepairStaleness(d time.Duration) {
+	i.staleness = append(i.staleness, d)
+}
@@ -0,0 +1,89 @@
+package farm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// Score returns the authoritative presence of each key-member across the
+// farm, and so satisfies cluster.Scorer. It asks every cluster concurrently,
+// and resolves their answers like repairs do: the highest score wins, and
+// the delete wins among equal scores. Key-members which aren't present in
+// any cluster are returned with a zero Presence. Clusters which fail are
+// ignored, unless all of them fail. Unlike Selects, Score doesn't trigger
+// repairs.
+func (f *Farm) Score(keyMembers []common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
+	if len(keyMembers) <= 0 {
+		return map[common.KeyMember]cluster.Presence{}, nil
+	}
+	if f.maxSelectKeys > 0 && len(keyMembers) > f.maxSelectKeys {
+		return map[common.KeyMember]cluster.Presence{}, TooManyKeysError{Keys: len(keyMembers), Max: f.maxSelectKeys}
+	}
+	if len(f.clusters) <= 0 {
+		return map[common.KeyMember]cluster.Presence{}, ErrNoClusters
+	}
+
+	// Scatter
+	type response struct {
+		presence map[common.KeyMember]cluster.Presence
+		err      error
+	}
+	responses := make(chan response, len(f.clusters))
+	for _, c := range f.clusters {
+		go func(c cluster.Cluster) {
+			presence, err := c.Score(keyMembers)
+			responses <- response{presence, err}
+		}(c)
+	}
+
+	// Gather
+	var (
+		presences = make(map[common.KeyMember][]cluster.Presence, len(keyMembers))
+		errors    = []string{}
+	)
+	for i := 0; i < len(f.clusters); i++ {
+		response := <-responses
+		if response.err != nil {
+			errors = append(errors, response.err.Error())
+			continue
+		}
+		for keyMember, presence := range response.presence {
+			presences[keyMember] = append(presences[keyMember], presence)
+		}
+	}
+	if len(errors) >= len(f.clusters) {
+		return map[common.KeyMember]cluster.Presence{}, fmt.Errorf("all clusters failed (%s)", strings.Join(errors, "; "))
+	}
+
+	// Resolve
+	result := make(map[common.KeyMember]cluster.Presence, len(keyMembers))
+	for _, keyMember := range keyMembers {
+		result[keyMember] = resolvePresence(presences[keyMember])
+	}
+	return result, nil
+}
+
+// resolvePresence returns the correct state of a key-member from its
+// presence in several clusters: the highest score wins. Among equal scores,
+// the delete wins, as it does in the cluster write script, so that repairs
+// can actually be applied everywhere, and repeated repairs converge. See
+// https://github.com/soundcloud/roshi/issues/24. The result isn't Present if
+// none of the presences is.
+func resolvePresence(presences []cluster.Presence) cluster.Presence {
+	var winner cluster.Presence
+	for _, presence := range presences {
+		if !presence.Present {
+			continue
+		}
+		switch {
+		case !winner.Present || presence.Score > winner.Score:
+			winner = presence
+		case presence.Score == winner.Score:
+			winner.Inserted = winner.Inserted && presence.Inserted
+		}
+	}
+	return winner
+}
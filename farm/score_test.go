@@ -0,0 +1,79 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/memcluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestScore(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{memcluster.New(10), memcluster.New(10), memcluster.New(10)}
+		farm     = New(clusters, 1, SendAllReadAll, NoRepairs, nil)
+	)
+
+	// The clusters diverge: a is inserted at 1 in cluster 0, inserted at 2
+	// in cluster 1, and deleted at 2 in cluster 2; b is only inserted in
+	// cluster 1; c is inserted at 3 in cluster 0, and deleted at 2 in
+	// cluster 2.
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}, {Key: "foo", Score: 3, Member: "c"}})
+	clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "a"}, {Key: "foo", Score: 5, Member: "b"}})
+	clusters[2].Delete([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "a"}, {Key: "foo", Score: 2, Member: "c"}})
+
+	var (
+		a, b, c, d = common.KeyMember{Key: "foo", Member: "a"}, common.KeyMember{Key: "foo", Member: "b"}, common.KeyMember{Key: "foo", Member: "c"}, common.KeyMember{Key: "bar", Member: "d"}
+		expected   = map[common.KeyMember]cluster.Presence{
+			a: {Present: true, Inserted: false, Score: 2}, // the delete wins at equal scores
+			b: {Present: true, Inserted: true, Score: 5},
+			c: {Present: true, Inserted: true, Score: 3},
+			d: {},
+		}
+	)
+	got, err := farm.Score([]common.KeyMember{a, b, c, d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Score doesn't repair.
+	if presence, _ := clusters[0].Score([]common.KeyMember{b}); presence[b].Present {
+		t.Errorf("%v was repaired", b)
+	}
+}
+
+func TestScoreFailures(t *testing.T) {
+	var (
+		keyMember = common.KeyMember{Key: "foo", Member: "a"}
+		clusters  = newMockClusters(2)
+	)
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	clusters = append(clusters, newFailingMockCluster())
+
+	// Failing clusters are ignored...
+	got, err := New(clusters, 1, SendAllReadAll, NoRepairs, nil).Score([]common.KeyMember{keyMember})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (cluster.Presence{Present: true, Inserted: true, Score: 1}); expected != got[keyMember] {
+		t.Errorf("expected %v, got %v", expected, got[keyMember])
+	}
+
+	// ...unless all of them fail.
+	clusters = newFailingMockClusters(2)
+	if _, err := New(clusters, 1, SendAllReadAll, NoRepairs, nil).Score([]common.KeyMember{keyMember}); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	// Too many key-members are rejected.
+	farm := New(newMockClusters(2), 1, SendAllReadAll, NoRepairs, nil, WithMaxSelectKeys(1))
+	if _, err := farm.Score([]common.KeyMember{keyMember, keyMember}); err == nil {
+		t.Errorf("expected an error")
+	} else if _, ok := err.(TooManyKeysError); !ok {
+		t.Errorf("expected TooManyKeysError, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package farm
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// selectCache is a bounded, least-recently-used cache of SelectOffset
+// results per key, with a time-to-live. Entries are dropped on writes to
+// their key. To avoid storing a result which was read before a concurrent
+// write, misses are tracked as pending fills until their results are put,
+// and invalidating a key records when that happened in its pending fills.
+type selectCache struct {
+	mtx     sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]map[cacheQuery]*list.Element
+	fills   map[string]*pendingFill
+	seq     uint64 // incremented by every invalidation
+}
+
+type cacheQuery struct {
+	offset, limit int
+	order         common.Order
+}
+
+type cacheEntry struct {
+	key     string
+	query   cacheQuery
+	value   []common.KeyScoreMember
+	expires time.Time
+}
+
+type pendingFill struct {
+	n           int
+	invalidated uint64
+}
+
+func newSelectCache(size int, ttl time.Duration) *selectCache {
+	return &selectCache{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: map[string]map[cacheQuery]*list.Element{},
+		fills:   map[string]*pendingFill{},
+	}
+}
+
+// get returns the cached results for the keys, the keys which missed, and a
+// sequence number to pass to put. Every get must be followed by a put.
+func (c *selectCache) get(keys []string, q cacheQuery, now time.Time) (map[string][]common.KeyScoreMember, []string, uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var (
+		hits   = map[string][]common.KeyScoreMember{}
+		misses = []string{}
+	)
+	for _, key := range keys {
+		if e, ok := c.entries[key][q]; ok {
+			entry := e.Value.(*cacheEntry)
+			if now.Before(entry.expires) {
+				c.lru.MoveToFront(e)
+				hits[key] = copyKeyScoreMembers(entry.value)
+				continue
+			}
+			c.remove(e)
+		}
+		if f, ok := c.fills[key]; ok {
+			f.n++
+		} else {
+			c.fills[key] = &pendingFill{n: 1}
+		}
+		misses = append(misses, key)
+	}
+	return hits, misses, c.seq
+}
+
+// put stores the results for keys which missed in get, unless the key has
+// been invalidated since. A nil response stores nothing, but still releases
+// the pending fills.
+func (c *selectCache) put(misses []string, q cacheQuery, seq uint64, response map[string][]common.KeyScoreMember, now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, key := range misses {
+		f := c.fills[key]
+		if f.n--; f.n <= 0 {
+			delete(c.fills, key)
+		}
+		value, ok := response[key]
+		if !ok || f.invalidated > seq {
+			continue
+		}
+		if e, ok := c.entries[key][q]; ok {
+			c.remove(e)
+		}
+		if _, ok := c.entries[key]; !ok {
+			c.entries[key] = map[cacheQuery]*list.Element{}
+		}
+		c.entries[key][q] = c.lru.PushFront(&cacheEntry{
+			key:     key,
+			query:   q,
+			value:   copyKeyScoreMembers(value),
+			expires: now.Add(c.ttl),
+		})
+		for c.lru.Len() > c.size {
+			c.remove(c.lru.Back())
+		}
+	}
+}
+
+// invalidate drops all entries of the written keys.
+func (c *selectCache) invalidate(tuples []common.KeyScoreMember) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.seq++
+	for _, tuple := range tuples {
+		for _, e := range c.entries[tuple.Key] {
+			c.remove(e)
+		}
+		if f, ok := c.fills[tuple.Key]; ok {
+			f.invalidated = c.seq
+		}
+	}
+}
+
+func (c *selectCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries[entry.key], entry.query)
+	if len(c.entries[entry.key]) <= 0 {
+		delete(c.entries, entry.key)
+	}
+}
+
+func copyKeyScoreMembers(a []common.KeyScoreMember) []common.KeyScoreMember {
+	return append(make([]common.KeyScoreMember, 0, len(a)), a...)
+}
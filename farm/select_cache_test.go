@@ -0,0 +1,143 @@
+package farm
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSelectCache(t *testing.T) {
+	clusters := newMockClusters(1)
+	farm := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil, WithSelectCache(10, time.Minute))
+	selects := func() int32 { return atomic.LoadInt32(&clusters[0].(*mockCluster).countSelect) }
+
+	if err := farm.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "one"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]common.KeyScoreMember{"foo": {{Key: "foo", Score: 1, Member: "one"}}}
+	for i := 0; i < 3; i++ {
+		got, err := farm.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("%d: expected %v, got %v", i, expected, got)
+		}
+	}
+	if expected, got := int32(1), selects(); expected != got {
+		t.Errorf("expected %d select(s), got %d", expected, got)
+	}
+
+	// Different queries are cached separately.
+	if _, err := farm.SelectOffset([]string{"foo"}, 0, 5, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := int32(2), selects(); expected != got {
+		t.Errorf("expected %d select(s), got %d", expected, got)
+	}
+
+	// Writes through the farm invalidate the key.
+	if err := farm.Insert([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "two"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := farm.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string][]common.KeyScoreMember{"foo": {
+		{Key: "foo", Score: 2, Member: "two"},
+		{Key: "foo", Score: 1, Member: "one"},
+	}}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if expected, got := int32(3), selects(); expected != got {
+		t.Errorf("expected %d select(s), got %d", expected, got)
+	}
+
+	// Only missing keys are read.
+	if _, err := farm.SelectOffset([]string{"foo", "bar"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := farm.SelectOffset([]string{"foo", "bar"}, 0, 10, common.Descending); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := int32(4), selects(); expected != got {
+		t.Errorf("expected %d select(s), got %d", expected, got)
+	}
+}
+
+func TestSelectCacheExpiry(t *testing.T) {
+	var (
+		c   = newSelectCache(10, time.Second)
+		q   = cacheQuery{offset: 0, limit: 10}
+		now = time.Now()
+	)
+
+	_, misses, seq := c.get([]string{"foo"}, q, now)
+	c.put(misses, q, seq, map[string][]common.KeyScoreMember{"foo": {}}, now)
+
+	if _, misses, seq := c.get([]string{"foo"}, q, now.Add(999*time.Millisecond)); len(misses) != 0 {
+		t.Errorf("expected a hit before the TTL, got misses %v", misses)
+	} else {
+		c.put(misses, q, seq, nil, now)
+	}
+	if _, misses, seq := c.get([]string{"foo"}, q, now.Add(time.Second)); len(misses) != 1 {
+		t.Errorf("expected a miss after the TTL, got misses %v", misses)
+	} else {
+		c.put(misses, q, seq, nil, now)
+	}
+}
+
+func TestSelectCacheEviction(t *testing.T) {
+	var (
+		c   = newSelectCache(2, time.Minute)
+		q   = cacheQuery{offset: 0, limit: 10}
+		now = time.Now()
+	)
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		_, misses, seq := c.get([]string{key}, q, now)
+		c.put(misses, q, seq, map[string][]common.KeyScoreMember{key: {}}, now)
+	}
+
+	// "b" was least recently used when "c" was added.
+	hits, misses, seq := c.get([]string{"a", "b", "c"}, q, now)
+	c.put(misses, q, seq, nil, now)
+	if expected, got := []string{"b"}, misses; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected misses %v, got %v", expected, got)
+	}
+	if expected, got := 2, len(hits); expected != got {
+		t.Errorf("expected %d hits, got %d", expected, got)
+	}
+}
+
+func TestSelectCacheConcurrentWrite(t *testing.T) {
+	var (
+		c   = newSelectCache(10, time.Minute)
+		q   = cacheQuery{offset: 0, limit: 10}
+		now = time.Now()
+	)
+
+	// A write between the read and storing its result.
+	_, misses, seq := c.get([]string{"foo"}, q, now)
+	c.invalidate([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "one"}})
+	c.put(misses, q, seq, map[string][]common.KeyScoreMember{"foo": {}}, now)
+
+	// A read starting after the write.
+	_, misses, seq = c.get([]string{"foo"}, q, now)
+	if expected, got := []string{"foo"}, misses; !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected misses %v, got %v", expected, got)
+	}
+	c.put(misses, q, seq, map[string][]common.KeyScoreMember{"foo": {}}, now)
+
+	_, misses, seq = c.get([]string{"foo"}, q, now)
+	c.put(misses, q, seq, nil, now)
+	if len(misses) != 0 {
+		t.Errorf("expected a hit, got misses %v", misses)
+	}
+}
@@ -0,0 +1,85 @@
+package farm
+
+import (
+	"fmt"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// FloorSelecter is a Selecter which can select the newest records of keys
+// down to a minimum score, see SelectOffsetFloor. All built-in ReadStrategies
+// yield a FloorSelecter, and Farm and Reader implement it.
+type FloorSelecter interface {
+	Selecter
+	SelectOffsetFloorComplete(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, bool, error)
+}
+
+// SelectOffsetFloor is SelectOffset in descending order, which skips records
+// with scores below minScore, so that the offset and limit only count the
+// records at or above it, e.g. for "the newest records, but nothing older
+// than T". Reads with a floor bypass the select cache.
+//
+// Clusters which implement cluster.FloorSelecter apply the floor themselves.
+// The others are asked with SelectOffset, and their records below the floor
+// are dropped. Since the floor only cuts off the oldest records, that yields
+// the same records, but transfers the ones below the floor.
+func (f *Farm) SelectOffsetFloor(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := f.SelectOffsetFloorComplete(keys, offset, limit, minScore)
+	return response, err
+}
+
+// SelectOffsetFloorComplete satisfies FloorSelecter, with the same notion of
+// completeness as SelectOffsetComplete.
+func (f *Farm) SelectOffsetFloorComplete(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, bool, error) {
+	return selectOffsetFloorComplete(f.selecter, f.maxSelectKeys, keys, offset, limit, minScore)
+}
+
+// SelectOffsetFloor is Farm.SelectOffsetFloor, reading with the ReadStrategy
+// of the view.
+func (r *Reader) SelectOffsetFloor(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := r.SelectOffsetFloorComplete(keys, offset, limit, minScore)
+	return response, err
+}
+
+// SelectOffsetFloorComplete satisfies FloorSelecter.
+func (r *Reader) SelectOffsetFloorComplete(keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, bool, error) {
+	return selectOffsetFloorComplete(r.selecter, r.farm.maxSelectKeys, keys, offset, limit, minScore)
+}
+
+// selectOffsetFloorComplete invokes the selecter of a ReadStrategy, which
+// must be a FloorSelecter.
+func selectOffsetFloorComplete(selecter Selecter, maxSelectKeys int, keys []string, offset, limit int, minScore float64) (map[string][]common.KeyScoreMember, bool, error) {
+	if len(keys) <= 0 {
+		return map[string][]common.KeyScoreMember{}, true, nil
+	}
+	if maxSelectKeys > 0 && len(keys) > maxSelectKeys {
+		return map[string][]common.KeyScoreMember{}, false, TooManyKeysError{Keys: len(keys), Max: maxSelectKeys}
+	}
+	s, ok := selecter.(FloorSelecter)
+	if !ok {
+		return map[string][]common.KeyScoreMember{}, false, fmt.Errorf("read strategy doesn't support a minimum score")
+	}
+	return s.SelectOffsetFloorComplete(keys, offset, limit, minScore)
+}
+
+// selectOffsetFloor asks the cluster for the newest members of the keys down
+// to minScore, see SelectOffsetFloor.
+func selectOffsetFloor(c cluster.Cluster, keys []string, offset, limit int, minScore float64) <-chan cluster.Element {
+	if f, ok := c.(cluster.FloorSelecter); ok {
+		return f.SelectOffsetFloor(keys, offset, limit, minScore)
+	}
+	out := make(chan cluster.Element)
+	go func() {
+		defer close(out)
+		for e := range c.SelectOffset(keys, offset, limit, common.Descending) {
+			n := 0
+			for n < len(e.KeyScoreMembers) && e.KeyScoreMembers[n].Score >= minScore {
+				n++
+			}
+			e.KeyScoreMembers = e.KeyScoreMembers[:n]
+			out <- e
+		}
+	}()
+	return out
+}
@@ -0,0 +1,72 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/memcluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSelectOffsetFloor(t *testing.T) {
+	records := []common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 3, Member: "c"},
+		{Key: "foo", Score: 4, Member: "d"},
+		{Key: "foo", Score: 5, Member: "e"},
+	}
+	for _, tc := range []struct {
+		name     string
+		clusters func() []cluster.Cluster
+	}{
+		// memcluster applies the floor itself, the mock cluster doesn't.
+		{"FloorSelecter", func() []cluster.Cluster { return []cluster.Cluster{memcluster.New(10), memcluster.New(10)} }},
+		{"filtered", func() []cluster.Cluster { return newMockClusters(2) }},
+	} {
+		for name, readStrategy := range map[string]ReadStrategy{
+			"SendOneReadOne":         SendOneReadOne,
+			"SendAllReadAll":         SendAllReadAll,
+			"SendAllReadFirstLinger": SendAllReadFirstLinger,
+			"SendVarReadFirstLinger": SendVarReadFirstLinger(0, 0),
+		} {
+			clusters := tc.clusters()
+			farm := New(clusters, len(clusters), readStrategy, NoRepairs, nil, WithSelectCache(10, 0))
+			if err := farm.Insert(records); err != nil {
+				t.Fatal(err)
+			}
+
+			// The window of offset 1 and limit 3 would reach down to score 2,
+			// but the floor excludes it.
+			got, complete, err := farm.SelectOffsetFloorComplete([]string{"foo", "bar"}, 1, 3, 3)
+			if err != nil {
+				t.Fatalf("%s, %s: %s", tc.name, name, err)
+			}
+			if !complete {
+				t.Errorf("%s, %s: expected a complete response", tc.name, name)
+			}
+			expected := map[string][]common.KeyScoreMember{
+				"foo": {records[3], records[2]},
+				"bar": {},
+			}
+			if !reflect.DeepEqual(expected, got) {
+				t.Errorf("%s, %s: expected %v, got %v", tc.name, name, expected, got)
+			}
+
+			got, err = farm.ReadingWith(SendAllReadAll).SelectOffsetFloor([]string{"foo"}, 0, 10, 4.5)
+			if expected := []common.KeyScoreMember{records[4]}; err != nil || !reflect.DeepEqual(expected, got["foo"]) {
+				t.Errorf("%s, %s: Reader: expected %v, got %v (%v)", tc.name, name, expected, got["foo"], err)
+			}
+		}
+	}
+}
+
+func TestSelectOffsetFloorTooManyKeys(t *testing.T) {
+	farm := New(newMockClusters(1), 1, SendAllReadAll, NoRepairs, nil, WithMaxSelectKeys(1))
+	if _, err := farm.SelectOffsetFloor([]string{"foo", "bar"}, 0, 10, 0); err == nil {
+		t.Errorf("expected an error")
+	} else if _, ok := err.(TooManyKeysError); !ok {
+		t.Errorf("expected TooManyKeysError, got %v", err)
+	}
+}
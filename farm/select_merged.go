@@ -0,0 +1,189 @@
+package farm
+
+import (
+	"sort"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// MergingSelecter is a Selecter which can merge the records of many keys
+// into a single ordered stream, and apply the offset and limit to that
+// stream rather than to every key. Farm implements it.
+type MergingSelecter interface {
+	Selecter
+	SelectMergedComplete(keys []string, offset, limit int, order common.Order) ([]common.KeyScoreMember, bool, error)
+}
+
+// SelectMerged returns the records of all keys merged into a single stream,
+// skipping the first offset records of the stream, and returning up to limit
+// records after that. With Descending order, the stream is ordered by
+// descending score, and records with equal scores by descending member. With
+// Ascending order, both are ascending. Duplicate keys are ignored.
+//
+// Rather than reading offset+limit records of every key, SelectMerged reads
+// every key in pages, and only reads the next page of a key when the merge
+// has consumed the previous one. The first page of every key is read in a
+// single Select. The size of the first page is offset+limit spread evenly
+// over the keys, and the pages of a key double in size from there, but never
+// exceed the number of records the merge still needs. Keys which need their
+// next page at the same time and offset are read together.
+//
+// Every page is read with SelectOffset, through the read strategy of the
+// farm, so the usual read repairs apply, but only to the records which were
+// actually read. Records of a key beyond the page where the merge stopped
+// aren't read, and therefore not repaired.
+//
+// Pages are read at different times, so concurrent writes may shift the
+// records of a key between two of its pages. A record which is read twice
+// is only returned once, but a record may be skipped if records before it
+// were deleted in the meantime.
+func (f *Farm) SelectMerged(keys []string, offset, limit int, order common.Order) ([]common.KeyScoreMember, error) {
+	records, _, err := f.SelectMergedComplete(keys, offset, limit, order)
+	return records, err
+}
+
+// SelectMergedComplete is SelectMerged which additionally reports whether the
+// response is complete, i.e. whether every page was complete. See
+// SelectOffsetComplete.
+func (f *Farm) SelectMergedComplete(keys []string, offset, limit int, order common.Order) ([]common.KeyScoreMember, bool, error) {
+	return selectMergedComplete(f, f.maxSelectKeys, keys, offset, limit, order)
+}
+
+// selectMergedComplete implements SelectMergedComplete, reading the pages
+// from s.
+func selectMergedComplete(s CompletenessSelecter, maxSelectKeys int, keys []string, offset, limit int, order common.Order) ([]common.KeyScoreMember, bool, error) {
+	var (
+		streams  = map[string]*mergeStream{}
+		unique   = make([]string, 0, len(keys))
+		need     = offset + limit
+		emitted  = 0
+		records  = []common.KeyScoreMember{}
+		complete = true
+	)
+	for _, key := range keys {
+		if _, ok := streams[key]; ok {
+			continue
+		}
+		streams[key] = &mergeStream{seen: map[string]bool{}}
+		unique = append(unique, key)
+	}
+	if len(unique) <= 0 || limit <= 0 {
+		return records, true, nil
+	}
+	if maxSelectKeys > 0 && len(unique) > maxSelectKeys {
+		return records, false, TooManyKeysError{Keys: len(unique), Max: maxSelectKeys}
+	}
+	firstPage := (need + len(unique) - 1) / len(unique)
+	for _, stream := range streams {
+		stream.page = firstPage
+	}
+
+	for emitted < need {
+		// Every key must have a next record, or be exhausted, before we know
+		// which record is next in the merge.
+		if empty := emptyStreams(unique, streams); len(empty) > 0 {
+			pageComplete, err := readPages(s, empty, streams, need-emitted, order)
+			if err != nil {
+				return []common.KeyScoreMember{}, false, err
+			}
+			complete = complete && pageComplete
+			continue
+		}
+
+		var next *mergeStream
+		for _, key := range unique {
+			stream := streams[key]
+			if len(stream.buffer) <= 0 {
+				continue // exhausted
+			}
+			if next == nil || mergesBefore(stream.buffer[0], next.buffer[0], order) {
+				next = stream
+			}
+		}
+		if next == nil {
+			break // all exhausted
+		}
+		record := next.buffer[0]
+		next.buffer = next.buffer[1:]
+		if emitted++; emitted > offset {
+			records = append(records, record)
+		}
+	}
+	return records, complete, nil
+}
+
+// mergeStream is the state of a single key in SelectMergedComplete.
+type mergeStream struct {
+	buffer    []common.KeyScoreMember // read, but not yet merged
+	read      int                     // offset of the next page
+	page      int                     // size of the next page
+	exhausted bool                    // no records beyond buffer
+	seen      map[string]bool         // members read so far
+}
+
+// emptyStreams returns the keys which need their next page.
+func emptyStreams(keys []string, streams map[string]*mergeStream) []string {
+	var empty []string
+	for _, key := range keys {
+		if s := streams[key]; len(s.buffer) <= 0 && !s.exhausted {
+			empty = append(empty, key)
+		}
+	}
+	return empty
+}
+
+// readPages reads the next page of every key from selecter, but at most remaining
+// records of each, with one SelectOffset per distinct offset and page size.
+func readPages(selecter CompletenessSelecter, keys []string, streams map[string]*mergeStream, remaining int, order common.Order) (bool, error) {
+	type pageQuery struct{ offset, limit int }
+	var (
+		queries  = map[pageQuery][]string{}
+		complete = true
+	)
+	for _, key := range keys {
+		s := streams[key]
+		q := pageQuery{offset: s.read, limit: s.page}
+		if q.limit > remaining {
+			q.limit = remaining
+		}
+		queries[q] = append(queries[q], key)
+	}
+	for q, keys := range queries {
+		response, queryComplete, err := selecter.SelectOffsetComplete(keys, q.offset, q.limit, order)
+		if err != nil {
+			return false, err
+		}
+		complete = complete && queryComplete
+		for _, key := range keys {
+			s := streams[key]
+			for _, record := range response[key] {
+				if s.seen[record.Member] {
+					continue // shifted by a concurrent insert
+				}
+				s.seen[record.Member] = true
+				s.buffer = append(s.buffer, record)
+			}
+			// Records with equal scores may come in any order.
+			sort.SliceStable(s.buffer, func(i, j int) bool { return mergesBefore(s.buffer[i], s.buffer[j], order) })
+			s.read += len(response[key])
+			s.page *= 2
+			s.exhausted = len(response[key]) < q.limit
+		}
+	}
+	return complete, nil
+}
+
+// mergesBefore returns true if a comes before b in the merged stream of
+// SelectMerged.
+func mergesBefore(a, b common.KeyScoreMember, order common.Order) bool {
+	if order == common.Ascending {
+		a, b = b, a
+	}
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.Member != b.Member {
+		return a.Member > b.Member
+	}
+	return a.Key > b.Key
+}
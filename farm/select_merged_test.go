@@ -0,0 +1,124 @@
+package farm
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSelectMerged(t *testing.T) {
+	var (
+		clusters = newMockClusters(2)
+		farm     = New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil)
+		keys     = []string{"a", "b", "c", "d"}
+		all      = []common.KeyScoreMember{}
+		r        = rand.New(rand.NewSource(1))
+	)
+	for i, score := range r.Perm(100) {
+		tuple := common.KeyScoreMember{Key: keys[r.Intn(len(keys))], Score: float64(score), Member: fmt.Sprint(i)}
+		all = append(all, tuple)
+	}
+	if err := farm.Insert(all); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Score > all[j].Score })
+
+	for _, tc := range []struct {
+		offset, limit int
+		order         common.Order
+	}{
+		{0, 10, common.Descending},
+		{0, 100, common.Descending},
+		{35, 10, common.Descending},
+		{95, 10, common.Descending},
+		{100, 10, common.Descending},
+		{0, 10, common.Ascending},
+		{42, 7, common.Ascending},
+	} {
+		stream := append([]common.KeyScoreMember{}, all...)
+		if tc.order == common.Ascending {
+			sort.Slice(stream, func(i, j int) bool { return stream[i].Score < stream[j].Score })
+		}
+		expected := []common.KeyScoreMember{}
+		if tc.offset < len(stream) {
+			expected = stream[tc.offset:]
+		}
+		if len(expected) > tc.limit {
+			expected = expected[:tc.limit]
+		}
+
+		got, err := farm.SelectMerged(append(keys, "a"), tc.offset, tc.limit, tc.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("offset %d, limit %d, order %v: expected %v, got %v", tc.offset, tc.limit, tc.order, expected, got)
+		}
+	}
+}
+
+func TestSelectMergedReadsIncrementally(t *testing.T) {
+	// One key with all the recent records, and many old keys.
+	var (
+		counting = &recordCountingCluster{Cluster: newMockCluster()}
+		farm     = New([]cluster.Cluster{counting}, 1, SendAllReadAll, NoRepairs, nil)
+		keys     = []string{}
+		tuples   = []common.KeyScoreMember{}
+	)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		for j := 0; j < 100; j++ {
+			score := float64(j)
+			if i == 0 {
+				score += 1000
+			}
+			tuples = append(tuples, common.KeyScoreMember{Key: key, Score: score, Member: fmt.Sprint(j)})
+		}
+	}
+	if err := farm.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := farm.SelectMerged(keys, 20, 20, common.Descending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 20; len(got) != expected {
+		t.Fatalf("expected %d records, got %d", expected, len(got))
+	}
+	for _, record := range got {
+		if record.Key != "key0" {
+			t.Fatalf("expected only records of key0, got %v", record)
+		}
+	}
+
+	// Coalescing would read offset+limit records of every key: 400. Instead,
+	// every key is read in a first page of 4 records, and only key0 further.
+	if read := counting.records; read >= 100 {
+		t.Errorf("expected less than 100 records read, got %d", read)
+	}
+}
+
+// recordCountingCluster counts the records returned by SelectOffset.
+type recordCountingCluster struct {
+	cluster.Cluster
+	records int
+}
+
+func (c *recordCountingCluster) SelectOffset(keys []string, offset, limit int, order common.Order) <-chan cluster.Element {
+	out := make(chan cluster.Element)
+	go func() {
+		defer close(out)
+		for e := range c.Cluster.SelectOffset(keys, offset, limit, order) {
+			c.records += len(e.KeyScoreMembers)
+			out <- e
+		}
+	}()
+	return out
+}
@@ -0,0 +1,86 @@
+package farm
+
+import (
+	"fmt"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// RangeOffsetSelecter is a Selecter which can skip records after the start
+// cursor of a cursor-based select, see SelectRangeOffset. All built-in
+// ReadStrategies yield a RangeOffsetSelecter, and Farm and Reader implement
+// it.
+type RangeOffsetSelecter interface {
+	Selecter
+	SelectRangeOffsetComplete(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error)
+}
+
+// SelectRangeOffset is SelectRange, which skips the first offset records
+// after the start cursor, so that the limit only counts the records after
+// those, e.g. to jump a few pages ahead of a cursor. Like SelectRange, it
+// isn't cached.
+//
+// Clusters which implement cluster.RangeOffsetSelecter skip the records
+// themselves. The others are asked with SelectRange for offset+limit records,
+// and the first offset of them are dropped, which yields the same records,
+// but transfers the skipped ones.
+func (f *Farm) SelectRangeOffset(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := f.SelectRangeOffsetComplete(keys, start, stop, offset, limit)
+	return response, err
+}
+
+// SelectRangeOffsetComplete satisfies RangeOffsetSelecter, with the same
+// notion of completeness as SelectRangeComplete.
+func (f *Farm) SelectRangeOffsetComplete(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	return selectRangeOffsetComplete(f.selecter, f.maxSelectKeys, keys, start, stop, offset, limit)
+}
+
+// SelectRangeOffset is Farm.SelectRangeOffset, reading with the ReadStrategy
+// of the view.
+func (r *Reader) SelectRangeOffset(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	response, _, err := r.SelectRangeOffsetComplete(keys, start, stop, offset, limit)
+	return response, err
+}
+
+// SelectRangeOffsetComplete satisfies RangeOffsetSelecter.
+func (r *Reader) SelectRangeOffsetComplete(keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	return selectRangeOffsetComplete(r.selecter, r.farm.maxSelectKeys, keys, start, stop, offset, limit)
+}
+
+// selectRangeOffsetComplete invokes the selecter of a ReadStrategy, which
+// must be a RangeOffsetSelecter.
+func selectRangeOffsetComplete(selecter Selecter, maxSelectKeys int, keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	if len(keys) <= 0 {
+		return map[string][]common.KeyScoreMember{}, true, nil
+	}
+	if maxSelectKeys > 0 && len(keys) > maxSelectKeys {
+		return map[string][]common.KeyScoreMember{}, false, TooManyKeysError{Keys: len(keys), Max: maxSelectKeys}
+	}
+	s, ok := selecter.(RangeOffsetSelecter)
+	if !ok {
+		return map[string][]common.KeyScoreMember{}, false, fmt.Errorf("read strategy doesn't support an offset with cursors")
+	}
+	return s.SelectRangeOffsetComplete(keys, start, stop, offset, limit)
+}
+
+// selectRangeOffset asks the cluster for the records of the keys after the
+// first offset after start, see SelectRangeOffset.
+func selectRangeOffset(c cluster.Cluster, keys []string, start, stop common.Cursor, offset, limit int) <-chan cluster.Element {
+	if r, ok := c.(cluster.RangeOffsetSelecter); ok {
+		return r.SelectRangeOffset(keys, start, stop, offset, limit)
+	}
+	out := make(chan cluster.Element)
+	go func() {
+		defer close(out)
+		for e := range c.SelectRange(keys, start, stop, offset+limit) {
+			if offset < len(e.KeyScoreMembers) {
+				e.KeyScoreMembers = e.KeyScoreMembers[offset:]
+			} else {
+				e.KeyScoreMembers = []common.KeyScoreMember{}
+			}
+			out <- e
+		}
+	}()
+	return out
+}
@@ -0,0 +1,68 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/memcluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSelectRangeOffset(t *testing.T) {
+	records := []common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 3, Member: "c"},
+		{Key: "foo", Score: 4, Member: "d"},
+		{Key: "foo", Score: 5, Member: "e"},
+	}
+	for _, tc := range []struct {
+		name     string
+		clusters func() []cluster.Cluster
+	}{
+		// memcluster skips the offset itself, rangeOnlyCluster hides that.
+		{"RangeOffsetSelecter", func() []cluster.Cluster { return []cluster.Cluster{memcluster.New(10), memcluster.New(10)} }},
+		{"dropped", func() []cluster.Cluster {
+			return []cluster.Cluster{rangeOnlyCluster{memcluster.New(10)}, rangeOnlyCluster{memcluster.New(10)}}
+		}},
+	} {
+		for name, readStrategy := range map[string]ReadStrategy{
+			"SendOneReadOne":         SendOneReadOne,
+			"SendAllReadAll":         SendAllReadAll,
+			"SendAllReadFirstLinger": SendAllReadFirstLinger,
+			"SendVarReadFirstLinger": SendVarReadFirstLinger(0, 0),
+		} {
+			clusters := tc.clusters()
+			farm := New(clusters, len(clusters), readStrategy, NoRepairs, nil, WithSelectCache(10, 0))
+			if err := farm.Insert(records); err != nil {
+				t.Fatal(err)
+			}
+
+			// After the cursor at "d", skip "c", and take 2.
+			got, complete, err := farm.SelectRangeOffsetComplete([]string{"foo", "bar"}, common.Cursor{Score: 4, Member: "d"}, common.Cursor{}, 1, 2)
+			if err != nil {
+				t.Fatalf("%s, %s: %s", tc.name, name, err)
+			}
+			if !complete {
+				t.Errorf("%s, %s: expected a complete response", tc.name, name)
+			}
+			expected := map[string][]common.KeyScoreMember{
+				"foo": {records[1], records[0]},
+				"bar": {},
+			}
+			if !reflect.DeepEqual(expected, got) {
+				t.Errorf("%s, %s: expected %v, got %v", tc.name, name, expected, got)
+			}
+
+			got, err = farm.ReadingWith(SendAllReadAll).SelectRangeOffset([]string{"foo"}, common.Cursor{Score: 5, Member: "e"}, common.Cursor{}, 10, 10)
+			if expected := []common.KeyScoreMember{}; err != nil || !reflect.DeepEqual(expected, got["foo"]) {
+				t.Errorf("%s, %s: Reader: expected %v, got %v (%v)", tc.name, name, expected, got["foo"], err)
+			}
+		}
+	}
+}
+
+// rangeOnlyCluster hides the optional interfaces of a Cluster, in particular
+// cluster.RangeOffsetSelecter.
+type rangeOnlyCluster struct{ cluster.Cluster }
@@ -0,0 +1,41 @@
+package farm
+
+import (
+	"github.com/soundcloud/roshi/common"
+)
+
+// SelectTop returns the single newest record of each key, e.g. for
+// leaderboards, and so satisfies cluster.TopSelecter. Keys without records
+// are missing from the result.
+//
+// It's SelectOffset with offset 0, limit 1, and descending order, so it
+// reads with the ReadStrategy of the farm, which merges the records of the
+// clusters it reads, and repairs the differences it finds, as usual. Every
+// cluster reads the keys with a pipelined ZREVRANGE key 0 0.
+func (f *Farm) SelectTop(keys []string) (map[string]common.KeyScoreMember, error) {
+	records, err := f.SelectOffset(keys, 0, 1, common.Descending)
+	if err != nil {
+		return map[string]common.KeyScoreMember{}, err
+	}
+	return top(records), nil
+}
+
+// SelectTop is Farm.SelectTop, reading with the ReadStrategy of the view.
+func (r *Reader) SelectTop(keys []string) (map[string]common.KeyScoreMember, error) {
+	records, err := r.SelectOffset(keys, 0, 1, common.Descending)
+	if err != nil {
+		return map[string]common.KeyScoreMember{}, err
+	}
+	return top(records), nil
+}
+
+// top returns the first record of every key which has one.
+func top(records map[string][]common.KeyScoreMember) map[string]common.KeyScoreMember {
+	m := make(map[string]common.KeyScoreMember, len(records))
+	for key, a := range records {
+		if len(a) > 0 {
+			m[key] = a[0]
+		}
+	}
+	return m
+}
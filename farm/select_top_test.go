@@ -0,0 +1,63 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSelectTop(t *testing.T) {
+	clusters := newMockClusters(3)
+	farm := New(clusters, 2, SendAllReadAll, AllRepairs, nil)
+
+	// The clusters diverge on the top member of foo and bar; baz is only in
+	// cluster 2, and qux nowhere.
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 3, Member: "a"}, {Key: "bar", Score: 1, Member: "x"}})
+	clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 5, Member: "b"}, {Key: "bar", Score: 1, Member: "x"}})
+	clusters[2].Insert([]common.KeyScoreMember{{Key: "foo", Score: 4, Member: "c"}, {Key: "bar", Score: 2, Member: "y"}, {Key: "baz", Score: 1, Member: "z"}})
+
+	expected := map[string]common.KeyScoreMember{
+		"foo": {Key: "foo", Score: 5, Member: "b"},
+		"bar": {Key: "bar", Score: 2, Member: "y"},
+		"baz": {Key: "baz", Score: 1, Member: "z"},
+	}
+	got, err := farm.SelectTop([]string{"foo", "bar", "baz", "qux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// The returned members were repaired in every cluster.
+	for i, c := range clusters {
+		for _, ksm := range expected {
+			keyMember := common.KeyMember{Key: ksm.Key, Member: ksm.Member}
+			presence, err := c.Score([]common.KeyMember{keyMember})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p := presence[keyMember]; !p.Present || !p.Inserted || p.Score != ksm.Score {
+				t.Errorf("cluster %d: %v wasn't repaired: %+v", i, ksm, p)
+			}
+		}
+	}
+
+	// A single cluster gives its own view.
+	if got, err := farm.ReadingWith(SendOneReadOne).SelectTop([]string{"foo"}); err != nil {
+		t.Fatal(err)
+	} else if expected := (common.KeyScoreMember{Key: "foo", Score: 5, Member: "b"}); got["foo"] != expected {
+		t.Errorf("expected %v, got %v", expected, got["foo"])
+	}
+}
+
+func TestSelectTopTooManyKeys(t *testing.T) {
+	farm := New([]cluster.Cluster{newMockCluster()}, 1, SendAllReadAll, NoRepairs, nil, WithMaxSelectKeys(1))
+	if _, err := farm.SelectTop([]string{"foo", "bar"}); err == nil {
+		t.Errorf("expected an error")
+	} else if _, ok := err.(TooManyKeysError); !ok {
+		t.Errorf("expected TooManyKeysError, got %v", err)
+	}
+}
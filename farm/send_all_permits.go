@@ -0,0 +1,155 @@
+package farm
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// ErrNoSendAllPermits is returned by SendAllPermits and SetMaxKeysPerSecond
+// if the farm doesn't read with SendVarReadFirstLinger.
+var ErrNoSendAllPermits = errors.New("read strategy isn't SendVarReadFirstLinger")
+
+// SendAllTuner is implemented by Farm, to inspect and change the rate at
+// which SendVarReadFirstLinger may send reads to all clusters, e.g. to
+// retune it during an incident. Farms which read with another ReadStrategy
+// return ErrNoSendAllPermits.
+type SendAllTuner interface {
+	SendAllPermits() (SendAllPermits, error)
+	SetMaxKeysPerSecond(maxKeysPerSecond int) error
+}
+
+// SendAllPermits is the state of the permitter of SendVarReadFirstLinger.
+type SendAllPermits struct {
+	MaxKeysPerSecond int // negative if every read is sent to all clusters
+	Available        int // keys which may be read from all clusters right now
+}
+
+// SendAllPermits returns the state of the permitter of
+// SendVarReadFirstLinger.
+func (f *Farm) SendAllPermits() (SendAllPermits, error) {
+	p, ok := f.sendAllPermitter()
+	if !ok {
+		return SendAllPermits{}, ErrNoSendAllPermits
+	}
+	return p.permits(), nil
+}
+
+// SetMaxKeysPerSecond replaces the maxKeysPerSecond of
+// SendVarReadFirstLinger, with the same meaning, and refills the permits to
+// it, as if the farm were created with it. The change lasts for the lifetime
+// of the read strategy, i.e. it applies to every Farm and Reader created from
+// the same SendVarReadFirstLinger.
+func (f *Farm) SetMaxKeysPerSecond(maxKeysPerSecond int) error {
+	p, ok := f.sendAllPermitter()
+	if !ok {
+		return ErrNoSendAllPermits
+	}
+	p.setRate(maxKeysPerSecond)
+	return nil
+}
+
+func (f *Farm) sendAllPermitter() (*sendAllPermitter, bool) {
+	s, ok := f.selecter.(sendVarReadFirstLinger)
+	if !ok {
+		return nil, false
+	}
+	p, ok := s.permitter.(*sendAllPermitter)
+	return p, ok
+}
+
+// sendAllPermitsInterval is how often SendVarReadFirstLinger reports the
+// available permits, and the share of reads it sent to all clusters.
+var sendAllPermitsInterval = 10 * time.Second
+
+// sendAllPermitter is the permitter of SendVarReadFirstLinger: a token bucket
+// of keys, which holds up to rate keys, and refills at rate keys per second.
+// Unlike tokenBucketPermitter, it can report its tokens, and change its rate.
+type sendAllPermitter struct {
+	mtx       sync.Mutex
+	rate      int // negative to permit everything
+	tokens    float64
+	last      time.Time // of the last refill
+	granted   int       // reads, since the last report
+	rejected  int       // reads, since the last report
+	reporting sync.Once
+}
+
+func newSendAllPermitter(rate int) *sendAllPermitter {
+	return &sendAllPermitter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+func (p *sendAllPermitter) canHas(n int64) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.refill(time.Now())
+	if p.rate >= 0 && p.tokens < float64(n) {
+		p.rejected++
+		return false
+	}
+	if p.rate >= 0 {
+		p.tokens -= float64(n)
+	}
+	p.granted++
+	return true
+}
+
+// refill must be called with mtx held.
+func (p *sendAllPermitter) refill(now time.Time) {
+	if p.rate >= 0 {
+		p.tokens += now.Sub(p.last).Seconds() * float64(p.rate)
+		if max := float64(p.rate); p.tokens > max {
+			p.tokens = max
+		}
+	}
+	p.last = now
+}
+
+func (p *sendAllPermitter) permits() SendAllPermits {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.refill(time.Now())
+	available := -1
+	if p.rate >= 0 {
+		available = int(p.tokens)
+	}
+	return SendAllPermits{MaxKeysPerSecond: p.rate, Available: available}
+}
+
+func (p *sendAllPermitter) setRate(rate int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.rate, p.tokens, p.last = rate, float64(rate), time.Now()
+}
+
+// reportTo starts reporting to instr, once per permitter. Farms which share
+// the read strategy share the permitter, so the first one reports for all.
+func (p *sendAllPermitter) reportTo(instr instrumentation.SelectInstrumentation, interval time.Duration) {
+	p.reporting.Do(func() {
+		go func() {
+			for range time.Tick(interval) {
+				p.reportOnce(instr)
+			}
+		}()
+	})
+}
+
+func (p *sendAllPermitter) reportOnce(instr instrumentation.SelectInstrumentation) {
+	p.mtx.Lock()
+	p.refill(time.Now())
+	var (
+		rate, tokens      = p.rate, int(p.tokens)
+		granted, rejected = p.granted, p.rejected
+	)
+	p.granted, p.rejected = 0, 0
+	p.mtx.Unlock()
+
+	if rate >= 0 {
+		instr.SelectSendAllPermitsAvailable(tokens)
+	}
+	if total := granted + rejected; total > 0 {
+		instr.SelectSendAllPermitRatio(float64(granted) / float64(total))
+	}
+}
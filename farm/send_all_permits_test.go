@@ -0,0 +1,122 @@
+package farm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func TestSendAllPermits(t *testing.T) {
+	clusters := newMockClusters(3)
+	farm := New(clusters, len(clusters), SendVarReadFirstLinger(2, time.Millisecond), NoRepairs, nil)
+
+	if expected, got := (SendAllPermits{MaxKeysPerSecond: 2, Available: 2}), mustSendAllPermits(t, farm); expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	farm.SelectOffset([]string{"foo", "bar"}, 0, 10, common.Descending)
+	if expected, got := 0, mustSendAllPermits(t, farm).Available; expected != got {
+		t.Errorf("after a SendAll: expected %d available, got %d", expected, got)
+	}
+
+	// A new rate refills the permits.
+	if err := farm.SetMaxKeysPerSecond(3); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := (SendAllPermits{MaxKeysPerSecond: 3, Available: 3}), mustSendAllPermits(t, farm); expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	farm.SelectOffset([]string{"foo", "bar"}, 0, 10, common.Descending)
+	if expected, got := 1, mustSendAllPermits(t, farm).Available; expected != got {
+		t.Errorf("expected %d available, got %d", expected, got)
+	}
+
+	// Farms reading with other strategies have no permits.
+	for _, readStrategy := range []ReadStrategy{SendAllReadAll, SendAllReadFirstLinger} {
+		other := New(clusters, len(clusters), readStrategy, NoRepairs, nil)
+		if _, err := other.SendAllPermits(); err != ErrNoSendAllPermits {
+			t.Errorf("expected %v, got %v", ErrNoSendAllPermits, err)
+		}
+		if err := other.SetMaxKeysPerSecond(1); err != ErrNoSendAllPermits {
+			t.Errorf("expected %v, got %v", ErrNoSendAllPermits, err)
+		}
+	}
+}
+
+func TestSendAllPermitterRefill(t *testing.T) {
+	p := newSendAllPermitter(10)
+	if !p.canHas(10) {
+		t.Fatal("expected a full bucket")
+	}
+	if p.canHas(1) {
+		t.Fatal("expected an empty bucket")
+	}
+
+	// Rewind the last refill, rather than sleep.
+	p.last = p.last.Add(-500 * time.Millisecond)
+	if expected, got := 5, p.permits().Available; expected != got {
+		t.Errorf("after 500ms: expected %d available, got %d", expected, got)
+	}
+	p.last = p.last.Add(-time.Hour)
+	if expected, got := 10, p.permits().Available; expected != got {
+		t.Errorf("after 1h: expected %d available, capped at the rate, got %d", expected, got)
+	}
+
+	for _, tc := range []struct {
+		rate        int
+		n           int64
+		expected    bool
+		description string
+	}{
+		{0, 1, false, "0 never permits"},
+		{-1, 1000000, true, "negative always permits"},
+		{10, 11, false, "more than the rate is never permitted"},
+	} {
+		if got := newSendAllPermitter(tc.rate).canHas(tc.n); tc.expected != got {
+			t.Errorf("%s: expected %v, got %v", tc.description, tc.expected, got)
+		}
+	}
+}
+
+func TestSendAllPermitterReport(t *testing.T) {
+	var (
+		p     = newSendAllPermitter(2)
+		instr = &permitsRecordingInstrumentation{available: -2, ratio: -1}
+	)
+	p.canHas(1)
+	p.canHas(1)
+	p.canHas(1)
+	p.canHas(1)
+	p.reportOnce(instr)
+	if instr.available != 0 {
+		t.Errorf("expected 0 available, got %d", instr.available)
+	}
+	if expected, got := 0.5, instr.ratio; expected != got {
+		t.Errorf("expected ratio %v, got %v", expected, got)
+	}
+
+	// Without reads, there's no ratio to report.
+	instr.ratio = -1
+	p.reportOnce(instr)
+	if instr.ratio != -1 {
+		t.Errorf("expected no ratio, got %v", instr.ratio)
+	}
+}
+
+type permitsRecordingInstrumentation struct {
+	instrumentation.NopInstrumentation
+	available int
+	ratio     float64
+}
+
+func (i *permitsRecordingInstrumentation) SelectSendAllPermitsAvailable(n int) { i.available = n }
+func (i *permitsRecordingInstrumentation) SelectSendAllPermitRatio(r float64)  { i.ratio = r }
+
+func mustSendAllPermits(t *testing.T, f *Farm) SendAllPermits {
+	permits, err := f.SendAllPermits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return permits
+}
@@ -51,28 +51,33 @@ func TestOrderedLimitedSlice(t *testing.T) {
 	t3 := common.KeyScoreMember{Key: "a", Score: 9, Member: "first"}
 	s := makeSet([]common.KeyScoreMember{t1, t2, t3})
 
-	got := s.orderedLimitedSlice(4)
+	got := s.orderedLimitedSlice(4, common.Descending)
 	if expected := []common.KeyScoreMember{t3, t1, t2}; !reflect.DeepEqual(expected, got) {
 		t.Errorf("expected\n%v, got\n%v", expected, got)
 	}
 
-	got = s.orderedLimitedSlice(3)
+	got = s.orderedLimitedSlice(3, common.Descending)
 	if expected := []common.KeyScoreMember{t3, t1, t2}; !reflect.DeepEqual(expected, got) {
 		t.Errorf("expected\n%v, got\n%v", expected, got)
 	}
 
-	got = s.orderedLimitedSlice(2)
+	got = s.orderedLimitedSlice(2, common.Descending)
 	if expected := []common.KeyScoreMember{t3, t1}; !reflect.DeepEqual(expected, got) {
 		t.Errorf("expected\n%v, got\n%v", expected, got)
 	}
 
-	got = s.orderedLimitedSlice(1)
+	got = s.orderedLimitedSlice(1, common.Descending)
 	if expected := []common.KeyScoreMember{t3}; !reflect.DeepEqual(expected, got) {
 		t.Errorf("expected\n%v, got\n%v", expected, got)
 	}
 
-	got = s.orderedLimitedSlice(0)
+	got = s.orderedLimitedSlice(0, common.Descending)
 	if expected := []common.KeyScoreMember{}; !reflect.DeepEqual(expected, got) {
 		t.Errorf("expected\n%v, got\n%v", expected, got)
 	}
+
+	got = s.orderedLimitedSlice(2, common.Ascending)
+	if expected := []common.KeyScoreMember{t2, t1}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected\n%v, got\n%v", expected, got)
+	}
 }
@@ -13,6 +13,7 @@ type Instrumentation interface {
 	DeleteInstrumentation
 	RepairInstrumentation
 	WalkInstrumentation
+	PoolInstrumentation
 }
 
 // InsertInstrumentation describes metrics for the Insert path.
@@ -22,24 +23,36 @@ type InsertInstrumentation interface {
 	InsertCallDuration(time.Duration)   // time spent per call
 	InsertRecordDuration(time.Duration) // time spent per record (average)
 	InsertQuorumFailure()               // called if the Insert failed due to lack of quorum
+	InsertMemberTooLarge(int)           // +N, where N is how many members of a rejected Insert call exceeded the max member size of a cluster
 }
 
 // SelectInstrumentation describes metrics for the Select path.
 type SelectInstrumentation interface {
-	SelectCall()                               // called for every invocation of Select
-	SelectKeys(int)                            // how many keys were requested
-	SelectSendTo(int)                          // how many clusters the read strategy sent the read to
-	SelectFirstResponseDuration(time.Duration) // how long until we got the first element
-	SelectPartialError()                       // called when an individual key gave an error from the cluster
-	SelectBlockingDuration(time.Duration)      // time spent waiting for everything
-	SelectOverheadDuration(time.Duration)      // time spent not waiting
-	SelectDuration(time.Duration)              // overall time performing this read (blocking + overhead)
-	SelectSendAllPermitGranted()               // called when the permitter allows SendVarReadFirstLinger to send to all clusters
-	SelectSendAllPermitRejected()              // called when the permitter doesn't allow SendVarReadFirstLinger to send to all clusters
-	SelectSendAllPromotion()                   // called when the read strategy promotes a "SendOne" to a "SendAll" because of missing results
-	SelectRetrieved(int)                       // total number of KeyScoreMembers retrieved from the backing store
-	SelectReturned(int)                        // total number of KeyScoreMembers returned to the caller
-	SelectRepairNeeded(int)                    // +N, where N is every keyMember detected in a difference set (prior to entering repair strategy)
+	SelectCall()                                           // called for every invocation of Select
+	SelectKeys(int)                                        // how many keys were requested
+	SelectSendTo(int)                                      // how many clusters the read strategy sent the read to
+	SelectFirstResponseDuration(time.Duration)             // how long until we got the first element
+	SelectPartialError()                                   // called when an individual key gave an error from the cluster
+	SelectBlockingDuration(time.Duration)                  // time spent waiting for everything
+	SelectOverheadDuration(time.Duration)                  // time spent not waiting
+	SelectDuration(time.Duration)                          // overall time performing this read (blocking + overhead)
+	SelectClusterFirstResponseDuration(int, time.Duration) // how long until the cluster with the given index sent its first element
+	SelectClusterDuration(int, time.Duration)              // how long until the cluster with the given index sent all elements, even if the read strategy stopped waiting
+	SelectSendAllPermitGranted()                           // called when the permitter allows SendVarReadFirstLinger to send to all clusters
+	SelectSendAllPermitRejected()                          // called when the permitter doesn't allow SendVarReadFirstLinger to send to all clusters
+	SelectSendAllPermitsAvailable(int)                     // how many keys the permitter of SendVarReadFirstLinger allows to send to all clusters right now, sampled periodically
+	SelectSendAllPermitRatio(float64)                      // share of reads the permitter of SendVarReadFirstLinger allowed to send to all clusters, per sampling interval
+	SelectSendAllPromotion()                               // called when the read strategy promotes a "SendOne" to a "SendAll" because of missing results
+	SelectPromotionLatency()                               // called in addition to SelectSendAllPromotion, if results were missing only because the cluster was slow
+	SelectPromotionError()                                 // called in addition to SelectSendAllPromotion, if results were missing because the cluster returned errors
+	SelectRetrieved(int)                                   // total number of KeyScoreMembers retrieved from the backing store
+	SelectReturned(int)                                    // total number of KeyScoreMembers returned to the caller
+	SelectRepairNeeded(int)                                // +N, where N is every keyMember detected in a difference set (prior to entering repair strategy)
+	SelectRepairTruncated(int)                             // +N, where N is keyMembers of a difference set not requested to repair, because they exceeded the max repairs per select
+	SelectCacheHit(int)                                    // +N, where N is how many keys were answered from the select cache
+	SelectCacheMiss(int)                                   // +N, where N is how many keys weren't found in the select cache
+	SelectRangeAttempts(int)                               // how many attempts a cursor-based select took for a key, with a growing limit; called for every key
+	SelectOffsetPastEnd(int)                               // +N, where N is how many keys of an offset-based select with a positive offset had no members at or past the offset
 }
 
 // DeleteInstrumentation describes metrics for the Delete path.
@@ -49,18 +62,35 @@ type DeleteInstrumentation interface {
 	DeleteCallDuration(time.Duration)   // time spent per call
 	DeleteRecordDuration(time.Duration) // time spent per record (average)
 	DeleteQuorumFailure()               // called if the Delete failed due to lack of quorum
+	DeleteMemberTooLarge(int)           // +N, where N is how many members of a rejected Delete call exceeded the max member size of a cluster
 }
 
 // RepairInstrumentation describes metrics for Repairs.
 type RepairInstrumentation interface {
-	RepairCall()            // called for every requested repair
-	RepairRequest(int)      // +N, where N is the total number of keyMembers for which repair was requested
-	RepairDiscarded(int)    // +N, where N is keyMembers requested to repair but discarded due to e.g. rate limits
-	RepairWriteSuccess(int) // +N, where N is keyMembers successfully written to a cluster as a result of a repair
-	RepairWriteFailure(int) // +N, where N is keyMembers unsuccessfully written to a cluster as a result of a repair
+	RepairCall()                       // called for every requested repair
+	RepairRequest(int)                 // +N, where N is the total number of keyMembers for which repair was requested
+	RepairDiscarded(int)               // +N, where N is keyMembers requested to repair but discarded due to e.g. rate limits
+	RepairBufferDepth(int)             // how many repair requests are queued in the Nonblocking buffer, sampled periodically
+	RepairCheckPartialFailure()        // called for every cluster that failed to respond to a repair check
+	RepairCheckCompleteFailure()       // called if no cluster responded to a repair check
+	RepairCheckRedundant(int)          // +N, where N is keyMembers requested to repair but already consistent across all clusters
+	RepairCheckDuration(time.Duration) // time spent checking the state of the keyMembers in all clusters, per repair
+	RepairWriteCount(int)              // +N, where N is write operations (Inserts or Deletes) issued against clusters as a result of a repair
+	RepairWriteSuccess(int)            // +N, where N is keyMembers successfully written to a cluster as a result of a repair
+	RepairWriteFailure(int)            // +N, where N is keyMembers unsuccessfully written to a cluster as a result of a repair
+	RepairWriteThrottled(int, int)     // +N in the cluster with the given index, where N is keyMembers not written to it as a result of a repair, due to its write rate limit
+	RepairStaleness(time.Duration)     // how long ago the score of a keyMember written to a cluster by a repair was, if scores are timestamps, per keyMember
 }
 
 // WalkInstrumentation describes metrics for walkers.
 type WalkInstrumentation interface {
-	WalkKeys(int) // +N, where N is the number of keys received from a Scanner and sent for Select
+	WalkKeys(int)                // +N, where N is the number of keys received from a Scanner and sent for Select
+	WalkClockSkew(time.Duration) // spread between the fastest and slowest Redis instance clocks, per clock probe
+}
+
+// PoolInstrumentation describes metrics for the connection pools of Redis
+// instances.
+type PoolInstrumentation interface {
+	PoolDial()        // called for every new connection dialed for the pool of a Redis instance
+	PoolDialFailure() // called for every new connection which failed to be dialed
 }
@@ -55,6 +55,13 @@ func (i MultiInstrumentation) InsertQuorumFailure() {
 	}
 }
 
+// InsertMemberTooLarge satisfies the Instrumentation interface.
+func (i MultiInstrumentation) InsertMemberTooLarge(n int) {
+	for _, instr := range i.instrs {
+		instr.InsertMemberTooLarge(n)
+	}
+}
+
 // SelectCall satisfies the Instrumentation interface.
 func (i MultiInstrumentation) SelectCall() {
 	for _, instr := range i.instrs {
@@ -115,6 +122,20 @@ func (i MultiInstrumentation) SelectDuration(d time.Duration) {
 	}
 }
 
+// SelectClusterFirstResponseDuration satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectClusterFirstResponseDuration(index int, d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.SelectClusterFirstResponseDuration(index, d)
+	}
+}
+
+// SelectClusterDuration satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectClusterDuration(index int, d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.SelectClusterDuration(index, d)
+	}
+}
+
 // SelectSendAllPermitGranted satisfies the Instrumentation interface.
 func (i MultiInstrumentation) SelectSendAllPermitGranted() {
 	for _, instr := range i.instrs {
@@ -129,6 +150,20 @@ func (i MultiInstrumentation) SelectSendAllPermitRejected() {
 	}
 }
 
+// SelectSendAllPermitsAvailable satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectSendAllPermitsAvailable(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectSendAllPermitsAvailable(n)
+	}
+}
+
+// SelectSendAllPermitRatio satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectSendAllPermitRatio(ratio float64) {
+	for _, instr := range i.instrs {
+		instr.SelectSendAllPermitRatio(ratio)
+	}
+}
+
 // SelectSendAllPromotion satisfies the Instrumentation interface.
 func (i MultiInstrumentation) SelectSendAllPromotion() {
 	for _, instr := range i.instrs {
@@ -136,6 +171,20 @@ func (i MultiInstrumentation) SelectSendAllPromotion() {
 	}
 }
 
+// SelectPromotionLatency satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectPromotionLatency() {
+	for _, instr := range i.instrs {
+		instr.SelectPromotionLatency()
+	}
+}
+
+// SelectPromotionError satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectPromotionError() {
+	for _, instr := range i.instrs {
+		instr.SelectPromotionError()
+	}
+}
+
 // SelectRetrieved satisfies the Instrumentation interface.
 func (i MultiInstrumentation) SelectRetrieved(n int) {
 	for _, instr := range i.instrs {
@@ -157,6 +206,41 @@ func (i MultiInstrumentation) SelectRepairNeeded(n int) {
 	}
 }
 
+// SelectRepairTruncated satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectRepairTruncated(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectRepairTruncated(n)
+	}
+}
+
+// SelectCacheHit satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectCacheHit(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectCacheHit(n)
+	}
+}
+
+// SelectCacheMiss satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectCacheMiss(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectCacheMiss(n)
+	}
+}
+
+// SelectRangeAttempts satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectRangeAttempts(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectRangeAttempts(n)
+	}
+}
+
+// SelectOffsetPastEnd satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectOffsetPastEnd(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectOffsetPastEnd(n)
+	}
+}
+
 // DeleteCall satisfies the Instrumentation interface.
 func (i MultiInstrumentation) DeleteCall() {
 	for _, instr := range i.instrs {
@@ -193,6 +277,13 @@ func (i MultiInstrumentation) DeleteQuorumFailure() {
 	}
 }
 
+// DeleteMemberTooLarge satisfies the Instrumentation interface.
+func (i MultiInstrumentation) DeleteMemberTooLarge(n int) {
+	for _, instr := range i.instrs {
+		instr.DeleteMemberTooLarge(n)
+	}
+}
+
 // RepairCall satisfies the Instrumentation interface.
 func (i MultiInstrumentation) RepairCall() {
 	for _, instr := range i.instrs {
@@ -214,6 +305,48 @@ func (i MultiInstrumentation) RepairDiscarded(n int) {
 	}
 }
 
+// RepairBufferDepth satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairBufferDepth(n int) {
+	for _, instr := range i.instrs {
+		instr.RepairBufferDepth(n)
+	}
+}
+
+// RepairCheckPartialFailure satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairCheckPartialFailure() {
+	for _, instr := range i.instrs {
+		instr.RepairCheckPartialFailure()
+	}
+}
+
+// RepairCheckCompleteFailure satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairCheckCompleteFailure() {
+	for _, instr := range i.instrs {
+		instr.RepairCheckCompleteFailure()
+	}
+}
+
+// RepairCheckRedundant satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairCheckRedundant(n int) {
+	for _, instr := range i.instrs {
+		instr.RepairCheckRedundant(n)
+	}
+}
+
+// RepairCheckDuration satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairCheckDuration(d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.RepairCheckDuration(d)
+	}
+}
+
+// RepairWriteCount satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairWriteCount(n int) {
+	for _, instr := range i.instrs {
+		instr.RepairWriteCount(n)
+	}
+}
+
 // RepairWriteSuccess satisfies the Instrumentation interface.
 func (i MultiInstrumentation) RepairWriteSuccess(n int) {
 	for _, instr := range i.instrs {
@@ -228,9 +361,44 @@ func (i MultiInstrumentation) RepairWriteFailure(n int) {
 	}
 }
 
+// RepairWriteThrottled satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairWriteThrottled(index, n int) {
+	for _, instr := range i.instrs {
+		instr.RepairWriteThrottled(index, n)
+	}
+}
+
+// RepairStaleness satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairStaleness(d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.RepairStaleness(d)
+	}
+}
+
 // WalkKeys satisfies the Instrumentation interface.
 func (i MultiInstrumentation) WalkKeys(n int) {
 	for _, instr := range i.instrs {
 		instr.WalkKeys(n)
 	}
 }
+
+// WalkClockSkew satisfies the Instrumentation interface.
+func (i MultiInstrumentation) WalkClockSkew(d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.WalkClockSkew(d)
+	}
+}
+
+// PoolDial satisfies the Instrumentation interface.
+func (i MultiInstrumentation) PoolDial() {
+	for _, instr := range i.instrs {
+		instr.PoolDial()
+	}
+}
+
+// PoolDialFailure satisfies the Instrumentation interface.
+func (i MultiInstrumentation) PoolDialFailure() {
+	for _, instr := range i.instrs {
+		instr.PoolDialFailure()
+	}
+}
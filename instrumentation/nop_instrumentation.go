@@ -23,6 +23,9 @@ func (i NopInstrumentation) InsertRecordDuration(time.Duration) {}
 // InsertQuorumFailure satisfies the Instrumentation interface.
 func (i NopInstrumentation) InsertQuorumFailure() {}
 
+// InsertMemberTooLarge satisfies the Instrumentation interface.
+func (i NopInstrumentation) InsertMemberTooLarge(int) {}
+
 // SelectCall satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectCall() {}
 
@@ -47,15 +50,33 @@ func (i NopInstrumentation) SelectOverheadDuration(time.Duration) {}
 // SelectDuration satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectDuration(time.Duration) {}
 
+// SelectClusterFirstResponseDuration satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectClusterFirstResponseDuration(int, time.Duration) {}
+
+// SelectClusterDuration satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectClusterDuration(int, time.Duration) {}
+
 // SelectSendAllPermitGranted satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectSendAllPermitGranted() {}
 
 // SelectSendAllPermitRejected satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectSendAllPermitRejected() {}
 
+// SelectSendAllPermitsAvailable satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectSendAllPermitsAvailable(int) {}
+
+// SelectSendAllPermitRatio satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectSendAllPermitRatio(float64) {}
+
 // SelectSendAllPromotion satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectSendAllPromotion() {}
 
+// SelectPromotionLatency satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectPromotionLatency() {}
+
+// SelectPromotionError satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectPromotionError() {}
+
 // SelectRetrieved satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectRetrieved(int) {}
 
@@ -65,6 +86,21 @@ func (i NopInstrumentation) SelectReturned(int) {}
 // SelectRepairNeeded satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectRepairNeeded(int) {}
 
+// SelectRepairTruncated satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectRepairTruncated(int) {}
+
+// SelectCacheHit satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectCacheHit(int) {}
+
+// SelectCacheMiss satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectCacheMiss(int) {}
+
+// SelectRangeAttempts satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectRangeAttempts(int) {}
+
+// SelectOffsetPastEnd satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectOffsetPastEnd(int) {}
+
 // DeleteCall satisfies the Instrumentation interface.
 func (i NopInstrumentation) DeleteCall() {}
 
@@ -80,6 +116,9 @@ func (i NopInstrumentation) DeleteRecordDuration(time.Duration) {}
 // DeleteQuorumFailure satisfies the Instrumentation interface.
 func (i NopInstrumentation) DeleteQuorumFailure() {}
 
+// DeleteMemberTooLarge satisfies the Instrumentation interface.
+func (i NopInstrumentation) DeleteMemberTooLarge(int) {}
+
 // RepairCall satisfies the Instrumentation interface.
 func (i NopInstrumentation) RepairCall() {}
 
@@ -89,11 +128,44 @@ func (i NopInstrumentation) RepairRequest(int) {}
 // RepairDiscarded satisfies the Instrumentation interface.
 func (i NopInstrumentation) RepairDiscarded(int) {}
 
+// RepairBufferDepth satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairBufferDepth(int) {}
+
+// RepairCheckPartialFailure satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairCheckPartialFailure() {}
+
+// RepairCheckCompleteFailure satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairCheckCompleteFailure() {}
+
+// RepairCheckRedundant satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairCheckRedundant(int) {}
+
+// RepairCheckDuration satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairCheckDuration(time.Duration) {}
+
+// RepairWriteCount satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairWriteCount(int) {}
+
 // RepairWriteSuccess satisfies the Instrumentation interface.
 func (i NopInstrumentation) RepairWriteSuccess(int) {}
 
 // RepairWriteFailure satisfies the Instrumentation interface.
 func (i NopInstrumentation) RepairWriteFailure(int) {}
 
+// RepairWriteThrottled satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairWriteThrottled(int, int) {}
+
+// RepairStaleness satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairStaleness(time.Duration) {}
+
 // WalkKeys satisfies the Instrumentation interface.
 func (i NopInstrumentation) WalkKeys(int) {}
+
+// WalkClockSkew satisfies the Instrumentation interface.
+func (i NopInstrumentation) WalkClockSkew(time.Duration) {}
+
+// PoolDial satisfies the Instrumentation interface.
+func (i NopInstrumentation) PoolDial() {}
+
+// PoolDialFailure satisfies the Instrumentation interface.
+func (i NopInstrumentation) PoolDialFailure() {}
@@ -41,6 +41,10 @@ func (i plaintextInstrumentation) InsertQuorumFailure() {
 	fmt.Fprintf(i, "insert.quorum_failure.count 1")
 }
 
+func (i plaintextInstrumentation) InsertMemberTooLarge(n int) {
+	fmt.Fprintf(i, "insert.member_too_large.count %d", n)
+}
+
 func (i plaintextInstrumentation) SelectCall() {
 	fmt.Fprintf(i, "select.call.count 1")
 }
@@ -73,6 +77,10 @@ func (i plaintextInstrumentation) SelectDuration(d time.Duration) {
 	fmt.Fprintf(i, "select.duration_ms %d", d.Nanoseconds()/1e6)
 }
 
+// Per-cluster Select timings are only exported to Prometheus.
+func (i plaintextInstrumentation) SelectClusterFirstResponseDuration(int, time.Duration) {}
+func (i plaintextInstrumentation) SelectClusterDuration(int, time.Duration)              {}
+
 func (i plaintextInstrumentation) SelectSendAllPermitGranted() {
 	fmt.Fprintf(i, "select.send_all_permit_granted.count 1")
 }
@@ -81,10 +89,26 @@ func (i plaintextInstrumentation) SelectSendAllPermitRejected() {
 	fmt.Fprintf(i, "select.send_all_permit_rejected.count 1")
 }
 
+func (i plaintextInstrumentation) SelectSendAllPermitsAvailable(n int) {
+	fmt.Fprintf(i, "select.send_all_permits_available.gauge %d", n)
+}
+
+func (i plaintextInstrumentation) SelectSendAllPermitRatio(ratio float64) {
+	fmt.Fprintf(i, "select.send_all_permit_ratio.gauge %f", ratio)
+}
+
 func (i plaintextInstrumentation) SelectSendAllPromotion() {
 	fmt.Fprintf(i, "select.send_all_promotion.count 1")
 }
 
+func (i plaintextInstrumentation) SelectPromotionLatency() {
+	fmt.Fprintf(i, "select.promotion_latency.count 1")
+}
+
+func (i plaintextInstrumentation) SelectPromotionError() {
+	fmt.Fprintf(i, "select.promotion_error.count 1")
+}
+
 func (i plaintextInstrumentation) SelectRetrieved(n int) {
 	fmt.Fprintf(i, "select.retrieved.count %d", n)
 }
@@ -97,6 +121,26 @@ func (i plaintextInstrumentation) SelectRepairNeeded(n int) {
 	fmt.Fprintf(i, "select.repair_needed.count %d", n)
 }
 
+func (i plaintextInstrumentation) SelectRepairTruncated(n int) {
+	fmt.Fprintf(i, "select.repair_truncated.count %d", n)
+}
+
+func (i plaintextInstrumentation) SelectCacheHit(n int) {
+	fmt.Fprintf(i, "select.cache_hit.count %d", n)
+}
+
+func (i plaintextInstrumentation) SelectCacheMiss(n int) {
+	fmt.Fprintf(i, "select.cache_miss.count %d", n)
+}
+
+func (i plaintextInstrumentation) SelectOffsetPastEnd(n int) {
+	fmt.Fprintf(i, "select.offset_past_end.count %d", n)
+}
+
+func (i plaintextInstrumentation) SelectRangeAttempts(n int) {
+	fmt.Fprintf(i, "select.range.attempts %d", n)
+}
+
 func (i plaintextInstrumentation) DeleteCall() {
 	fmt.Fprintf(i, "delete.call.count 1")
 }
@@ -117,6 +161,10 @@ func (i plaintextInstrumentation) DeleteQuorumFailure() {
 	fmt.Fprintf(i, "delete.quorum_failure.count 1")
 }
 
+func (i plaintextInstrumentation) DeleteMemberTooLarge(n int) {
+	fmt.Fprintf(i, "delete.member_too_large.count %d", n)
+}
+
 func (i plaintextInstrumentation) RepairCall() {
 	fmt.Fprintf(i, "repair.call.count 1")
 }
@@ -129,6 +177,30 @@ func (i plaintextInstrumentation) RepairDiscarded(n int) {
 	fmt.Fprintf(i, "repair.discarded.count %d", n)
 }
 
+func (i plaintextInstrumentation) RepairBufferDepth(n int) {
+	fmt.Fprintf(i, "repair.buffer_depth.gauge %d", n)
+}
+
+func (i plaintextInstrumentation) RepairCheckPartialFailure() {
+	fmt.Fprintf(i, "repair.check_partial_failure.count 1")
+}
+
+func (i plaintextInstrumentation) RepairCheckCompleteFailure() {
+	fmt.Fprintf(i, "repair.check_complete_failure.count 1")
+}
+
+func (i plaintextInstrumentation) RepairCheckRedundant(n int) {
+	fmt.Fprintf(i, "repair.check_redundant.count %d", n)
+}
+
+func (i plaintextInstrumentation) RepairCheckDuration(d time.Duration) {
+	fmt.Fprintf(i, "repair.check.duration_ms %d", d.Nanoseconds()/1e6)
+}
+
+func (i plaintextInstrumentation) RepairWriteCount(n int) {
+	fmt.Fprintf(i, "repair.write.count %d", n)
+}
+
 func (i plaintextInstrumentation) RepairWriteSuccess(n int) {
 	fmt.Fprintf(i, "repair.write_success.count %d", n)
 }
@@ -137,6 +209,26 @@ func (i plaintextInstrumentation) RepairWriteFailure(n int) {
 	fmt.Fprintf(i, "repair.write_failure.count %d", n)
 }
 
+func (i plaintextInstrumentation) RepairWriteThrottled(index, n int) {
+	fmt.Fprintf(i, "repair.write_throttled.cluster_%d.count %d", index, n)
+}
+
+func (i plaintextInstrumentation) RepairStaleness(d time.Duration) {
+	fmt.Fprintf(i, "repair.staleness.duration_ms %d", d.Nanoseconds()/1e6)
+}
+
 func (i plaintextInstrumentation) WalkKeys(n int) {
 	fmt.Fprintf(i, "walk.keys.count %d", n)
 }
+
+func (i plaintextInstrumentation) WalkClockSkew(d time.Duration) {
+	fmt.Fprintf(i, "walk.clock_skew.duration_ms %d", d.Nanoseconds()/1e6)
+}
+
+func (i plaintextInstrumentation) PoolDial() {
+	fmt.Fprintf(i, "pool.dial.count 1")
+}
+
+func (i plaintextInstrumentation) PoolDialFailure() {
+	fmt.Fprintf(i, "pool.dial.failure.count 1")
+}
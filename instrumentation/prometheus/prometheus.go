@@ -4,6 +4,7 @@ package prometheus
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -15,36 +16,61 @@ var _ instrumentation.Instrumentation = PrometheusInstrumentation{}
 
 // PrometheusInstrumentation holds metrics for all instrumented methods.
 type PrometheusInstrumentation struct {
-	insertCallCount                  prometheus.Counter
-	insertRecordCount                prometheus.Counter
-	insertCallDuration               prometheus.Summary
-	insertRecordDuration             prometheus.Summary
-	insertQuorumFailureCount         prometheus.Counter
-	selectCallCount                  prometheus.Counter
-	selectKeysCount                  prometheus.Counter
-	selectSendToCount                prometheus.Counter
-	selectFirstResponseDuration      prometheus.Summary
-	selectPartialErrorCount          prometheus.Counter
-	selectBlockingDuration           prometheus.Summary
-	selectOverheadDuration           prometheus.Summary
-	selectDuration                   prometheus.Summary
-	selectSendAllPermitGrantedCount  prometheus.Counter
-	selectSendAllPermitRejectedCount prometheus.Counter
-	selectSendAllPromotionCount      prometheus.Counter
-	selectRetrievedCount             prometheus.Counter
-	selectReturnedCount              prometheus.Counter
-	selectRepairNeededCount          prometheus.Counter
-	deleteCallCount                  prometheus.Counter
-	deleteRecordCount                prometheus.Counter
-	deleteCallDuration               prometheus.Summary
-	deleteRecordDuration             prometheus.Summary
-	deleteQuorumFailureCount         prometheus.Counter
-	repairCallCount                  prometheus.Counter
-	repairRequestCount               prometheus.Counter
-	repairDiscardedCount             prometheus.Counter
-	repairWriteSuccessCount          prometheus.Counter
-	repairWriteFailureCount          prometheus.Counter
-	walkKeysCount                    prometheus.Counter
+	insertCallCount                    prometheus.Counter
+	insertRecordCount                  prometheus.Counter
+	insertCallDuration                 prometheus.Summary
+	insertRecordDuration               prometheus.Summary
+	insertQuorumFailureCount           prometheus.Counter
+	insertMemberTooLargeCount          prometheus.Counter
+	selectCallCount                    prometheus.Counter
+	selectKeysCount                    prometheus.Counter
+	selectSendToCount                  prometheus.Counter
+	selectFirstResponseDuration        prometheus.Summary
+	selectPartialErrorCount            prometheus.Counter
+	selectBlockingDuration             prometheus.Summary
+	selectOverheadDuration             prometheus.Summary
+	selectDuration                     prometheus.Summary
+	selectClusterFirstResponseDuration *prometheus.SummaryVec
+	selectClusterDuration              *prometheus.SummaryVec
+	selectSendAllPermitGrantedCount    prometheus.Counter
+	selectSendAllPermitRejectedCount   prometheus.Counter
+	selectSendAllPermitsAvailable      prometheus.Gauge
+	selectSendAllPermitRatio           prometheus.Gauge
+	selectSendAllPromotionCount        prometheus.Counter
+	selectPromotionLatencyCount        prometheus.Counter
+	selectPromotionErrorCount          prometheus.Counter
+	selectRetrievedCount               prometheus.Counter
+	selectReturnedCount                prometheus.Counter
+	selectRepairNeededCount            prometheus.Counter
+	selectRepairTruncatedCount         prometheus.Counter
+	selectCacheHitCount                prometheus.Counter
+	selectCacheMissCount               prometheus.Counter
+	selectRangeAttempts                prometheus.Histogram
+	selectOffsetPastEndCount           prometheus.Counter
+	deleteCallCount                    prometheus.Counter
+	deleteRecordCount                  prometheus.Counter
+	deleteCallDuration                 prometheus.Summary
+	deleteRecordDuration               prometheus.Summary
+	deleteQuorumFailureCount           prometheus.Counter
+	deleteMemberTooLargeCount          prometheus.Counter
+	repairCallCount                    prometheus.Counter
+	repairRequestCount                 prometheus.Counter
+	repairDiscardedCount               prometheus.Counter
+	repairBufferDepth                  prometheus.Gauge
+	repairCheckPartialFailureCount     prometheus.Counter
+	repairCheckCompleteFailureCount    prometheus.Counter
+	repairCheckRedundantCount          prometheus.Counter
+	repairCheckDuration                prometheus.Summary
+	repairWriteCount                   prometheus.Counter
+	repairWriteSuccessCount            prometheus.Counter
+	repairWriteFailureCount            prometheus.Counter
+	repairWriteThrottledCount          *prometheus.CounterVec
+	repairStaleness                    prometheus.Summary
+	walkKeysCount                      prometheus.Counter
+	walkClockSkewDuration              prometheus.Summary
+	poolDialCount                      prometheus.Counter
+	poolDialFailureCount               prometheus.Counter
+	instanceUp                         *prometheus.GaugeVec
 }
 
 // New returns a new Instrumentation that prints metrics to the passed
@@ -79,6 +105,11 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "insert_quorum_failure_count",
 			Help:      "Insert quorum failure count.",
 		}),
+		insertMemberTooLargeCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "insert_member_too_large_count",
+			Help:      "Insert members rejected for exceeding the max member size.",
+		}),
 		selectCallCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "select_call_count",
@@ -123,6 +154,18 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Help:      "Overall select duration.",
 			MaxAge:    maxSummaryAge,
 		}),
+		selectClusterFirstResponseDuration: prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "select_cluster_first_response_duration_nanoseconds",
+			Help:      "Select first response duration, by the index of the cluster.",
+			MaxAge:    maxSummaryAge,
+		}, []string{"cluster"}),
+		selectClusterDuration: prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "select_cluster_duration_nanoseconds",
+			Help:      "Select duration until the last response, by the index of the cluster.",
+			MaxAge:    maxSummaryAge,
+		}, []string{"cluster"}),
 		selectSendAllPermitGrantedCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "select_send_all_permit_granted_count",
@@ -133,11 +176,31 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "select_send_all_permit_rejected_count",
 			Help:      "How many select requests were denied initial permission to send-all, in appropriate read strategies.",
 		}),
+		selectSendAllPermitsAvailable: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "select_send_all_permits_available",
+			Help:      "How many keys may currently be read with a send-all, in appropriate read strategies.",
+		}),
+		selectSendAllPermitRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "select_send_all_permit_ratio",
+			Help:      "Share of select requests granted initial permission to send-all over the last sampling interval, in appropriate read strategies.",
+		}),
 		selectSendAllPromotionCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "select_send_all_promotion_count",
 			Help:      "How many select requests were promoted to a send-all, in appropriate read strategies.",
 		}),
+		selectPromotionLatencyCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_promotion_latency_count",
+			Help:      "How many select requests were promoted to a send-all because the first cluster was too slow.",
+		}),
+		selectPromotionErrorCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_promotion_error_count",
+			Help:      "How many select requests were promoted to a send-all because the first cluster returned errors.",
+		}),
 		selectRetrievedCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "select_retrieved_count",
@@ -153,6 +216,32 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "select_repair_needed_count",
 			Help:      "How many repairs have been detected and requested by select calls.",
 		}),
+		selectRepairTruncatedCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_repair_truncated_count",
+			Help:      "How many repairs detected by select calls have been left to the walker, because they exceeded the max repairs per select.",
+		}),
+		selectCacheHitCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_cache_hit_count",
+			Help:      "How many keys in select calls have been answered from the select cache.",
+		}),
+		selectCacheMissCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_cache_miss_count",
+			Help:      "How many keys in select calls haven't been found in the select cache.",
+		}),
+		selectRangeAttempts: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: prefix,
+			Name:      "select_range_attempts",
+			Help:      "How many attempts a cursor-based select took per key, with a growing limit.",
+			Buckets:   prometheus.LinearBuckets(1, 1, 8),
+		}),
+		selectOffsetPastEndCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_offset_past_end_count",
+			Help:      "How many keys in offset-based select calls with a positive offset had no members at or past the offset.",
+		}),
 		deleteCallCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "delete_call_count",
@@ -180,6 +269,11 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "delete_quorum_failure_count",
 			Help:      "Delete quorum failure count.",
 		}),
+		deleteMemberTooLargeCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "delete_member_too_large_count",
+			Help:      "Delete members rejected for exceeding the max member size.",
+		}),
 		repairCallCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "repair_call_count",
@@ -195,6 +289,37 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "repair_discarded_count",
 			Help:      "How many repair calls have been discarded due to rate or buffer limits.",
 		}),
+		repairBufferDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "repair_buffer_depth",
+			Help:      "How many repair calls are queued in the Nonblocking repair buffer.",
+		}),
+		repairCheckPartialFailureCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "repair_check_partial_failure_count",
+			Help:      "How many clusters failed to respond to a repair check.",
+		}),
+		repairCheckCompleteFailureCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "repair_check_complete_failure_count",
+			Help:      "How many repair checks failed against every cluster.",
+		}),
+		repairCheckRedundantCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "repair_check_redundant_count",
+			Help:      "How many key-member tuples requested for repair were already consistent.",
+		}),
+		repairCheckDuration: prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "repair_check_duration_nanoseconds",
+			Help:      "Repair check duration per-call.",
+			MaxAge:    maxSummaryAge,
+		}),
+		repairWriteCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "repair_write_count",
+			Help:      "How many writes have been issued as a result of repairs.",
+		}),
 		repairWriteSuccessCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "repair_write_success_count",
@@ -205,11 +330,43 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "repair_write_failure_count",
 			Help:      "Repair write failure count.",
 		}),
+		repairWriteThrottledCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "repair_write_throttled_count",
+			Help:      "Repair write throttled count, by the index of the destination cluster.",
+		}, []string{"cluster"}),
+		repairStaleness: prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "repair_staleness_nanoseconds",
+			Help:      "How long ago the scores of key-member tuples written by repairs were, if scores are timestamps.",
+			MaxAge:    maxSummaryAge,
+		}),
 		walkKeysCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "walk_keys_count",
 			Help:      "How many keys have been walked by the walker process.",
 		}),
+		walkClockSkewDuration: prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "walk_clock_skew_nanoseconds",
+			Help:      "Spread between the fastest and slowest Redis instance clocks, per clock probe.",
+			MaxAge:    maxSummaryAge,
+		}),
+		poolDialCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "pool_dial_count",
+			Help:      "How many connections have been dialed for the pools of Redis instances.",
+		}),
+		poolDialFailureCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "pool_dial_failure_count",
+			Help:      "How many connections have failed to be dialed for the pools of Redis instances.",
+		}),
+		instanceUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "instance_up",
+			Help:      "Whether the Redis instance responded to the last health check (1) or not (0).",
+		}, []string{"instance"}),
 	}
 
 	prometheus.MustRegister(i.insertCallCount)
@@ -217,6 +374,7 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 	prometheus.MustRegister(i.insertCallDuration)
 	prometheus.MustRegister(i.insertRecordDuration)
 	prometheus.MustRegister(i.insertQuorumFailureCount)
+	prometheus.MustRegister(i.insertMemberTooLargeCount)
 	prometheus.MustRegister(i.selectCallCount)
 	prometheus.MustRegister(i.selectKeysCount)
 	prometheus.MustRegister(i.selectSendToCount)
@@ -225,23 +383,47 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 	prometheus.MustRegister(i.selectBlockingDuration)
 	prometheus.MustRegister(i.selectOverheadDuration)
 	prometheus.MustRegister(i.selectDuration)
+	prometheus.MustRegister(i.selectClusterFirstResponseDuration)
+	prometheus.MustRegister(i.selectClusterDuration)
 	prometheus.MustRegister(i.selectSendAllPermitGrantedCount)
 	prometheus.MustRegister(i.selectSendAllPermitRejectedCount)
+	prometheus.MustRegister(i.selectSendAllPermitsAvailable)
+	prometheus.MustRegister(i.selectSendAllPermitRatio)
 	prometheus.MustRegister(i.selectSendAllPromotionCount)
+	prometheus.MustRegister(i.selectPromotionLatencyCount)
+	prometheus.MustRegister(i.selectPromotionErrorCount)
 	prometheus.MustRegister(i.selectRetrievedCount)
 	prometheus.MustRegister(i.selectReturnedCount)
 	prometheus.MustRegister(i.selectRepairNeededCount)
+	prometheus.MustRegister(i.selectRepairTruncatedCount)
+	prometheus.MustRegister(i.selectCacheHitCount)
+	prometheus.MustRegister(i.selectCacheMissCount)
+	prometheus.MustRegister(i.selectRangeAttempts)
+	prometheus.MustRegister(i.selectOffsetPastEndCount)
 	prometheus.MustRegister(i.deleteCallCount)
 	prometheus.MustRegister(i.deleteRecordCount)
 	prometheus.MustRegister(i.deleteCallDuration)
 	prometheus.MustRegister(i.deleteRecordDuration)
 	prometheus.MustRegister(i.deleteQuorumFailureCount)
+	prometheus.MustRegister(i.deleteMemberTooLargeCount)
 	prometheus.MustRegister(i.repairCallCount)
 	prometheus.MustRegister(i.repairRequestCount)
 	prometheus.MustRegister(i.repairDiscardedCount)
+	prometheus.MustRegister(i.repairBufferDepth)
+	prometheus.MustRegister(i.repairCheckPartialFailureCount)
+	prometheus.MustRegister(i.repairCheckCompleteFailureCount)
+	prometheus.MustRegister(i.repairCheckRedundantCount)
+	prometheus.MustRegister(i.repairCheckDuration)
+	prometheus.MustRegister(i.repairWriteCount)
 	prometheus.MustRegister(i.repairWriteSuccessCount)
 	prometheus.MustRegister(i.repairWriteFailureCount)
+	prometheus.MustRegister(i.repairWriteThrottledCount)
+	prometheus.MustRegister(i.repairStaleness)
 	prometheus.MustRegister(i.walkKeysCount)
+	prometheus.MustRegister(i.walkClockSkewDuration)
+	prometheus.MustRegister(i.poolDialCount)
+	prometheus.MustRegister(i.poolDialFailureCount)
+	prometheus.MustRegister(i.instanceUp)
 
 	return i
 }
@@ -276,6 +458,11 @@ func (i PrometheusInstrumentation) InsertQuorumFailure() {
 	i.insertQuorumFailureCount.Inc()
 }
 
+// InsertMemberTooLarge satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) InsertMemberTooLarge(n int) {
+	i.insertMemberTooLargeCount.Add(float64(n))
+}
+
 // SelectCall satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) SelectCall() {
 	i.selectCallCount.Inc()
@@ -316,6 +503,16 @@ func (i PrometheusInstrumentation) SelectDuration(d time.Duration) {
 	i.selectDuration.Observe(float64(d.Nanoseconds()))
 }
 
+// SelectClusterFirstResponseDuration satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectClusterFirstResponseDuration(index int, d time.Duration) {
+	i.selectClusterFirstResponseDuration.WithLabelValues(strconv.Itoa(index)).Observe(float64(d.Nanoseconds()))
+}
+
+// SelectClusterDuration satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectClusterDuration(index int, d time.Duration) {
+	i.selectClusterDuration.WithLabelValues(strconv.Itoa(index)).Observe(float64(d.Nanoseconds()))
+}
+
 // SelectSendAllPermitGranted satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) SelectSendAllPermitGranted() {
 	i.selectSendAllPermitGrantedCount.Inc()
@@ -326,11 +523,31 @@ func (i PrometheusInstrumentation) SelectSendAllPermitRejected() {
 	i.selectSendAllPermitRejectedCount.Inc()
 }
 
+// SelectSendAllPermitsAvailable satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectSendAllPermitsAvailable(n int) {
+	i.selectSendAllPermitsAvailable.Set(float64(n))
+}
+
+// SelectSendAllPermitRatio satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectSendAllPermitRatio(ratio float64) {
+	i.selectSendAllPermitRatio.Set(ratio)
+}
+
 // SelectSendAllPromotion satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) SelectSendAllPromotion() {
 	i.selectSendAllPromotionCount.Inc()
 }
 
+// SelectPromotionLatency satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectPromotionLatency() {
+	i.selectPromotionLatencyCount.Inc()
+}
+
+// SelectPromotionError satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectPromotionError() {
+	i.selectPromotionErrorCount.Inc()
+}
+
 // SelectRetrieved satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) SelectRetrieved(n int) {
 	i.selectRetrievedCount.Add(float64(n))
@@ -346,6 +563,31 @@ func (i PrometheusInstrumentation) SelectRepairNeeded(n int) {
 	i.selectRepairNeededCount.Add(float64(n))
 }
 
+// SelectRepairTruncated satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectRepairTruncated(n int) {
+	i.selectRepairTruncatedCount.Add(float64(n))
+}
+
+// SelectCacheHit satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectCacheHit(n int) {
+	i.selectCacheHitCount.Add(float64(n))
+}
+
+// SelectCacheMiss satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectCacheMiss(n int) {
+	i.selectCacheMissCount.Add(float64(n))
+}
+
+// SelectRangeAttempts satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectRangeAttempts(n int) {
+	i.selectRangeAttempts.Observe(float64(n))
+}
+
+// SelectOffsetPastEnd satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectOffsetPastEnd(n int) {
+	i.selectOffsetPastEndCount.Add(float64(n))
+}
+
 // DeleteCall satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) DeleteCall() {
 	i.deleteCallCount.Inc()
@@ -371,6 +613,11 @@ func (i PrometheusInstrumentation) DeleteQuorumFailure() {
 	i.deleteQuorumFailureCount.Inc()
 }
 
+// DeleteMemberTooLarge satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) DeleteMemberTooLarge(n int) {
+	i.deleteMemberTooLargeCount.Add(float64(n))
+}
+
 // RepairCall satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) RepairCall() {
 	i.repairCallCount.Inc()
@@ -386,6 +633,36 @@ func (i PrometheusInstrumentation) RepairDiscarded(n int) {
 	i.repairDiscardedCount.Add(float64(n))
 }
 
+// RepairBufferDepth satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairBufferDepth(n int) {
+	i.repairBufferDepth.Set(float64(n))
+}
+
+// RepairCheckPartialFailure satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairCheckPartialFailure() {
+	i.repairCheckPartialFailureCount.Inc()
+}
+
+// RepairCheckCompleteFailure satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairCheckCompleteFailure() {
+	i.repairCheckCompleteFailureCount.Inc()
+}
+
+// RepairCheckRedundant satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairCheckRedundant(n int) {
+	i.repairCheckRedundantCount.Add(float64(n))
+}
+
+// RepairCheckDuration satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairCheckDuration(d time.Duration) {
+	i.repairCheckDuration.Observe(float64(d.Nanoseconds()))
+}
+
+// RepairWriteCount satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairWriteCount(n int) {
+	i.repairWriteCount.Add(float64(n))
+}
+
 // RepairWriteSuccess satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) RepairWriteSuccess(n int) {
 	i.repairWriteSuccessCount.Add(float64(n))
@@ -396,7 +673,43 @@ func (i PrometheusInstrumentation) RepairWriteFailure(n int) {
 	i.repairWriteFailureCount.Add(float64(n))
 }
 
+// RepairWriteThrottled satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairWriteThrottled(index, n int) {
+	i.repairWriteThrottledCount.WithLabelValues(strconv.Itoa(index)).Add(float64(n))
+}
+
+// RepairStaleness satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairStaleness(d time.Duration) {
+	i.repairStaleness.Observe(float64(d.Nanoseconds()))
+}
+
 // WalkKeys satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) WalkKeys(n int) {
 	i.walkKeysCount.Add(float64(n))
 }
+
+// InstanceUp records the result of a health check of the Redis instance
+// with the given address. It isn't part of the Instrumentation interface;
+// pass it to cluster.CheckInstances.
+func (i PrometheusInstrumentation) InstanceUp(address string, err error) {
+	up := 0.
+	if err == nil {
+		up = 1.
+	}
+	i.instanceUp.WithLabelValues(address).Set(up)
+}
+
+// WalkClockSkew satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) WalkClockSkew(d time.Duration) {
+	i.walkClockSkewDuration.Observe(float64(d.Nanoseconds()))
+}
+
+// PoolDial satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) PoolDial() {
+	i.poolDialCount.Inc()
+}
+
+// PoolDialFailure satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) PoolDialFailure() {
+	i.poolDialFailureCount.Inc()
+}
@@ -0,0 +1,124 @@
+package statsd
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/g2s"
+)
+
+// BufferedWriter packs the statsd messages written to it into larger
+// packets, to save on syscalls and UDP sends. Pass it to g2s.New.
+//
+// Messages are written to the underlying writer when a packet would exceed
+// maxPacketSize bytes, every flushInterval, and on Flush and Close. Metric
+// semantics are unchanged: statsd handles multiple newline-separated
+// messages per packet. BufferedWriter is safe for concurrent use.
+type BufferedWriter struct {
+	mtx           sync.Mutex
+	w             io.Writer
+	buf           bytes.Buffer
+	maxPacketSize int
+	quit          chan chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewBufferedWriter returns a new BufferedWriter over w, which is usually a
+// UDP connection to statsd. A flushInterval of zero or less disables
+// periodic flushing.
+func NewBufferedWriter(w io.Writer, maxPacketSize int, flushInterval time.Duration) *BufferedWriter {
+	bw := &BufferedWriter{
+		w:             w,
+		maxPacketSize: maxPacketSize,
+		quit:          make(chan chan struct{}),
+	}
+	go bw.loop(flushInterval)
+	return bw
+}
+
+// Write buffers the messages in p. g2s writes one or more newline-separated
+// messages per call, without a trailing newline.
+func (bw *BufferedWriter) Write(p []byte) (int, error) {
+	bw.mtx.Lock()
+	defer bw.mtx.Unlock()
+
+	if bw.buf.Len() > 0 && bw.buf.Len()+1+len(p) > bw.maxPacketSize {
+		if err := bw.flush(); err != nil {
+			log.Printf("statsd: flush: %s", err)
+		}
+	}
+	if bw.buf.Len() > 0 {
+		bw.buf.WriteByte('\n')
+	}
+	bw.buf.Write(p)
+	return len(p), nil
+}
+
+// Flush writes all buffered messages to the underlying writer.
+func (bw *BufferedWriter) Flush() error {
+	bw.mtx.Lock()
+	defer bw.mtx.Unlock()
+	return bw.flush()
+}
+
+// Close stops periodic flushing, and flushes any buffered messages. It
+// doesn't close the underlying writer. Close may be called more than once;
+// later calls only flush.
+func (bw *BufferedWriter) Close() error {
+	bw.closeOnce.Do(func() {
+		q := make(chan struct{})
+		bw.quit <- q
+		<-q
+	})
+	return bw.Flush()
+}
+
+func (bw *BufferedWriter) flush() error {
+	if bw.buf.Len() <= 0 {
+		return nil
+	}
+	defer bw.buf.Reset()
+	_, err := bw.w.Write(bw.buf.Bytes())
+	return err
+}
+
+func (bw *BufferedWriter) loop(flushInterval time.Duration) {
+	var tick <-chan time.Time
+	if flushInterval > 0 {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			if err := bw.Flush(); err != nil {
+				log.Printf("statsd: flush: %s", err)
+			}
+		case q := <-bw.quit:
+			close(q)
+			return
+		}
+	}
+}
+
+// DialBuffered is like g2s.Dial, but buffers metrics with a BufferedWriter.
+// Close the returned BufferedWriter to send the last metrics.
+func DialBuffered(proto, endpoint string, maxPacketSize int, flushInterval time.Duration) (g2s.Statter, *BufferedWriter, error) {
+	conn, err := net.DialTimeout(proto, endpoint, 2*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	bw := NewBufferedWriter(conn, maxPacketSize, flushInterval)
+	statter, err := g2s.New(bw, "")
+	if err != nil {
+		bw.Close()
+		conn.Close()
+		return nil, nil, err
+	}
+	return statter, bw, nil
+}
@@ -0,0 +1,94 @@
+package statsd
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// packets records every write as a packet.
+type packets struct {
+	mtx sync.Mutex
+	a   []string
+}
+
+func (p *packets) Write(b []byte) (int, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.a = append(p.a, string(b))
+	return len(b), nil
+}
+
+func (p *packets) get() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return append([]string{}, p.a...)
+}
+
+func TestBufferedWriterFlushOnSize(t *testing.T) {
+	var (
+		p  = &packets{}
+		bw = NewBufferedWriter(p, 10, 0)
+	)
+	defer bw.Close()
+
+	bw.Write([]byte("a:1|c"))
+	bw.Write([]byte("b:1|c")) // "a:1|c\nb:1|c" would be 11 bytes
+	if expected, got := []string{"a:1|c"}, p.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	bw.Flush()
+	if expected, got := []string{"a:1|c", "b:1|c"}, p.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestBufferedWriterFlushOnInterval(t *testing.T) {
+	var (
+		p  = &packets{}
+		bw = NewBufferedWriter(p, 1000, time.Millisecond)
+	)
+	defer bw.Close()
+
+	bw.Write([]byte("a:1|c"))
+	bw.Write([]byte("b:1|c"))
+	deadline := time.Now().Add(time.Second)
+	for len(p.get()) <= 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if expected, got := []string{"a:1|c\nb:1|c"}, p.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestBufferedWriterClose(t *testing.T) {
+	var (
+		p  = &packets{}
+		bw = NewBufferedWriter(p, 1000, time.Hour)
+	)
+	bw.Write([]byte("a:1|c"))
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []string{"a:1|c"}, p.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	// Closing again doesn't block, and still flushes.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bw.Write([]byte("b:1|c"))
+		bw.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("second Close blocked")
+	}
+	if expected, got := []string{"a:1|c", "b:1|c"}, p.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
@@ -2,6 +2,8 @@
 package statsd
 
 import (
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/peterbourgon/g2s"
@@ -48,6 +50,10 @@ func (i statsdInstrumentation) InsertQuorumFailure() {
 	i.statter.Counter(i.sampleRate, i.prefix+"insert.quorum_failure.count", 1)
 }
 
+func (i statsdInstrumentation) InsertMemberTooLarge(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"insert.member_too_large.count", n)
+}
+
 func (i statsdInstrumentation) SelectCall() {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.call.count", 1)
 }
@@ -80,6 +86,10 @@ func (i statsdInstrumentation) SelectDuration(d time.Duration) {
 	i.statter.Timing(i.sampleRate, i.prefix+"select.duration", d)
 }
 
+// Per-cluster Select timings are only exported to Prometheus.
+func (i statsdInstrumentation) SelectClusterFirstResponseDuration(int, time.Duration) {}
+func (i statsdInstrumentation) SelectClusterDuration(int, time.Duration)              {}
+
 func (i statsdInstrumentation) SelectSendAllPermitGranted() {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.send_all_permit_granted.count", 1)
 }
@@ -88,10 +98,26 @@ func (i statsdInstrumentation) SelectSendAllPermitRejected() {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.send_all_permit_rejected.count", 1)
 }
 
+func (i statsdInstrumentation) SelectSendAllPermitsAvailable(n int) {
+	i.statter.Gauge(i.sampleRate, i.prefix+"select.send_all_permits_available.gauge", strconv.Itoa(n))
+}
+
+func (i statsdInstrumentation) SelectSendAllPermitRatio(ratio float64) {
+	i.statter.Gauge(i.sampleRate, i.prefix+"select.send_all_permit_ratio.gauge", strconv.FormatFloat(ratio, 'f', 3, 64))
+}
+
 func (i statsdInstrumentation) SelectSendAllPromotion() {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.send_all_promotion.count", 1)
 }
 
+func (i statsdInstrumentation) SelectPromotionLatency() {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.promotion_latency.count", 1)
+}
+
+func (i statsdInstrumentation) SelectPromotionError() {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.promotion_error.count", 1)
+}
+
 func (i statsdInstrumentation) SelectRetrieved(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.retrieved.count", n)
 }
@@ -104,6 +130,29 @@ func (i statsdInstrumentation) SelectRepairNeeded(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.repair_needed.count", n)
 }
 
+func (i statsdInstrumentation) SelectRepairTruncated(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.repair_truncated.count", n)
+}
+
+func (i statsdInstrumentation) SelectCacheHit(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.cache_hit.count", n)
+}
+
+func (i statsdInstrumentation) SelectCacheMiss(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.cache_miss.count", n)
+}
+
+func (i statsdInstrumentation) SelectOffsetPastEnd(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.offset_past_end.count", n)
+}
+
+func (i statsdInstrumentation) SelectRangeAttempts(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.range.attempts.count", n)
+	if n > 1 {
+		i.statter.Counter(i.sampleRate, i.prefix+"select.range.retry.count", n-1)
+	}
+}
+
 func (i statsdInstrumentation) DeleteCall() {
 	i.statter.Counter(i.sampleRate, i.prefix+"delete.call.count", 1)
 }
@@ -124,6 +173,10 @@ func (i statsdInstrumentation) DeleteQuorumFailure() {
 	i.statter.Counter(i.sampleRate, i.prefix+"delete.quorum_failure.count", 1)
 }
 
+func (i statsdInstrumentation) DeleteMemberTooLarge(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"delete.member_too_large.count", n)
+}
+
 func (i statsdInstrumentation) RepairCall() {
 	i.statter.Counter(i.sampleRate, i.prefix+"repair.call.count", 1)
 }
@@ -136,6 +189,30 @@ func (i statsdInstrumentation) RepairDiscarded(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"repair.discarded.count", n)
 }
 
+func (i statsdInstrumentation) RepairBufferDepth(n int) {
+	i.statter.Gauge(i.sampleRate, i.prefix+"repair.buffer_depth.gauge", strconv.Itoa(n))
+}
+
+func (i statsdInstrumentation) RepairCheckPartialFailure() {
+	i.statter.Counter(i.sampleRate, i.prefix+"repair.check_partial_failure.count", 1)
+}
+
+func (i statsdInstrumentation) RepairCheckCompleteFailure() {
+	i.statter.Counter(i.sampleRate, i.prefix+"repair.check_complete_failure.count", 1)
+}
+
+func (i statsdInstrumentation) RepairCheckRedundant(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"repair.check_redundant.count", n)
+}
+
+func (i statsdInstrumentation) RepairCheckDuration(d time.Duration) {
+	i.statter.Timing(i.sampleRate, i.prefix+"repair.check.duration", d)
+}
+
+func (i statsdInstrumentation) RepairWriteCount(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"repair.write.count", n)
+}
+
 func (i statsdInstrumentation) RepairWriteSuccess(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"repair.write_success.count", n)
 }
@@ -144,6 +221,26 @@ func (i statsdInstrumentation) RepairWriteFailure(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"repair.write_failure.count", n)
 }
 
+func (i statsdInstrumentation) RepairWriteThrottled(index, n int) {
+	i.statter.Counter(i.sampleRate, fmt.Sprintf("%srepair.write_throttled.cluster_%d.count", i.prefix, index), n)
+}
+
+func (i statsdInstrumentation) RepairStaleness(d time.Duration) {
+	i.statter.Timing(i.sampleRate, i.prefix+"repair.staleness.duration", d)
+}
+
 func (i statsdInstrumentation) WalkKeys(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"walk.keys.count", n)
 }
+
+func (i statsdInstrumentation) WalkClockSkew(d time.Duration) {
+	i.statter.Timing(i.sampleRate, i.prefix+"walk.clock_skew.duration", d)
+}
+
+func (i statsdInstrumentation) PoolDial() {
+	i.statter.Counter(i.sampleRate, i.prefix+"pool.dial.count", 1)
+}
+
+func (i statsdInstrumentation) PoolDialFailure() {
+	i.statter.Counter(i.sampleRate, i.prefix+"pool.dial.failure.count", 1)
+}
@@ -0,0 +1,326 @@
+package instrumentation
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SwappableInstrumentation satisfies the Instrumentation interface by
+// forwarding each call to a target which can be swapped at any time, e.g. to
+// turn on verbose instrumentation during an incident without a restart. Pass
+// it to farm.New and cluster.New in place of the target. It's safe for
+// concurrent use, and forwarding costs an atomic load per call.
+type SwappableInstrumentation struct {
+	mtx    sync.Mutex   // serializes Swaps
+	target atomic.Value // of swappableTarget
+}
+
+// swappableTarget gives atomic.Value the same concrete type to store,
+// whatever the Instrumentation.
+type swappableTarget struct{ Instrumentation }
+
+// Satisfaction guaranteed.
+var _ Instrumentation = &SwappableInstrumentation{}
+
+// NewSwappableInstrumentation creates a new SwappableInstrumentation which
+// forwards all calls to target, until it's swapped. A nil target drops all
+// calls, like NopInstrumentation.
+func NewSwappableInstrumentation(target Instrumentation) *SwappableInstrumentation {
+	i := &SwappableInstrumentation{}
+	i.Swap(target)
+	return i
+}
+
+// Swap makes target receive all calls from now on, and returns the previous
+// target. Calls which are running keep going to the previous target. A nil
+// target drops all calls, like NopInstrumentation.
+func (i *SwappableInstrumentation) Swap(target Instrumentation) Instrumentation {
+	if target == nil {
+		target = NopInstrumentation{}
+	}
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	previous := i.Target()
+	i.target.Store(swappableTarget{target})
+	return previous
+}
+
+// Target returns the Instrumentation which currently receives all calls.
+func (i *SwappableInstrumentation) Target() Instrumentation {
+	t, ok := i.target.Load().(swappableTarget)
+	if !ok {
+		return NopInstrumentation{}
+	}
+	return t.Instrumentation
+}
+
+// InsertCall satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) InsertCall() {
+	i.Target().InsertCall()
+}
+
+// InsertRecordCount satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) InsertRecordCount(n int) {
+	i.Target().InsertRecordCount(n)
+}
+
+// InsertCallDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) InsertCallDuration(d time.Duration) {
+	i.Target().InsertCallDuration(d)
+}
+
+// InsertRecordDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) InsertRecordDuration(d time.Duration) {
+	i.Target().InsertRecordDuration(d)
+}
+
+// InsertQuorumFailure satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) InsertQuorumFailure() {
+	i.Target().InsertQuorumFailure()
+}
+
+// InsertMemberTooLarge satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) InsertMemberTooLarge(n int) {
+	i.Target().InsertMemberTooLarge(n)
+}
+
+// SelectCall satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectCall() {
+	i.Target().SelectCall()
+}
+
+// SelectKeys satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectKeys(n int) {
+	i.Target().SelectKeys(n)
+}
+
+// SelectSendTo satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectSendTo(n int) {
+	i.Target().SelectSendTo(n)
+}
+
+// SelectFirstResponseDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectFirstResponseDuration(d time.Duration) {
+	i.Target().SelectFirstResponseDuration(d)
+}
+
+// SelectPartialError satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectPartialError() {
+	i.Target().SelectPartialError()
+}
+
+// SelectBlockingDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectBlockingDuration(d time.Duration) {
+	i.Target().SelectBlockingDuration(d)
+}
+
+// SelectOverheadDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectOverheadDuration(d time.Duration) {
+	i.Target().SelectOverheadDuration(d)
+}
+
+// SelectDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectDuration(d time.Duration) {
+	i.Target().SelectDuration(d)
+}
+
+// SelectClusterFirstResponseDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectClusterFirstResponseDuration(index int, d time.Duration) {
+	i.Target().SelectClusterFirstResponseDuration(index, d)
+}
+
+// SelectClusterDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectClusterDuration(index int, d time.Duration) {
+	i.Target().SelectClusterDuration(index, d)
+}
+
+// SelectSendAllPermitGranted satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectSendAllPermitGranted() {
+	i.Target().SelectSendAllPermitGranted()
+}
+
+// SelectSendAllPermitRejected satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectSendAllPermitRejected() {
+	i.Target().SelectSendAllPermitRejected()
+}
+
+// SelectSendAllPermitsAvailable satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectSendAllPermitsAvailable(n int) {
+	i.Target().SelectSendAllPermitsAvailable(n)
+}
+
+// SelectSendAllPermitRatio satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectSendAllPermitRatio(f float64) {
+	i.Target().SelectSendAllPermitRatio(f)
+}
+
+// SelectSendAllPromotion satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectSendAllPromotion() {
+	i.Target().SelectSendAllPromotion()
+}
+
+// SelectPromotionLatency satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectPromotionLatency() {
+	i.Target().SelectPromotionLatency()
+}
+
+// SelectPromotionError satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectPromotionError() {
+	i.Target().SelectPromotionError()
+}
+
+// SelectRetrieved satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectRetrieved(n int) {
+	i.Target().SelectRetrieved(n)
+}
+
+// SelectReturned satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectReturned(n int) {
+	i.Target().SelectReturned(n)
+}
+
+// SelectRepairNeeded satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectRepairNeeded(n int) {
+	i.Target().SelectRepairNeeded(n)
+}
+
+// SelectRepairTruncated satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectRepairTruncated(n int) {
+	i.Target().SelectRepairTruncated(n)
+}
+
+// SelectCacheHit satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectCacheHit(n int) {
+	i.Target().SelectCacheHit(n)
+}
+
+// SelectCacheMiss satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectCacheMiss(n int) {
+	i.Target().SelectCacheMiss(n)
+}
+
+// SelectRangeAttempts satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectRangeAttempts(n int) {
+	i.Target().SelectRangeAttempts(n)
+}
+
+// SelectOffsetPastEnd satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) SelectOffsetPastEnd(n int) {
+	i.Target().SelectOffsetPastEnd(n)
+}
+
+// DeleteCall satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) DeleteCall() {
+	i.Target().DeleteCall()
+}
+
+// DeleteRecordCount satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) DeleteRecordCount(n int) {
+	i.Target().DeleteRecordCount(n)
+}
+
+// DeleteCallDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) DeleteCallDuration(d time.Duration) {
+	i.Target().DeleteCallDuration(d)
+}
+
+// DeleteRecordDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) DeleteRecordDuration(d time.Duration) {
+	i.Target().DeleteRecordDuration(d)
+}
+
+// DeleteQuorumFailure satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) DeleteQuorumFailure() {
+	i.Target().DeleteQuorumFailure()
+}
+
+// DeleteMemberTooLarge satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) DeleteMemberTooLarge(n int) {
+	i.Target().DeleteMemberTooLarge(n)
+}
+
+// RepairCall satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairCall() {
+	i.Target().RepairCall()
+}
+
+// RepairRequest satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairRequest(n int) {
+	i.Target().RepairRequest(n)
+}
+
+// RepairDiscarded satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairDiscarded(n int) {
+	i.Target().RepairDiscarded(n)
+}
+
+// RepairBufferDepth satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairBufferDepth(n int) {
+	i.Target().RepairBufferDepth(n)
+}
+
+// RepairCheckPartialFailure satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairCheckPartialFailure() {
+	i.Target().RepairCheckPartialFailure()
+}
+
+// RepairCheckCompleteFailure satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairCheckCompleteFailure() {
+	i.Target().RepairCheckCompleteFailure()
+}
+
+// RepairCheckRedundant satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairCheckRedundant(n int) {
+	i.Target().RepairCheckRedundant(n)
+}
+
+// RepairCheckDuration satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairCheckDuration(d time.Duration) {
+	i.Target().RepairCheckDuration(d)
+}
+
+// RepairWriteCount satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairWriteCount(n int) {
+	i.Target().RepairWriteCount(n)
+}
+
+// RepairWriteSuccess satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairWriteSuccess(n int) {
+	i.Target().RepairWriteSuccess(n)
+}
+
+// RepairWriteFailure satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairWriteFailure(n int) {
+	i.Target().RepairWriteFailure(n)
+}
+
+// RepairWriteThrottled satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairWriteThrottled(index, n int) {
+	i.Target().RepairWriteThrottled(index, n)
+}
+
+// RepairStaleness satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) RepairStaleness(d time.Duration) {
+	i.Target().RepairStaleness(d)
+}
+
+// WalkKeys satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) WalkKeys(n int) {
+	i.Target().WalkKeys(n)
+}
+
+// WalkClockSkew satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) WalkClockSkew(d time.Duration) {
+	i.Target().WalkClockSkew(d)
+}
+
+// PoolDial satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) PoolDial() {
+	i.Target().PoolDial()
+}
+
+// PoolDialFailure satisfies the Instrumentation interface.
+func (i *SwappableInstrumentation) PoolDialFailure() {
+	i.Target().PoolDialFailure()
+}
@@ -0,0 +1,89 @@
+// Package cmdutil holds the flag handling which roshi-server and roshi-walker
+// share, so that both binaries configure clusters the same way.
+package cmdutil
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// RedisPasswordEnv is the environment variable with the Redis password,
+// unless -redis.password.file is set. The password is never taken from the
+// command line, which is published at /debug/vars and in the process list.
+const RedisPasswordEnv = "ROSHI_REDIS_PASSWORD"
+
+// ReadRedisPassword reads the Redis password from the file at path, without
+// trailing newlines, or, for an empty path, from $ROSHI_REDIS_PASSWORD.
+func ReadRedisPassword(path string) (string, error) {
+	if path == "" {
+		return os.Getenv(RedisPasswordEnv), nil
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// ReadWriteScript reads and validates the custom write script at path, see
+// cluster.WithScript. An empty path means the default script, and returns
+// an empty script.
+func ReadWriteScript(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if err := cluster.ValidateScript(string(buf)); err != nil {
+		return "", fmt.Errorf("%s: %s", path, err)
+	}
+	return string(buf), nil
+}
+
+// MemberCompression returns the cluster.Option for the member.compression
+// flags, or nil if compression is disabled.
+func MemberCompression(threshold int, write bool) cluster.Option {
+	switch {
+	case threshold <= 0:
+		return nil
+	case write:
+		return cluster.WithMemberCompression(threshold)
+	default:
+		return cluster.WithUncompressedMembers(threshold)
+	}
+}
+
+// SplitPrefixes splits a comma-separated list of key prefixes, ignoring
+// empty ones, which would match every key.
+func SplitPrefixes(s string) []string {
+	prefixes := []string{}
+	for _, prefix := range strings.Split(s, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// CloseOnSignal closes c when the process is interrupted or terminated, and
+// then lets the signal take its default effect. It's used to send buffered
+// metrics before exiting.
+func CloseOnSignal(c io.Closer) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-ch
+		c.Close()
+		signal.Stop(ch)
+		syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+	}()
+}
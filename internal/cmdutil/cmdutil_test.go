@@ -0,0 +1,90 @@
+package cmdutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+func TestReadRedisPassword(t *testing.T) {
+	os.Setenv(RedisPasswordEnv, "from-env")
+	defer os.Unsetenv(RedisPasswordEnv)
+	if password, err := ReadRedisPassword(""); password != "from-env" || err != nil {
+		t.Errorf("no path: expected the password from $%s, got %q, %v", RedisPasswordEnv, password, err)
+	}
+
+	dir, err := ioutil.TempDir("", "cmdutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := ReadRedisPassword(path); password != "from-file" || err != nil {
+		t.Errorf("file: expected the password without its newline, got %q, %v", password, err)
+	}
+	if _, err := ReadRedisPassword(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("missing file: expected an error")
+	}
+}
+
+func TestReadWriteScript(t *testing.T) {
+	if script, err := ReadWriteScript(""); script != "" || err != nil {
+		t.Errorf("no path: expected no script and no error, got %q, %v", script, err)
+	}
+
+	dir, err := ioutil.TempDir("", "cmdutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		valid   = filepath.Join(dir, "valid.lua")
+		invalid = filepath.Join(dir, "invalid.lua")
+	)
+	if err := ioutil.WriteFile(valid, []byte(cluster.DefaultScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(invalid, []byte("return 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if script, err := ReadWriteScript(valid); script != cluster.DefaultScript || err != nil {
+		t.Errorf("valid script: expected the script and no error, got error %v", err)
+	}
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.lua")} {
+		if _, err := ReadWriteScript(path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestMemberCompression(t *testing.T) {
+	if option := MemberCompression(0, true); option != nil {
+		t.Error("threshold 0: expected no option")
+	}
+	for _, write := range []bool{true, false} {
+		if option := MemberCompression(100, write); option == nil {
+			t.Errorf("write=%v: expected an option", write)
+		}
+	}
+}
+
+func TestSplitPrefixes(t *testing.T) {
+	for s, expected := range map[string][]string{
+		"":               {},
+		" , ,":           {},
+		"a:":             {"a:"},
+		" a: , b:,, c: ": {"a:", "b:", "c:"},
+	} {
+		if got := SplitPrefixes(s); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%q: expected %q, got %q", s, expected, got)
+		}
+	}
+}
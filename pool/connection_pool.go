@@ -1,10 +1,13 @@
 package pool
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/soundcloud/roshi/instrumentation"
 )
 
 type connectionPool struct {
@@ -19,12 +22,26 @@ type connectionPool struct {
 	available   []redis.Conn
 	outstanding int
 	max         int
+
+	instr instrumentation.PoolInstrumentation
+
+	lifetime time.Duration
+	dialed   map[redis.Conn]time.Time // only tracked with a lifetime
+
+	auth auth
+
+	pingMu   *sync.Mutex
+	pingConn redis.Conn // dedicated to ping, not counted in outstanding
+	down     int32      // set to 1 while the last ping failed
 }
 
 func newConnectionPool(
 	address string,
 	connectTimeout, readTimeout, writeTimeout time.Duration,
 	maxConnections int,
+	maxLifetime time.Duration,
+	auth auth,
+	instr instrumentation.PoolInstrumentation,
 ) *connectionPool {
 	mu := &sync.Mutex{}
 	co := sync.NewCond(mu)
@@ -40,6 +57,15 @@ func newConnectionPool(
 		available:   []redis.Conn{},
 		outstanding: 0,
 		max:         maxConnections,
+
+		instr: instr,
+
+		lifetime: maxLifetime,
+		dialed:   map[redis.Conn]time.Time{},
+
+		auth: auth,
+
+		pingMu: &sync.Mutex{},
 	}
 }
 
@@ -64,7 +90,7 @@ func (p *connectionPool) get() (redis.Conn, error) {
 			// if it is nil. put() must handle that circumstance.
 			p.outstanding++
 			p.mu.Unlock()
-			return redis.DialTimeout("tcp", p.address, p.connect, p.read, p.write)
+			return p.dial()
 
 		case available > 0:
 			// Best case. We can directly use an available connection.
@@ -79,12 +105,73 @@ func (p *connectionPool) get() (redis.Conn, error) {
 	}
 }
 
+// dial dials a new connection, which the caller must have counted in
+// outstanding already.
+func (p *connectionPool) dial() (redis.Conn, error) {
+	conn, err := p.connectAuthenticated()
+	p.instr.PoolDial()
+	if err != nil {
+		p.instr.PoolDialFailure()
+	}
+	if err == nil && p.lifetime > 0 {
+		p.mu.Lock()
+		p.dialed[conn] = time.Now()
+		p.mu.Unlock()
+	}
+	return conn, err
+}
+
+// connectAuthenticated dials the instance, and authenticates the
+// connection, if the pool has credentials. Connections which fail to
+// authenticate are closed.
+func (p *connectionPool) connectAuthenticated() (redis.Conn, error) {
+	conn, err := redis.DialTimeout("tcp", p.address, p.connect, p.read, p.write)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.auth.authenticate(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: AUTH: %s", p.address, err)
+	}
+	return conn, nil
+}
+
+// warm dials and pings connections until the pool holds max of them,
+// counting the outstanding ones, and stops at the first failure.
+func (p *connectionPool) warm() error {
+	for {
+		p.mu.Lock()
+		if len(p.available)+p.outstanding >= p.max {
+			p.mu.Unlock()
+			return nil
+		}
+		p.outstanding++ // like get, so that put can take it back
+		p.mu.Unlock()
+
+		conn, err := p.dial()
+		if err == nil {
+			_, err = conn.Do("PING")
+		}
+		if err != nil {
+			if conn != nil {
+				conn.Close() // put drops closed connections
+			}
+			p.put(conn)
+			return err
+		}
+		p.put(conn)
+	}
+}
+
 func (p *connectionPool) put(conn redis.Conn) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	if conn == nil || conn.Err() != nil {
 		// Failed to dial, closed, or some other problem
+		if conn != nil {
+			delete(p.dialed, conn)
+		}
 		if p.outstanding > 0 {
 			p.outstanding--
 		}
@@ -92,7 +179,19 @@ func (p *connectionPool) put(conn redis.Conn) {
 		return
 	}
 
+	if dialed, ok := p.dialed[conn]; ok && time.Since(dialed) >= p.lifetime {
+		// Too old to be reused, see WithMaxConnectionLifetime
+		delete(p.dialed, conn)
+		go conn.Close() // don't block
+		if p.outstanding > 0 {
+			p.outstanding--
+		}
+		p.co.Signal() // someone can dial a new one
+		return
+	}
+
 	if len(p.available) >= p.max {
+		delete(p.dialed, conn)
 		go conn.Close() // don't block
 		return
 	}
@@ -104,12 +203,52 @@ func (p *connectionPool) put(conn redis.Conn) {
 	p.co.Signal()
 }
 
+func (p *connectionPool) ping() (err error) {
+	p.pingMu.Lock()
+	defer p.pingMu.Unlock()
+
+	defer func() {
+		var down int32
+		if err != nil {
+			down = 1
+		}
+		atomic.StoreInt32(&p.down, down)
+	}()
+
+	if p.pingConn == nil {
+		conn, err := p.connectAuthenticated()
+		if err != nil {
+			return err
+		}
+		p.pingConn = conn
+	}
+
+	if _, err := p.pingConn.Do("PING"); err != nil {
+		p.pingConn.Close()
+		p.pingConn = nil // redial next time
+		return err
+	}
+	return nil
+}
+
+func (p *connectionPool) isDown() bool {
+	return atomic.LoadInt32(&p.down) == 1
+}
+
 func (p *connectionPool) closeAll() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	for _, conn := range p.available {
+		delete(p.dialed, conn)
 		conn.Close()
 	}
 	p.available = []redis.Conn{}
+
+	p.pingMu.Lock()
+	defer p.pingMu.Unlock()
+	if p.pingConn != nil {
+		p.pingConn.Close()
+		p.pingConn = nil
+	}
 	return nil
 }
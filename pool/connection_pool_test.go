@@ -7,6 +7,8 @@ import (
 	"runtime"
 	"testing"
 	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
 )
 
 func TestMemoryRegression(t *testing.T) {
@@ -46,7 +48,7 @@ func TestMemoryRegression(t *testing.T) {
 	addr := "127.0.0.1:54321" // invalid
 	timeout := 500 * time.Millisecond
 	maxConnections := 25
-	p := newConnectionPool(addr, timeout, timeout, timeout, maxConnections)
+	p := newConnectionPool(addr, timeout, timeout, timeout, maxConnections, 0, auth{}, instrumentation.NopInstrumentation{})
 	for i, n := 0, 10; i < n; i++ {
 		runtime.GC()
 		p.get()
@@ -3,9 +3,33 @@ package pool
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"hash/fnv"
+	"strings"
 )
 
+// HashNames are the names HashByName knows, in the order of its docs.
+var HashNames = []string{"murmur3", "fnv", "fnva", "xxhash"}
+
+// HashByName returns the string hashing function with the given name,
+// ignoring case: murmur3 for Murmur3, fnv for FNV, fnva for FNVa, or xxhash
+// for XXHash. Every process which reads or writes the same instances must use
+// the same hash, or it will look for keys on the wrong instances.
+func HashByName(name string) (func(string) uint32, error) {
+	switch strings.ToLower(name) {
+	case "murmur3":
+		return Murmur3, nil
+	case "fnv":
+		return FNV, nil
+	case "fnva":
+		return FNVa, nil
+	case "xxhash":
+		return XXHash, nil
+	default:
+		return nil, fmt.Errorf("unknown hash %q", name)
+	}
+}
+
 // FNV implements the FNV-1 string hashing function. It can be passed to
 // NewCluster.
 func FNV(s string) uint32 {
@@ -73,3 +97,67 @@ func Murmur3(s string) uint32 {
 	h ^= h >> 16
 	return h
 }
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+// XXHash implements the 32-bit xxHash string hashing function, XXH32, with a
+// seed of 0. It can be passed to NewCluster.
+//
+// https://github.com/Cyan4973/xxHash
+func XXHash(s string) uint32 {
+	var (
+		length = len(s)
+		seed   = uint32(0)
+		i      = 0
+		h      uint32
+	)
+	if length >= 16 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for ; i+16 <= length; i += 16 {
+			v1 = xxRound(v1, xxUint32(s[i:]))
+			v2 = xxRound(v2, xxUint32(s[i+4:]))
+			v3 = xxRound(v3, xxUint32(s[i+8:]))
+			v4 = xxRound(v4, xxUint32(s[i+12:]))
+		}
+		h = rotl32(v1, 1) + rotl32(v2, 7) + rotl32(v3, 12) + rotl32(v4, 18)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint32(length)
+
+	for ; i+4 <= length; i += 4 {
+		h += xxUint32(s[i:]) * xxPrime3
+		h = rotl32(h, 17) * xxPrime4
+	}
+	for ; i < length; i++ {
+		h += uint32(s[i]) * xxPrime5
+		h = rotl32(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxRound(v, input uint32) uint32 {
+	v += input * xxPrime2
+	return rotl32(v, 13) * xxPrime1
+}
+
+func xxUint32(s string) uint32 {
+	return uint32(s[0]) | uint32(s[1])<<8 | uint32(s[2])<<16 | uint32(s[3])<<24
+}
+
+func rotl32(x uint32, r uint) uint32 { return (x << r) | (x >> (32 - r)) }
@@ -22,6 +22,71 @@ func TestMurmur3Correctness(t *testing.T) {
 	}
 }
 
+func TestXXHashCorrectness(t *testing.T) {
+	for input, expected := range map[string]uint32{
+		"":    0x02cc5d05,
+		"a":   0x550d7456,
+		"abc": 0x32d153ff,
+		"Nobody inspects the spammish repetition": 0xe2293b2f,
+	} {
+		if got := XXHash(input); expected != got {
+			t.Errorf("%q: expected %#x, got %#x", input, expected, got)
+		}
+	}
+}
+
+func TestHashByName(t *testing.T) {
+	for _, name := range append(HashNames, "MURMUR3") {
+		hash, err := HashByName(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if hash == nil {
+			t.Errorf("%s: expected a hash function", name)
+		}
+	}
+	if got, _ := HashByName("xxhash"); got("abc") != XXHash("abc") {
+		t.Errorf("xxhash: expected XXHash")
+	}
+	if _, err := HashByName("md5"); err == nil {
+		t.Errorf("md5: expected an error")
+	}
+}
+
+// TestHashDistribution compares how evenly the hashes spread keys across 16
+// instances. The keys are fixed, so that the test is deterministic. They're
+// similar to one another, like real keys, which FNV and FNVa spread less
+// evenly than Murmur3 and XXHash.
+func TestHashDistribution(t *testing.T) {
+	const (
+		instances = 16
+		n         = 100000
+		tolerance = 0.1 // of the mean
+	)
+	for _, name := range HashNames {
+		hash, err := HashByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make([]int, instances)
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("redis:%d:%s", i, stdevSuffixes[i%len(stdevSuffixes)])
+			counts[hash(key)%instances]++
+		}
+		var (
+			mean         = float64(n) / instances
+			maxDeviation = 0.0
+		)
+		for _, count := range counts {
+			maxDeviation = math.Max(maxDeviation, math.Abs(float64(count)-mean)/mean)
+		}
+		if maxDeviation > tolerance {
+			t.Errorf("%s: an instance got %.1f%% more or fewer keys than the mean, expected at most %.1f%%: %v", name, 100*maxDeviation, 100*tolerance, counts)
+		}
+		t.Logf("%s: max deviation from the mean %.1f%%", name, 100*maxDeviation)
+	}
+}
+
 const (
 	stdevN         int     = 100000
 	stdevModulo    uint32  = 64
@@ -78,6 +143,10 @@ func TestFNVaStdev(t *testing.T) {
 	testStdev(t, FNVa)
 }
 
+func TestXXHashStdev(t *testing.T) {
+	testStdev(t, XXHash)
+}
+
 func stdev(keyGenerator func() string, n int, hash func(string) uint32, modulo uint32) float64 {
 	m := map[uint32]int{}
 	for i := 0; i < n; i++ {
@@ -123,3 +192,9 @@ func BenchmarkFNVa(b *testing.B) {
 		FNVa(benchmarkString)
 	}
 }
+
+func BenchmarkXXHash(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		XXHash(benchmarkString)
+	}
+}
@@ -2,16 +2,94 @@
 package pool
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/soundcloud/roshi/instrumentation"
 )
 
 // Pool maintains a connection pool for multiple Redis instances.
 type Pool struct {
-	connections []*connectionPool
+	connections []*connectionPool // of the addresses, then old-only addresses
 	hash        func(string) uint32
+	primaries   int       // number of addresses passed to New
+	migration   migration // see WithMigration
+	instr       instrumentation.PoolInstrumentation
+	lifetime    time.Duration // see WithMaxConnectionLifetime
+	auth        auth          // see WithAuth
+}
+
+// Option changes the default behavior of a Pool.
+type Option func(*Pool)
+
+// WithMigration puts the pool in migration mode until the given time, to
+// reshard from the oldAddresses to the addresses passed to New without
+// downtime. During the window, Fallback returns the instance which held
+// each key in the old layout, so that readers can check both instances,
+// while writers keep using Index, i.e. the new layout. Once the window is
+// over, Fallback returns nothing, and the old layout is ignored.
+//
+// Migration mode doesn't move any data. The keys of the old layout must be
+// copied to the new one during the window; the window only keeps keys which
+// haven't been copied yet from missing in reads. Old addresses which aren't
+// among the new ones are added to the pool, after them, so Size includes
+// them, e.g. for scanning the keys of the old layout.
+func WithMigration(oldAddresses []string, until time.Time) Option {
+	return func(p *Pool) { p.migration = migration{addresses: oldAddresses, until: until} }
+}
+
+// WithInstrumentation reports dials of new connections, and dial failures,
+// to instr. By default, they aren't reported.
+func WithInstrumentation(instr instrumentation.PoolInstrumentation) Option {
+	return func(p *Pool) { p.instr = instr }
+}
+
+// WithMaxConnectionLifetime closes connections which are older than d when
+// they are returned to their connection pool, rather than reusing them, so
+// that the pool periodically redials every instance. That keeps connections
+// from accumulating server-side state, and lets load balancers in front of
+// the instances rebalance them. The default, 0, means connections live until
+// they fail, or the pool is closed.
+func WithMaxConnectionLifetime(d time.Duration) Option {
+	return func(p *Pool) { p.lifetime = d }
+}
+
+// WithAuth authenticates every connection with AUTH right after dialing it:
+// with AUTH username password, for the ACLs of Redis 6 and later, if a
+// username is given, and with AUTH password otherwise, which authenticates
+// as the default user. A connection which fails to authenticate is closed,
+// and the error returned, like for a failed dial. The default, and an empty
+// password without username, means connections don't authenticate.
+func WithAuth(username, password string) Option {
+	return func(p *Pool) { p.auth = auth{username: username, password: password} }
+}
+
+// auth are the credentials of the connections of a pool, see WithAuth.
+type auth struct {
+	username, password string
+}
+
+// authenticate sends AUTH on conn, unless there are no credentials.
+func (a auth) authenticate(conn redis.Conn) error {
+	var err error
+	switch {
+	case a.username != "":
+		_, err = conn.Do("AUTH", a.username, a.password)
+	case a.password != "":
+		_, err = conn.Do("AUTH", a.password)
+	}
+	return err
+}
+
+// migration is the old layout of a migrating pool.
+type migration struct {
+	addresses []string
+	indexes   []int // of the addresses in connections
+	until     time.Time
 }
 
 // New creates and returns a new Pool object.
@@ -28,35 +106,85 @@ type Pool struct {
 // Max connections per instance is the size of the connection pool for each
 // Redis instance. Hash defines the hash function used by the With methods.
 // Any function that takes a string and returns a uint32 may be used. Package
-// pool ships with several options, including Murmur3, FNV, and FNVa.
+// pool ships with several options, including Murmur3, FNV, FNVa, and XXHash,
+// see HashByName. Options may be used to change the default behavior.
 func New(
 	addresses []string,
 	connectTimeout, readTimeout, writeTimeout time.Duration,
 	maxConnectionsPerInstance int,
 	hash func(string) uint32,
+	options ...Option,
 ) *Pool {
-	connections := make([]*connectionPool, len(addresses))
+	p := &Pool{
+		hash:      hash,
+		primaries: len(addresses),
+		instr:     instrumentation.NopInstrumentation{},
+	}
+	for _, option := range options {
+		option(p)
+	}
+	if p.instr == nil {
+		p.instr = instrumentation.NopInstrumentation{}
+	}
+
+	p.connections = make([]*connectionPool, len(addresses))
 	for i, address := range addresses {
-		connections[i] = newConnectionPool(
+		p.connections[i] = newConnectionPool(
 			address,
 			connectTimeout, readTimeout, writeTimeout,
 			maxConnectionsPerInstance,
+			p.lifetime,
+			p.auth,
+			p.instr,
 		)
 	}
-	return &Pool{
-		connections: connections,
-		hash:        hash,
+
+	// Old addresses share the connection pool of the same new address.
+	indexes := make(map[string]int, len(p.connections))
+	for i, c := range p.connections {
+		indexes[c.address] = i
+	}
+	for _, address := range p.migration.addresses {
+		index, ok := indexes[address]
+		if !ok {
+			index = len(p.connections)
+			indexes[address] = index
+			p.connections = append(p.connections, newConnectionPool(
+				address,
+				connectTimeout, readTimeout, writeTimeout,
+				maxConnectionsPerInstance,
+				p.lifetime,
+				p.auth,
+				p.instr,
+			))
+		}
+		p.migration.indexes = append(p.migration.indexes, index)
 	}
+	return p
 }
 
 // Index returns a reference to the connection pool that will be used to
 // satisfy any request for the given key. Pass that value to WithIndex.
 func (p *Pool) Index(key string) int {
-	return int(p.hash(key) % uint32(len(p.connections)))
+	return int(p.hash(key) % uint32(p.primaries))
+}
+
+// Fallback returns a reference to the connection pool of the instance which
+// held the given key in the old layout, while the pool is migrating, see
+// WithMigration. ok is false if the pool isn't migrating, the window is
+// over, or the key is on the same instance in both layouts.
+func (p *Pool) Fallback(key string) (index int, ok bool) {
+	m := p.migration
+	if len(m.indexes) <= 0 || !time.Now().Before(m.until) {
+		return 0, false
+	}
+	index = m.indexes[p.hash(key)%uint32(len(m.indexes))]
+	return index, index != p.Index(key)
 }
 
-// Size returns how many instances the pool sits over. Useful for ranging
-// over with WithIndex.
+// Size returns how many instances the pool sits over, including the
+// instances which are only in the old layout of a migration. Useful for
+// ranging over with WithIndex.
 func (p *Pool) Size() int {
 	return len(p.connections)
 }
@@ -66,9 +194,14 @@ func (p *Pool) Size() int {
 // function returns a nil error, WithIndex returns the connection to the pool
 // after it's used. Otherwise, WithIndex discards the connection.
 //
+// If the function returns a Redis error reply (redis.Error), e.g. because the
+// instance is out of memory, the connection is still healthy. WithIndex reads
+// any replies left pending by the function, returns the connection to the
+// pool, and returns the error reply wrapped in a LogicalError.
+//
 // WithIndex will return an error if it wasn't able to successfully retrieve a
-// connection from the referenced connection pool, and will forward any error
-// returned by the `do` function.
+// connection from the referenced connection pool, and will forward any other
+// error returned by the `do` function.
 func (p *Pool) WithIndex(index int, do func(redis.Conn) error) error {
 	conn, err := p.connections[index].get() // blocking up to connectTimeout
 	defer p.connections[index].put(conn)    // always put, even if it's nil
@@ -77,12 +210,44 @@ func (p *Pool) WithIndex(index int, do func(redis.Conn) error) error {
 	}
 
 	err = do(conn)
+	if reply, ok := err.(redis.Error); ok {
+		if _, drainErr := conn.Do(""); drainErr != nil {
+			conn.Close()
+		}
+		return LogicalError{Address: p.connections[index].address, Reply: reply}
+	}
 	if err != nil {
 		conn.Close() // deferred `put` will detect this, and reject the conn
 	}
 	return err
 }
 
+// LogicalError is returned by WithIndex when a Redis instance rejected a
+// command with an error reply, rather than being unreachable. Typical causes
+// are the instance running out of memory, or a key holding another type.
+type LogicalError struct {
+	Address string
+	Reply   redis.Error
+}
+
+func (e LogicalError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Address, e.Reply)
+}
+
+// OutOfMemory returns true if the instance rejected the command because it
+// reached its maxmemory limit. Error replies of scripts wrap the reply of the
+// rejected command, so the message is searched rather than its prefix.
+func (e LogicalError) OutOfMemory() bool {
+	return strings.Contains(string(e.Reply), "OOM command not allowed")
+}
+
+// IsOutOfMemory returns true if err is a LogicalError caused by an instance
+// running out of memory.
+func IsOutOfMemory(err error) bool {
+	e, ok := err.(LogicalError)
+	return ok && e.OutOfMemory()
+}
+
 // With is a convenience function that combines Index and WithIndex, for
 // simple/single Redis requests on a single key.
 func (p *Pool) With(key string, do func(redis.Conn) error) error {
@@ -98,6 +263,94 @@ func (p *Pool) ID(index int) string {
 	return p.connections[index].address
 }
 
+// Warm dials connections to the Redis instance represented by index until
+// its connection pool is full, and pings each, e.g. at startup, so that the
+// first requests don't pay for dialing. Connections which are in use count
+// as well. Warm stops at the first failure.
+func (p *Pool) Warm(index int) error {
+	return p.connections[index].warm()
+}
+
+// Ping sends a PING to the Redis instance represented by index, and returns
+// any error. Pings use a dedicated connection per instance, which is kept
+// open between calls, and doesn't count against the max connections per
+// instance. Concurrent Pings to the same instance are serialized.
+func (p *Pool) Ping(index int) error {
+	return p.connections[index].ping()
+}
+
+// Down returns true if the last Ping of the Redis instance represented by
+// index failed. Instances which have never been pinged aren't down.
+func (p *Pool) Down(index int) bool {
+	return p.connections[index].isDown()
+}
+
+// ErrDown is returned by Info for instances which are down, see Down.
+var ErrDown = errors.New("instance is down")
+
+// Info is the state of a Redis instance, as reported by INFO.
+type Info struct {
+	UsedMemory int64 // bytes, used_memory
+	MaxMemory  int64 // bytes, maxmemory, 0 if unlimited
+	Keys       int64 // in all databases
+	Expires    int64 // keys with a TTL, in all databases
+}
+
+// Info sends an INFO to the Redis instance represented by index, and parses
+// the memory and keyspace fields of the reply. It's a single command, but
+// the reply is a few KB. Instances which are down, i.e. whose last Ping
+// failed, fail with ErrDown right away, rather than after the connect
+// timeout.
+func (p *Pool) Info(index int) (Info, error) {
+	if p.Down(index) {
+		return Info{}, ErrDown
+	}
+	var info Info
+	err := p.WithIndex(index, func(conn redis.Conn) error {
+		reply, err := redis.String(conn.Do("INFO"))
+		if err != nil {
+			return err
+		}
+		info = parseInfo(reply)
+		return nil
+	})
+	return info, err
+}
+
+// parseInfo parses the reply of INFO. Missing or malformed fields are left
+// zero.
+func parseInfo(reply string) Info {
+	var info Info
+	for _, line := range strings.Split(reply, "\n") {
+		field := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(field) != 2 {
+			continue // blank, or a section header
+		}
+		switch name, value := field[0], field[1]; {
+		case name == "used_memory":
+			info.UsedMemory, _ = strconv.ParseInt(value, 10, 64)
+		case name == "maxmemory":
+			info.MaxMemory, _ = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(name, "db"):
+			// db0:keys=1,expires=0,avg_ttl=0
+			for _, pair := range strings.Split(value, ",") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				n, _ := strconv.ParseInt(kv[1], 10, 64)
+				switch kv[0] {
+				case "keys":
+					info.Keys += n
+				case "expires":
+					info.Expires += n
+				}
+			}
+		}
+	}
+	return info
+}
+
 // Close closes all available (idle) connections in the cluster.
 // Close does not affect outstanding (in-use) connections.
 func (p *Pool) Close() error {
@@ -0,0 +1,421 @@
+package pool
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestLogicalErrorKeepsConnection(t *testing.T) {
+	addr, accepted := fakeRedis(t)
+	p := New([]string{addr}, time.Second, time.Second, time.Second, 1, Murmur3)
+	defer p.Close()
+
+	// Pipeline three SETs, and bail out at the first error reply, leaving
+	// two replies pending.
+	err := p.WithIndex(0, func(conn redis.Conn) error {
+		for i := 0; i < 3; i++ {
+			if err := conn.Send("SET", "foo", "bar"); err != nil {
+				return err
+			}
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		_, err := conn.Receive()
+		return err
+	})
+	e, ok := err.(LogicalError)
+	if !ok {
+		t.Fatalf("expected a LogicalError, got %#v", err)
+	}
+	if e.Address != addr {
+		t.Errorf("expected address %q, got %q", addr, e.Address)
+	}
+	if !IsOutOfMemory(err) {
+		t.Errorf("expected %q to be out of memory", err)
+	}
+
+	// The connection is reused, and the pending replies are gone.
+	if err := p.WithIndex(0, func(conn redis.Conn) error {
+		reply, err := redis.String(conn.Do("PING"))
+		if err != nil {
+			return err
+		}
+		if reply != "PONG" {
+			return fmt.Errorf("expected PONG, got %q", reply)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := int32(1), atomic.LoadInt32(accepted); expected != got {
+		t.Errorf("expected %d connection(s), got %d", expected, got)
+	}
+
+	// Other error replies are logical errors too, but not out of memory.
+	err = p.WithIndex(0, func(conn redis.Conn) error {
+		_, err := conn.Do("ZADD", "foo", 1, "bar")
+		return err
+	})
+	if _, ok := err.(LogicalError); !ok || IsOutOfMemory(err) {
+		t.Errorf("expected a LogicalError other than out of memory, got %#v", err)
+	}
+	if expected, got := int32(1), atomic.LoadInt32(accepted); expected != got {
+		t.Errorf("expected %d connection(s), got %d", expected, got)
+	}
+}
+
+const fakeInfo = "# Server\r\nredis_version:6.2.6\r\n\r\n" +
+	"# Memory\r\nused_memory:1048576\r\nused_memory_human:1.00M\r\nmaxmemory:4194304\r\n\r\n" +
+	"# Keyspace\r\ndb0:keys=10,expires=2,avg_ttl=100\r\ndb1:keys=5,expires=0,avg_ttl=0\r\n"
+
+func TestInfo(t *testing.T) {
+	addr, _ := fakeRedis(t)
+	p := New([]string{addr, "127.0.0.1:1"}, time.Second, time.Second, time.Second, 1, Murmur3)
+	defer p.Close()
+
+	info, err := p.Info(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Info{UsedMemory: 1048576, MaxMemory: 4194304, Keys: 15, Expires: 2}); expected != info {
+		t.Errorf("expected %+v, got %+v", expected, info)
+	}
+
+	// Once a Ping failed, Info fails without dialing.
+	if err := p.Ping(1); err == nil {
+		t.Fatal("expected the Ping to fail")
+	}
+	if _, err := p.Info(1); err != ErrDown {
+		t.Errorf("expected %v, got %v", ErrDown, err)
+	}
+}
+
+// fakeRedis serves a Redis which is out of memory: SETs are rejected, ZADDs
+// hit a key of the wrong type, and PINGs and INFOs work. It returns the address, and
+// the number of accepted connections.
+func fakeRedis(t *testing.T) (string, *int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := new(int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go serveFakeRedis(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String(), accepted
+}
+
+func serveFakeRedis(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			reply = "+PONG\r\n"
+		case "INFO":
+			reply = fmt.Sprintf("$%d\r\n%s\r\n", len(fakeInfo), fakeInfo)
+		case "SET":
+			reply = "-OOM command not allowed when used memory > 'maxmemory'.\r\n"
+		default:
+			reply = "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil { // $length
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestMigration(t *testing.T) {
+	var (
+		oldAddresses = []string{"a:6379", "b:6379"}
+		newAddresses = []string{"a:6379", "b:6379", "c:6379"}
+		oldPool      = New(oldAddresses, time.Second, time.Second, time.Second, 1, Murmur3)
+		newPool      = New(newAddresses, time.Second, time.Second, time.Second, 1, Murmur3, WithMigration(oldAddresses, time.Now().Add(time.Hour)))
+		moved        = 0
+	)
+	if expected, got := len(newAddresses), newPool.Size(); expected != got {
+		t.Errorf("expected %d instance(s), got %d", expected, got)
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		var (
+			oldID, newID = oldPool.ID(oldPool.Index(key)), newPool.ID(newPool.Index(key))
+			index, ok    = newPool.Fallback(key)
+		)
+		if !ok {
+			if oldID != newID {
+				t.Fatalf("%s: moved from %s to %s, but no fallback", key, oldID, newID)
+			}
+			continue
+		}
+		moved++
+		if expected, got := oldID, newPool.ID(index); expected != got {
+			t.Fatalf("%s: expected fallback %s, got %s", key, expected, got)
+		}
+	}
+	if moved <= 0 {
+		t.Errorf("expected some keys to move")
+	}
+
+	// Old-only instances are added after the new ones.
+	p := New([]string{"c:6379"}, time.Second, time.Second, time.Second, 1, Murmur3, WithMigration(oldAddresses, time.Now().Add(time.Hour)))
+	if expected, got := 3, p.Size(); expected != got {
+		t.Fatalf("expected %d instance(s), got %d", expected, got)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if index, ok := p.Fallback(key); !ok || p.ID(index) != oldPool.ID(oldPool.Index(key)) || p.Index(key) != 0 {
+			t.Fatalf("%s: unexpected index %d, fallback %d (%v)", key, p.Index(key), index, ok)
+		}
+	}
+
+	// Once the window is over, there are no fallbacks.
+	p = New(newAddresses, time.Second, time.Second, time.Second, 1, Murmur3, WithMigration(oldAddresses, time.Now()))
+	for i := 0; i < 100; i++ {
+		if _, ok := p.Fallback(fmt.Sprintf("key%d", i)); ok {
+			t.Fatalf("fallback after the window")
+		}
+	}
+}
+
+type dialInstrumentation struct{ dials, failures int32 }
+
+func (i *dialInstrumentation) PoolDial()        { atomic.AddInt32(&i.dials, 1) }
+func (i *dialInstrumentation) PoolDialFailure() { atomic.AddInt32(&i.failures, 1) }
+
+func TestDialInstrumentation(t *testing.T) {
+	addr, _ := fakeRedis(t)
+	instr := &dialInstrumentation{}
+	p := New([]string{addr, "127.0.0.1:54321"}, time.Second, time.Second, time.Second, 1, Murmur3, WithInstrumentation(instr)) // the second address is invalid
+	defer p.Close()
+
+	// The first use of an instance dials, the second reuses the connection.
+	for i := 0; i < 2; i++ {
+		if err := p.WithIndex(0, func(conn redis.Conn) error {
+			_, err := conn.Do("PING")
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dials, failures := atomic.LoadInt32(&instr.dials), atomic.LoadInt32(&instr.failures); dials != 1 || failures != 0 {
+		t.Errorf("expected 1 dial and 0 failures, got %d and %d", dials, failures)
+	}
+
+	// Failed dials are counted as both.
+	if err := p.WithIndex(1, func(redis.Conn) error { return nil }); err == nil {
+		t.Fatal("expected an error")
+	}
+	if dials, failures := atomic.LoadInt32(&instr.dials), atomic.LoadInt32(&instr.failures); dials != 2 || failures != 1 {
+		t.Errorf("expected 2 dials and 1 failure, got %d and %d", dials, failures)
+	}
+}
+
+func TestMaxConnectionLifetime(t *testing.T) {
+	addr, accepted := fakeRedis(t)
+	p := New([]string{addr}, time.Second, time.Second, time.Second, 1, Murmur3, WithMaxConnectionLifetime(50*time.Millisecond))
+	defer p.Close()
+
+	ping := func() {
+		if err := p.WithIndex(0, func(conn redis.Conn) error {
+			_, err := conn.Do("PING")
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A young connection is reused...
+	ping()
+	ping()
+	if expected, got := int32(1), atomic.LoadInt32(accepted); expected != got {
+		t.Errorf("expected %d connection(s), got %d", expected, got)
+	}
+
+	// ...an aged one isn't.
+	time.Sleep(100 * time.Millisecond)
+	ping() // returns the aged connection
+	ping()
+	if expected, got := int32(2), atomic.LoadInt32(accepted); expected != got {
+		t.Errorf("expected %d connection(s), got %d", expected, got)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	addr, accepted := fakeRedis(t)
+	p := New([]string{addr, "127.0.0.1:54321"}, time.Second, time.Second, time.Second, 3, Murmur3)
+	defer p.Close()
+
+	if err := p.Warm(0); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := int32(3), atomic.LoadInt32(accepted); expected != got {
+		t.Errorf("expected %d connection(s), got %d", expected, got)
+	}
+
+	// A full pool doesn't dial, neither on use nor on another warmup.
+	if err := p.WithIndex(0, func(conn redis.Conn) error {
+		_, err := conn.Do("PING")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Warm(0); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := int32(3), atomic.LoadInt32(accepted); expected != got {
+		t.Errorf("expected %d connection(s), got %d", expected, got)
+	}
+
+	if err := p.Warm(1); err == nil {
+		t.Error("expected an error warming an unreachable instance")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		username, password string
+		ok                 bool
+	}{
+		{"password", "", "s3cr3t", true},
+		{"username and password", "app", "pa55", true},
+		{"wrong password", "", "wrong", false},
+		{"password of another user", "", "pa55", false},
+		{"none", "", "", false},
+	} {
+		addr, accepted := authRedis(t, map[string]string{"default": "s3cr3t", "app": "pa55"})
+		p := New([]string{addr}, time.Second, time.Second, time.Second, 1, Murmur3, WithAuth(tc.username, tc.password))
+
+		for i := 0; i < 2; i++ {
+			err := p.WithIndex(0, func(conn redis.Conn) error {
+				_, err := conn.Do("PING")
+				return err
+			})
+			if tc.ok && err != nil {
+				t.Errorf("%s: %s", tc.name, err)
+			}
+			if !tc.ok && err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			}
+		}
+
+		// Connections which failed to authenticate are discarded, so every
+		// use dials anew; without credentials, PING itself fails, and the
+		// connection is kept.
+		expected := int32(1)
+		if !tc.ok && tc.password != "" {
+			expected = 2
+		}
+		if got := atomic.LoadInt32(accepted); expected != got {
+			t.Errorf("%s: expected %d connection(s), got %d", tc.name, expected, got)
+		}
+		p.Close()
+	}
+}
+
+// authRedis serves a Redis which requires AUTH with the password of one of
+// the users before any other command. AUTH with a password alone
+// authenticates as the default user, like in Redis 6 and later. It returns
+// the address, and the number of accepted connections.
+func authRedis(t *testing.T, passwords map[string]string) (string, *int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := new(int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go serveAuthRedis(conn, passwords)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String(), accepted
+}
+
+func serveAuthRedis(conn net.Conn, passwords map[string]string) {
+	defer conn.Close()
+	var (
+		r             = bufio.NewReader(conn)
+		authenticated = false
+	)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch {
+		case strings.ToUpper(args[0]) == "AUTH":
+			username, password := "default", args[len(args)-1]
+			if len(args) == 3 {
+				username = args[1]
+			}
+			if want, ok := passwords[username]; ok && want == password {
+				authenticated = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid username-password pair or user is disabled.\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case strings.ToUpper(args[0]) == "PING":
+			reply = "+PONG\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
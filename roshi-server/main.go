@@ -3,10 +3,17 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	_ "expvar"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net/http"
@@ -17,6 +24,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/pat"
@@ -26,33 +34,77 @@ import (
 	"github.com/soundcloud/roshi/common"
 	"github.com/soundcloud/roshi/farm"
 	"github.com/soundcloud/roshi/instrumentation"
+	"github.com/soundcloud/roshi/instrumentation/plaintext"
 	"github.com/soundcloud/roshi/instrumentation/prometheus"
 	"github.com/soundcloud/roshi/instrumentation/statsd"
+	"github.com/soundcloud/roshi/internal/cmdutil"
 	"github.com/soundcloud/roshi/pool"
 )
 
+// version is the build version, injected at build time via
+// -ldflags "-X main.version=...". See the Makefile.
+var version = "dev"
+
 func main() {
 	var (
-		redisInstances             = flag.String("redis.instances", "", "Semicolon-separated list of comma-separated lists of Redis instances")
-		redisConnectTimeout        = flag.Duration("redis.connect.timeout", 3*time.Second, "Redis connect timeout")
-		redisReadTimeout           = flag.Duration("redis.read.timeout", 3*time.Second, "Redis read timeout")
-		redisWriteTimeout          = flag.Duration("redis.write.timeout", 3*time.Second, "Redis write timeout")
-		redisMCPI                  = flag.Int("redis.mcpi", 10, "Max connections per Redis instance")
-		redisHash                  = flag.String("redis.hash", "murmur3", "Redis hash function: murmur3, fnv, fnva")
-		farmWriteQuorum            = flag.String("farm.write.quorum", "51%", "Write quorum, either number of clusters (2) or percentage of clusters (51%)")
-		farmReadStrategy           = flag.String("farm.read.strategy", "SendAllReadAll", "Farm read strategy: SendAllReadAll, SendOneReadOne, SendAllReadFirstLinger, SendVarReadFirstLinger")
-		farmReadThresholdRate      = flag.Int("farm.read.threshold.rate", 2000, "Baseline SendAll keys read per sec, additional keys are SendOne (SendVarReadFirstLinger strategy only)")
-		farmReadThresholdLatency   = flag.Duration("farm.read.threshold.latency", 50*time.Millisecond, "If a SendOne read has not returned anything after this latency, it's promoted to SendAll (SendVarReadFirstLinger strategy only)")
-		farmRepairStrategy         = flag.String("farm.repair.strategy", "RateLimitedRepairs", "Farm repair strategy: AllRepairs, NoRepairs, RateLimitedRepairs")
-		farmRepairMaxKeysPerSecond = flag.Int("farm.repair.max.keys.per.second", 1000, "Max repaired keys per second (RateLimited repairer only)")
-		maxSize                    = flag.Int("max.size", 10000, "Maximum number of events per key")
-		selectGap                  = flag.Duration("select.gap", 0*time.Millisecond, "delay between pipeline read invocations when Selecting over multiple keys")
-		statsdAddress              = flag.String("statsd.address", "", "Statsd address (blank to disable)")
-		statsdSampleRate           = flag.Float64("statsd.sample.rate", 0.1, "Statsd sample rate for normal metrics")
-		statsdBucketPrefix         = flag.String("statsd.bucket.prefix", "myservice.", "Statsd bucket key prefix, including trailing period")
-		prometheusNamespace        = flag.String("prometheus.namespace", "roshiserver", "Prometheus key namespace, excluding trailing punctuation")
-		prometheusMaxSummaryAge    = flag.Duration("prometheus.max.summary.age", 10*time.Second, "Prometheus max age for instantaneous histogram data")
-		httpAddress                = flag.String("http.address", ":6302", "HTTP listen address")
+		redisInstances              = flag.String("redis.instances", "", "Semicolon-separated list of comma-separated lists of Redis instances")
+		redisConnectTimeout         = flag.Duration("redis.connect.timeout", 3*time.Second, "Redis connect timeout")
+		redisReadTimeout            = flag.Duration("redis.read.timeout", 3*time.Second, "Redis read timeout")
+		redisWriteTimeout           = flag.Duration("redis.write.timeout", 3*time.Second, "Redis write timeout")
+		redisMCPI                   = flag.Int("redis.mcpi", 10, "Max connections per Redis instance")
+		redisHash                   = flag.String("redis.hash", "murmur3", "Redis hash function: "+strings.Join(pool.HashNames, ", "))
+		redisUsername               = flag.String("redis.username", "", "Redis ACL user to authenticate connections as, with AUTH username password (Redis 6 and later; blank for AUTH password)")
+		redisPasswordFile           = flag.String("redis.password.file", "", "Path to a file with the Redis password to authenticate connections with (blank for $"+cmdutil.RedisPasswordEnv+"; no password to not authenticate, unless redis.username is set)")
+		farmAllowDuplicateInstances = flag.Bool("farm.allow.duplicate.instances", false, "Allow the same Redis instance in multiple clusters, e.g. during a migration, and only log a warning")
+		farmWriteQuorum             = flag.String("farm.write.quorum", "51%", "Write quorum, either number of clusters (2) or percentage of clusters (51%)")
+		farmWriteQuorumPrefixes     = flag.String("farm.write.quorum.prefixes", "", "Comma-separated list of prefix=quorum pairs, which override farm.write.quorum for keys with the prefix, e.g. billing:=100%,feed:=1; the longest matching prefix wins")
+		farmReadOnly                = flag.Bool("farm.read.only", false, "Never write to Redis: disable read repairs, and reject inserts, deletes, copies, and repairs")
+		farmWriteFailFast           = flag.Bool("farm.write.fail.fast", false, "Fail writes immediately if fewer than write quorum clusters are reachable, according to health checks (requires -health.check.interval)")
+		farmReadStrategy            = flag.String("farm.read.strategy", "SendAllReadAll", "Farm read strategy: SendAllReadAll, SendOneReadOne, SendKReadAll, SendAllReadFirstLinger, SendVarReadFirstLinger")
+		farmReadK                   = flag.Int("farm.read.k", 2, "Clusters to read from, picked at random per read (SendKReadAll strategy only; 0 for all)")
+		farmReadThresholdRate       = flag.Int("farm.read.threshold.rate", 2000, "Baseline SendAll keys read per sec, additional keys are SendOne (SendVarReadFirstLinger strategy only)")
+		farmReadThresholdLatency    = flag.Duration("farm.read.threshold.latency", 50*time.Millisecond, "If a SendOne read has not returned anything after this latency, it's promoted to SendAll (SendVarReadFirstLinger strategy only)")
+		farmReadFirstResponseGrace  = flag.Duration("farm.read.first.response.grace", 0, "Max time to wait for other clusters after the first response, to return fresher results (SendAllReadFirstLinger and SendVarReadFirstLinger strategies only; 0 to disable)")
+		farmReadMaxLinger           = flag.Duration("farm.read.max.linger", 0, "Max time to linger for slow clusters after a read returned, to collect responses for repairs (SendAllReadFirstLinger and SendVarReadFirstLinger strategies only; 0 to disable)")
+		farmReadErrorTolerance      = flag.Float64("farm.read.error.tolerance", 1, "Max fraction of the clusters read which may fail for a key before a Select fails with 503, rather than returning what the others have (SendAllReadAll and SendKReadAll strategies only; 1 to disable)")
+		farmRepairStrategy          = flag.String("farm.repair.strategy", "RateLimitedRepairs", "Farm repair strategy: AllRepairs, NoRepairs, RateLimitedRepairs")
+		farmRepairMaxKeysPerSecond  = flag.Int("farm.repair.max.keys.per.second", 1000, "Max repaired keys per second (RateLimited repairer only)")
+		farmRepairMaxClusterWrites  = flag.Int("farm.repair.max.cluster.writes.per.second", 0, "Max key-members written per second to each cluster by repairs; more are dropped, not delayed, and repaired when read again (AllRepairs and RateLimitedRepairs; 0 to disable)")
+		farmTimestampUnit           = flag.Duration("farm.timestamp.unit", 0, "If nonzero, scores are Unix timestamps in this unit, e.g. 1ms, and repairs report how stale the clusters they write to were (0 if scores aren't timestamps)")
+		farmRepairMaxPerSelect      = flag.Int("farm.repair.max.per.select", 0, "Max key-members a single Select requests to repair; the rest are left to the walker (0 to disable)")
+		farmSelectMaxKeys           = flag.Int("farm.select.max.keys", farm.DefaultMaxSelectKeys, "Max keys per Select request; larger requests are rejected (0 to disable)")
+		farmSelectCacheSize         = flag.Int("farm.select.cache.size", 0, "Max Select results cached per key, offset and limit; cached results may not reflect writes through other servers (0 to disable)")
+		farmSelectCacheTTL          = flag.Duration("farm.select.cache.ttl", time.Second, "How long Select results are cached (see farm.select.cache.size)")
+		maxSize                     = flag.Int("max.size", 10000, "Maximum number of events per key")
+		uncappedKeyPrefixes         = flag.String("uncapped.key.prefixes", "", "Comma-separated list of key prefixes exempt from max.size; such keys grow without bounds (configure walkers identically)")
+		scoreMaxKeyMembers          = flag.Int("score.max.key.members", cluster.DefaultMaxScoreKeyMembers, "Max key-members per Score call to a cluster, e.g. during repairs; larger calls fail (0 to disable)")
+		selectRangeAttempts         = flag.Int("select.range.attempts", cluster.DefaultRangeAttempts, "Reads per key of a start/stop Select to skip members at the start score; keys which need more fail alone")
+		memberCompressionThreshold  = flag.Int("member.compression.threshold", 0, "Compress members of at least this many bytes in Redis (0 to disable; configure walkers identically)")
+		memberCompressionWrite      = flag.Bool("member.compression.write", true, "With member.compression.threshold, write members compressed; disable while rolling compression out or back, to write them uncompressed but replace compressed ones")
+		writeScriptPath             = flag.String("write.script", "", "Path to a Lua script which replaces the insert and delete script, see cluster.DefaultScript (advanced; configure walkers identically)")
+		maxMemberSize               = flag.Int("max.member.size", 0, "Reject inserts and deletes with members of more than this many bytes with 400 Bad Request, before they reach Redis (0 to disable)")
+		tombstoneGrace              = flag.Float64("tombstone.grace", 0, "If nonzero, every write trims the tombstones of its key with scores more than this many score units below its own; stale inserts may then resurrect deleted members (see cluster.WithTombstoneGrace; configure walkers identically)")
+		insertOnly                  = flag.Bool("insert.only", false, "Disable the delete set, for append-only workloads; DELETE requests fail (don't enable on a farm which has received deletes)")
+		insertChunkSize             = flag.Int("insert.chunk.size", 10000, "Insert requests are decoded and written in chunks of this many tuples, to bound memory (0 to write the whole request at once)")
+		selectBufferMaxBytes        = flag.Int64("select.buffer.max.bytes", 0, "Max estimated bytes of members buffered by all Selects in flight together; Selects beyond it wait, see select.buffer.wait (0 to disable)")
+		selectBufferMemberBytes     = flag.Int("select.buffer.member.bytes", 256, "Estimated bytes per member, including overhead, for select.buffer.max.bytes")
+		selectBufferWait            = flag.Duration("select.buffer.wait", 0, "How long a Select waits for select.buffer.max.bytes to allow it, before it fails with 503 (0 to fail right away)")
+		selectGap                   = flag.Duration("select.gap", 0*time.Millisecond, "delay between pipeline read invocations when Selecting over multiple keys")
+		statsdAddress               = flag.String("statsd.address", "", "Statsd address (blank to disable)")
+		statsdSampleRate            = flag.Float64("statsd.sample.rate", 0.1, "Statsd sample rate for normal metrics")
+		statsdBucketPrefix          = flag.String("statsd.bucket.prefix", "myservice.", "Statsd bucket key prefix, including trailing period")
+		statsdFlushInterval         = flag.Duration("statsd.flush.interval", 0, "If nonzero, buffer statsd metrics and send them every interval, packed into packets of up to statsd.packet.size bytes")
+		statsdPacketSize            = flag.Int("statsd.packet.size", 1432, "Max statsd packet size in bytes, when buffering (see statsd.flush.interval)")
+		prometheusNamespace         = flag.String("prometheus.namespace", "roshiserver", "Prometheus key namespace, excluding trailing punctuation")
+		prometheusMaxSummaryAge     = flag.Duration("prometheus.max.summary.age", 10*time.Second, "Prometheus max age for instantaneous histogram data")
+		validate                    = flag.Bool("validate", false, "Only validate the configuration: parse -redis.instances, ping every instance, print a report, and exit; 1 for an invalid configuration, 2 for unreachable instances")
+		validateUnreachable         = flag.String("validate.unreachable", "fail", "With -validate, whether unreachable instances fail validation (fail) or only print a warning (warn)")
+		warmup                      = flag.Bool("warmup", false, "At startup, fill the connection pool of every Redis instance, and load the write scripts, before serving")
+		healthCheckInterval         = flag.Duration("health.check.interval", 10*time.Second, "How often to ping every Redis instance, for the instance_up Prometheus metric (0 to disable)")
+		httpAddress                 = flag.String("http.address", ":6302", "HTTP listen address")
+		adminToken                  = flag.String("admin.token", "", "Token which /admin requests must carry, as Authorization: Bearer <token> (blank disables /admin)")
+		httpAccessLog               = flag.String("http.access.log", "", "Log a line per request to stdout, with its endpoint, size, duration and status, as logfmt or json (blank to disable)")
+		httpMaxResponse             = flag.Int("http.max.response", 0, "Max size in bytes of a Select response body; larger responses fail with HTTP 413 (0 for no limit)")
 	)
 	flag.Parse()
 	log.SetOutput(os.Stdout)
@@ -63,17 +115,34 @@ func main() {
 	statter := g2s.Noop()
 	if *statsdAddress != "" {
 		var err error
-		statter, err = g2s.Dial("udp", *statsdAddress)
+		if *statsdFlushInterval > 0 {
+			var bw *statsd.BufferedWriter
+			statter, bw, err = statsd.DialBuffered("udp", *statsdAddress, *statsdPacketSize, *statsdFlushInterval)
+			if err == nil {
+				defer bw.Close()
+				cmdutil.CloseOnSignal(bw)
+			}
+		} else {
+			statter, err = g2s.Dial("udp", *statsdAddress)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 	prometheusInstr := prometheus.New(*prometheusNamespace, *prometheusMaxSummaryAge)
 	prometheusInstr.Install("/metrics", http.DefaultServeMux)
-	instr := instrumentation.NewMultiInstrumentation(
-		statsd.New(statter, float32(*statsdSampleRate), *statsdBucketPrefix),
-		prometheusInstr,
-	)
+	buildInstr := func(s instrumentationSettings) instrumentation.Instrumentation {
+		instrs := []instrumentation.Instrumentation{
+			statsd.New(statter, float32(s.StatsdSampleRate), *statsdBucketPrefix),
+			prometheusInstr,
+		}
+		if s.Plaintext {
+			instrs = append(instrs, plaintext.New(logWriter{}))
+		}
+		return instrumentation.NewMultiInstrumentation(instrs...)
+	}
+	instrSettings := instrumentationSettings{StatsdSampleRate: *statsdSampleRate}
+	instr := instrumentation.NewSwappableInstrumentation(buildInstr(instrSettings))
 
 	// Parse read strategy.
 	var readStrategy farm.ReadStrategy
@@ -82,6 +151,8 @@ func main() {
 		readStrategy = farm.SendAllReadAll
 	case "sendonereadone":
 		readStrategy = farm.SendOneReadOne
+	case "sendkreadall":
+		readStrategy = farm.SendKReadAll(*farmReadK)
 	case "sendallreadfirstlinger":
 		readStrategy = farm.SendAllReadFirstLinger
 	case "sendvarreadfirstlinger":
@@ -94,36 +165,90 @@ func main() {
 	// Parse repair strategy. Note that because this is a client-facing
 	// production server, all repair strategies get a Nonblocking wrapper!
 	repairRequestBufferSize := 100
+	allRepairs := farm.ClusterRateLimitedRepairs(*farmRepairMaxClusterWrites, farm.RepairTimestampScores(*farmTimestampUnit)) // AllRepairs unless enabled
 	var repairStrategy farm.RepairStrategy
 	switch strings.ToLower(*farmRepairStrategy) {
 	case "allrepairs":
-		repairStrategy = farm.Nonblocking(repairRequestBufferSize, farm.AllRepairs)
+		repairStrategy = farm.Nonblocking(repairRequestBufferSize, allRepairs)
 	case "norepairs":
 		repairStrategy = farm.Nonblocking(repairRequestBufferSize, farm.NoRepairs)
 	case "ratelimitedrepairs":
-		repairStrategy = farm.Nonblocking(repairRequestBufferSize, farm.RateLimited(*farmRepairMaxKeysPerSecond, farm.AllRepairs))
+		repairStrategy = farm.Nonblocking(repairRequestBufferSize, farm.RateLimited(*farmRepairMaxKeysPerSecond, allRepairs))
 	default:
 		log.Fatalf("unknown repair strategy %q", *farmRepairStrategy)
 	}
 	log.Printf("using %s repair strategy", *farmRepairStrategy)
 
 	// Parse hash function.
-	var hashFunc func(string) uint32
-	switch strings.ToLower(*redisHash) {
-	case "murmur3":
-		hashFunc = pool.Murmur3
-	case "fnv":
-		hashFunc = pool.FNV
-	case "fnva":
-		hashFunc = pool.FNVa
-	default:
-		log.Fatalf("unknown hash %q", *redisHash)
+	hashFunc, err := pool.HashByName(*redisHash)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Build the farm.
-	farm, err := newFarm(
+	farmOptions := []farm.Option{
+		farm.WithMaxSelectKeys(*farmSelectMaxKeys),
+		farm.WithSelectCache(*farmSelectCacheSize, *farmSelectCacheTTL),
+		farm.WithMaxLinger(*farmReadMaxLinger),
+		farm.WithReadErrorTolerance(*farmReadErrorTolerance),
+		farm.WithFirstResponseGrace(*farmReadFirstResponseGrace),
+		farm.WithMaxRepairsPerSelect(*farmRepairMaxPerSelect),
+		farm.WithTimestampScores(*farmTimestampUnit),
+	}
+	if *farmWriteFailFast {
+		if *healthCheckInterval <= 0 {
+			log.Printf("warning: -farm.write.fail.fast has no effect without -health.check.interval")
+		}
+		farmOptions = append(farmOptions, farm.WithFailFast())
+	}
+	if *farmReadOnly {
+		farmOptions = append(farmOptions, farm.WithReadOnly())
+	}
+	clusterOptions := []cluster.Option{
+		cluster.WithMaxScoreKeyMembers(*scoreMaxKeyMembers),
+		cluster.WithRangeAttempts(*selectRangeAttempts),
+		cluster.WithMaxMemberSize(*maxMemberSize),
+	}
+	if *insertOnly {
+		clusterOptions = append(clusterOptions, cluster.WithInsertOnly())
+	}
+	if prefixes := cmdutil.SplitPrefixes(*uncappedKeyPrefixes); len(prefixes) > 0 {
+		clusterOptions = append(clusterOptions, cluster.WithUncappedPrefixes(prefixes...))
+	}
+	if option := cmdutil.MemberCompression(*memberCompressionThreshold, *memberCompressionWrite); option != nil {
+		clusterOptions = append(clusterOptions, option)
+	}
+	writeScript, err := cmdutil.ReadWriteScript(*writeScriptPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if writeScript != "" {
+		log.Printf("using the write script in %s", *writeScriptPath)
+		option, err := cluster.WithScript(writeScript)
+		if err != nil {
+			log.Fatalf("%s: %s", *writeScriptPath, err)
+		}
+		clusterOptions = append(clusterOptions, option)
+	}
+	if *tombstoneGrace > 0 {
+		clusterOptions = append(clusterOptions, cluster.WithTombstoneGrace(*tombstoneGrace))
+	}
+	redisPassword, err := cmdutil.ReadRedisPassword(*redisPasswordFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var poolOptions []pool.Option
+	if *redisUsername != "" || redisPassword != "" {
+		poolOptions = append(poolOptions, pool.WithAuth(*redisUsername, redisPassword))
+	}
+	instances, err := farm.ParseClusterInstances(*redisInstances)
+	if err != nil {
+		log.Fatal(err)
+	}
+	farm, clusters, err := newFarm(
 		*redisInstances,
 		*farmWriteQuorum,
+		*farmWriteQuorumPrefixes,
 		*redisConnectTimeout, *redisReadTimeout, *redisWriteTimeout,
 		*redisMCPI,
 		hashFunc,
@@ -131,30 +256,163 @@ func main() {
 		repairStrategy,
 		*maxSize,
 		*selectGap,
+		*farmAllowDuplicateInstances,
+		poolOptions,
+		clusterOptions,
 		instr,
+		farmOptions...,
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *validate {
+		if *validateUnreachable != "fail" && *validateUnreachable != "warn" {
+			log.Fatalf("validate.unreachable must be fail or warn, not %q", *validateUnreachable)
+		}
+		unreachable := validateClusters(os.Stdout, clusters)
+		switch {
+		case unreachable <= 0:
+			log.Printf("validate: configuration is valid, every instance is reachable")
+		case *validateUnreachable == "warn":
+			log.Printf("validate: warning: configuration is valid, but %d instance(s) are unreachable", unreachable)
+		default:
+			log.Printf("validate: %d instance(s) are unreachable", unreachable)
+			os.Exit(2)
+		}
+		return
+	}
+	if *warmup {
+		warmClusters(clusters)
+	}
+
+	// Check the health of every instance, and derive readiness from it.
+	var ready *readiness
+	if *healthCheckInterval > 0 {
+		writeQuorum, err := evaluateScalarPercentage(*farmWriteQuorum, len(clusters))
+		if err != nil {
+			log.Fatal(err)
+		}
+		ready = newReadiness(len(clusters), writeQuorum)
+		go cluster.CheckClusters(clusters, *healthCheckInterval, func(index int, results map[string]error) {
+			for id, err := range results {
+				prometheusInstr.InstanceUp(id, err)
+			}
+			ready.update(index, results)
+		})
+	} else {
+		log.Printf("warning: /readyz always reports ready without -health.check.interval")
+	}
 
 	// Build the HTTP server.
 	r := pat.New()
+	if *adminToken != "" {
+		// Ahead of /debug, which would match it as a prefix.
+		r.Get("/debug/keymember", withAdminToken(*adminToken, handleKeyMember(clusters, instances)))
+	}
 	r.Add("GET", "/metrics", http.DefaultServeMux)
 	r.Add("GET", "/debug", http.DefaultServeMux)
 	r.Add("POST", "/debug", http.DefaultServeMux)
-	r.Get("/", handleSelect(farm))
-	r.Post("/", handleInsert(farm))
-	r.Delete("/", handleDelete(farm))
-	h := http.Handler(r)
+	r.Get("/livez", handleLivez)
+	r.Get("/readyz", handleReadyz(ready))
+	settings := map[string]string{
+		"redis.hash":                   *redisHash,
+		"farm.write.quorum":            *farmWriteQuorum,
+		"farm.read.strategy":           *farmReadStrategy,
+		"farm.repair.strategy":         *farmRepairStrategy,
+		"max.size":                     strconv.Itoa(*maxSize),
+		"uncapped.key.prefixes":        *uncappedKeyPrefixes,
+		"member.compression.threshold": strconv.Itoa(*memberCompressionThreshold),
+	}
+	if writeScript != "" {
+		settings["write.script"] = writeScript // only if set, to keep existing hashes
+	}
+	if *tombstoneGrace > 0 {
+		settings["tombstone.grace"] = strconv.FormatFloat(*tombstoneGrace, 'g', -1, 64) // likewise
+	}
+	tuning := newTunables(clusters, *maxSize)
+	r.Get("/version", handleVersion(func() versionInfo {
+		// max.size may have been tuned since.
+		current := map[string]string{}
+		for key, value := range settings {
+			current[key] = value
+		}
+		current["max.size"] = strconv.Itoa(tuning.MaxSize())
+		return versionInfo{
+			Version:    version,
+			GoVersion:  runtime.Version(),
+			ConfigHash: configHash(*redisInstances, current),
+		}
+	}))
+	r.Get("/export", handleExport(farm))
+	if *adminToken != "" {
+		tuningHandler := withAdminToken(*adminToken, handleTuning(tuning))
+		r.Get("/admin/tuning", tuningHandler)
+		r.Post("/admin/tuning", tuningHandler)
+		r.Get("/admin/permits", withAdminToken(*adminToken, handlePermits(farm)))
+		r.Post("/admin/permits", withAdminToken(*adminToken, handlePermits(farm)))
+		instrHandler := withAdminToken(*adminToken, handleInstrumentation(instr, instrSettings, buildInstr)) // shares the settings
+		r.Get("/admin/instrumentation", instrHandler)
+		r.Post("/admin/instrumentation", instrHandler)
+		r.Post("/admin/copy", withAdminToken(*adminToken, handleCopy(farm)))
+		r.Get("/redis-info", withAdminToken(*adminToken, handleRedisInfo(clusters)))
+		r.Get("/admin/key-estimate", withAdminToken(*adminToken, handleKeyEstimate(clusters)))
+		r.Post("/repair", withAdminToken(*adminToken, handleRepair(farm, tuning)))
+	}
+	r.Post("/score", handleScore(farm))
+	var budget *selectBudget
+	if *selectBufferMaxBytes > 0 {
+		if *selectBufferMemberBytes <= 0 {
+			log.Fatalf("select.buffer.member.bytes must be positive")
+		}
+		budget = newSelectBudget(*selectBufferMaxBytes, int64(*selectBufferMemberBytes), *selectBufferWait)
+	}
+	r.Get("/", handleSelect(farm, tuning, *httpMaxResponse, budget))
+	r.Post("/", handleInsert(farm, *insertChunkSize))
+	if *insertOnly {
+		r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
+			respondError(w, r.Method, r.URL.String(), http.StatusMethodNotAllowed, cluster.ErrInsertOnly)
+		})
+	} else {
+		r.Delete("/", handleDelete(farm))
+	}
+	var h http.Handler = r
+	if *httpAccessLog != "" {
+		format, err := parseAccessLogFormat(*httpAccessLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h = withAccessLog(h, log.New(os.Stdout, "", 0), format)
+	}
+	h = withRequestID(h)
 
 	// Go for it.
 	log.Printf("listening on %s", *httpAddress)
 	log.Fatal(http.ListenAndServe(*httpAddress, h))
 }
 
+// parsePrefixQuorums parses the farm.write.quorum.prefixes flag, a
+// comma-separated list of prefix=quorum pairs, where quorums are numbers or
+// percentages of n clusters, like farm.write.quorum.
+func parsePrefixQuorums(s string, n int) (map[string]int, error) {
+	quorums := map[string]int{}
+	for _, pair := range cmdutil.SplitPrefixes(s) {
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("write quorum prefix %q has no =quorum", pair)
+		}
+		quorum, err := evaluateScalarPercentage(pair[i+1:], n)
+		if err != nil {
+			return nil, fmt.Errorf("write quorum of prefix %q: %s", pair[:i], err)
+		}
+		quorums[pair[:i]] = quorum
+	}
+	return quorums, nil
+}
+
 func newFarm(
 	redisInstances string,
 	writeQuorumStr string,
+	prefixQuorumsStr string,
 	connectTimeout, readTimeout, writeTimeout time.Duration,
 	redisMCPI int,
 	hash func(string) uint32,
@@ -162,8 +420,12 @@ func newFarm(
 	repairStrategy farm.RepairStrategy,
 	maxSize int,
 	selectGap time.Duration,
+	allowDuplicateInstances bool,
+	poolOptions []pool.Option,
+	clusterOptions []cluster.Option,
 	instr instrumentation.Instrumentation,
-) (*farm.Farm, error) {
+	options ...farm.Option,
+) (*farm.Farm, []cluster.Cluster, error) {
 	clusters, err := farm.ParseFarmString(
 		redisInstances,
 		connectTimeout,
@@ -173,10 +435,13 @@ func newFarm(
 		hash,
 		maxSize,
 		selectGap,
+		allowDuplicateInstances,
+		poolOptions,
 		instr,
+		clusterOptions...,
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	log.Printf("%d cluster(s)", len(clusters))
 
@@ -185,7 +450,28 @@ func newFarm(
 		len(clusters),
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	prefixQuorums, err := parsePrefixQuorums(prefixQuorumsStr, len(clusters))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(prefixQuorums) > 0 {
+		log.Printf("write quorums by key prefix: %v", prefixQuorums)
+		options = append(options, farm.WithPrefixQuorums(prefixQuorums))
+	}
+
+	weights, err := farm.ParseClusterWeights(redisInstances)
+	if err != nil {
+		return nil, nil, err
+	}
+	if weights != nil {
+		log.Printf("cluster weights: %v", weights)
+		options = append(options, farm.WithClusterWeights(weights...))
+	}
+	if err := farm.Validate(clusters, options...); err != nil {
+		return nil, nil, err
 	}
 
 	return farm.New(
@@ -194,10 +480,11 @@ func newFarm(
 		readStrategy,
 		repairStrategy,
 		instr,
-	), nil
+		options...,
+	), clusters, nil
 }
 
-func handleSelect(selecter farm.Selecter) http.HandlerFunc {
+func handleSelect(selecter farm.Selecter, tuning *tunables, maxResponse int, budget *selectBudget) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		began := time.Now()
 
@@ -206,29 +493,121 @@ func handleSelect(selecter farm.Selecter) http.HandlerFunc {
 			return
 		}
 
-		var keys [][]byte
+		var keys common.Keys
 		if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
 			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
 			return
 		}
-
-		keyStrings := make([]string, len(keys))
-		for i := range keys {
-			keyStrings[i] = string(keys[i])
-		}
+		keyStrings := []string(keys)
+		reportAccess(w, accessStats{keys: len(keyStrings)})
 
 		var (
-			offset, offsetGiven  = parseInt(r.Form, "offset", 0)
 			startStr, startGiven = parseStr(r.Form, "start", "")
 			stopStr, stopGiven   = parseStr(r.Form, "stop", "")
-			limit, _             = parseInt(r.Form, "limit", 10)
 			coalesce, _          = parseBool(r.Form, "coalesce", false)
+			orderStr, _          = parseStr(r.Form, "order", "desc")
+			sortStr, sortGiven   = parseStr(r.Form, "sort", "score_desc")
+			tiebreakStr, _       = parseStr(r.Form, "tiebreak", "member_desc")
+			tombstones, _        = parseBool(r.Form, "tombstones", false)
+			consistency, _       = parseStr(r.Form, "consistency", "default")
+			floorStr, floorGiven = parseStr(r.Form, "minScore", "")
 		)
 
+		offset, _, err := parseInt(r.Form, "offset", 0)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		limit, _, err := parseInt(r.Form, "limit", 10)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		// coalesce=grouped is an alias of coalesce=true&sort=key.
+		grouped := r.Form.Get("coalesce") == "grouped"
+		if grouped {
+			coalesce = true
+		}
+
+		limit, err = validateOffsetLimit(offset, limit, tuning.MaxSize())
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		format, err := parseScoreFormat(r.Form)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		selectOrder, err := parseSelectOrder(orderStr)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		minScore := math.Inf(-1)
+		if floorGiven {
+			if minScore, err = strconv.ParseFloat(floorStr, 64); err != nil || math.IsNaN(minScore) {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid minScore %q", floorStr))
+				return
+			}
+			if selectOrder == common.Ascending {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("minScore is only supported with order=desc"))
+				return
+			}
+		}
+
+		if selectOrder == common.Ascending && !sortGiven {
+			sortStr = "score_asc" // coalesce oldest-first selects oldest-first
+		}
+		if grouped {
+			if sortGiven && sortStr != "key" {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("coalesce=grouped is sort=key, and can't be combined with sort=%s", sortStr))
+				return
+			}
+			sortStr = "key"
+		}
+		order, err := parseCoalesceOrder(sortStr, tiebreakStr)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		selecter, err := consistentSelecter(selecter, consistency)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		// Coalescing reads offset+limit members of every key, otherwise
+		// limit members.
+		members := int64(len(keyStrings)) * int64(limit)
+		if coalesce {
+			members = int64(len(keyStrings)) * (int64(offset) + int64(limit))
+		}
+		reserved, ok := budget.acquire(members)
+		if !ok {
+			respondError(w, r.Method, r.URL.String(), http.StatusServiceUnavailable, errSelectBudget)
+			return
+		}
+		defer budget.release(reserved)
+
 		switch {
-		case !offsetGiven && (startGiven || stopGiven):
-			// SelectRange. `coalesce` has no impact on the request, only the
-			// handling of the response.
+		case startGiven || stopGiven:
+			// SelectRange. The offset counts the members after start. With
+			// `coalesce`, it applies to the coalesced members instead, like
+			// the offset of SelectOffset.
+			if selectOrder == common.Ascending {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("order=%s is only supported with offset/limit, not start/stop", orderStr))
+				return
+			}
+			if floorGiven {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("minScore is only supported with offset/limit, not start/stop"))
+				return
+			}
 
 			var (
 				start = common.Cursor{Score: math.MaxFloat64}
@@ -249,159 +628,1709 @@ func handleSelect(selecter farm.Selecter) http.HandlerFunc {
 				}
 			}
 
-			results, err := selecter.SelectRange(keyStrings, start, stop, limit)
+			var (
+				selectOffset = offset
+				selectLimit  = limit
+			)
+			if coalesce {
+				selectOffset = 0
+				selectLimit = offset + limit
+			}
+
+			results, complete, err := selectRangeOffsetComplete(selecter, keyStrings, start, stop, selectOffset, selectLimit)
 			if err != nil {
-				respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+				respondError(w, r.Method, r.URL.String(), selectErrorStatus(err), err)
 				return
 			}
 
 			//cursorResults := addCursor(results)
 
+			if !complete {
+				w.Header().Set(degradedHeader, "true")
+				logDegraded(r)
+			}
+
+			var status map[string]string
+			if tombstones {
+				if status, err = keyStatus(selecter, keyStrings, results); err != nil {
+					respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+					return
+				}
+			}
+
 			if coalesce {
-				respondSelected(w, flatten(results, 0, limit), time.Since(began))
+				respondSelected(w, r, maxResponse, format, flatten(results, keyStrings, offset, limit, order), status, time.Since(began))
 				return
 			}
 
-			respondSelected(w, results, time.Since(began))
+			respondSelected(w, r, maxResponse, format, results, status, time.Since(began))
 			return
 
-		case !startGiven && !stopGiven:
+		default:
 			// SelectOffset. The offset/limit may be altered by `coalesce`.
 			var (
 				selectOffset = offset
 				selectLimit  = limit
 			)
 
+			if m, ok := selecter.(farm.MergingSelecter); ok && coalesce && !tombstones && !floorGiven && order.merges(selectOrder) {
+				// The farm merges the keys itself, and reads only as many
+				// records of each key as the merge needs.
+				records, complete, err := m.SelectMergedComplete(keyStrings, offset, limit, selectOrder)
+				if err != nil {
+					respondError(w, r.Method, r.URL.String(), selectErrorStatus(err), err)
+					return
+				}
+				if !complete {
+					w.Header().Set(degradedHeader, "true")
+					logDegraded(r)
+				}
+				respondSelected(w, r, maxResponse, format, records, nil, time.Since(began))
+				return
+			}
+
 			if coalesce {
 				selectOffset = 0
 				selectLimit = offset + limit
 			}
 
-			results, err := selecter.SelectOffset(keyStrings, selectOffset, selectLimit)
+			var (
+				results  map[string][]common.KeyScoreMember
+				complete bool
+			)
+			if floorGiven {
+				f, ok := selecter.(farm.FloorSelecter)
+				if !ok {
+					respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("minScore isn't supported"))
+					return
+				}
+				results, complete, err = f.SelectOffsetFloorComplete(keyStrings, selectOffset, selectLimit, minScore)
+			} else {
+				results, complete, err = selectOffsetComplete(selecter, keyStrings, selectOffset, selectLimit, selectOrder)
+			}
 			if err != nil {
-				respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+				respondError(w, r.Method, r.URL.String(), selectErrorStatus(err), err)
 				return
 			}
 
 			//cursorResults := addCursor(results)
 
+			if !complete {
+				w.Header().Set(degradedHeader, "true")
+				logDegraded(r)
+			}
+
+			var status map[string]string
+			if tombstones {
+				if status, err = keyStatus(selecter, keyStrings, results); err != nil {
+					respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+					return
+				}
+			}
+
 			if coalesce {
-				respondSelected(w, flatten(results, offset, limit), time.Since(began))
+				respondSelected(w, r, maxResponse, format, flatten(results, keyStrings, offset, limit, order), status, time.Since(began))
 				return
 			}
 
-			respondSelected(w, results, time.Since(began))
-			return
-
-		case offsetGiven && (startGiven || stopGiven):
-			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("cannot specify both offset and start/stop"))
+			respondSelected(w, r, maxResponse, format, results, status, time.Since(began))
 			return
 
-		default:
-			panic("unreachable")
 		}
 	}
 }
 
-func handleInsert(inserter cluster.Inserter) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		began := time.Now()
-
-		var tuples []common.KeyScoreMember
-		if err := json.NewDecoder(r.Body).Decode(&tuples); err != nil {
-			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
-			return
-		}
+// selectErrorStatus maps the errors of Selects to HTTP status codes.
+func selectErrorStatus(err error) int {
+	switch err.(type) {
+	case farm.TooManyKeysError:
+		return http.StatusBadRequest
+	case farm.TooDegradedError:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
 
-		if err := inserter.Insert(tuples); err != nil {
-			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
-			return
-		}
+// maxInt is the largest value of type int.
+const maxInt = int(^uint(0) >> 1)
 
-		respondInserted(w, len(tuples), time.Since(began))
+// validateOffsetLimit checks the offset and limit of a Select request. It
+// returns the limit, capped to maxLimit, or an error if the request is
+// invalid. The offset+limit may be used as a single limit when coalescing,
+// so it must not overflow.
+func validateOffsetLimit(offset, limit, maxLimit int) (int, error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d is invalid", offset)
 	}
+	if limit < 0 {
+		return 0, fmt.Errorf("negative limit %d is invalid", limit)
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	if offset > maxInt-limit {
+		return 0, fmt.Errorf("offset %d plus limit %d overflows", offset, limit)
+	}
+	return limit, nil
 }
 
-func handleDelete(deleter cluster.Deleter) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		began := time.Now()
-
-		var tuples []common.KeyScoreMember
-		if err := json.NewDecoder(r.Body).Decode(&tuples); err != nil {
-			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
-			return
+// keyStatus tells, for every key which selected no members, whether it's
+// "exhausted", i.e. has members, but none in the requested page, e.g. as the
+// offset is past its end, "deleted", i.e. has seen deletes, or "unknown". It
+// requires the selecter to implement cluster.Tombstoner.
+func keyStatus(selecter farm.Selecter, keys []string, results map[string][]common.KeyScoreMember) (map[string]string, error) {
+	empty := []string{}
+	for _, key := range keys {
+		if len(results[key]) <= 0 {
+			empty = append(empty, key)
 		}
+	}
 
-		if err := deleter.Delete(tuples); err != nil {
-			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
-			return
+	status := make(map[string]string, len(empty))
+	if len(empty) <= 0 {
+		return status, nil
+	}
+	t, ok := selecter.(cluster.Tombstoner)
+	if !ok {
+		return nil, fmt.Errorf("tombstone lookups not supported")
+	}
+
+	// A key with any member is merely paged past, regardless of its deletes.
+	first, err := selecter.SelectOffset(empty, 0, 1, common.Descending)
+	if err != nil {
+		return nil, err
+	}
+	unpaged := []string{}
+	for _, key := range empty {
+		if len(first[key]) > 0 {
+			status[key] = "exhausted"
+		} else {
+			unpaged = append(unpaged, key)
 		}
+	}
+	if len(unpaged) <= 0 {
+		return status, nil
+	}
 
-		respondDeleted(w, len(tuples), time.Since(began))
+	tombstoned, err := t.Tombstoned(unpaged)
+	if err != nil {
+		return nil, err
 	}
+	for _, key := range unpaged {
+		status[key] = "unknown"
+		if tombstoned[key] {
+			status[key] = "deleted"
+		}
+	}
+	return status, nil
 }
 
-func addCursor(in map[string][]common.KeyScoreMember) map[string][]keyScoreMemberCursor {
-	var (
-		out = make(map[string][]keyScoreMemberCursor, len(in))
-	)
+// requestIDHeader carries the ID of a request, which is included in the log
+// lines of the request. Clients may provide it; otherwise, it's generated.
+// It's always returned in the response.
+const requestIDHeader = "X-Request-ID"
 
-	// We do a little nonstandard dance with cursor encoding, here, as a
-	// result of memory profiling.
+// maxRequestIDLength bounds client-provided request IDs, which are logged.
+const maxRequestIDLength = 128
 
-	for key, keyScoreMembers := range in {
-		keyScoreMemberCursors := make([]keyScoreMemberCursor, len(keyScoreMembers))
+type requestIDKey struct{}
 
-		for i, keyScoreMember := range keyScoreMembers {
-			keyScoreMemberCursors[i].KeyScoreMember = keyScoreMember
-			keyScoreMember.Cursor().Encode(keyScoreMemberCursors[i].Cursor)
+// withRequestID assigns every request an ID, from the X-Request-ID header
+// if it's valid, or a new random one. The ID is set on the response header
+// and stored in the request context.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
 		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
 
-		out[key] = keyScoreMemberCursors
-	}
+// requestID returns the ID assigned to the request by withRequestID, or the
+// empty string.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
 
-	return out
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' { // printable ASCII, no spaces
+			return false
+		}
+	}
+	return true
 }
 
-func flatten(m map[string][]common.KeyScoreMember, offset, limit int) []common.KeyScoreMember {
-	a := []common.KeyScoreMember{}
-	for _, slice := range m {
-		a = append(a, slice...)
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
+	return hex.EncodeToString(b)
+}
 
-	sort.Sort(keyScoreMembers(a))
+// accessStats are the details of a request which handlers report for its
+// access log line, see withAccessLog.
+type accessStats struct {
+	keys     int           // of a Select, export, or score
+	tuples   int           // of an insert or delete
+	records  int           // of a Select, export, or score response
+	duration time.Duration // as measured by the handler, if nonzero
+}
 
-	if len(a) < offset {
-		return []common.KeyScoreMember{}
-	}
+// accessRecorder records the status and size of a response, and the stats
+// reported by its handler.
+type accessRecorder struct {
+	http.ResponseWriter
+	accessStats
+	status int
+	bytes  int
+}
 
-	a = a[offset:]
+func (a *accessRecorder) WriteHeader(code int) {
+	if a.status == 0 {
+		a.status = code
+	}
+	a.ResponseWriter.WriteHeader(code)
+}
 
-	if len(a) > limit {
-		a = a[:limit]
+func (a *accessRecorder) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
 	}
+	n, err := a.ResponseWriter.Write(p)
+	a.bytes += n
+	return n, err
+}
 
-	return a
+// Flush satisfies http.Flusher, so that handlers can still stream.
+func (a *accessRecorder) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
 }
 
-func parseInt(values url.Values, key string, defaultValue int) (int, bool) {
-	valueStr := values.Get(key)
-	if valueStr == "" {
-		return defaultValue, false
+// reportAccess adds the nonzero stats to the access log line of the request
+// which w responds to, if access logging is enabled.
+func reportAccess(w http.ResponseWriter, stats accessStats) {
+	a, ok := w.(*accessRecorder)
+	if !ok {
+		return
 	}
-	value, err := strconv.ParseInt(valueStr, 10, 64)
-	if err != nil {
-		return defaultValue, true
+	if stats.keys != 0 {
+		a.keys = stats.keys
+	}
+	if stats.tuples != 0 {
+		a.tuples = stats.tuples
+	}
+	if stats.records != 0 {
+		a.records = stats.records
+	}
+	if stats.duration != 0 {
+		a.duration = stats.duration
 	}
-	return int(value), true
 }
 
-func parseBool(values url.Values, key string, defaultValue bool) (bool, bool) {
-	valueStr := values.Get(key)
-	if valueStr == "" {
-		return defaultValue, false
+// countRecords returns the number of records in a Select response.
+func countRecords(records interface{}) int {
+	switch records := records.(type) {
+	case []common.KeyScoreMember:
+		return len(records)
+	case map[string][]common.KeyScoreMember:
+		n := 0
+		for _, a := range records {
+			n += len(a)
+		}
+		return n
+	default:
+		return 0
 	}
-	value, err := strconv.ParseBool(valueStr)
+}
+
+// accessEntry is an access log line.
+type accessEntry struct {
+	Time      string `json:"time"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Keys      int    `json:"keys"`
+	Tuples    int    `json:"tuples"`
+	Records   int    `json:"records"`
+	Bytes     int    `json:"bytes"`
+	Duration  string `json:"duration"`
+	RequestID string `json:"request_id"`
+}
+
+// parseAccessLogFormat returns the function which formats access log lines
+// for the -http.access.log flag.
+func parseAccessLogFormat(s string) (func(accessEntry) string, error) {
+	switch s {
+	case "logfmt":
+		return formatLogfmt, nil
+	case "json":
+		return formatJSON, nil
+	default:
+		return nil, fmt.Errorf("unknown access log format %q (want logfmt or json)", s)
+	}
+}
+
+func formatLogfmt(e accessEntry) string {
+	return fmt.Sprintf(
+		"time=%s method=%s path=%s status=%d keys=%d tuples=%d records=%d bytes=%d duration=%s request_id=%s",
+		e.Time, e.Method, logfmtValue(e.Path), e.Status, e.Keys, e.Tuples, e.Records, e.Bytes, e.Duration, logfmtValue(e.RequestID),
+	)
+}
+
+// logfmtValue quotes s if it's empty, or contains anything but printable
+// ASCII other than spaces, quotes and equal signs.
+func logfmtValue(s string) string {
+	for _, c := range s {
+		if c <= ' ' || c > '~' || c == '"' || c == '=' {
+			return strconv.Quote(s)
+		}
+	}
+	if s == "" {
+		return `""`
+	}
+	return s
+}
+
+func formatJSON(e accessEntry) string {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		panic(err) // only strings and ints
+	}
+	return string(buf)
+}
+
+// withAccessLog logs a line per request to logger, formatted by format,
+// with the method, path, status, response bytes, duration, and request ID,
+// and the keys, tuples and records reported by the handler, see
+// reportAccess. The duration is the one measured by the handler, if it
+// reported one, and otherwise the time it took to serve the request.
+func withAccessLog(next http.Handler, logger *log.Logger, format func(accessEntry) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+		a := &accessRecorder{ResponseWriter: w}
+		next.ServeHTTP(a, r)
+		if a.status == 0 {
+			a.status = http.StatusOK // nothing written
+		}
+		if a.duration == 0 {
+			a.duration = time.Since(began)
+		}
+		logger.Print(format(accessEntry{
+			Time:      began.UTC().Format(time.RFC3339Nano),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    a.status,
+			Keys:      a.keys,
+			Tuples:    a.tuples,
+			Records:   a.records,
+			Bytes:     a.bytes,
+			Duration:  a.duration.String(),
+			RequestID: requestID(r),
+		}))
+	})
+}
+
+// logDegraded logs a degraded Select response, so that it can be correlated
+// with the partial errors logged by the farm at the same time.
+func logDegraded(r *http.Request) {
+	log.Printf("%s %s [%s]: degraded response", r.Method, r.URL.String(), requestID(r))
+}
+
+// degradedHeader is set on Select responses which were built from an
+// incomplete set of cluster responses, and may therefore be stale or partial.
+const degradedHeader = "X-Roshi-Degraded"
+
+// consistentSelecter returns the selecter for the consistency parameter of
+// a Select: the selecter itself by default, or a view which reads from every
+// cluster for "strong", so that reads see preceding quorum writes.
+func consistentSelecter(selecter farm.Selecter, consistency string) (farm.Selecter, error) {
+	switch consistency {
+	case "default":
+		return selecter, nil
+	case "strong":
+		o, ok := selecter.(farm.ReadStrategyOverrider)
+		if !ok {
+			return nil, fmt.Errorf("consistency=strong isn't supported")
+		}
+		return o.ReadingWith(farm.SendAllReadAll), nil
+	default:
+		return nil, fmt.Errorf("invalid consistency %q (default, strong)", consistency)
+	}
+}
+
+// selectOffsetComplete invokes SelectOffset, reporting completeness if the selecter
+// supports it.
+func selectOffsetComplete(selecter farm.Selecter, keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	if s, ok := selecter.(farm.CompletenessSelecter); ok {
+		return s.SelectOffsetComplete(keys, offset, limit, order)
+	}
+	results, err := selecter.SelectOffset(keys, offset, limit, order)
+	return results, true, err
+}
+
+// selectRangeComplete invokes SelectRange, reporting completeness if the selecter
+// supports it.
+func selectRangeComplete(selecter farm.Selecter, keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	if s, ok := selecter.(farm.CompletenessSelecter); ok {
+		return s.SelectRangeComplete(keys, start, stop, limit)
+	}
+	results, err := selecter.SelectRange(keys, start, stop, limit)
+	return results, true, err
+}
+
+// selectRangeOffsetComplete invokes SelectRangeOffset if there's an offset
+// to skip, and selectRangeComplete otherwise. Selecters which aren't a
+// farm.RangeOffsetSelecter are asked for offset+limit members, and the
+// first offset of them are dropped.
+func selectRangeOffsetComplete(selecter farm.Selecter, keys []string, start, stop common.Cursor, offset, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	if offset <= 0 {
+		return selectRangeComplete(selecter, keys, start, stop, limit)
+	}
+	if s, ok := selecter.(farm.RangeOffsetSelecter); ok {
+		return s.SelectRangeOffsetComplete(keys, start, stop, offset, limit)
+	}
+	results, complete, err := selectRangeComplete(selecter, keys, start, stop, offset+limit)
+	for key, a := range results {
+		if offset < len(a) {
+			results[key] = a[offset:]
+		} else {
+			results[key] = []common.KeyScoreMember{}
+		}
+	}
+	return results, complete, err
+}
+
+// withAdminToken only passes requests on to next which carry the admin
+// token, as "Authorization: Bearer <token>", and rejects the others.
+func withAdminToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	const scheme = "Bearer "
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, scheme) || subtle.ConstantTimeCompare([]byte(header[len(scheme):]), []byte(token)) != 1 {
+			respondError(w, r.Method, r.URL.String(), http.StatusUnauthorized, fmt.Errorf("invalid admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// tunables holds the clusters which can be tuned, see cluster.Tuner, and
+// serializes changes to their tuning, so that concurrent changes of
+// different parameters don't overwrite each other.
+type tunables struct {
+	mtx     sync.Mutex // serializes Tune
+	tuners  []cluster.Tuner
+	maxSize int // if there are no tuners
+}
+
+// newTunables returns the tunables of the clusters. maxSize is the max.size
+// of clusters which can't be tuned.
+func newTunables(clusters []cluster.Cluster, maxSize int) *tunables {
+	t := &tunables{maxSize: maxSize}
+	for _, c := range clusters {
+		if tuner, ok := c.(cluster.Tuner); ok {
+			t.tuners = append(t.tuners, tuner)
+		}
+	}
+	return t
+}
+
+// MaxSize returns the max.size currently in effect.
+func (t *tunables) MaxSize() int {
+	if len(t.tuners) <= 0 {
+		return t.maxSize
+	}
+	return t.tuners[0].Tuning().MaxSize
+}
+
+// Tune passes the current tuning to change, and tunes every cluster to the
+// result, unless change returns an error.
+func (t *tunables) Tune(change func(*cluster.Tuning) error) (cluster.Tuning, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	tuning := t.tuners[0].Tuning()
+	if err := change(&tuning); err != nil {
+		return tuning, err
+	}
+	for _, tuner := range t.tuners {
+		tuner.Tune(tuning)
+	}
+	return tuning, nil
+}
+
+// handleTuning responds with the max.size and select.gap of the clusters,
+// see cluster.Tuner. A POST first changes them, to the max.size and
+// select.gap parameters; parameters which aren't given are left alone. The
+// changes last until roshi-server restarts.
+func handleTuning(t *tunables) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(t.tuners) <= 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusNotImplemented, fmt.Errorf("clusters can't be tuned"))
+			return
+		}
+		tuning := t.tuners[0].Tuning()
+
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+				return
+			}
+			var err error
+			tuning, err = t.Tune(func(tuning *cluster.Tuning) error {
+				if s, given := parseStr(r.Form, "max.size", ""); given {
+					n, err := strconv.Atoi(s)
+					if err != nil || n <= 0 {
+						return fmt.Errorf("max.size must be a positive integer, got %q", s)
+					}
+					tuning.MaxSize = n
+				}
+				if s, given := parseStr(r.Form, "select.gap", ""); given {
+					d, err := time.ParseDuration(s)
+					if err != nil || d < 0 {
+						return fmt.Errorf("select.gap must be a non-negative duration, got %q", s)
+					}
+					tuning.SelectGap = d
+				}
+				return nil
+			})
+			if err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+				return
+			}
+			log.Printf("%s %s [%s]: tuned %d cluster(s) to max.size %d, select.gap %s", r.Method, r.URL.String(), requestID(r), len(t.tuners), tuning.MaxSize, tuning.SelectGap)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"max_size":   tuning.MaxSize,
+			"select_gap": tuning.SelectGap.String(),
+		})
+	}
+}
+
+// handlePermits responds with the permits of the SendVarReadFirstLinger read
+// strategy, see farm.SendAllTuner. A POST first changes its max keys per
+// second to the farm.read.threshold.rate parameter, and refills the permits.
+// The change lasts until roshi-server restarts.
+func handlePermits(tuner farm.SendAllTuner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+				return
+			}
+			s, given := parseStr(r.Form, "farm.read.threshold.rate", "")
+			n, err := strconv.Atoi(s)
+			if !given || err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("farm.read.threshold.rate must be an integer, got %q", s))
+				return
+			}
+			if err := tuner.SetMaxKeysPerSecond(n); err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusNotImplemented, err)
+				return
+			}
+			log.Printf("%s %s [%s]: tuned farm.read.threshold.rate to %d", r.Method, r.URL.String(), requestID(r), n)
+		}
+
+		permits, err := tuner.SendAllPermits()
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusNotImplemented, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"threshold_rate": permits.MaxKeysPerSecond,
+			"available":      permits.Available,
+		})
+	}
+}
+
+// warmClusters warms the instances of every cluster which implements
+// cluster.Warmer, and logs how long it took, and which instances failed.
+// Instances which failed to warm are still used; they're cold, not down.
+func warmClusters(clusters []cluster.Cluster) {
+	began := time.Now()
+	var instances, failed int
+	for i, c := range clusters {
+		w, ok := c.(cluster.Warmer)
+		if !ok {
+			continue
+		}
+		for id, err := range w.Warm() {
+			instances++
+			if err != nil {
+				failed++
+				log.Printf("warmup: cluster %d: %s: %s", i+1, id, err)
+			}
+		}
+	}
+	log.Printf("warmup: %d instance(s) warmed, %d failed, in %s", instances-failed, failed, time.Since(began))
+}
+
+// validateClusters pings the instances of every cluster which implements
+// cluster.Pinger, and writes a line per instance to w, with its cluster and
+// whether it's reachable. It returns the number of unreachable instances.
+func validateClusters(w io.Writer, clusters []cluster.Cluster) int {
+	unreachable := 0
+	for i, c := range clusters {
+		p, ok := c.(cluster.Pinger)
+		if !ok {
+			fmt.Fprintf(w, "cluster %d: can't be pinged\n", i+1)
+			continue
+		}
+		results := p.Ping()
+		ids := make([]string, 0, len(results))
+		for id := range results {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err := results[id]; err != nil {
+				unreachable++
+				fmt.Fprintf(w, "cluster %d: %s: unreachable: %s\n", i+1, id, err)
+				continue
+			}
+			fmt.Fprintf(w, "cluster %d: %s: ok\n", i+1, id)
+		}
+	}
+	return unreachable
+}
+
+// instrumentationSettings are the parameters of the instrumentation of the
+// server which can be changed at runtime, see handleInstrumentation.
+type instrumentationSettings struct {
+	StatsdSampleRate float64 `json:"statsd_sample_rate"`
+	Plaintext        bool    `json:"plaintext"` // every metric is logged
+}
+
+// handleInstrumentation reports the instrumentation settings, and changes
+// them on POST, given by the statsd.sample.rate and plaintext parameters,
+// by swapping the instrumentation for one built from the new settings.
+func handleInstrumentation(
+	target *instrumentation.SwappableInstrumentation,
+	settings instrumentationSettings,
+	build func(instrumentationSettings) instrumentation.Instrumentation,
+) http.HandlerFunc {
+	var mtx sync.Mutex // guards settings
+	return func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+				return
+			}
+			next := settings
+			if s, given := parseStr(r.Form, "statsd.sample.rate", ""); given {
+				rate, err := strconv.ParseFloat(s, 64)
+				if err != nil || rate < 0 || rate > 1 {
+					respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("statsd.sample.rate must be between 0 and 1, got %q", s))
+					return
+				}
+				next.StatsdSampleRate = rate
+			}
+			if s, given := parseStr(r.Form, "plaintext", ""); given {
+				plaintext, err := strconv.ParseBool(s)
+				if err != nil {
+					respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("plaintext must be a boolean, got %q", s))
+					return
+				}
+				next.Plaintext = plaintext
+			}
+			target.Swap(build(next))
+			settings = next
+			log.Printf("%s %s [%s]: instrumentation set to statsd sample rate %v, plaintext %v", r.Method, r.URL.String(), requestID(r), settings.StatsdSampleRate, settings.Plaintext)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+	}
+}
+
+// logWriter logs every write on its own line, e.g. for plaintext
+// instrumentation, which writes a metric at a time.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	log.Printf("instrumentation: %s", p)
+	return len(p), nil
+}
+
+// handleCopy copies the key given by the src parameter to the key given by
+// the dst parameter, see cluster.KeyCopier. Keys are raw strings, escaped
+// as query parameters like with /export.
+func handleCopy(copier cluster.KeyCopier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		src, srcGiven := parseStr(r.Form, "src", "")
+		dst, dstGiven := parseStr(r.Form, "dst", "")
+		if !srcGiven || !dstGiven {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("src and dst are required"))
+			return
+		}
+		if err := copier.CopyKey(src, dst); err != nil {
+			respondError(w, r.Method, r.URL.String(), writeErrorStatus(err), err)
+			return
+		}
+		duration := time.Since(began)
+		reportAccess(w, accessStats{keys: 2, duration: duration})
+		log.Printf("%s %s [%s]: copied %q to %q", r.Method, r.URL.String(), requestID(r), src, dst)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"copied":   true,
+			"duration": duration.String(),
+		})
+	}
+}
+
+// handleRedisInfo responds with the memory use and key counts of every
+// Redis instance, per cluster, see cluster.InfoReader. Instances which
+// couldn't be asked have an error instead, and clusters which can't report
+// them are null.
+func handleRedisInfo(clusters []cluster.Cluster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		// Clusters are asked concurrently, so that one which waits for
+		// unreachable instances doesn't hold up the others.
+		type result struct {
+			index     int
+			instances map[string]interface{}
+		}
+		results := make(chan result, len(clusters))
+		asked := 0
+		for i, c := range clusters {
+			reader, ok := c.(cluster.InfoReader)
+			if !ok {
+				continue
+			}
+			asked++
+			go func(i int, reader cluster.InfoReader) {
+				instances := map[string]interface{}{}
+				for id, info := range reader.Info() {
+					if info.Error != nil {
+						instances[id] = map[string]interface{}{"error": info.Error.Error()}
+						continue
+					}
+					instances[id] = map[string]interface{}{
+						"used_memory": info.UsedMemory,
+						"maxmemory":   info.MaxMemory,
+						"keys":        info.Keys,
+						"expires":     info.Expires,
+					}
+				}
+				results <- result{i, instances}
+			}(i, reader)
+		}
+		response := make([]map[string]interface{}, len(clusters))
+		for j := 0; j < asked; j++ {
+			res := <-results
+			response[res.index] = res.instances
+		}
+		if asked <= 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusNotImplemented, fmt.Errorf("clusters can't report Redis info"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"clusters": response,
+			"duration": time.Since(began).String(),
+		})
+	}
+}
+
+const (
+	defaultKeyEstimateSample = 1000
+	maxKeyEstimateSample     = 100000
+)
+
+// handleKeyEstimate responds with the estimated number of keys of every
+// Redis instance and cluster, see cluster.KeyEstimator, sampling up to the
+// sample parameter keys per instance. Clusters are replicas of each other,
+// so the farm-wide estimate is the one of the cluster with the most keys.
+// Instances which couldn't be asked have an error instead, and don't count,
+// and clusters which can't estimate are null.
+func handleKeyEstimate(clusters []cluster.Cluster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		sample := defaultKeyEstimateSample
+		if s := r.URL.Query().Get("sample"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 || n > maxKeyEstimateSample {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("sample must be an integer between 1 and %d, got %q", maxKeyEstimateSample, s))
+				return
+			}
+			sample = n
+		}
+
+		// Clusters are asked concurrently, like for /redis-info.
+		type result struct {
+			index     int
+			keys      int64
+			instances map[string]interface{}
+		}
+		results := make(chan result, len(clusters))
+		asked := 0
+		for i, c := range clusters {
+			estimator, ok := c.(cluster.KeyEstimator)
+			if !ok {
+				continue
+			}
+			asked++
+			go func(i int, estimator cluster.KeyEstimator) {
+				res := result{index: i, instances: map[string]interface{}{}}
+				for id, estimate := range estimator.EstimateKeys(sample) {
+					if estimate.Error != nil {
+						res.instances[id] = map[string]interface{}{"error": estimate.Error.Error()}
+						continue
+					}
+					res.keys += estimate.Keys
+					res.instances[id] = map[string]interface{}{
+						"keys":    estimate.Keys,
+						"sampled": estimate.Sampled,
+						"dbsize":  estimate.DBSize,
+						"exact":   estimate.Exact,
+					}
+				}
+				results <- res
+			}(i, estimator)
+		}
+		if asked <= 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusNotImplemented, fmt.Errorf("clusters can't estimate their keys"))
+			return
+		}
+		var keys int64
+		response := make([]map[string]interface{}, len(clusters))
+		for j := 0; j < asked; j++ {
+			res := <-results
+			response[res.index] = map[string]interface{}{
+				"keys":      res.keys,
+				"instances": res.instances,
+			}
+			if res.keys > keys {
+				keys = res.keys
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"clusters": response,
+			"keys":     keys,
+			"duration": time.Since(began).String(),
+		})
+	}
+}
+
+// handleRepair repairs the keys in the body, a JSON array like for Selects,
+// and waits for the repairs, see farm.KeyRepairer. Up to the current max.size
+// members of every key are compared. It responds with the number of inconsistent
+// key-members, and the number of key-members repaired and failed to be
+// repaired per cluster.
+func handleRepair(repairer farm.KeyRepairer, tuning *tunables) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+		var keys common.Keys
+		if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		reportAccess(w, accessStats{keys: len(keys)})
+
+		report, err := repairer.RepairKeys(keys, tuning.MaxSize())
+		if _, ok := err.(farm.TooManyKeysError); ok {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		if err == farm.ErrReadOnly {
+			respondError(w, r.Method, r.URL.String(), http.StatusMethodNotAllowed, err)
+			return
+		}
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("%s %s [%s]: %d key(s), %d inconsistent key-member(s), repaired %v, failed %v", r.Method, r.URL.String(), requestID(r), len(keys), report.Inconsistent, report.Written, report.Failed)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"inconsistent": report.Inconsistent,
+			"repaired":     report.Written,
+			"failed":       report.Failed,
+			"complete":     report.Complete,
+			"duration":     time.Since(began).String(),
+		})
+	}
+}
+
+// versionInfo is returned by the /version endpoint, so operators can verify
+// that all instances of a fleet run the same binary and configuration.
+type versionInfo struct {
+	Version    string `json:"version"`
+	GoVersion  string `json:"go_version"`
+	ConfigHash string `json:"config_hash"`
+}
+
+func handleVersion(info func() versionInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info())
+	}
+}
+
+// handleLivez reports that the process is alive, i.e. that it serves HTTP. It
+// doesn't depend on Redis, so orchestrators don't restart roshi-server during
+// a Redis outage, which restarting wouldn't fix.
+func handleLivez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"live": true})
+}
+
+// handleReadyz reports whether roshi-server is ready to serve traffic: 200 if
+// at least write quorum clusters passed their last health check, 503 if not.
+// With a nil readiness, i.e. without health checks, it always reports ready.
+func handleReadyz(ready *readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]interface{}{"ready": true}
+		code := http.StatusOK
+		if ready != nil {
+			healthy, quorum, ok := ready.state()
+			response["ready"] = ok
+			response["healthy_clusters"] = healthy
+			response["write_quorum"] = quorum
+			if !ok {
+				code = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// readiness tracks which clusters are healthy, i.e. whose instances all
+// passed their last health check. Clusters which haven't been checked yet
+// aren't healthy, so roshi-server isn't ready until the first health check
+// has reached enough clusters. It's safe for concurrent use.
+type readiness struct {
+	mtx     sync.Mutex
+	healthy []bool
+	quorum  int
+}
+
+func newReadiness(clusters, quorum int) *readiness {
+	return &readiness{healthy: make([]bool, clusters), quorum: quorum}
+}
+
+// update records the results of a health check of the cluster at index. It
+// has the signature cluster.CheckClusters reports to.
+func (r *readiness) update(index int, results map[string]error) {
+	healthy := true
+	for _, err := range results {
+		if err != nil {
+			healthy = false
+			break
+		}
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.healthy[index] = healthy
+}
+
+// state returns the number of healthy clusters, the write quorum, and
+// whether the former reaches the latter.
+func (r *readiness) state() (healthy, quorum int, ok bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, h := range r.healthy {
+		if h {
+			healthy++
+		}
+	}
+	return healthy, r.quorum, healthy >= r.quorum
+}
+
+// errSelectBudget is returned by Selects which didn't fit in the budget of
+// buffered members in time.
+var errSelectBudget = errors.New("too many members buffered by Selects in flight, try again later")
+
+// selectBudget bounds the estimated bytes of members buffered by all Selects
+// in flight, see the select.buffer flags. A nil *selectBudget allows every
+// Select. It's safe for concurrent use.
+type selectBudget struct {
+	mtx         sync.Mutex
+	max         int64
+	used        int64
+	memberBytes int64
+	wait        time.Duration
+	released    chan struct{} // closed and replaced by every release
+}
+
+func newSelectBudget(max, memberBytes int64, wait time.Duration) *selectBudget {
+	return &selectBudget{
+		max:         max,
+		memberBytes: memberBytes,
+		wait:        wait,
+		released:    make(chan struct{}),
+	}
+}
+
+// acquire reserves the bytes of the given number of members, waiting up to
+// the configured wait for other Selects to release theirs. A Select which
+// exceeds the whole budget reserves all of it, so that it can still run
+// alone. It returns the reserved bytes, which must be released, and false if
+// they couldn't be reserved in time.
+func (b *selectBudget) acquire(members int64) (int64, bool) {
+	if b == nil {
+		return 0, true
+	}
+	n := b.max
+	if members < b.max/b.memberBytes {
+		n = members * b.memberBytes
+	}
+
+	var timeout <-chan time.Time
+	for {
+		b.mtx.Lock()
+		if b.used+n <= b.max {
+			b.used += n
+			b.mtx.Unlock()
+			return n, true
+		}
+		released := b.released
+		b.mtx.Unlock()
+
+		if b.wait <= 0 {
+			return 0, false
+		}
+		if timeout == nil {
+			t := time.NewTimer(b.wait)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-released:
+		case <-timeout:
+			return 0, false
+		}
+	}
+}
+
+// release returns reserved bytes to the budget, and wakes up the waiting
+// Selects.
+func (b *selectBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// configHash returns a stable hash of the farm string and the settings that
+// determine how keys are placed and read. Whitespace in the farm string is
+// ignored, and settings are hashed in key order.
+func configHash(farmString string, settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", strings.Join(strings.Fields(farmString), ""))
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%s\n", key, settings[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handleInsert decodes the JSON array in the request body one element at a
+// time, and Inserts every chunkSize tuples as they're read, so that bulk
+// imports don't have to be held in memory at once. A chunkSize of 0 or less
+// Inserts the whole request at once.
+//
+// Chunks that have been Inserted stay Inserted if a later element is
+// malformed or a later Insert fails. In that case, the error response
+// reports how many tuples were Inserted, so the client can resume.
+func handleInsert(inserter cluster.Inserter, chunkSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		// Don't use r.Form, which may consume the body.
+		report, _ := parseBool(r.URL.Query(), "report", false)
+		reporter, ok := inserter.(cluster.InsertReporter)
+		if report && !ok {
+			respondInsertError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("insert reporting not supported"), 0)
+			return
+		}
+		format, err := parseScoreFormat(r.URL.Query())
+		if err != nil {
+			respondInsertError(w, r.Method, r.URL.String(), http.StatusBadRequest, err, 0)
+			return
+		}
+
+		var (
+			inserted int
+			scores   []*float64 // if reporting
+			tuples   []common.KeyScoreMember
+			flush    = func() error {
+				if len(tuples) <= 0 {
+					return nil
+				}
+				if !report {
+					if err := insertContext(r.Context(), inserter, tuples); err != nil {
+						return err
+					}
+				} else {
+					presence, err := reporter.InsertReporting(tuples)
+					if err != nil {
+						return err
+					}
+					scores = appendScores(scores, tuples, presence)
+				}
+				inserted += len(tuples)
+				// The farm returns at write quorum, while slower clusters
+				// may still read the chunk, so don't reuse it.
+				tuples = make([]common.KeyScoreMember, 0, len(tuples))
+				return nil
+			}
+		)
+		if report {
+			scores = []*float64{}
+		}
+
+		if err := decodeTuples(r.Body, format, func(tuple common.KeyScoreMember) error {
+			tuples = append(tuples, tuple)
+			if chunkSize > 0 && len(tuples) >= chunkSize {
+				return flush()
+			}
+			return nil
+		}); err != nil {
+			code := http.StatusBadRequest
+			if e, ok := err.(insertError); ok {
+				code = writeErrorStatus(e.error)
+			}
+			respondInsertError(w, r.Method, r.URL.String(), code, err, inserted)
+			return
+		}
+
+		if err := flush(); err != nil {
+			respondInsertError(w, r.Method, r.URL.String(), writeErrorStatus(err), err, inserted)
+			return
+		}
+
+		respondInserted(w, r, format, inserted, scores, time.Since(began))
+	}
+}
+
+// insertContext inserts the tuples, and gives up once ctx is done, e.g. when
+// the client disconnected, if the inserter supports it.
+func insertContext(ctx context.Context, inserter cluster.Inserter, tuples []common.KeyScoreMember) error {
+	if ci, ok := inserter.(cluster.ContextInserter); ok {
+		return ci.InsertContext(ctx, tuples)
+	}
+	return inserter.Insert(tuples)
+}
+
+// appendScores appends the effective score of each tuple to scores: the
+// score at which its member is stored after the insert, which is higher
+// than the tuple's if a newer insert was already stored. It's nil if the
+// member isn't stored, because of a newer delete or the max size.
+func appendScores(scores []*float64, tuples []common.KeyScoreMember, presence map[common.KeyMember]cluster.Presence) []*float64 {
+	for _, tuple := range tuples {
+		p := presence[common.KeyMember{Key: tuple.Key, Member: tuple.Member}]
+		if !p.Present || !p.Inserted {
+			scores = append(scores, nil)
+			continue
+		}
+		score := p.Score
+		scores = append(scores, &score)
+	}
+	return scores
+}
+
+// decodeTuples stream-decodes a JSON array of key-score-member tuples, with
+// scores in the format, from r, and calls f with each one in order. Errors
+// returned by f are wrapped in an insertError, to distinguish them from
+// decode errors.
+func decodeTuples(r io.Reader, format scoreFormat, f func(common.KeyScoreMember) error) error {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil // null, as accepted by json.Unmarshal
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected JSON array, got %v", tok)
+	}
+
+	for i := 0; dec.More(); i++ {
+		tuple, err := format.decode(dec)
+		if err != nil {
+			return fmt.Errorf("element %d: %s", i, err)
+		}
+		if err := common.CheckScore(tuple.Score); err != nil {
+			return fmt.Errorf("element %d: %s", i, err)
+		}
+		if err := f(tuple); err != nil {
+			return insertError{err}
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	return nil
+}
+
+type insertError struct{ error }
+
+// writeErrorStatus returns the HTTP status for a failed write: 400 Bad
+// Request for invalid scores and oversized members, 507 Insufficient
+// Storage if Redis ran out of memory, so that clients and operators can tell
+// it apart from unreachable instances, 503 Service Unavailable if the
+// request context ended before write quorum, 405 Method Not Allowed if the
+// farm is read-only, and 500 otherwise.
+func writeErrorStatus(err error) int {
+	if _, ok := err.(common.ScoreError); ok {
+		return http.StatusBadRequest
+	}
+	if _, ok := err.(cluster.MemberSizeError); ok {
+		return http.StatusBadRequest
+	}
+	if err == farm.ErrReadOnly {
+		return http.StatusMethodNotAllowed
+	}
+	if e, ok := err.(farm.QuorumError); ok && e.OutOfMemory() {
+		return http.StatusInsufficientStorage
+	}
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func handleDelete(deleter cluster.Deleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		format, err := parseScoreFormat(r.URL.Query())
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		tuples := []common.KeyScoreMember{}
+		if err := decodeTuples(r.Body, format, func(tuple common.KeyScoreMember) error {
+			tuples = append(tuples, tuple)
+			return nil
+		}); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		reportAccess(w, accessStats{tuples: len(tuples)})
+
+		if err := deleter.Delete(tuples); err != nil {
+			respondError(w, r.Method, r.URL.String(), writeErrorStatus(err), err)
+			return
+		}
+
+		respondDeleted(w, len(tuples), time.Since(began))
+	}
+}
+
+// scoreRecord is the presence of a key-member in a /score response. Like
+// the keys and members of records, they're byte sequences.
+type scoreRecord struct {
+	Key      []byte  `json:"key"`
+	Member   []byte  `json:"member"`
+	Present  bool    `json:"present"`
+	Inserted bool    `json:"inserted"`
+	Score    float64 `json:"score"`
+}
+
+// handleScore returns the authoritative presence of every key-member in the
+// request body, in the order of the request.
+func handleScore(scorer cluster.Scorer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		var keyMembers []common.KeyMember
+		if err := json.NewDecoder(r.Body).Decode(&keyMembers); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		presence, err := scorer.Score(keyMembers)
+		if _, ok := err.(farm.TooManyKeysError); ok {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+
+		records := make([]scoreRecord, len(keyMembers))
+		for i, keyMember := range keyMembers {
+			p := presence[keyMember]
+			records[i] = scoreRecord{
+				Key:      []byte(keyMember.Key),
+				Member:   []byte(keyMember.Member),
+				Present:  p.Present,
+				Inserted: p.Inserted,
+				Score:    p.Score,
+			}
+		}
+		duration := time.Since(began)
+		reportAccess(w, accessStats{keys: len(keyMembers), records: len(records), duration: duration})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"records":  records,
+			"duration": duration.String(),
+		})
+	}
+}
+
+// clusterPresence is the presence of a key-member in a single cluster, see
+// handleKeyMember. The cluster is identified by its index and instances in
+// -redis.instances.
+type clusterPresence struct {
+	Cluster   int      `json:"cluster"`
+	Instances []string `json:"instances"`
+	Present   bool     `json:"present"`
+	Inserted  bool     `json:"inserted"`
+	Score     float64  `json:"score"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// samePresence tells whether both clusters report the same presence.
+func (p clusterPresence) samePresence(other clusterPresence) bool {
+	return p.Present == other.Present && p.Inserted == other.Inserted && p.Score == other.Score
+}
+
+// handleKeyMember responds with the presence of the key-member in the query
+// in every cluster, in the order of -redis.instances, rather than the merged
+// presence of /score, to find the clusters which diverge. instances are the
+// instances of every cluster, see farm.ParseClusterInstances. Clusters which
+// couldn't be asked have an error instead. It's divergent if the clusters
+// which could be asked disagree.
+func handleKeyMember(clusters []cluster.Cluster, instances [][]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		key, keyGiven := parseStr(r.Form, "key", "")
+		member, memberGiven := parseStr(r.Form, "member", "")
+		if !keyGiven || !memberGiven {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("key and member are required"))
+			return
+		}
+		keyMember := common.KeyMember{Key: key, Member: member}
+
+		// Clusters are asked concurrently, like for /redis-info.
+		var (
+			response = make([]clusterPresence, len(clusters))
+			wg       sync.WaitGroup
+		)
+		for i, c := range clusters {
+			wg.Add(1)
+			go func(i int, c cluster.Cluster) {
+				defer wg.Done()
+				response[i] = clusterPresence{Cluster: i, Instances: []string{}}
+				if i < len(instances) {
+					response[i].Instances = instances[i]
+				}
+				presence, err := c.Score([]common.KeyMember{keyMember})
+				if err != nil {
+					response[i].Error = err.Error()
+					return
+				}
+				p := presence[keyMember]
+				response[i].Present, response[i].Inserted, response[i].Score = p.Present, p.Inserted, p.Score
+			}(i, c)
+		}
+		wg.Wait()
+
+		divergent := false
+		var first *clusterPresence
+		for i := range response {
+			if response[i].Error != "" {
+				continue
+			}
+			if first == nil {
+				first = &response[i]
+			} else if !first.samePresence(response[i]) {
+				divergent = true
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"key":       key,
+			"member":    member,
+			"clusters":  response,
+			"divergent": divergent,
+			"duration":  time.Since(began).String(),
+		})
+	}
+}
+
+// defaultExportWindow is the number of members handleExport reads from
+// Redis at a time, unless the request sets a window.
+const defaultExportWindow = 1000
+
+// handleExport streams every member of the key in the query as NDJSON, i.e.
+// one JSON object per line, by descending score. Every window of members is
+// flushed as soon as it's read, so neither roshi-server nor the client need
+// to hold the whole key. Errors before the first window fail the request;
+// later errors end the stream with an object with an "error" field.
+func handleExport(selecter cluster.AllSelecter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		key, keyGiven := parseStr(r.Form, "key", "")
+		if !keyGiven {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("key is required"))
+			return
+		}
+		window, _, err := parseInt(r.Form, "window", defaultExportWindow)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		if window <= 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("window must be positive"))
+			return
+		}
+
+		var (
+			enc        = json.NewEncoder(w)
+			flusher, _ = w.(http.Flusher)
+			started    = false
+			records    = 0
+		)
+		defer func() { reportAccess(w, accessStats{keys: 1, records: records}) }()
+		for e := range selecter.SelectAll(r.Context(), key, window) {
+			if e.Error != nil {
+				if !started {
+					respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, e.Error)
+					return
+				}
+				log.Printf("%s %s [%s]: export aborted: %s", r.Method, r.URL.String(), w.Header().Get(requestIDHeader), e.Error)
+				enc.Encode(map[string]string{"error": e.Error.Error()})
+				return
+			}
+			if !started {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				started = true
+			}
+			for _, keyScoreMember := range e.KeyScoreMembers {
+				if err := enc.Encode(keyScoreMember); err != nil {
+					return // the client is gone
+				}
+				records++
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+	}
+}
+
+func addCursor(in map[string][]common.KeyScoreMember) map[string][]keyScoreMemberCursor {
+	var (
+		out = make(map[string][]keyScoreMemberCursor, len(in))
+	)
+
+	// We do a little nonstandard dance with cursor encoding, here, as a
+	// result of memory profiling.
+
+	for key, keyScoreMembers := range in {
+		keyScoreMemberCursors := make([]keyScoreMemberCursor, len(keyScoreMembers))
+
+		for i, keyScoreMember := range keyScoreMembers {
+			keyScoreMemberCursors[i].KeyScoreMember = keyScoreMember
+			keyScoreMember.Cursor().Encode(keyScoreMemberCursors[i].Cursor)
+		}
+
+		out[key] = keyScoreMemberCursors
+	}
+
+	return out
+}
+
+// flatten merges the records of multiple keys into a single slice, ordered
+// according to order, and applies the offset and limit to that slice.
+func flatten(m map[string][]common.KeyScoreMember, keys []string, offset, limit int, order coalesceOrder) []common.KeyScoreMember {
+	a := []common.KeyScoreMember{}
+	if order.byKey {
+		seen := map[string]bool{}
+		for _, key := range keys {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			slice := append([]common.KeyScoreMember{}, m[key]...)
+			sort.Sort(orderedKeyScoreMembers{slice, order})
+			a = append(a, slice...)
+		}
+	} else {
+		for _, slice := range m {
+			a = append(a, slice...)
+		}
+		sort.Sort(orderedKeyScoreMembers{a, order})
+	}
+
+	if len(a) < offset {
+		return []common.KeyScoreMember{}
+	}
+
+	a = a[offset:]
+
+	if len(a) > limit {
+		a = a[:limit]
+	}
+
+	return a
+}
+
+// coalesceOrder describes the order of coalesced records. The zero value
+// sorts by descending score, and equal scores by descending member (z to a),
+// which is the default.
+type coalesceOrder struct {
+	byKey     bool // keep records grouped by key, in the order of the request
+	scoreAsc  bool
+	memberAsc bool
+}
+
+// parseSelectOrder parses the order query parameter, which may be desc
+// (default), for the newest members of each key first, or asc, for the
+// oldest first.
+func parseSelectOrder(s string) (common.Order, error) {
+	switch s {
+	case "desc":
+		return common.Descending, nil
+	case "asc":
+		return common.Ascending, nil
+	default:
+		return common.Descending, fmt.Errorf("invalid order %q (desc, asc)", s)
+	}
+}
+
+// parseCoalesceOrder parses the sort and tiebreak query parameters.
+//
+// sort may be score_desc (default), score_asc, or key. key preserves the
+// per-key grouping, in the order the keys were requested, and sorts the
+// records of each key by descending score.
+//
+// tiebreak may be member_desc (default) or member_asc, and orders records
+// with equal scores.
+func parseCoalesceOrder(sortStr, tiebreakStr string) (coalesceOrder, error) {
+	var order coalesceOrder
+	switch sortStr {
+	case "score_desc":
+	case "score_asc":
+		order.scoreAsc = true
+	case "key":
+		order.byKey = true
+	default:
+		return coalesceOrder{}, fmt.Errorf("invalid sort %q (score_desc, score_asc, key)", sortStr)
+	}
+	switch tiebreakStr {
+	case "member_desc":
+	case "member_asc":
+		order.memberAsc = true
+	default:
+		return coalesceOrder{}, fmt.Errorf("invalid tiebreak %q (member_desc, member_asc)", tiebreakStr)
+	}
+	return order, nil
+}
+
+// merges returns true if the order is the one of farm.MergingSelecter for
+// the select order: score and member both descending, or both ascending.
+func (o coalesceOrder) merges(order common.Order) bool {
+	if o.byKey {
+		return false
+	}
+	if order == common.Ascending {
+		return o.scoreAsc && o.memberAsc
+	}
+	return !o.scoreAsc && !o.memberAsc
+}
+
+func (o coalesceOrder) less(a, b common.KeyScoreMember) bool {
+	if a.Score != b.Score {
+		if o.scoreAsc {
+			return a.Score < b.Score
+		}
+		return a.Score > b.Score
+	}
+	if o.memberAsc {
+		return bytes.Compare([]byte(a.Member), []byte(b.Member)) < 0
+	}
+	return bytes.Compare([]byte(a.Member), []byte(b.Member)) > 0
+}
+
+type orderedKeyScoreMembers struct {
+	a     []common.KeyScoreMember
+	order coalesceOrder
+}
+
+func (o orderedKeyScoreMembers) Len() int           { return len(o.a) }
+func (o orderedKeyScoreMembers) Swap(i, j int)      { o.a[i], o.a[j] = o.a[j], o.a[i] }
+func (o orderedKeyScoreMembers) Less(i, j int) bool { return o.order.less(o.a[i], o.a[j]) }
+
+// parseInt parses key as an int. Values which aren't numbers, or don't fit
+// in an int, are an error rather than the default.
+func parseInt(values url.Values, key string, defaultValue int) (int, bool, error) {
+	valueStr := values.Get(key)
+	if valueStr == "" {
+		return defaultValue, false, nil
+	}
+	value, err := strconv.ParseInt(valueStr, 10, strconv.IntSize)
+	if err != nil {
+		return defaultValue, true, fmt.Errorf("invalid %s %q", key, valueStr)
+	}
+	return int(value), true, nil
+}
+
+func parseBool(values url.Values, key string, defaultValue bool) (bool, bool) {
+	valueStr := values.Get(key)
+	if valueStr == "" {
+		return defaultValue, false
+	}
+	value, err := strconv.ParseBool(valueStr)
 	if err != nil {
 		return defaultValue, true
 	}
@@ -416,23 +2345,232 @@ func parseStr(values url.Values, key, defaultValue string) (string, bool) {
 	return value, true
 }
 
-func respondInserted(w http.ResponseWriter, n int, duration time.Duration) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+// scoreFormat is the format of the scores in the JSON bodies of selects,
+// inserts and deletes, see parseScoreFormat. The zero value is the stored
+// float.
+type scoreFormat struct {
+	rfc3339 bool
+	unit    time.Duration // of a score, since the Unix epoch
+}
+
+// scoreUnits are the valid values of the scoreUnit parameter.
+var scoreUnits = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+// parseScoreFormat parses the scoreFormat parameter, float by default, or
+// rfc3339 for RFC3339 timestamps in UTC, and the scoreUnit parameter, which
+// is the unit of a score since the Unix epoch for rfc3339, s by default.
+func parseScoreFormat(values url.Values) (scoreFormat, error) {
+	var (
+		formatStr, _       = parseStr(values, "scoreFormat", "float")
+		unitStr, unitGiven = parseStr(values, "scoreUnit", "s")
+	)
+	switch formatStr {
+	case "float":
+		if unitGiven {
+			return scoreFormat{}, fmt.Errorf("scoreUnit is only supported with scoreFormat=rfc3339")
+		}
+		return scoreFormat{}, nil
+	case "rfc3339":
+		unit, ok := scoreUnits[unitStr]
+		if !ok {
+			return scoreFormat{}, fmt.Errorf("invalid scoreUnit %q (must be s, ms, us, or ns)", unitStr)
+		}
+		return scoreFormat{rfc3339: true, unit: unit}, nil
+	default:
+		return scoreFormat{}, fmt.Errorf("invalid scoreFormat %q (must be float or rfc3339)", formatStr)
+	}
+}
+
+// timestampRecord is a record with its score as an RFC3339 timestamp.
+type timestampRecord struct {
+	Key    []byte `json:"key"`
+	Score  string `json:"score"`
+	Member []byte `json:"member"`
+}
+
+// format returns the score as an RFC3339 timestamp, to the nanosecond. The
+// whole units are converted exactly, so that e.g. milliseconds don't pick up
+// rounding errors.
+func (f scoreFormat) format(score float64) (string, error) {
+	var (
+		unit  = int64(f.unit)
+		whole = math.Floor(score)
+		// Whole units must be strictly within max, so that whole units plus
+		// a fraction of a unit fit into int64 nanoseconds. max is exact for
+		// every unit: for ns, math.MaxInt64 rounds up to 2^63, which is then
+		// still the exclusive bound.
+		max = float64(math.MaxInt64 / unit)
+	)
+	if math.IsNaN(whole) || whole <= -max || whole >= max {
+		return "", fmt.Errorf("score %v can't be formatted as an RFC3339 timestamp", score)
+	}
+	ns := int64(whole)*unit + int64(math.Round((score-whole)*float64(unit)))
+	return time.Unix(0, ns).UTC().Format(time.RFC3339Nano), nil
+}
+
+// parse returns the score of an RFC3339 timestamp.
+func (f scoreFormat) parse(s string) (float64, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	if year := t.UTC().Year(); year < 1678 || year > 2261 {
+		return 0, fmt.Errorf("timestamp %q out of range", s) // of UnixNano
+	}
+	var (
+		ns   = t.UnixNano()
+		unit = int64(f.unit)
+	)
+	return float64(ns/unit) + float64(ns%unit)/float64(unit), nil
+}
+
+// records returns the records of a Select response, as returned by the
+// farm, or flattened, with their scores in the format.
+func (f scoreFormat) records(records interface{}) (interface{}, error) {
+	if !f.rfc3339 {
+		return records, nil
+	}
+	formatSlice := func(a []common.KeyScoreMember) ([]timestampRecord, error) {
+		formatted := make([]timestampRecord, len(a))
+		for i, ksm := range a {
+			score, err := f.format(ksm.Score)
+			if err != nil {
+				return nil, err
+			}
+			formatted[i] = timestampRecord{Key: []byte(ksm.Key), Score: score, Member: []byte(ksm.Member)}
+		}
+		return formatted, nil
+	}
+	switch records := records.(type) {
+	case []common.KeyScoreMember:
+		return formatSlice(records)
+	case map[string][]common.KeyScoreMember:
+		formatted := make(map[string][]timestampRecord, len(records))
+		for key, a := range records {
+			var err error
+			if formatted[key], err = formatSlice(a); err != nil {
+				return nil, err
+			}
+		}
+		return formatted, nil
+	default:
+		return records, nil
+	}
+}
+
+// scores returns the effective scores of an insert report in the format.
+func (f scoreFormat) scores(scores []*float64) (interface{}, error) {
+	if !f.rfc3339 {
+		return scores, nil
+	}
+	formatted := make([]*string, len(scores))
+	for i, score := range scores {
+		if score == nil {
+			continue
+		}
+		s, err := f.format(*score)
+		if err != nil {
+			return nil, err
+		}
+		formatted[i] = &s
+	}
+	return formatted, nil
+}
+
+// decode decodes the next record of dec, with its score in the format.
+func (f scoreFormat) decode(dec *json.Decoder) (common.KeyScoreMember, error) {
+	if !f.rfc3339 {
+		var tuple common.KeyScoreMember
+		err := dec.Decode(&tuple)
+		return tuple, err
+	}
+	var record timestampRecord
+	if err := dec.Decode(&record); err != nil {
+		return common.KeyScoreMember{}, err
+	}
+	score, err := f.parse(record.Score)
+	if err != nil {
+		return common.KeyScoreMember{}, err
+	}
+	return common.KeyScoreMember{Key: string(record.Key), Score: score, Member: string(record.Member)}, nil
+}
+
+// respondInserted responds with the number of inserted tuples, and their
+// effective scores in the format, if scores isn't nil. The tuples are
+// inserted already, so scores which can't be formatted, e.g. which another
+// writer stored, fail with 500 rather than blaming the request.
+func respondInserted(w http.ResponseWriter, r *http.Request, format scoreFormat, n int, scores []*float64, duration time.Duration) {
+	reportAccess(w, accessStats{tuples: n, duration: duration})
+	response := map[string]interface{}{
 		"inserted": n,
 		"duration": duration.String(),
-	})
+	}
+	if scores != nil {
+		formatted, err := format.scores(scores)
+		if err != nil {
+			respondInsertError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err, n)
+			return
+		}
+		response["scores"] = formatted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-func respondSelected(w http.ResponseWriter, records interface{}, duration time.Duration) {
+func respondInsertError(w http.ResponseWriter, method, url string, code int, err error, inserted int) {
+	reportAccess(w, accessStats{tuples: inserted})
+	log.Printf("%s %s [%s]: HTTP %d: %s (%d inserted)", method, url, w.Header().Get(requestIDHeader), code, err, inserted)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       err.Error(),
+		"code":        code,
+		"description": http.StatusText(code),
+		"inserted":    inserted,
+	})
+}
+
+// respondSelected responds with the records, with their scores in the
+// format, unless maxResponse is positive, and the encoded response is
+// larger, which fails with 413.
+func respondSelected(w http.ResponseWriter, r *http.Request, maxResponse int, format scoreFormat, records interface{}, status map[string]string, duration time.Duration) {
+	reportAccess(w, accessStats{records: countRecords(records), duration: duration})
+	records, err := format.records(records)
+	if err != nil {
+		respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+		return
+	}
+	response := map[string]interface{}{
 		"records":  records,
 		"duration": duration.String(),
-	})
+	}
+	if status != nil {
+		response["key_status"] = status
+	}
+	if maxResponse <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// The size is only known once the response is encoded, so buffer it.
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(response)
+	if buf.Len() > maxResponse {
+		respondError(w, r.Method, r.URL.String(), http.StatusRequestEntityTooLarge, fmt.Errorf("response of %d bytes exceeds the max of %d; select fewer keys or records, and page through them with offset or start", buf.Len(), maxResponse))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
 }
 
 func respondDeleted(w http.ResponseWriter, n int, duration time.Duration) {
+	reportAccess(w, accessStats{tuples: n, duration: duration})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"deleted":  n,
@@ -441,7 +2579,7 @@ func respondDeleted(w http.ResponseWriter, n int, duration time.Duration) {
 }
 
 func respondError(w http.ResponseWriter, method, url string, code int, err error) {
-	log.Printf("%s %s: HTTP %d: %s", method, url, code, err)
+	log.Printf("%s %s [%s]: HTTP %d: %s", method, url, w.Header().Get(requestIDHeader), code, err)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]interface{}{
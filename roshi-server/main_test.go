@@ -2,20 +2,54 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/gorilla/pat"
+	"github.com/soundcloud/roshi/client"
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/memcluster"
 	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+	"github.com/soundcloud/roshi/instrumentation"
+	"github.com/soundcloud/roshi/pool"
 )
 
+func TestParsePrefixQuorums(t *testing.T) {
+	quorums, err := parsePrefixQuorums(" billing:=100%, feed:=1,a=b=2 ", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string]int{"billing:": 3, "feed:": 1, "a=b": 2}, quorums; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if quorums, err := parsePrefixQuorums("", 3); err != nil || len(quorums) != 0 {
+		t.Errorf("empty: expected no quorums, got %v, %v", quorums, err)
+	}
+	for _, s := range []string{"billing:", "billing:=4", "billing:=0%", "billing:=x"} {
+		if _, err := parsePrefixQuorums(s, 3); err == nil {
+			t.Errorf("%q: expected error, got none", s)
+		}
+	}
+}
+
 func TestEvaluateScalarPercentage(t *testing.T) {
 	for _, tuple := range []struct {
 		s        string
@@ -62,58 +96,1993 @@ func TestEvaluateScalarPercentage(t *testing.T) {
 			t.Errorf("%q of %d: expected valid=%v, got error '%v' (value %d)", tuple.s, tuple.n, tuple.valid, err, got)
 			continue
 		}
-		if tuple.valid && tuple.expected != got {
-			t.Errorf("%q of %d: expected %d, got %d", tuple.s, tuple.n, tuple.expected, got)
-			continue
+		if tuple.valid && tuple.expected != got {
+			t.Errorf("%q of %d: expected %d, got %d", tuple.s, tuple.n, tuple.expected, got)
+			continue
+		}
+	}
+}
+
+func TestValidateOffsetLimit(t *testing.T) {
+	for _, tuple := range []struct {
+		offset, limit, maxLimit int
+		expected                int
+		valid                   bool
+	}{
+		{0, 0, 100, 0, true},
+		{0, 10, 100, 10, true},
+		{0, 100, 100, 100, true},
+		{0, 101, 100, 100, true},            // capped
+		{0, maxInt, 100, 100, true},         // capped
+		{maxInt - 100, 100, 100, 100, true}, // right at the edge
+		{maxInt - 99, 100, 100, 0, false},   // overflow
+		{maxInt, 1, 100, 0, false},          // overflow
+		{maxInt, 0, 100, 0, true},
+		{-1, 10, 100, 0, false},
+		{0, -1, 100, 0, false},
+		{-maxInt - 1, 10, 100, 0, false},
+	} {
+		got, err := validateOffsetLimit(tuple.offset, tuple.limit, tuple.maxLimit)
+		if (tuple.valid && err != nil) || (!tuple.valid && err == nil) {
+			t.Errorf("offset %d limit %d max %d: expected valid=%v, got error '%v'", tuple.offset, tuple.limit, tuple.maxLimit, tuple.valid, err)
+			continue
+		}
+		if tuple.valid && tuple.expected != got {
+			t.Errorf("offset %d limit %d max %d: expected %d, got %d", tuple.offset, tuple.limit, tuple.maxLimit, tuple.expected, got)
+		}
+	}
+}
+
+func TestSelectInvalidOffsetLimit(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	for _, query := range []string{
+		"?offset=-1",
+		"?limit=-1",
+		"?offset=9223372036854775807&limit=10",
+		"?offset=9223372036854775807&limit=10&coalesce=true",
+		"?offset=abc",
+		"?limit=abc",
+		"?offset=1.5",
+		"?offset=99999999999999999999",
+		"?limit=99999999999999999999",
+		"?offset=-99999999999999999999",
+	} {
+		body, _ := json.Marshal([][]byte{[]byte("foo")})
+		req, _ := http.NewRequest("GET", server.URL+query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected HTTP %d, got %d", query, expected, got)
+		}
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	var (
+		settings = map[string]string{"redis.hash": "murmur3", "max.size": "10000"}
+		base     = configHash("a1:6379,a2:6379;b1:6379", settings)
+	)
+	if expected, got := base, configHash(" a1:6379, a2:6379 ;\tb1:6379\n", settings); expected != got {
+		t.Errorf("whitespace changed the hash: expected %s, got %s", expected, got)
+	}
+	if expected, got := base, configHash("a1:6379,a2:6379;b1:6379", map[string]string{"max.size": "10000", "redis.hash": "murmur3"}); expected != got {
+		t.Errorf("settings order changed the hash: expected %s, got %s", expected, got)
+	}
+	for _, tc := range []struct {
+		farmString string
+		settings   map[string]string
+	}{
+		{"a1:6379,a2:6379;b1:6379,b2:6379", settings},
+		{"a1:6379;a2:6379,b1:6379", settings},
+		{"a1:6379,a2:6379;b1:6379", map[string]string{"redis.hash": "fnv", "max.size": "10000"}},
+		{"a1:6379,a2:6379;b1:6379", map[string]string{"redis.hash": "murmur3"}},
+	} {
+		if got := configHash(tc.farmString, tc.settings); got == base {
+			t.Errorf("%q %v: expected a different hash", tc.farmString, tc.settings)
+		}
+	}
+}
+
+func TestHandleVersion(t *testing.T) {
+	expected := versionInfo{Version: "v1.2.3", GoVersion: "go1.x", ConfigHash: "abc"}
+	r := pat.New()
+	r.Get("/version", handleVersion(func() versionInfo { return expected }))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got versionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestHandleTuning(t *testing.T) {
+	// Tuning doesn't contact Redis, so no instance needs to be reachable.
+	var clusters []cluster.Cluster
+	for i := 0; i < 2; i++ {
+		p := pool.New([]string{"127.0.0.1:1"}, time.Millisecond, time.Millisecond, time.Millisecond, 1, pool.Murmur3)
+		clusters = append(clusters, cluster.New(p, 100, time.Millisecond, nil))
+	}
+	var (
+		tuning = newTunables(clusters, 100)
+		f      = farm.New([]cluster.Cluster{memcluster.New(1000)}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+		r      = pat.New()
+	)
+	for i := 0; i < 100; i++ {
+		f.Insert([]common.KeyScoreMember{{Key: "foo", Score: float64(i), Member: strconv.Itoa(i)}})
+	}
+	r.Get("/admin/tuning", withAdminToken("secret", handleTuning(tuning)))
+	r.Post("/admin/tuning", withAdminToken("secret", handleTuning(tuning)))
+	r.Get("/", handleSelect(f, tuning, 0, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(method, query, authorization string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, server.URL+"/admin/tuning"+query, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	selected := func() int {
+		body, _ := json.Marshal(common.Keys{"foo"})
+		req, _ := http.NewRequest("GET", server.URL+"/?limit=1000", bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var response struct {
+			Records map[string][]common.KeyScoreMember `json:"records"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return len(response.Records["foo"])
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong", "secret", "Basic secret"} {
+		if code, _ := do("POST", "?max.size=1", authorization); code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected HTTP 401, got %d", authorization, code)
+		}
+	}
+	if code, body := do("GET", "", "Bearer secret"); code != http.StatusOK || body["max_size"] != 100.0 || body["select_gap"] != "1ms" {
+		t.Errorf("GET: expected max size 100 and select gap 1ms, got HTTP %d %v", code, body)
+	}
+	if expected, got := 100, selected(); expected != got {
+		t.Errorf("Select: expected %d record(s), got %d", expected, got)
+	}
+
+	if code, body := do("POST", "?max.size=50", "Bearer secret"); code != http.StatusOK || body["max_size"] != 50.0 || body["select_gap"] != "1ms" {
+		t.Errorf("POST: expected max size 50 and select gap 1ms, got HTTP %d %v", code, body)
+	}
+	if code, _ := do("POST", "?select.gap=5ms", "Bearer secret"); code != http.StatusOK {
+		t.Errorf("POST: expected HTTP 200, got %d", code)
+	}
+	for i, c := range clusters {
+		if expected, got := (cluster.Tuning{MaxSize: 50, SelectGap: 5 * time.Millisecond}), c.(cluster.Tuner).Tuning(); expected != got {
+			t.Errorf("cluster %d: expected %+v, got %+v", i, expected, got)
+		}
+	}
+	if expected, got := 50, selected(); expected != got {
+		t.Errorf("Select after tuning: expected %d record(s), got %d", expected, got)
+	}
+
+	for _, query := range []string{"?max.size=0", "?max.size=x", "?select.gap=-1s"} {
+		if code, _ := do("POST", query, "Bearer secret"); code != http.StatusBadRequest {
+			t.Errorf("%s: expected HTTP 400, got %d", query, code)
+		}
+	}
+
+	// Concurrent changes of different parameters both stick.
+	for i := 0; i < 20; i++ {
+		var (
+			maxSize   = 10 + i
+			selectGap = time.Duration(i) * time.Millisecond
+			wg        sync.WaitGroup
+		)
+		wg.Add(2)
+		go func() { defer wg.Done(); do("POST", fmt.Sprintf("?max.size=%d", maxSize), "Bearer secret") }()
+		go func() { defer wg.Done(); do("POST", "?select.gap="+selectGap.String(), "Bearer secret") }()
+		wg.Wait()
+		for j, c := range clusters {
+			if expected, got := (cluster.Tuning{MaxSize: maxSize, SelectGap: selectGap}), c.(cluster.Tuner).Tuning(); expected != got {
+				t.Fatalf("round %d, cluster %d: expected %+v, got %+v", i, j, expected, got)
+			}
+		}
+	}
+}
+
+func TestHandleInstrumentation(t *testing.T) {
+	var (
+		initial = &insertCountingInstrumentation{}
+		rates   = map[float64]*insertCountingInstrumentation{} // of the built targets
+		instr   = instrumentation.NewSwappableInstrumentation(initial)
+		f       = farm.New([]cluster.Cluster{memcluster.New(10)}, 1, farm.SendAllReadAll, farm.NoRepairs, instr)
+		build   = func(s instrumentationSettings) instrumentation.Instrumentation {
+			rates[s.StatsdSampleRate] = &insertCountingInstrumentation{}
+			return rates[s.StatsdSampleRate]
+		}
+		handler = handleInstrumentation(instr, instrumentationSettings{StatsdSampleRate: 0.1}, build)
+	)
+	r := pat.New()
+	r.Get("/", handler)
+	r.Post("/", handler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(method, query string) (int, instrumentationSettings) {
+		req, _ := http.NewRequest(method, server.URL+"/?"+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var settings instrumentationSettings
+		json.NewDecoder(resp.Body).Decode(&settings)
+		return resp.StatusCode, settings
+	}
+	insert := func() {
+		if err := f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Swap while inserts are running.
+	var (
+		stop = make(chan struct{})
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				insert()
+			}
+		}
+	}()
+	if code, settings := do("POST", "statsd.sample.rate=0.5&plaintext=true"); code != http.StatusOK || settings != (instrumentationSettings{StatsdSampleRate: 0.5, Plaintext: true}) {
+		t.Errorf("POST: expected rate 0.5 with plaintext, got HTTP %d %+v", code, settings)
+	}
+	close(stop)
+	<-done
+
+	// From then on, calls only go to the new target.
+	before := atomic.LoadInt32(&initial.inserts)
+	insert()
+	if got := atomic.LoadInt32(&initial.inserts); got != before {
+		t.Errorf("initial target: expected %d insert(s), got %d", before, got)
+	}
+	if got := atomic.LoadInt32(&rates[0.5].inserts); got < 1 {
+		t.Errorf("new target: expected inserts, got %d", got)
+	}
+
+	// GET reports the settings; invalid changes are rejected.
+	if code, settings := do("GET", ""); code != http.StatusOK || settings != (instrumentationSettings{StatsdSampleRate: 0.5, Plaintext: true}) {
+		t.Errorf("GET: expected rate 0.5 with plaintext, got HTTP %d %+v", code, settings)
+	}
+	for _, query := range []string{"statsd.sample.rate=2", "statsd.sample.rate=x", "plaintext=maybe"} {
+		if code, _ := do("POST", query); code != http.StatusBadRequest {
+			t.Errorf("POST %s: expected HTTP 400, got %d", query, code)
+		}
+	}
+	if code, settings := do("POST", "plaintext=false"); code != http.StatusOK || settings != (instrumentationSettings{StatsdSampleRate: 0.5}) {
+		t.Errorf("POST: expected rate 0.5 without plaintext, got HTTP %d %+v", code, settings)
+	}
+}
+
+type insertCountingInstrumentation struct {
+	instrumentation.NopInstrumentation
+	inserts int32
+}
+
+func (i *insertCountingInstrumentation) InsertCall() { atomic.AddInt32(&i.inserts, 1) }
+
+func TestHandlePermits(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{memcluster.New(10), memcluster.New(10)}
+		varFarm  = farm.New(clusters, 1, farm.SendVarReadFirstLinger(100, time.Second), farm.NoRepairs, nil)
+		allFarm  = farm.New(clusters, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	)
+	r := pat.New()
+	r.Get("/var", withAdminToken("secret", handlePermits(varFarm)))
+	r.Post("/var", withAdminToken("secret", handlePermits(varFarm)))
+	r.Get("/all", withAdminToken("secret", handlePermits(allFarm)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(method, path string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	if code, body := do("GET", "/var"); code != http.StatusOK || body["threshold_rate"] != 100.0 || body["available"] != 100.0 {
+		t.Errorf("GET: expected threshold rate 100 and 100 available, got HTTP %d %v", code, body)
+	}
+	varFarm.SelectOffset([]string{"foo"}, 0, 10, common.Descending)
+	if code, body := do("GET", "/var"); code != http.StatusOK || body["available"] != 99.0 {
+		t.Errorf("GET after a read: expected 99 available, got HTTP %d %v", code, body)
+	}
+	if code, body := do("POST", "/var?farm.read.threshold.rate=5"); code != http.StatusOK || body["threshold_rate"] != 5.0 || body["available"] != 5.0 {
+		t.Errorf("POST: expected threshold rate 5 and 5 available, got HTTP %d %v", code, body)
+	}
+	for _, query := range []string{"", "?farm.read.threshold.rate=x"} {
+		if code, _ := do("POST", "/var"+query); code != http.StatusBadRequest {
+			t.Errorf("POST %q: expected HTTP 400, got %d", query, code)
+		}
+	}
+	if code, _ := do("GET", "/all"); code != http.StatusNotImplemented {
+		t.Errorf("SendAllReadAll: expected HTTP 501, got %d", code)
+	}
+}
+
+type mockCopier struct{ copied [][2]string }
+
+func (m *mockCopier) CopyKey(src, dst string) error {
+	m.copied = append(m.copied, [2]string{src, dst})
+	return nil
+}
+
+func TestHandleCopy(t *testing.T) {
+	copier := &mockCopier{}
+	r := pat.New()
+	r.Post("/admin/copy", withAdminToken("secret", handleCopy(copier)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(query string) int {
+		req, _ := http.NewRequest("POST", server.URL+"/admin/copy"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	src, dst := string([]byte{0, 255}), "new key"
+	query := "?" + url.Values{"src": {src}, "dst": {dst}}.Encode()
+	if code := do(query); code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d", code)
+	}
+	if expected, got := [][2]string{{src, dst}}, copier.copied; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	for _, query := range []string{"", "?src=foo", "?dst=foo"} {
+		if code := do(query); code != http.StatusBadRequest {
+			t.Errorf("%q: expected HTTP 400, got %d", query, code)
+		}
+	}
+}
+
+type infoCluster struct {
+	cluster.Cluster
+	info map[string]cluster.InstanceInfo
+}
+
+func (c infoCluster) Info() map[string]cluster.InstanceInfo { return c.info }
+
+func TestHandleRedisInfo(t *testing.T) {
+	clusters := []cluster.Cluster{
+		infoCluster{memcluster.New(10), map[string]cluster.InstanceInfo{
+			"redis1:6379": {Info: pool.Info{UsedMemory: 100, MaxMemory: 1000, Keys: 3, Expires: 1}},
+			"redis2:6379": {Error: pool.ErrDown},
+		}},
+		memcluster.New(10),
+	}
+	r := pat.New()
+	r.Get("/redis-info", withAdminToken("secret", handleRedisInfo(clusters)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/redis-info", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected HTTP %d, got %d", expected, got)
+	}
+	var body struct {
+		Clusters []map[string]map[string]interface{} `json:"clusters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]map[string]interface{}{
+		{
+			"redis1:6379": {"used_memory": 100.0, "maxmemory": 1000.0, "keys": 3.0, "expires": 1.0},
+			"redis2:6379": {"error": pool.ErrDown.Error()},
+		},
+		nil,
+	}
+	if !reflect.DeepEqual(expected, body.Clusters) {
+		t.Errorf("expected %v, got %v", expected, body.Clusters)
+	}
+}
+
+type scoreErrorCluster struct{ cluster.Cluster }
+
+func (c scoreErrorCluster) Score([]common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
+	return nil, pool.ErrDown
+}
+
+func TestHandleKeyMember(t *testing.T) {
+	clusters := []cluster.Cluster{memcluster.New(10), memcluster.New(10), scoreErrorCluster{memcluster.New(10)}}
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	clusters[1].Delete([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "a"}})
+	r := pat.New()
+	r.Get("/debug/keymember", withAdminToken("secret", handleKeyMember(clusters, [][]string{{"a1:6379", "a2:6379"}, {"b1:6379"}, {"c1:6379"}})))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(query url.Values) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+"/debug/keymember?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get(url.Values{"key": {"foo"}, "member": {"a"}})
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected HTTP %d, got %d", expected, got)
+	}
+	var body struct {
+		Clusters  []clusterPresence `json:"clusters"`
+		Divergent bool              `json:"divergent"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	expected := []clusterPresence{
+		{Cluster: 0, Instances: []string{"a1:6379", "a2:6379"}, Present: true, Inserted: true, Score: 1},
+		{Cluster: 1, Instances: []string{"b1:6379"}, Present: true, Inserted: false, Score: 2},
+		{Cluster: 2, Instances: []string{"c1:6379"}, Error: pool.ErrDown.Error()},
+	}
+	if !reflect.DeepEqual(expected, body.Clusters) {
+		t.Errorf("expected %+v, got %+v", expected, body.Clusters)
+	}
+	if !body.Divergent {
+		t.Errorf("expected divergent clusters")
+	}
+
+	resp = get(url.Values{"key": {"foo"}})
+	defer resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("without member: expected HTTP %d, got %d", expected, got)
+	}
+}
+
+type pingCluster struct {
+	cluster.Cluster
+	results map[string]error
+}
+
+func (c pingCluster) Ping() map[string]error { return c.results }
+
+func TestValidateClusters(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{
+			pingCluster{memcluster.New(10), map[string]error{"redis2:6379": pool.ErrDown, "redis1:6379": nil}},
+			pingCluster{memcluster.New(10), map[string]error{"redis3:6379": nil}},
+			memcluster.New(10),
+		}
+		buf = bytes.Buffer{}
+	)
+	if expected, got := 1, validateClusters(&buf, clusters); expected != got {
+		t.Errorf("expected %d unreachable instance(s), got %d", expected, got)
+	}
+	expected := strings.Join([]string{
+		"cluster 1: redis1:6379: ok",
+		"cluster 1: redis2:6379: unreachable: " + pool.ErrDown.Error(),
+		"cluster 2: redis3:6379: ok",
+		"cluster 3: can't be pinged",
+		"",
+	}, "\n")
+	if got := buf.String(); expected != got {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+type estimateCluster struct {
+	cluster.Cluster
+	estimates map[string]cluster.KeyEstimate
+	sampled   []int
+}
+
+func (c *estimateCluster) EstimateKeys(sampleSize int) map[string]cluster.KeyEstimate {
+	c.sampled = append(c.sampled, sampleSize)
+	return c.estimates
+}
+
+func TestHandleKeyEstimate(t *testing.T) {
+	var (
+		c1 = &estimateCluster{memcluster.New(10), map[string]cluster.KeyEstimate{
+			"redis1:6379": {Keys: 40, Sampled: 100, DBSize: 80},
+			"redis2:6379": {Keys: 3, Sampled: 6, DBSize: 6, Exact: true},
+		}, nil}
+		c2 = &estimateCluster{memcluster.New(10), map[string]cluster.KeyEstimate{
+			"redis3:6379": {Keys: 45, Sampled: 100, DBSize: 90},
+			"redis4:6379": {Error: pool.ErrDown},
+		}, nil}
+		clusters = []cluster.Cluster{c1, c2, memcluster.New(10)}
+	)
+	r := pat.New()
+	r.Get("/admin/key-estimate", withAdminToken("secret", handleKeyEstimate(clusters)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(query string) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+"/admin/key-estimate"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("?sample=100")
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected HTTP %d, got %d", expected, got)
+	}
+	var body struct {
+		Clusters []map[string]interface{} `json:"clusters"`
+		Keys     int64                    `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{
+			"keys": 43.0,
+			"instances": map[string]interface{}{
+				"redis1:6379": map[string]interface{}{"keys": 40.0, "sampled": 100.0, "dbsize": 80.0, "exact": false},
+				"redis2:6379": map[string]interface{}{"keys": 3.0, "sampled": 6.0, "dbsize": 6.0, "exact": true},
+			},
+		},
+		{
+			"keys": 45.0,
+			"instances": map[string]interface{}{
+				"redis3:6379": map[string]interface{}{"keys": 45.0, "sampled": 100.0, "dbsize": 90.0, "exact": false},
+				"redis4:6379": map[string]interface{}{"error": pool.ErrDown.Error()},
+			},
+		},
+		nil,
+	}
+	if !reflect.DeepEqual(expected, body.Clusters) {
+		t.Errorf("expected %v, got %v", expected, body.Clusters)
+	}
+	if expected, got := int64(45), body.Keys; expected != got {
+		t.Errorf("expected %d key(s), got %d", expected, got)
+	}
+	if expected, got := []int{100}, c1.sampled; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected sample sizes %v, got %v", expected, got)
+	}
+
+	for _, query := range []string{"?sample=0", "?sample=foo", "?sample=100001"} {
+		resp := get(query)
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected HTTP %d, got %d", query, expected, got)
+		}
+	}
+}
+
+func TestHandleRepair(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{memcluster.New(10), memcluster.New(10)}
+		f        = farm.New(clusters, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	)
+	clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	r := pat.New()
+	r.Post("/repair", withAdminToken("secret", handleRepair(f, newTunables(nil, 10))))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal(common.Keys{"foo", "bar"})
+	req, _ := http.NewRequest("POST", server.URL+"/repair", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected HTTP %d, got %d", expected, got)
+	}
+	var response struct {
+		Inconsistent int   `json:"inconsistent"`
+		Repaired     []int `json:"repaired"`
+		Failed       []int `json:"failed"`
+		Complete     bool  `json:"complete"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Inconsistent != 1 || !reflect.DeepEqual([]int{1, 0}, response.Repaired) || !reflect.DeepEqual([]int{0, 0}, response.Failed) || !response.Complete {
+		t.Errorf("expected 1 inconsistent key-member repaired in the first cluster, got %+v", response)
+	}
+	if got := <-clusters[0].SelectOffset([]string{"foo"}, 0, 10, common.Descending); len(got.KeyScoreMembers) != 1 {
+		t.Errorf("expected the repair in the first cluster, got %v", got.KeyScoreMembers)
+	}
+}
+
+func TestHandleScore(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{memcluster.New(10), memcluster.New(10)}
+		f        = farm.New(clusters, 1, farm.SendAllReadAll, farm.NoRepairs, nil, farm.WithMaxSelectKeys(3))
+	)
+	clusters[0].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	clusters[1].Delete([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "a"}})
+	clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 3, Member: "b"}})
+	r := pat.New()
+	r.Post("/score", handleScore(f))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	post := func(keyMembers []common.KeyMember) *http.Response {
+		body, _ := json.Marshal(keyMembers)
+		resp, err := http.Post(server.URL+"/score", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post([]common.KeyMember{{Key: "foo", Member: "b"}, {Key: "foo", Member: "a"}, {Key: "bar", Member: "c"}})
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected HTTP %d, got %d", expected, got)
+	}
+	var response struct {
+		Records []scoreRecord `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	expected := []scoreRecord{
+		{Key: []byte("foo"), Member: []byte("b"), Present: true, Inserted: true, Score: 3},
+		{Key: []byte("foo"), Member: []byte("a"), Present: true, Inserted: false, Score: 2},
+		{Key: []byte("bar"), Member: []byte("c")},
+	}
+	if !reflect.DeepEqual(expected, response.Records) {
+		t.Errorf("expected %+v, got %+v", expected, response.Records)
+	}
+
+	resp = post(make([]common.KeyMember, 4))
+	defer resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("too many key-members: expected HTTP %d, got %d", expected, got)
+	}
+}
+
+func TestHandleLivezReadyz(t *testing.T) {
+	ready := newReadiness(3, 2)
+	r := pat.New()
+	r.Get("/livez", handleLivez)
+	r.Get("/readyz", handleReadyz(ready))
+	r.Get("/unchecked/readyz", handleReadyz(nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	status := func(path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	down := errors.New("connection refused")
+
+	for _, tc := range []struct {
+		name    string
+		index   int
+		results map[string]error
+		livez   int
+		readyz  int
+	}{
+		{"before the first check", -1, nil, http.StatusOK, http.StatusServiceUnavailable},
+		{"one cluster up", 0, map[string]error{"a": nil, "b": nil}, http.StatusOK, http.StatusServiceUnavailable},
+		{"one instance of a second cluster down", 1, map[string]error{"c": nil, "d": down}, http.StatusOK, http.StatusServiceUnavailable},
+		{"a second cluster up", 1, map[string]error{"c": nil, "d": nil}, http.StatusOK, http.StatusOK},
+		{"the first cluster down", 0, map[string]error{"a": down, "b": nil}, http.StatusOK, http.StatusServiceUnavailable},
+		{"a third cluster up", 2, map[string]error{"e": nil}, http.StatusOK, http.StatusOK},
+	} {
+		if tc.index >= 0 {
+			ready.update(tc.index, tc.results)
+		}
+		if expected, got := tc.livez, status("/livez"); expected != got {
+			t.Errorf("%s: /livez: expected HTTP %d, got %d", tc.name, expected, got)
+		}
+		if expected, got := tc.readyz, status("/readyz"); expected != got {
+			t.Errorf("%s: /readyz: expected HTTP %d, got %d", tc.name, expected, got)
+		}
+	}
+
+	if expected, got := http.StatusOK, status("/unchecked/readyz"); expected != got {
+		t.Errorf("without health checks: expected HTTP %d, got %d", expected, got)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	server := httptest.NewServer(withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r)
+	})))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		provided string
+		kept     bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"has space", false},
+		{strings.Repeat("x", maxRequestIDLength+1), false},
+	} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		if tc.provided != "" {
+			req.Header.Set(requestIDHeader, tc.provided)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		id := resp.Header.Get(requestIDHeader)
+		if id != seen {
+			t.Errorf("%q: response has ID %q, but handler saw %q", tc.provided, id, seen)
+		}
+		if tc.kept && id != tc.provided {
+			t.Errorf("%q: expected ID to be kept, got %q", tc.provided, id)
+		}
+		if !tc.kept && (id == tc.provided || len(id) != 32) {
+			t.Errorf("%q: expected a generated ID, got %q", tc.provided, id)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "bar", Score: 3, Member: "c"},
+	})
+	r := pat.New()
+	r.Get("/", handleSelect(farm, newTunables(nil, 1000), 0, nil))
+	r.Delete("/", handleDelete(farm))
+
+	var (
+		keys, _   = json.Marshal(common.Keys{"foo", "bar", "baz"})
+		tuples, _ = json.Marshal([]common.KeyScoreMember{{Key: "foo", Score: 5, Member: "a"}})
+	)
+	for _, tc := range []struct {
+		format   string
+		method   string
+		body     string
+		expected accessEntry
+	}{
+		{"logfmt", "GET", string(keys), accessEntry{Method: "GET", Path: "/", Status: 200, Keys: 3, Records: 3}},
+		{"json", "DELETE", string(tuples), accessEntry{Method: "DELETE", Path: "/", Status: 200, Tuples: 1}},
+		{"json", "DELETE", `garbage`, accessEntry{Method: "DELETE", Path: "/", Status: 400}},
+	} {
+		format, err := parseAccessLogFormat(tc.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		h := withRequestID(withAccessLog(r, log.New(&buf, "", 0), format))
+		req, _ := http.NewRequest(tc.method, "/", strings.NewReader(tc.body))
+		req.Header.Set(requestIDHeader, "abc-123")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		line := strings.TrimSpace(buf.String())
+		var got accessEntry
+		if tc.format == "json" {
+			if err := json.Unmarshal([]byte(line), &got); err != nil {
+				t.Fatalf("%s: %s", line, err)
+			}
+		} else {
+			fields := map[string]string{}
+			for _, field := range strings.Fields(line) {
+				kv := strings.SplitN(field, "=", 2)
+				fields[kv[0]] = kv[1]
+			}
+			atoi := func(name string) int { n, _ := strconv.Atoi(fields[name]); return n }
+			got = accessEntry{
+				Time: fields["time"], Method: fields["method"], Path: fields["path"], Status: atoi("status"),
+				Keys: atoi("keys"), Tuples: atoi("tuples"), Records: atoi("records"), Bytes: atoi("bytes"),
+				Duration: fields["duration"], RequestID: fields["request_id"],
+			}
+		}
+		if _, err := time.Parse(time.RFC3339Nano, got.Time); err != nil {
+			t.Errorf("%s: time: %s", line, err)
+		}
+		if _, err := time.ParseDuration(got.Duration); err != nil {
+			t.Errorf("%s: duration: %s", line, err)
+		}
+		if expected, got := rec.Body.Len(), got.Bytes; expected != got {
+			t.Errorf("%s: expected %d bytes, got %d", line, expected, got)
+		}
+		tc.expected.Time, tc.expected.Duration, tc.expected.Bytes, tc.expected.RequestID = got.Time, got.Duration, got.Bytes, "abc-123"
+		if !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("%s: expected %+v, got %+v", line, tc.expected, got)
+		}
+	}
+
+	if _, err := parseAccessLogFormat("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if expected, got := `"a b"`, logfmtValue("a b"); expected != got {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestHandleInsert(t *testing.T) {
+	farm := newMockFarm()
+	r := pat.New()
+	r.Post("/", handleInsert(farm, 0))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	requestBody, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		common.KeyScoreMember{Key: "bar", Score: 200, Member: "xxx"},
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		common.KeyScoreMember{Key: "bar", Score: 900, Member: "yyy"},
+	})
+	resp, err := http.Post(server.URL, "text/plain", bytes.NewReader(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	t.Log(strings.TrimSpace(string(respBody)))
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+	}, farm.m["foo"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "bar", Score: 900, Member: "yyy"},
+		common.KeyScoreMember{Key: "bar", Score: 200, Member: "xxx"},
+	}, farm.m["bar"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestHandleInsertChunksSlowCluster(t *testing.T) {
+	var (
+		fast = memcluster.New(10)
+		slow = slowInsertCluster{memcluster.New(10), 20 * time.Millisecond}
+		f    = farm.New([]cluster.Cluster{fast, slow}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	)
+	r := pat.New()
+	r.Post("/", handleInsert(f, 2))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// Each chunk returns at the quorum of the fast cluster, before the slow
+	// cluster has read it.
+	tuples := []common.KeyScoreMember{}
+	for i := 0; i < 6; i++ {
+		tuples = append(tuples, common.KeyScoreMember{Key: fmt.Sprintf("key%d", i), Score: float64(i + 1), Member: "abc"})
+	}
+	requestBody, _ := json.Marshal(tuples)
+	resp, err := http.Post(server.URL, "text/plain", bytes.NewReader(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	// The slow cluster gets every tuple, not the ones of later chunks.
+	time.Sleep(100 * time.Millisecond)
+	for _, tuple := range tuples {
+		keyMember := common.KeyMember{Key: tuple.Key, Member: tuple.Member}
+		presence, err := slow.Score([]common.KeyMember{keyMember})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := presence[keyMember]; !p.Present || p.Score != tuple.Score {
+			t.Errorf("%s: expected score %v in the slow cluster, got %+v", tuple.Key, tuple.Score, p)
+		}
+	}
+}
+
+// slowInsertCluster reads the tuples of Inserts only after a delay.
+type slowInsertCluster struct {
+	cluster.Cluster
+	delay time.Duration
+}
+
+func (c slowInsertCluster) Insert(tuples []common.KeyScoreMember) error {
+	time.Sleep(c.delay)
+	return c.Cluster.Insert(tuples)
+}
+
+func TestHandleInsertReport(t *testing.T) {
+	clusters := []cluster.Cluster{memcluster.New(10)}
+	f := farm.New(clusters, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 500, Member: "abc"}})
+	f.Delete([]common.KeyScoreMember{{Key: "foo", Score: 900, Member: "ghi"}})
+
+	r := pat.New()
+	r.Post("/", handleInsert(f, 2))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	requestBody, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"},
+	})
+	resp, err := http.Post(server.URL+"?report=true", "text/plain", bytes.NewReader(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Inserted int        `json:"inserted"`
+		Scores   []*float64 `json:"scores"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 3, response.Inserted; expected != got {
+		t.Errorf("expected %d inserted, got %d", expected, got)
+	}
+	score := func(f float64) *float64 { return &f }
+	if expected, got := []*float64{score(500), score(456), nil}, response.Scores; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected scores %v, got %v", expected, got)
+	}
+
+	// Inserters which can't report reject the request.
+	r = pat.New()
+	r.Post("/", handleInsert(newMockFarm(), 0))
+	mockServer := httptest.NewServer(r)
+	defer mockServer.Close()
+	resp, err = http.Post(mockServer.URL+"?report=true", "text/plain", bytes.NewReader(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("expected HTTP %d, got %d", expected, got)
+	}
+}
+
+func TestHandleInsertChunks(t *testing.T) {
+	inserter := &chunkRecordingInserter{}
+	r := pat.New()
+	r.Post("/", handleInsert(inserter, 2))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	tuples, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"},
+		common.KeyScoreMember{Key: "foo", Score: 2, Member: "b"},
+		common.KeyScoreMember{Key: "foo", Score: 3, Member: "c"},
+		common.KeyScoreMember{Key: "foo", Score: 4, Member: "d"},
+		common.KeyScoreMember{Key: "foo", Score: 5, Member: "e"},
+	})
+
+	for _, tc := range []struct {
+		body     string
+		code     int
+		inserted int
+		chunks   []int
+	}{
+		{string(tuples), http.StatusOK, 5, []int{2, 2, 1}},
+		{`[]`, http.StatusOK, 0, nil},
+		{`null`, http.StatusOK, 0, nil},
+		{`{}`, http.StatusBadRequest, 0, nil},
+		{strings.Replace(string(tuples), `"score":3`, `"score":"x"`, 1), http.StatusBadRequest, 2, []int{2}},
+		{strings.TrimSuffix(string(tuples), "]"), http.StatusBadRequest, 4, []int{2, 2}},
+	} {
+		inserter.chunks = nil
+		resp, err := http.Post(server.URL, "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Inserted int `json:"inserted"`
+		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+
+		if expected, got := tc.code, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected HTTP %d, got %d", tc.body, expected, got)
+		}
+		if expected, got := tc.inserted, response.Inserted; expected != got {
+			t.Errorf("%s: expected %d inserted, got %d", tc.body, expected, got)
+		}
+		if expected, got := tc.chunks, inserter.chunks; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: expected chunks %v, got %v", tc.body, expected, got)
+		}
+	}
+}
+
+func TestHandleInsertOutOfMemory(t *testing.T) {
+	oom := pool.LogicalError{
+		Address: "localhost:6379",
+		Reply:   redis.Error("OOM command not allowed when used memory > 'maxmemory'."),
+	}
+	body, _ := json.Marshal([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{farm.QuorumError{Errors: []error{oom, errors.New("connection refused")}}, http.StatusInsufficientStorage},
+		{farm.QuorumError{Errors: []error{errors.New("connection refused")}}, http.StatusInternalServerError},
+		{errors.New("failtown"), http.StatusInternalServerError},
+		{context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{cluster.MemberSizeError{Key: "foo", Size: 2, Max: 1}, http.StatusBadRequest},
+	} {
+		r := pat.New()
+		r.Post("/", handleInsert(failingInserter{tc.err}, 0))
+		server := httptest.NewServer(r)
+		resp, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		server.Close()
+
+		if expected, got := tc.code, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected HTTP %d, got %d", tc.err, expected, got)
+		}
+	}
+}
+
+func TestHandleInsertContext(t *testing.T) {
+	inserter := &contextInserter{}
+	r := pat.New()
+	r.Post("/", handleInsert(inserter, 0))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	resp, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	// The request context is canceled once the request is served.
+	if inserter.ctx == nil {
+		t.Fatal("expected InsertContext to be called")
+	}
+	select {
+	case <-inserter.ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("expected the request context, got one which isn't canceled")
+	}
+}
+
+func TestHandleInsertNonFiniteScore(t *testing.T) {
+	var (
+		inserter = &chunkRecordingInserter{}
+		f        = farm.New([]cluster.Cluster{memcluster.New(10)}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	)
+	r := pat.New()
+	r.Post("/", handleInsert(inserter, 0))
+	r.Delete("/", handleDelete(f))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// JSON has no NaN or infinity, and overflowing numbers don't decode.
+	for _, score := range []string{`NaN`, `"NaN"`, `Infinity`, `-Infinity`, `1e999`} {
+		body := fmt.Sprintf(`[{"key":"Zm9v","score":%s,"member":"YQ=="}]`, score)
+		for _, method := range []string{"POST", "DELETE"} {
+			req, _ := http.NewRequest(method, server.URL, strings.NewReader(body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+				t.Errorf("%s %s: expected HTTP %d, got %d", method, score, expected, got)
+			}
+		}
+	}
+	if len(inserter.chunks) > 0 {
+		t.Errorf("expected no inserts, got %v", inserter.chunks)
+	}
+
+	// Other clients of the farm are rejected by the farm.
+	for _, score := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		err := f.Insert([]common.KeyScoreMember{{Key: "foo", Score: score, Member: "a"}})
+		if expected, got := http.StatusBadRequest, writeErrorStatus(err); expected != got {
+			t.Errorf("%v: expected HTTP %d, got %d (%v)", score, expected, got, err)
+		}
+	}
+}
+
+func TestSelectDefaults(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL, bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var normalResponse struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"},
+			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+			common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		},
+		"bar": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
+			common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+			common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		},
+	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestSelectOffsetLimit(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL+"?offset=1&limit=1", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var normalResponse struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		},
+		"bar": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+		},
+	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestSelectTooManyKeys(t *testing.T) {
+	f := farm.New(
+		[]cluster.Cluster{memcluster.New(10)},
+		1,
+		farm.SendAllReadAll,
+		farm.NoRepairs,
+		nil,
+		farm.WithMaxSelectKeys(2),
+	)
+	r := pat.New()
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		query    string
+		keys     [][]byte
+		expected int
+	}{
+		{"", [][]byte{[]byte("a"), []byte("b")}, http.StatusOK},
+		{"", [][]byte{[]byte("a"), []byte("b"), []byte("c")}, http.StatusBadRequest},
+		{"?start=" + common.Cursor{Score: 100}.String(), [][]byte{[]byte("a"), []byte("b")}, http.StatusOK},
+		{"?start=" + common.Cursor{Score: 100}.String(), [][]byte{[]byte("a"), []byte("b"), []byte("c")}, http.StatusBadRequest},
+	} {
+		body, _ := json.Marshal(tc.keys)
+		req, _ := http.NewRequest("GET", server.URL+tc.query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.StatusCode; tc.expected != got {
+			t.Errorf("%d key(s) %q: expected HTTP %d, got %d", len(tc.keys), tc.query, tc.expected, got)
+		}
+	}
+}
+
+func TestSelectMaxResponse(t *testing.T) {
+	f := farm.New([]cluster.Cluster{memcluster.New(100)}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	for i := 0; i < 10; i++ {
+		f.Insert([]common.KeyScoreMember{{Key: "foo", Score: float64(i), Member: strings.Repeat("x", 100) + strconv.Itoa(i)}})
+	}
+	r := pat.New()
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 1000, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	for _, tc := range []struct {
+		query    string
+		expected int
+	}{
+		{"?limit=2", http.StatusOK},
+		{"?limit=10", http.StatusRequestEntityTooLarge},
+		{"?limit=10&coalesce=true", http.StatusRequestEntityTooLarge},
+		{"?start=" + common.Cursor{Score: 100}.String() + "&limit=2", http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tc.query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := resp.StatusCode; tc.expected != got {
+			t.Errorf("%q: expected HTTP %d, got %d", tc.query, tc.expected, got)
+		}
+		if n := len(buf); n > 1000 {
+			t.Errorf("%q: expected at most 1000 bytes, got %d", tc.query, n)
+		}
+	}
+}
+
+func TestSelectBudget(t *testing.T) {
+	b := newSelectBudget(1000, 100, 0)
+	if n, ok := b.acquire(6); !ok || n != 600 {
+		t.Fatalf("expected 600 bytes reserved, got %d (%v)", n, ok)
+	}
+	if _, ok := b.acquire(5); ok {
+		t.Fatalf("expected 1100 bytes to exceed the budget")
+	}
+	if n, ok := b.acquire(4); !ok || n != 400 {
+		t.Fatalf("expected 400 bytes reserved, got %d (%v)", n, ok)
+	}
+	b.release(1000)
+
+	// A Select larger than the budget runs alone.
+	n, ok := b.acquire(1 << 40)
+	if !ok || n != 1000 {
+		t.Fatalf("expected the whole budget reserved, got %d (%v)", n, ok)
+	}
+
+	// Waiting Selects run once the budget is released, or give up.
+	b.wait = time.Second
+	done := make(chan bool)
+	go func() { _, ok := b.acquire(1); done <- ok }()
+	time.Sleep(10 * time.Millisecond)
+	b.release(n)
+	if ok := <-done; !ok {
+		t.Errorf("expected the waiting Select to run after a release")
+	}
+	b.acquire(9)
+	b.wait = 10 * time.Millisecond
+	if _, ok := b.acquire(1); ok {
+		t.Errorf("expected the waiting Select to time out")
+	}
+
+	// A nil budget allows everything.
+	if _, ok := (*selectBudget)(nil).acquire(1 << 40); !ok {
+		t.Errorf("expected a nil budget to allow every Select")
+	}
+}
+
+func TestSelectBudgetExceeded(t *testing.T) {
+	f := farm.New([]cluster.Cluster{memcluster.New(100)}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}})
+	budget := newSelectBudget(1000, 100, 0)
+	r := pat.New()
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, budget))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	get := func() int {
+		req, _ := http.NewRequest("GET", server.URL+"?limit=5", bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	reserved, _ := budget.acquire(6) // another Select in flight
+	if expected, got := http.StatusServiceUnavailable, get(); expected != got {
+		t.Errorf("expected HTTP %d, got %d", expected, got)
+	}
+	budget.release(reserved)
+	if expected, got := http.StatusOK, get(); expected != got {
+		t.Errorf("expected HTTP %d, got %d", expected, got)
+	}
+	if budget.used != 0 {
+		t.Errorf("expected the budget released, %d bytes still reserved", budget.used)
+	}
+}
+
+func TestSelectMinScore(t *testing.T) {
+	f := farm.New([]cluster.Cluster{memcluster.New(100)}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	for i := 1; i <= 5; i++ {
+		f.Insert([]common.KeyScoreMember{{Key: "foo", Score: float64(i), Member: strconv.Itoa(i)}})
+	}
+	r := pat.New()
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	for _, tc := range []struct {
+		query    string
+		expected int
+		members  []string
+	}{
+		{"?offset=1&limit=3&minScore=3", http.StatusOK, []string{"4", "3"}},
+		{"?offset=0&limit=10&minScore=4.5", http.StatusOK, []string{"5"}},
+		{"?offset=1&limit=3&minScore=3&coalesce=true", http.StatusOK, []string{"4", "3"}},
+		{"?minScore=3&order=asc", http.StatusBadRequest, nil},
+		{"?minScore=3&start=" + common.Cursor{Score: 100}.String(), http.StatusBadRequest, nil},
+		{"?minScore=x", http.StatusBadRequest, nil},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tc.query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Records json.RawMessage `json:"records"`
+		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if got := resp.StatusCode; tc.expected != got {
+			t.Errorf("%q: expected HTTP %d, got %d", tc.query, tc.expected, got)
+			continue
+		}
+		if tc.members == nil {
+			continue
+		}
+		var records []common.KeyScoreMember
+		if strings.Contains(tc.query, "coalesce") {
+			json.Unmarshal(response.Records, &records)
+		} else {
+			var byKey map[string][]common.KeyScoreMember
+			json.Unmarshal(response.Records, &byKey)
+			records = byKey["foo"]
+		}
+		members := []string{}
+		for _, record := range records {
+			members = append(members, record.Member)
+		}
+		if !reflect.DeepEqual(tc.members, members) {
+			t.Errorf("%q: expected %v, got %v", tc.query, tc.members, members)
+		}
+	}
+}
+
+func TestScoreFormatRFC3339(t *testing.T) {
+	f := farm.New([]cluster.Cluster{memcluster.New(100)}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	r := pat.New()
+	r.Post("/", handleInsert(f, 0))
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+	r.Delete("/", handleDelete(f))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(method, query string, body interface{}, response interface{}) int {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, server.URL+query, bytes.NewReader(data))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if response != nil {
+			json.NewDecoder(resp.Body).Decode(response)
+		}
+		return resp.StatusCode
+	}
+
+	// Insert timestamps, to the millisecond.
+	inserted := []timestampRecord{
+		{Key: []byte("foo"), Score: "2026-10-15T12:00:00.123Z", Member: []byte("a")},
+		{Key: []byte("foo"), Score: "2026-10-15T14:00:00+02:00", Member: []byte("b")},
+	}
+	if code := do("POST", "?scoreFormat=rfc3339&scoreUnit=ms", inserted, nil); code != http.StatusOK {
+		t.Fatalf("insert: expected HTTP 200, got %d", code)
+	}
+
+	// They're stored as milliseconds since the epoch...
+	var response struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if code := do("GET", "", common.Keys{"foo"}, &response); code != http.StatusOK {
+		t.Fatalf("select: expected HTTP 200, got %d", code)
+	}
+	if expected, got := []common.KeyScoreMember{
+		{Key: "foo", Score: 1792065600123, Member: "a"},
+		{Key: "foo", Score: 1792065600000, Member: "b"},
+	}, response.Records["foo"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// ...and selected as timestamps in UTC, in either unit.
+	for query, expected := range map[string][]string{
+		"?scoreFormat=rfc3339&scoreUnit=ms":               {"2026-10-15T12:00:00.123Z", "2026-10-15T12:00:00Z"},
+		"?scoreFormat=rfc3339&scoreUnit=ms&coalesce=true": {"2026-10-15T12:00:00.123Z", "2026-10-15T12:00:00Z"},
+		"?scoreFormat=rfc3339&scoreUnit=us":               {"1970-01-21T17:47:45.600123Z", "1970-01-21T17:47:45.6Z"},
+		"?scoreFormat=rfc3339":                            nil, // beyond the year 2262
+	} {
+		var response struct {
+			Records json.RawMessage `json:"records"`
+		}
+		code := do("GET", query, common.Keys{"foo"}, &response)
+		if expected == nil {
+			if code != http.StatusBadRequest {
+				t.Errorf("%q: expected HTTP 400, got %d", query, code)
+			}
+			continue
+		}
+		if code != http.StatusOK {
+			t.Errorf("%q: expected HTTP 200, got %d", query, code)
+			continue
+		}
+		var records []timestampRecord
+		if strings.Contains(query, "coalesce") {
+			json.Unmarshal(response.Records, &records)
+		} else {
+			var m map[string][]timestampRecord
+			json.Unmarshal(response.Records, &m)
+			records = m["foo"]
+		}
+		got := []string{}
+		for _, record := range records {
+			got = append(got, record.Score)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%q: expected %v, got %v", query, expected, got)
+		}
+	}
+
+	// Deletes take the same format.
+	if code := do("DELETE", "?scoreFormat=rfc3339&scoreUnit=ms", inserted[:1], nil); code != http.StatusOK {
+		t.Fatalf("delete: expected HTTP 200, got %d", code)
+	}
+	if code := do("GET", "", common.Keys{"foo"}, &response); code != http.StatusOK || len(response.Records["foo"]) != 1 {
+		t.Errorf("expected 1 record after the delete, got HTTP %d, %v", code, response.Records)
+	}
+
+	for _, query := range []string{"?scoreFormat=iso", "?scoreUnit=ms", "?scoreFormat=rfc3339&scoreUnit=d"} {
+		if code := do("GET", query, common.Keys{"foo"}, nil); code != http.StatusBadRequest {
+			t.Errorf("%q: expected HTTP 400, got %d", query, code)
+		}
+		if code := do("POST", query, inserted, nil); code != http.StatusBadRequest {
+			t.Errorf("%q: expected HTTP 400, got %d", query, code)
+		}
+	}
+	if code := do("POST", "?scoreFormat=rfc3339", []timestampRecord{{Key: []byte("foo"), Score: "yesterday", Member: []byte("c")}}, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid timestamp: expected HTTP 400, got %d", code)
+	}
+
+	// An effective score which can't be formatted fails the report, not the
+	// insert, which succeeded.
+	f.Insert([]common.KeyScoreMember{{Key: "bar", Score: 1e300, Member: "a"}})
+	var failed struct {
+		Inserted int `json:"inserted"`
+	}
+	if code := do("POST", "?scoreFormat=rfc3339&report=true", []timestampRecord{{Key: []byte("bar"), Score: "2026-10-15T12:00:00Z", Member: []byte("a")}}, &failed); code != http.StatusInternalServerError || failed.Inserted != 1 {
+		t.Errorf("unformattable effective score: expected HTTP 500 with 1 inserted, got HTTP %d with %d", code, failed.Inserted)
+	}
+}
+
+func TestScoreFormatBounds(t *testing.T) {
+	for _, tc := range []struct {
+		unit  time.Duration
+		score float64
+		ok    bool
+	}{
+		{time.Nanosecond, 1 << 62, true},
+		{time.Nanosecond, math.Nextafter(1<<63, 0), true},
+		{time.Nanosecond, 1 << 63, false}, // i.e. float64(math.MaxInt64)
+		{time.Nanosecond, -(1 << 63), false},
+		{time.Second, 9223372035.999, true},
+		{time.Second, 9223372036, false},
+		{time.Second, -9223372035, true},
+		{time.Second, -9223372035.5, false},
+		{time.Millisecond, math.Inf(1), false},
+		{time.Millisecond, math.NaN(), false},
+	} {
+		formatted, err := scoreFormat{rfc3339: true, unit: tc.unit}.format(tc.score)
+		if tc.ok != (err == nil) {
+			t.Errorf("%v in %s: expected ok %v, got %q, %v", tc.score, tc.unit, tc.ok, formatted, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, formatted)
+		if err != nil {
+			t.Errorf("%v in %s: %s", tc.score, tc.unit, err)
+			continue
+		}
+		if (parsed.UnixNano() < 0) != (tc.score < 0) {
+			t.Errorf("%v in %s: overflowed to %s", tc.score, tc.unit, formatted)
+		}
+	}
+}
+
+func TestSelectTombstones(t *testing.T) {
+	clusters := []cluster.Cluster{memcluster.New(10), memcluster.New(10)}
+	f := farm.New(clusters, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}, {Key: "bar", Score: 1, Member: "b"}})
+	f.Delete([]common.KeyScoreMember{{Key: "bar", Score: 2, Member: "b"}})
+	clusters[1].Delete([]common.KeyScoreMember{{Key: "baz", Score: 1, Member: "c"}}) // only on one cluster
+
+	r := pat.New()
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar"), []byte("baz"), []byte("qux")})
+	for _, tc := range []struct {
+		query    string
+		expected map[string]string
+	}{
+		{"", nil},
+		{"?tombstones=true", map[string]string{"bar": "deleted", "baz": "deleted", "qux": "unknown"}},
+		{"?tombstones=true&coalesce=true", map[string]string{"bar": "deleted", "baz": "deleted", "qux": "unknown"}},
+		{"?tombstones=true&start=" + common.Cursor{Score: 100}.String(), map[string]string{"bar": "deleted", "baz": "deleted", "qux": "unknown"}},
+		{"?tombstones=true&offset=1", map[string]string{"foo": "exhausted", "bar": "deleted", "baz": "deleted", "qux": "unknown"}},
+		{"?tombstones=true&start=" + common.Cursor{Score: 0.5}.String(), map[string]string{"foo": "exhausted", "bar": "deleted", "baz": "deleted", "qux": "unknown"}},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tc.query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			KeyStatus map[string]string `json:"key_status"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.query, err)
+		}
+		if expected, got := tc.expected, response.KeyStatus; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%q: expected %v, got %v", tc.query, expected, got)
+		}
+	}
+}
+
+func TestSelectConsistency(t *testing.T) {
+	// The write only reached one of the clusters, which SendOneReadOne reads
+	// half of the time.
+	clusters := []cluster.Cluster{memcluster.New(10), memcluster.New(10)}
+	f := farm.New(clusters, 1, farm.SendOneReadOne, farm.NoRepairs, nil)
+	clusters[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+
+	r := pat.New()
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+	mockServer := fixtureServer()
+	defer mockServer.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	expected := map[string][]common.KeyScoreMember{"foo": {{Key: "foo", Score: 1, Member: "a"}}}
+	for _, query := range []string{
+		"?consistency=strong",
+		"?consistency=strong&start=" + common.Cursor{Score: 100}.String(),
+	} {
+		for i := 0; i < 10; i++ {
+			req, _ := http.NewRequest("GET", server.URL+query, bytes.NewReader(body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var response struct {
+				Records map[string][]common.KeyScoreMember `json:"records"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&response)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("%q: %s", query, err)
+			}
+			if got := response.Records; !reflect.DeepEqual(expected, got) {
+				t.Fatalf("%q: expected %v, got %v", query, expected, got)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		url, query string
+	}{
+		{server.URL, "?consistency=eventual"},
+		{mockServer.URL, "?consistency=strong"}, // no farm.ReadStrategyOverrider
+	} {
+		req, _ := http.NewRequest("GET", tc.url+tc.query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%q: expected HTTP %d, got %d", tc.query, expected, got)
+		}
+	}
+}
+
+func TestSelectDegraded(t *testing.T) {
+	for _, complete := range []bool{true, false} {
+		farm := &incompleteMockFarm{mockFarm: newMockFarm(), complete: complete}
+		r := pat.New()
+		r.Get("/", handleSelect(farm, newTunables(nil, 1000), 0, nil))
+		server := httptest.NewServer(r)
+
+		body, _ := json.Marshal([][]byte{[]byte("foo")})
+		req, _ := http.NewRequest("GET", server.URL, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		server.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("HTTP %d", resp.StatusCode)
+		}
+
+		expected := ""
+		if !complete {
+			expected = "true"
+		}
+		if got := resp.Header.Get("X-Roshi-Degraded"); expected != got {
+			t.Errorf("complete=%v: expected header %q, got %q", complete, expected, got)
+		}
+	}
+}
+
+type selectErrorCluster struct{ cluster.Cluster }
+
+func (c selectErrorCluster) SelectOffset(keys []string, offset, limit int, order common.Order) <-chan cluster.Element {
+	out := make(chan cluster.Element, len(keys))
+	for _, key := range keys {
+		out <- cluster.Element{Key: key, Error: pool.ErrDown}
+	}
+	close(out)
+	return out
+}
+
+func TestSelectTooDegraded(t *testing.T) {
+	for _, tc := range []struct {
+		tolerance float64
+		expected  int
+	}{
+		{1, http.StatusOK},
+		{0.5, http.StatusOK},
+		{0, http.StatusServiceUnavailable},
+	} {
+		clusters := []cluster.Cluster{memcluster.New(10), selectErrorCluster{memcluster.New(10)}}
+		f := farm.New(clusters, 1, farm.SendAllReadAll, farm.NoRepairs, nil, farm.WithReadErrorTolerance(tc.tolerance))
+		r := pat.New()
+		r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+		server := httptest.NewServer(r)
+
+		body, _ := json.Marshal([][]byte{[]byte("foo")})
+		req, _ := http.NewRequest("GET", server.URL, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		server.Close()
+		if expected, got := tc.expected, resp.StatusCode; expected != got {
+			t.Errorf("tolerance %v: expected HTTP %d, got %d", tc.tolerance, expected, got)
+		}
+	}
+}
+
+func TestSelectRange(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	for _, tc := range []struct {
+		name     string
+		query    url.Values
+		expected map[string][]common.KeyScoreMember
+	}{
+		{
+			name: "start only",
+			query: url.Values{
+				"start": {common.Cursor{Score: 789, Member: "ghi"}.String()},
+				"limit": {"2"},
+			},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 456, Member: "def"}, {Key: "foo", Score: 123, Member: "abc"}},
+				"bar": {{Key: "bar", Score: 750, Member: "zzz"}, {Key: "bar", Score: 500, Member: "yyy"}},
+			},
+		},
+		{
+			name: "stop only",
+			query: url.Values{
+				"stop": {common.Cursor{Score: 300}.String()},
+			},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 789, Member: "ghi"}, {Key: "foo", Score: 456, Member: "def"}},
+				"bar": {{Key: "bar", Score: 750, Member: "zzz"}, {Key: "bar", Score: 500, Member: "yyy"}},
+			},
+		},
+		{
+			name: "start and stop, both exclusive",
+			query: url.Values{
+				"start": {common.Cursor{Score: 750, Member: "zzz"}.String()},
+				"stop":  {common.Cursor{Score: 250, Member: "xxx"}.String()},
+			},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 456, Member: "def"}},
+				"bar": {{Key: "bar", Score: 500, Member: "yyy"}},
+			},
+		},
+		{
+			name: "empty range",
+			query: url.Values{
+				"start": {common.Cursor{Score: 100}.String()},
+				"stop":  {common.Cursor{Score: 50}.String()},
+			},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {},
+				"bar": {},
+			},
+		},
+		{
+			name: "start and offset",
+			query: url.Values{
+				"start":  {common.Cursor{Score: 800}.String()},
+				"offset": {"1"},
+				"limit":  {"1"},
+			},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 456, Member: "def"}},
+				"bar": {{Key: "bar", Score: 500, Member: "yyy"}},
+			},
+		},
+		{
+			name: "start, stop and offset",
+			query: url.Values{
+				"start":  {common.Cursor{Score: 789, Member: "ghi"}.String()},
+				"stop":   {common.Cursor{Score: 100}.String()},
+				"offset": {"1"},
+			},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 123, Member: "abc"}},
+				"bar": {{Key: "bar", Score: 500, Member: "yyy"}, {Key: "bar", Score: 250, Member: "xxx"}},
+			},
+		},
+		{
+			name: "offset past the end",
+			query: url.Values{
+				"start":  {common.Cursor{Score: 800}.String()},
+				"offset": {"3"},
+			},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {},
+				"bar": {},
+			},
+		},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"?"+tc.query.Encode(), bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Records map[string][]common.KeyScoreMember `json:"records"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("%s: HTTP %d", tc.name, resp.StatusCode)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if expected, got := tc.expected, response.Records; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, expected, got)
+		}
+	}
+}
+
+func TestSelectBinary(t *testing.T) {
+	var (
+		f      = farm.New([]cluster.Cluster{memcluster.New(10)}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+		key    = string([]byte{0, 255, 'k'})
+		tuples = []common.KeyScoreMember{
+			{Key: key, Score: 2, Member: string([]byte{255, 0})},
+			{Key: key, Score: 1, Member: string([]byte{0, 0xc0, '\r', '\n'})},
+		}
+	)
+	r := pat.New()
+	r.Post("/", handleInsert(f, 0))
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal(tuples)
+	resp, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("insert: HTTP %d", resp.StatusCode)
+	}
+
+	// Page through the records with the cursors of the records.
+	var (
+		keys, _ = json.Marshal(common.Keys{key})
+		query   = url.Values{"limit": {"1"}, "start": {common.Cursor{Score: math.MaxFloat64}.String()}}
+		got     = []common.KeyScoreMember{}
+	)
+	for i := 0; i <= len(tuples); i++ {
+		req, _ := http.NewRequest("GET", server.URL+"?"+query.Encode(), bytes.NewReader(keys))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Records map[string][]common.KeyScoreMember `json:"records"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		// The keys of the records object are JSON strings, which can't hold
+		// the binary key, but the key of every record can.
+		if expected, got := 1, len(response.Records); expected != got {
+			t.Fatalf("expected %d key(s), got %d", expected, got)
 		}
+		var records []common.KeyScoreMember
+		for _, a := range response.Records {
+			records = a // the only key
+		}
+		if len(records) <= 0 {
+			break
+		}
+		got = append(got, records[0])
+		query.Set("start", records[0].Cursor().String())
+	}
+	if !reflect.DeepEqual(tuples, got) {
+		t.Errorf("expected %v, got %v", tuples, got)
 	}
 }
 
-func TestHandleInsert(t *testing.T) {
-	farm := newMockFarm()
-	r := pat.New()
-	r.Post("/", handleInsert(farm))
-	server := httptest.NewServer(r)
+func TestSelectRangeCoalesce(t *testing.T) {
+	server := fixtureServer()
 	defer server.Close()
 
-	requestBody, _ := json.Marshal([]common.KeyScoreMember{
-		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
-		common.KeyScoreMember{Key: "bar", Score: 200, Member: "xxx"},
-		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
-		common.KeyScoreMember{Key: "bar", Score: 900, Member: "yyy"},
-	})
-	resp, err := http.Post(server.URL, "text/plain", bytes.NewReader(requestBody))
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	query := url.Values{
+		"coalesce": {"true"},
+		"start":    {common.Cursor{Score: 760}.String()},
+		"stop":     {common.Cursor{Score: 200}.String()},
+		"limit":    {"3"},
+	}
+	req, _ := http.NewRequest("GET", server.URL+"?"+query.Encode(), bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer resp.Body.Close()
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	t.Log(strings.TrimSpace(string(respBody)))
 	if resp.StatusCode != 200 {
 		t.Fatalf("HTTP %d", resp.StatusCode)
 	}
 
-	if expected, got := []common.KeyScoreMember{
-		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
-		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
-	}, farm.m["foo"]; !reflect.DeepEqual(expected, got) {
-		t.Errorf("expected %+v, got %+v", expected, got)
+	var coalescedResponse struct {
+		Records []common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&coalescedResponse); err != nil {
+		t.Fatal(err)
 	}
-
 	if expected, got := []common.KeyScoreMember{
-		common.KeyScoreMember{Key: "bar", Score: 900, Member: "yyy"},
-		common.KeyScoreMember{Key: "bar", Score: 200, Member: "xxx"},
-	}, farm.m["bar"]; !reflect.DeepEqual(expected, got) {
+		common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
+		common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+	}, coalescedResponse.Records; !reflect.DeepEqual(expected, got) {
 		t.Errorf("expected %+v, got %+v", expected, got)
 	}
 }
 
-func TestSelectDefaults(t *testing.T) {
+func TestSelectRangeCoalesceOffset(t *testing.T) {
 	server := fixtureServer()
 	defer server.Close()
 
+	// The offset skips coalesced records, not those of every key.
 	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
-	req, _ := http.NewRequest("GET", server.URL, bytes.NewReader(body))
+	query := url.Values{
+		"coalesce": {"true"},
+		"start":    {common.Cursor{Score: 760}.String()},
+		"stop":     {common.Cursor{Score: 200}.String()},
+		"offset":   {"1"},
+		"limit":    {"2"},
+	}
+	req, _ := http.NewRequest("GET", server.URL+"?"+query.Encode(), bytes.NewReader(body))
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatal(err)
@@ -123,34 +2092,123 @@ func TestSelectDefaults(t *testing.T) {
 		t.Fatalf("HTTP %d", resp.StatusCode)
 	}
 
-	var normalResponse struct {
-		Records map[string][]common.KeyScoreMember `json:"records"`
+	var coalescedResponse struct {
+		Records []common.KeyScoreMember `json:"records"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&coalescedResponse); err != nil {
 		t.Fatal(err)
 	}
-	if expected, got := map[string][]common.KeyScoreMember{
-		"foo": []common.KeyScoreMember{
-			common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"},
-			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
-			common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+	if expected, got := []common.KeyScoreMember{
+		{Key: "bar", Score: 500, Member: "yyy"},
+		{Key: "foo", Score: 456, Member: "def"},
+	}, coalescedResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestSelectRangeInvalid(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	for _, query := range []url.Values{
+		{"start": {"garbage"}},
+		{"stop": {"garbage"}},
+		{"start": {"xA"}},
+		{"start": {common.Cursor{Score: 1}.String()}, "stop": {"1A!"}},
+		{"start": {common.Cursor{Score: 1}.String()}, "offset": {"-1"}},
+		{"start": {common.Cursor{Score: math.NaN()}.String()}},
+		{"stop": {common.Cursor{Score: math.Inf(-1)}.String()}},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"?"+query.Encode(), bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected HTTP %d, got %d", query.Encode(), expected, got)
+		}
+	}
+}
+
+func TestSelectOrder(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	for _, tc := range []struct {
+		query    url.Values
+		expected map[string][]common.KeyScoreMember
+	}{
+		{
+			query: url.Values{"order": {"desc"}, "limit": {"2"}},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 789, Member: "ghi"}, {Key: "foo", Score: 456, Member: "def"}},
+				"bar": {{Key: "bar", Score: 750, Member: "zzz"}, {Key: "bar", Score: 500, Member: "yyy"}},
+			},
 		},
-		"bar": []common.KeyScoreMember{
-			common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
-			common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
-			common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		{
+			query: url.Values{"order": {"asc"}, "limit": {"2"}},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 123, Member: "abc"}, {Key: "foo", Score: 456, Member: "def"}},
+				"bar": {{Key: "bar", Score: 250, Member: "xxx"}, {Key: "bar", Score: 500, Member: "yyy"}},
+			},
 		},
-	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
-		t.Errorf("expected %+v, got %+v", expected, got)
+		{
+			query: url.Values{"order": {"asc"}, "offset": {"2"}},
+			expected: map[string][]common.KeyScoreMember{
+				"foo": {{Key: "foo", Score: 789, Member: "ghi"}},
+				"bar": {{Key: "bar", Score: 750, Member: "zzz"}},
+			},
+		},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"?"+tc.query.Encode(), bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Records map[string][]common.KeyScoreMember `json:"records"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("%s: HTTP %d", tc.query.Encode(), resp.StatusCode)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.query.Encode(), err)
+			continue
+		}
+		if expected, got := tc.expected, response.Records; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: expected %+v, got %+v", tc.query.Encode(), expected, got)
+		}
+	}
+
+	for _, query := range []url.Values{
+		{"order": {"sideways"}},
+		{"order": {"asc"}, "start": {common.Cursor{Score: 1}.String()}},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"?"+query.Encode(), bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected HTTP %d, got %d", query.Encode(), expected, got)
+		}
 	}
 }
 
-func TestSelectOffsetLimit(t *testing.T) {
+func TestSelectOrderCoalesce(t *testing.T) {
 	server := fixtureServer()
 	defer server.Close()
 
+	// Coalesced oldest-first selects are sorted oldest-first by default.
 	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
-	req, _ := http.NewRequest("GET", server.URL+"?offset=1&limit=1", bytes.NewReader(body))
+	req, _ := http.NewRequest("GET", server.URL+"?coalesce=true&order=asc&offset=1&limit=3", bytes.NewReader(body))
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatal(err)
@@ -160,20 +2218,17 @@ func TestSelectOffsetLimit(t *testing.T) {
 		t.Fatalf("HTTP %d", resp.StatusCode)
 	}
 
-	var normalResponse struct {
-		Records map[string][]common.KeyScoreMember `json:"records"`
+	var coalescedResponse struct {
+		Records []common.KeyScoreMember `json:"records"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&coalescedResponse); err != nil {
 		t.Fatal(err)
 	}
-	if expected, got := map[string][]common.KeyScoreMember{
-		"foo": []common.KeyScoreMember{
-			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
-		},
-		"bar": []common.KeyScoreMember{
-			common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
-		},
-	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+	}, coalescedResponse.Records; !reflect.DeepEqual(expected, got) {
 		t.Errorf("expected %+v, got %+v", expected, got)
 	}
 }
@@ -211,6 +2266,122 @@ func TestSelectCoalesce(t *testing.T) {
 	}
 }
 
+func TestSelectCoalesceGrouped(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	// coalesce=grouped is sort=key: records are grouped by key, in the
+	// order of the request, and the offset and limit apply to the grouped
+	// records.
+	for _, tc := range []struct {
+		keys     []string
+		query    url.Values
+		expected []string // members
+	}{
+		{[]string{"foo", "bar"}, url.Values{}, []string{"ghi", "def", "abc", "zzz", "yyy", "xxx"}},
+		{[]string{"bar", "foo"}, url.Values{}, []string{"zzz", "yyy", "xxx", "ghi", "def", "abc"}},
+		{[]string{"foo", "bar"}, url.Values{"offset": {"1"}, "limit": {"4"}}, []string{"def", "abc", "zzz", "yyy"}},
+		{[]string{"foo", "bar"}, url.Values{"sort": {"key"}}, []string{"ghi", "def", "abc", "zzz", "yyy", "xxx"}},
+		{[]string{"foo", "bar"}, url.Values{"start": {common.Cursor{Score: 600}.String()}}, []string{"def", "abc", "yyy", "xxx"}},
+	} {
+		tc.query.Set("coalesce", "grouped")
+		keys := make([][]byte, len(tc.keys))
+		for i, key := range tc.keys {
+			keys[i] = []byte(key)
+		}
+		body, _ := json.Marshal(keys)
+		req, _ := http.NewRequest("GET", server.URL+"?"+tc.query.Encode(), bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Records []common.KeyScoreMember `json:"records"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("%v %s: HTTP %d", tc.keys, tc.query.Encode(), resp.StatusCode)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v %s: %s", tc.keys, tc.query.Encode(), err)
+			continue
+		}
+		got := []string{}
+		for _, ksm := range response.Records {
+			got = append(got, ksm.Member)
+		}
+		if !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("%v %s: expected %v, got %v", tc.keys, tc.query.Encode(), tc.expected, got)
+		}
+	}
+
+	// Any other sort conflicts with it.
+	for _, sortStr := range []string{"score_desc", "score_asc"} {
+		body, _ := json.Marshal([][]byte{[]byte("foo")})
+		req, _ := http.NewRequest("GET", server.URL+"?coalesce=grouped&sort="+sortStr, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("sort=%s: expected HTTP %d, got %d", sortStr, expected, got)
+		}
+	}
+}
+
+func TestSelectCoalesceMerged(t *testing.T) {
+	// A farm merges coalesced selects itself, with the same results.
+	f := farm.New([]cluster.Cluster{memcluster.New(10)}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+	f.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 123, Member: "abc"},
+		{Key: "foo", Score: 456, Member: "def"},
+		{Key: "foo", Score: 789, Member: "ghi"},
+		{Key: "bar", Score: 250, Member: "xxx"},
+		{Key: "bar", Score: 500, Member: "yyy"},
+		{Key: "bar", Score: 750, Member: "zzz"},
+	})
+	r := pat.New()
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	for query, expected := range map[string][]common.KeyScoreMember{
+		"?coalesce=true&offset=2&limit=2": {
+			{Key: "bar", Score: 500, Member: "yyy"},
+			{Key: "foo", Score: 456, Member: "def"},
+		},
+		"?coalesce=true&order=asc&tiebreak=member_asc&offset=1&limit=3": {
+			{Key: "bar", Score: 250, Member: "xxx"},
+			{Key: "foo", Score: 456, Member: "def"},
+			{Key: "bar", Score: 500, Member: "yyy"},
+		},
+		"?coalesce=true&offset=5&limit=10": {
+			{Key: "foo", Score: 123, Member: "abc"},
+		},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Records []common.KeyScoreMember `json:"records"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+		if !reflect.DeepEqual(expected, response.Records) {
+			t.Errorf("%s: expected %+v, got %+v", query, expected, response.Records)
+		}
+	}
+}
+
 func TestSelectCoalesceOffsetLimit(t *testing.T) {
 	server := fixtureServer()
 	defer server.Close()
@@ -240,6 +2411,70 @@ func TestSelectCoalesceOffsetLimit(t *testing.T) {
 	}
 }
 
+func TestHandleExport(t *testing.T) {
+	var (
+		c        = memcluster.New(10)
+		f        = farm.New([]cluster.Cluster{c}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)
+		key      = string([]byte{0, 255, '/'})
+		expected = []common.KeyScoreMember{
+			{Key: key, Score: 3, Member: "c"},
+			{Key: key, Score: 2, Member: "b"},
+			{Key: key, Score: 1, Member: "a"},
+		}
+	)
+	c.Insert(expected)
+	r := pat.New()
+	r.Get("/export", handleExport(f))
+	r.Get("/failing/export", handleExport(failingAllSelecter{}))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/export?window=2&key=" + url.QueryEscape(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := "application/x-ndjson", resp.Header.Get("Content-Type"); expected != got {
+		t.Errorf("expected Content-Type %q, got %q", expected, got)
+	}
+	got := []common.KeyScoreMember{}
+	for dec := json.NewDecoder(resp.Body); dec.More(); {
+		var keyScoreMember common.KeyScoreMember
+		if err := dec.Decode(&keyScoreMember); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, keyScoreMember)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for path, code := range map[string]int{
+		"/export":                  http.StatusBadRequest,
+		"/export?key=foo&window=0": http.StatusBadRequest,
+		"/failing/export?key=foo":  http.StatusInternalServerError,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, got := code, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected HTTP %d, got %d", path, expected, got)
+		}
+	}
+}
+
+// failingAllSelecter fails every SelectAll.
+type failingAllSelecter struct{}
+
+func (failingAllSelecter) SelectAll(ctx context.Context, key string, window int) <-chan cluster.Element {
+	ch := make(chan cluster.Element, 1)
+	ch <- cluster.Element{Key: key, Error: errors.New("failtown")}
+	close(ch)
+	return ch
+}
+
 func TestHandleDelete(t *testing.T) {
 	server := fixtureServer()
 	defer server.Close()
@@ -294,7 +2529,63 @@ func TestHandleDelete(t *testing.T) {
 }
 
 func TestFlattenOrdering(t *testing.T) {
-	// TODO(pb): need flattenOffset and flattenCursor
+	var (
+		keys = []string{"foo", "bar"}
+		m    = map[string][]common.KeyScoreMember{
+			"foo": {
+				{Key: "foo", Score: 3, Member: "c"},
+				{Key: "foo", Score: 2, Member: "a"},
+				{Key: "foo", Score: 1, Member: "x"},
+			},
+			"bar": {
+				{Key: "bar", Score: 4, Member: "d"},
+				{Key: "bar", Score: 2, Member: "b"},
+			},
+		}
+	)
+	for _, tc := range []struct {
+		sort, tiebreak string
+		offset, limit  int
+		expected       []string // members
+	}{
+		{"score_desc", "member_desc", 0, 10, []string{"d", "c", "b", "a", "x"}}, // default
+		{"score_desc", "member_asc", 0, 10, []string{"d", "c", "a", "b", "x"}},
+		{"score_asc", "member_desc", 0, 10, []string{"x", "b", "a", "c", "d"}},
+		{"score_asc", "member_asc", 0, 10, []string{"x", "a", "b", "c", "d"}},
+		{"key", "member_desc", 0, 10, []string{"c", "a", "x", "d", "b"}},
+		{"key", "member_desc", 2, 2, []string{"x", "d"}},
+		{"score_desc", "member_asc", 1, 3, []string{"c", "a", "b"}},
+		{"score_desc", "member_desc", 5, 10, []string{}},
+		{"score_desc", "member_desc", 6, 10, []string{}},
+	} {
+		order, err := parseCoalesceOrder(tc.sort, tc.tiebreak)
+		if err != nil {
+			t.Fatalf("%s/%s: %s", tc.sort, tc.tiebreak, err)
+		}
+		got := []string{}
+		for _, ksm := range flatten(m, keys, tc.offset, tc.limit, order) {
+			got = append(got, ksm.Member)
+		}
+		if !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("%s/%s offset %d limit %d: expected %v, got %v", tc.sort, tc.tiebreak, tc.offset, tc.limit, tc.expected, got)
+		}
+	}
+
+	// The zero value must match the defaults.
+	if order, _ := parseCoalesceOrder("score_desc", "member_desc"); order != (coalesceOrder{}) {
+		t.Errorf("default order %+v isn't the zero value", order)
+	}
+
+	for _, tc := range [][2]string{
+		{"", "member_desc"},
+		{"score", "member_desc"},
+		{"score_desc", "member"},
+		{"key", ""},
+	} {
+		if _, err := parseCoalesceOrder(tc[0], tc[1]); err == nil {
+			t.Errorf("%s/%s: expected error, got none", tc[0], tc[1])
+		}
+	}
 }
 
 func fixtureServer() *httptest.Server {
@@ -308,12 +2599,36 @@ func fixtureServer() *httptest.Server {
 		common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
 	})
 	r := pat.New()
-	r.Post("/", handleInsert(farm))
-	r.Get("/", handleSelect(farm))
+	r.Post("/", handleInsert(farm, 0))
+	r.Get("/", handleSelect(farm, newTunables(nil, 1000), 0, nil))
 	r.Delete("/", handleDelete(farm))
 	return httptest.NewServer(r)
 }
 
+type chunkRecordingInserter struct {
+	chunks []int
+}
+
+func (i *chunkRecordingInserter) Insert(tuples []common.KeyScoreMember) error {
+	i.chunks = append(i.chunks, len(tuples))
+	return nil
+}
+
+// contextInserter records the context of InsertContext.
+type contextInserter struct {
+	chunkRecordingInserter
+	ctx context.Context
+}
+
+func (i *contextInserter) InsertContext(ctx context.Context, tuples []common.KeyScoreMember) error {
+	i.ctx = ctx
+	return nil
+}
+
+type failingInserter struct{ err error }
+
+func (i failingInserter) Insert([]common.KeyScoreMember) error { return i.err }
+
 type mockFarm struct {
 	m map[string][]common.KeyScoreMember
 }
@@ -333,10 +2648,17 @@ func (f *mockFarm) Insert(tuples []common.KeyScoreMember) error {
 	return nil
 }
 
-func (f *mockFarm) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+func (f *mockFarm) SelectOffset(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, error) {
 	m := map[string][]common.KeyScoreMember{}
 	for _, key := range keys {
-		m[key] = f.m[key]
+		m[key] = f.m[key] // sorted descending
+		if order == common.Ascending {
+			a := make([]common.KeyScoreMember, len(m[key]))
+			for i, ksm := range m[key] {
+				a[len(a)-1-i] = ksm
+			}
+			m[key] = a
+		}
 
 		if len(m[key]) < offset {
 			m[key] = []common.KeyScoreMember{}
@@ -350,8 +2672,48 @@ func (f *mockFarm) SelectOffset(keys []string, offset, limit int) (map[string][]
 	return m, nil
 }
 
+// SelectRange has the same semantics as cluster.SelectRange: for each key,
+// up to limit members from start to stop, both exclusive, descending by
+// score and then member.
 func (f *mockFarm) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
-	return map[string][]common.KeyScoreMember{}, fmt.Errorf("not yet implemented")
+	if limit < 0 {
+		return map[string][]common.KeyScoreMember{}, fmt.Errorf("negative limit is invalid for cursor-based select")
+	}
+	m := map[string][]common.KeyScoreMember{}
+	for _, key := range keys {
+		m[key] = []common.KeyScoreMember{}
+		for _, ksm := range f.m[key] { // sorted descending
+			if len(m[key]) >= limit {
+				break
+			}
+			pastStart := ksm.Score < start.Score || (ksm.Score == start.Score && !start.ScoreOnly && ksm.Member < start.Member)
+			if !pastStart {
+				continue
+			}
+			beforeStop := ksm.Score > stop.Score || (ksm.Score == stop.Score && !stop.ScoreOnly && ksm.Member > stop.Member)
+			if !beforeStop {
+				break
+			}
+			m[key] = append(m[key], ksm)
+		}
+	}
+	return m, nil
+}
+
+// incompleteMockFarm is a mockFarm which reports a fixed completeness.
+type incompleteMockFarm struct {
+	*mockFarm
+	complete bool
+}
+
+func (f *incompleteMockFarm) SelectOffsetComplete(keys []string, offset, limit int, order common.Order) (map[string][]common.KeyScoreMember, bool, error) {
+	m, err := f.SelectOffset(keys, offset, limit, order)
+	return m, f.complete, err
+}
+
+func (f *incompleteMockFarm) SelectRangeComplete(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, bool, error) {
+	m, err := f.SelectRange(keys, start, stop, limit)
+	return m, f.complete, err
 }
 
 func (f *mockFarm) Delete(tuples []common.KeyScoreMember) error {
@@ -380,3 +2742,80 @@ func (f *mockFarm) Delete(tuples []common.KeyScoreMember) error {
 
 	return nil
 }
+
+func TestClient(t *testing.T) {
+	// The client against the real handlers, rather than a fake server.
+	f := farm.New([]cluster.Cluster{memcluster.New(10), memcluster.New(10)}, 2, farm.SendAllReadAll, farm.NoRepairs, nil)
+	r := pat.New()
+	r.Get("/", handleSelect(f, newTunables(nil, 1000), 0, nil))
+	r.Post("/", handleInsert(f, 0))
+	r.Delete("/", handleDelete(f))
+	server := httptest.NewServer(withRequestID(r))
+	defer server.Close()
+	c := client.New(server.URL)
+
+	binary := string([]byte{0, 255})
+	if err := c.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 3, Member: "c"},
+		{Key: binary, Score: 1, Member: binary},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{{Key: "foo", Score: 4, Member: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.SelectOffset([]string{"foo", binary, "bar"}, 0, 10, common.Descending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]common.KeyScoreMember{
+		"foo":  {{Key: "foo", Score: 2, Member: "b"}, {Key: "foo", Score: 1, Member: "a"}},
+		binary: {{Key: binary, Score: 1, Member: binary}},
+		"bar":  {},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("SelectOffset: expected %v, got %v", expected, got)
+	}
+
+	got, err = c.SelectOffset([]string{"foo"}, 1, 10, common.Ascending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}}; !reflect.DeepEqual(expected, got["foo"]) {
+		t.Errorf("SelectOffset ascending: expected %v, got %v", expected, got["foo"])
+	}
+
+	got, err = c.SelectOffsetFloor([]string{"foo"}, 0, 10, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}}; !reflect.DeepEqual(expected, got["foo"]) {
+		t.Errorf("SelectOffsetFloor: expected %v, got %v", expected, got["foo"])
+	}
+
+	// Paging by cursor picks up after the last record.
+	start, stop := common.Cursor{Score: math.MaxFloat64}, common.Cursor{Score: -math.MaxFloat64}
+	got, err = c.SelectRange([]string{"foo"}, start, stop, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}}; !reflect.DeepEqual(expected, got["foo"]) {
+		t.Fatalf("SelectRange: expected %v, got %v", expected, got["foo"])
+	}
+	got, err = c.SelectRange([]string{"foo"}, got["foo"][0].Cursor(), stop, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}; !reflect.DeepEqual(expected, got["foo"]) {
+		t.Errorf("SelectRange next page: expected %v, got %v", expected, got["foo"])
+	}
+
+	// Rejected requests come back as an Error with the server's message.
+	_, err = c.SelectOffset([]string{"foo"}, -1, 10, common.Descending)
+	if e, ok := err.(client.Error); !ok || e.Code != http.StatusBadRequest || e.Message == "" {
+		t.Errorf("negative offset: expected a client.Error with HTTP %d, got %v", http.StatusBadRequest, err)
+	}
+}
@@ -2,50 +2,79 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
 	"github.com/soundcloud/roshi/farm"
 	"github.com/soundcloud/roshi/instrumentation"
 	"github.com/soundcloud/roshi/instrumentation/prometheus"
 	"github.com/soundcloud/roshi/instrumentation/statsd"
+	"github.com/soundcloud/roshi/internal/cmdutil"
 	"github.com/soundcloud/roshi/pool"
 
 	"github.com/peterbourgon/g2s"
 	"github.com/tsenart/tb"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 func main() {
 	var (
-		redisInstances          = flag.String("redis.instances", "", "Semicolon-separated list of comma-separated lists of Redis instances")
-		redisConnectTimeout     = flag.Duration("redis.connect.timeout", 3*time.Second, "Redis connect timeout")
-		redisReadTimeout        = flag.Duration("redis.read.timeout", 3*time.Second, "Redis read timeout")
-		redisWriteTimeout       = flag.Duration("redis.write.timeout", 3*time.Second, "Redis write timeout")
-		redisMCPI               = flag.Int("redis.mcpi", 2, "Max connections per Redis instance")
-		redisHash               = flag.String("redis.hash", "murmur3", "Redis hash function: murmur3, fnv, fnva")
-		selectGap               = flag.Duration("select.gap", 0*time.Millisecond, "delay between pipeline read invocations when Selecting over multiple keys")
-		maxSize                 = flag.Int("max.size", 10000, "Maximum number of events per key")
-		batchSize               = flag.Int("batch.size", 100, "keys to select per request")
-		maxKeysPerSecond        = flag.Int64("max.keys.per.second", 1000, "max keys per second to walk")
-		scanLogInterval         = flag.Duration("scan.log.interval", 5*time.Second, "how often to report scan rates in log")
-		once                    = flag.Bool("once", false, "walk entire keyspace once and exit (default false, walk forever)")
-		statsdAddress           = flag.String("statsd.address", "", "Statsd address (blank to disable)")
-		statsdSampleRate        = flag.Float64("statsd.sample.rate", 0.1, "Statsd sample rate for normal metrics")
-		statsdBucketPrefix      = flag.String("statsd.bucket.prefix", "myservice.", "Statsd bucket key prefix, including trailing period")
-		prometheusNamespace     = flag.String("prometheus.namespace", "roshiwalker", "Prometheus key namespace, excluding trailing punctuation")
-		prometheusMaxSummaryAge = flag.Duration("prometheus.max.summary.age", 10*time.Second, "Prometheus max age for instantaneous histogram data")
-		httpAddress             = flag.String("http.address", ":6060", "HTTP listen address (profiling/metrics endpoints only)")
+		redisInstances              = flag.String("redis.instances", "", "Semicolon-separated list of comma-separated lists of Redis instances")
+		redisConnectTimeout         = flag.Duration("redis.connect.timeout", 3*time.Second, "Redis connect timeout")
+		redisReadTimeout            = flag.Duration("redis.read.timeout", 3*time.Second, "Redis read timeout")
+		redisWriteTimeout           = flag.Duration("redis.write.timeout", 3*time.Second, "Redis write timeout")
+		redisMCPI                   = flag.Int("redis.mcpi", 2, "Max connections per Redis instance")
+		redisHash                   = flag.String("redis.hash", "murmur3", "Redis hash function: "+strings.Join(pool.HashNames, ", "))
+		redisUsername               = flag.String("redis.username", "", "Redis ACL user to authenticate connections as, with AUTH username password (Redis 6 and later; blank for AUTH password)")
+		redisPasswordFile           = flag.String("redis.password.file", "", "Path to a file with the Redis password to authenticate connections with (blank for $"+cmdutil.RedisPasswordEnv+"; no password to not authenticate, unless redis.username is set)")
+		farmAllowDuplicateInstances = flag.Bool("farm.allow.duplicate.instances", false, "allow the same Redis instance in multiple clusters, e.g. during a migration, and only log a warning")
+		selectGap                   = flag.Duration("select.gap", 0*time.Millisecond, "delay between pipeline read invocations when Selecting over multiple keys")
+		maxSize                     = flag.Int("max.size", 10000, "Maximum number of events per key")
+		uncappedKeyPrefixes         = flag.String("uncapped.key.prefixes", "", "Comma-separated list of key prefixes exempt from max.size, as configured in roshi-server; only the first max.size members of such keys are walked")
+		scoreMaxKeyMembers          = flag.Int("score.max.key.members", cluster.DefaultMaxScoreKeyMembers, "Max key-members per Score call to a cluster during repairs; larger calls fail (0 to disable)")
+		memberCompressionThreshold  = flag.Int("member.compression.threshold", 0, "Compress members of at least this many bytes in Redis (0 to disable; as configured in roshi-server)")
+		memberCompressionWrite      = flag.Bool("member.compression.write", true, "With member.compression.threshold, write members compressed; disable while rolling compression out or back, to write them uncompressed but replace compressed ones")
+		writeScriptPath             = flag.String("write.script", "", "Path to a Lua script which replaces the insert and delete script, see cluster.DefaultScript (advanced; as configured in roshi-server)")
+		tombstoneGrace              = flag.Float64("tombstone.grace", 0, "If nonzero, trim the tombstones of every walked key, and of every repair, with scores more than this many score units below the newest score of the key (as configured in roshi-server; see cluster.WithTombstoneGrace)")
+		tombstoneReportRatio        = flag.Float64("tombstone.report.ratio", 0, "If nonzero, log walked keys with at least this many tombstones per inserted member, and at least tombstone.report.min tombstones, in every cluster")
+		tombstoneReportMin          = flag.Int("tombstone.report.min", 100, "Min tombstones of a key for tombstone.report.ratio")
+		tombstoneTrimAge            = flag.Duration("tombstone.trim.age", 0, "If nonzero, trim the tombstones older than this of the keys logged by tombstone.report.ratio, e.g. 720h (see walk.score.unit)")
+		tombstoneTrimKeysPerSecond  = flag.Int64("tombstone.trim.max.keys.per.second", 10, "Max keys per second to trim with tombstone.trim.age")
+		batchSize                   = flag.Int("batch.size", 100, "keys to select per request")
+		walkWindow                  = flag.Int("walk.window", 0, "if nonzero, page through each key in windows of this many members, to bound memory (0 selects max.size members at once)")
+		walkSince                   = flag.Duration("walk.since", 0, "if nonzero, only repair members with scores from this long ago onwards, e.g. 168h; members outside the window aren't repaired, so alternate with full walks (see walk.score.unit)")
+		walkUntil                   = flag.Duration("walk.until", 0, "if nonzero, only repair members with scores until this long ago (see walk.since)")
+		walkScoreUnit               = flag.Duration("walk.score.unit", time.Second, "duration of one score unit for walk.since and walk.until, where scores are Unix timestamps, e.g. 1ms for milliseconds")
+		walkSetTTL                  = flag.Duration("walk.set.ttl", 0, "if nonzero, set this TTL on every walked key, replacing any previous one")
+		walkRepair                  = flag.Bool("walk.repair", true, "repair walked keys (disable to only set TTLs, see walk.set.ttl)")
+		walkRepairLog               = flag.String("walk.repair.log", "", "if set, append a JSON line for every key which needed repairs to this file, or to stdout for -, e.g. to audit divergence (blank to disable)")
+		walkClusters                = flag.String("walk.clusters", "", "Comma-separated list of indexes of the clusters to scan keys from, e.g. to backfill a new cluster from the others (blank for all); repairs always span all clusters")
+		maxKeysPerSecond            = flag.Int64("max.keys.per.second", 1000, "max keys per second to walk")
+		scanLogInterval             = flag.Duration("scan.log.interval", 5*time.Second, "how often to report scan rates in log")
+		clockProbeInterval          = flag.Duration("clock.probe.interval", 0, "how often to measure clock skew between Redis instances (0 to disable)")
+		once                        = flag.Bool("once", false, "walk entire keyspace once and exit (default false, walk forever)")
+		statsdAddress               = flag.String("statsd.address", "", "Statsd address (blank to disable)")
+		statsdSampleRate            = flag.Float64("statsd.sample.rate", 0.1, "Statsd sample rate for normal metrics")
+		statsdBucketPrefix          = flag.String("statsd.bucket.prefix", "myservice.", "Statsd bucket key prefix, including trailing period")
+		statsdFlushInterval         = flag.Duration("statsd.flush.interval", 0, "If nonzero, buffer statsd metrics and send them every interval, packed into packets of up to statsd.packet.size bytes")
+		statsdPacketSize            = flag.Int("statsd.packet.size", 1432, "Max statsd packet size in bytes, when buffering (see statsd.flush.interval)")
+		prometheusNamespace         = flag.String("prometheus.namespace", "roshiwalker", "Prometheus key namespace, excluding trailing punctuation")
+		prometheusMaxSummaryAge     = flag.Duration("prometheus.max.summary.age", 10*time.Second, "Prometheus max age for instantaneous histogram data")
+		healthCheckInterval         = flag.Duration("health.check.interval", 10*time.Second, "how often to ping every Redis instance, for the instance_up Prometheus metric (0 to disable)")
+		httpAddress                 = flag.String("http.address", ":6060", "HTTP listen address (profiling/metrics endpoints only)")
 	)
 	flag.Parse()
 	log.SetOutput(os.Stdout)
@@ -55,12 +84,39 @@ func main() {
 	if *maxKeysPerSecond < int64(*batchSize) {
 		log.Fatal("max keys per second should be bigger than batch size")
 	}
+	if !*walkRepair && *walkSetTTL <= 0 && *tombstoneGrace <= 0 && *tombstoneReportRatio <= 0 {
+		log.Fatal("nothing to do: walk.repair is disabled, and neither walk.set.ttl, tombstone.grace, nor tombstone.report.ratio is set")
+	}
+	if *tombstoneTrimAge > 0 && *tombstoneReportRatio <= 0 {
+		log.Fatal("tombstone.trim.age requires tombstone.report.ratio, which picks the keys to trim")
+	}
+	if *tombstoneTrimAge > 0 && *tombstoneTrimKeysPerSecond <= 0 {
+		log.Fatal("tombstone.trim.max.keys.per.second must be positive")
+	}
+	if *walkScoreUnit <= 0 {
+		log.Fatal("walk.score.unit must be positive")
+	}
+	if *walkSince > 0 && *walkUntil >= *walkSince {
+		log.Fatal("walk.until should be less than walk.since, which is further in the past")
+	}
+	if *scoreMaxKeyMembers > 0 && *batchSize**maxSize > *scoreMaxKeyMembers {
+		log.Printf("warning: repairs of full batches (%d keys of %d members) exceed score.max.key.members (%d) and will fail", *batchSize, *maxSize, *scoreMaxKeyMembers)
+	}
 
 	// Set up instrumentation.
 	statter := g2s.Noop()
 	if *statsdAddress != "" {
 		var err error
-		statter, err = g2s.Dial("udp", *statsdAddress)
+		if *statsdFlushInterval > 0 {
+			var bw *statsd.BufferedWriter
+			statter, bw, err = statsd.DialBuffered("udp", *statsdAddress, *statsdPacketSize, *statsdFlushInterval)
+			if err == nil {
+				defer bw.Close()
+				cmdutil.CloseOnSignal(bw)
+			}
+		} else {
+			statter, err = g2s.Dial("udp", *statsdAddress)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -73,19 +129,42 @@ func main() {
 	)
 
 	// Parse hash function.
-	var hashFunc func(string) uint32
-	switch strings.ToLower(*redisHash) {
-	case "murmur3":
-		hashFunc = pool.Murmur3
-	case "fnv":
-		hashFunc = pool.FNV
-	case "fnva":
-		hashFunc = pool.FNVa
-	default:
-		log.Fatalf("unknown hash %q", *redisHash)
+	hashFunc, err := pool.HashByName(*redisHash)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Set up the clusters.
+	clusterOptions := []cluster.Option{cluster.WithMaxScoreKeyMembers(*scoreMaxKeyMembers)}
+	if prefixes := cmdutil.SplitPrefixes(*uncappedKeyPrefixes); len(prefixes) > 0 {
+		clusterOptions = append(clusterOptions, cluster.WithUncappedPrefixes(prefixes...))
+	}
+	if option := cmdutil.MemberCompression(*memberCompressionThreshold, *memberCompressionWrite); option != nil {
+		clusterOptions = append(clusterOptions, option)
+	}
+	writeScript, err := cmdutil.ReadWriteScript(*writeScriptPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if writeScript != "" {
+		log.Printf("using the write script in %s", *writeScriptPath)
+		option, err := cluster.WithScript(writeScript)
+		if err != nil {
+			log.Fatalf("%s: %s", *writeScriptPath, err)
+		}
+		clusterOptions = append(clusterOptions, option)
+	}
+	if *tombstoneGrace > 0 {
+		clusterOptions = append(clusterOptions, cluster.WithTombstoneGrace(*tombstoneGrace))
+	}
+	redisPassword, err := cmdutil.ReadRedisPassword(*redisPasswordFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var poolOptions []pool.Option
+	if *redisUsername != "" || redisPassword != "" {
+		poolOptions = append(poolOptions, pool.WithAuth(*redisUsername, redisPassword))
+	}
 	clusters, err := farm.ParseFarmString(
 		*redisInstances,
 		*redisConnectTimeout, *redisReadTimeout, *redisWriteTimeout,
@@ -93,15 +172,32 @@ func main() {
 		hashFunc,
 		*maxSize,
 		*selectGap,
+		*farmAllowDuplicateInstances,
+		poolOptions,
 		instr,
+		clusterOptions...,
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
+	sources, err := parseIndexes(*walkClusters, len(clusters))
+	if err != nil {
+		log.Fatalf("walk.clusters: %s", err)
+	}
 
 	// HTTP server for profiling.
 	go func() { log.Print(http.ListenAndServe(*httpAddress, nil)) }()
 
+	// Check the health of every instance.
+	if *healthCheckInterval > 0 {
+		go cluster.CheckInstances(clusters, *healthCheckInterval, prometheusInstr.InstanceUp)
+	}
+
+	// Probe the clocks of the Redis instances.
+	if *clockProbeInterval > 0 {
+		go probeClocks(clusters, *clockProbeInterval, instr)
+	}
+
 	// Set up our rate limiter. Remember: it's per-key, not per-request.
 	var (
 		freq   = time.Duration(1/(*maxKeysPerSecond)) * time.Second
@@ -109,30 +205,79 @@ func main() {
 	)
 
 	// Build the farm.
+	farmOptions := []farm.Option{farm.WithMaxSelectKeys(*batchSize), farm.WithTimestampScores(*walkScoreUnit)}
+	if *walkRepairLog != "" {
+		w := io.Writer(os.Stdout)
+		if *walkRepairLog != "-" {
+			f, err := os.OpenFile(*walkRepairLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				log.Fatalf("walk.repair.log: %s", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		farmOptions = append(farmOptions, farm.WithRepairObserver(newRepairLog(w).observe))
+	}
+	if err := farm.Validate(clusters, farmOptions...); err != nil {
+		log.Fatal(err)
+	}
 	var (
 		readStrategy   = farm.SendAllReadAll
-		repairStrategy = farm.AllRepairs // blocking
-		writeQuorum    = len(clusters)   // 100%
-		dst            = farm.New(clusters, writeQuorum, readStrategy, repairStrategy, instr)
+		repairStrategy = farm.AllRepairsWith(farm.RepairTimestampScores(*walkScoreUnit)) // blocking
+		writeQuorum    = len(clusters)                                                   // 100%
+		dst            = farm.New(clusters, writeQuorum, readStrategy, repairStrategy, instr, farmOptions...)
 	)
 
+	// Set up the sweeps of TTLs and tombstones.
+	sweeps := []func([]string){}
+	if *walkSetTTL > 0 {
+		sweeps = append(sweeps, expirer(clusters, *walkSetTTL))
+	}
+	if *tombstoneGrace > 0 {
+		sweeps = append(sweeps, tombstoneTrimmer(clusters, *tombstoneGrace))
+	}
+	if *tombstoneReportRatio > 0 {
+		var trim *tombstoneTrim
+		if *tombstoneTrimAge > 0 {
+			trim = &tombstoneTrim{
+				before: recentScores(*tombstoneTrimAge, 0, *walkScoreUnit),
+				wait:   tb.NewBucket(*tombstoneTrimKeysPerSecond, 0),
+			}
+		}
+		sweeps = append(sweeps, tombstoneReporter(clusters, *tombstoneReportRatio, *tombstoneReportMin, trim))
+	}
+	var repair farm.Selecter
+	if *walkRepair {
+		repair = dst
+	}
+	var scores scoreRange
+	if *walkSince > 0 || *walkUntil > 0 {
+		scores = recentScores(*walkSince, *walkUntil, *walkScoreUnit)
+	}
+
 	// Perform the walk.
 	defer func(t time.Time) { log.Printf("total walk complete, %s", time.Since(t)) }(time.Now())
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for {
-		src := scan(clusters, *batchSize, *scanLogInterval) // new key set
-		walkOnce(dst, bucket, src, *maxSize, instr)
+		src := scan(clusters, sources, *batchSize, *walkSetTTL > 0, *scanLogInterval, r) // new key set
+		walkOnce(repair, sweeps, bucket, src, *maxSize, *walkWindow, scores, instr)
 		if *once {
 			break
 		}
 	}
 }
 
-func scan(clusters []cluster.Cluster, batchSize int, logInterval time.Duration) <-chan []string {
+// scan sends the keys of the clusters with the given indexes, in random
+// order of clusters. With deletes, it also sends the keys which only have a
+// delete set, of every cluster which implements cluster.DeleteScanner, e.g.
+// so that they expire too.
+func scan(clusters []cluster.Cluster, indexes []int, batchSize int, deletes bool, logInterval time.Duration, r *rand.Rand) <-chan []string {
 	c := make(chan []string)
 	go func() {
 		defer close(c)
-		for i, index := range rand.Perm(len(clusters)) {
-			log.Printf("walking the keyspace of cluster index %d (%d/%d)", index, i+1, len(clusters))
+		for i, j := range r.Perm(len(indexes)) {
+			index := indexes[j]
+			log.Printf("walking the keyspace of cluster index %d (%d/%d)", index, i+1, len(indexes))
 			for batch := range clusters[index].Keys(batchSize) {
 				c <- batch
 				// log.Printf(
@@ -141,29 +286,332 @@ func scan(clusters []cluster.Cluster, batchSize int, logInterval time.Duration)
 				// 	len(batch),
 				// )
 			}
+			if !deletes {
+				continue
+			}
+			s, ok := clusters[index].(cluster.DeleteScanner)
+			if !ok {
+				log.Printf("warning: cluster index %d can't scan keys which only have deletes; they won't be walked", index)
+				continue
+			}
+			for batch := range s.DeleteOnlyKeys(batchSize) {
+				c <- batch
+			}
 		}
 	}()
 	return c
 }
 
+// scoreRange returns the cursors between which members are walked.
+type scoreRange func() (start, stop common.Cursor)
+
+// allScores is the scoreRange of every member. The start is +Inf rather
+// than math.MaxFloat64, as the start cursor excludes members at its score.
+func allScores() (start, stop common.Cursor) {
+	return common.Cursor{Score: math.Inf(1)}, common.Cursor{Score: math.Inf(-1)}
+}
+
+// recentScores returns the scoreRange of members with scores from since ago
+// until until ago, for scores which are Unix timestamps in units of unit.
+// Zero since or until leave that end unbounded. The range moves with the
+// clock.
+func recentScores(since, until, unit time.Duration) scoreRange {
+	return func() (start, stop common.Cursor) {
+		start, stop = allScores()
+		now := time.Now()
+		if since > 0 {
+			stop.Score = float64(now.Add(-since).UnixNano()) / float64(unit)
+		}
+		if until > 0 {
+			start.Score = float64(now.Add(-until).UnixNano()) / float64(unit)
+		}
+		return start, stop
+	}
+}
+
+// walkOnce repairs every batch of keys from src by selecting it from dst,
+// unless it's nil, and then passes it to every sweep, e.g. to set TTLs. All
+// of them share the per-key rate limit. If scores isn't nil, only members in its
+// range are selected, and therefore repaired.
 func walkOnce(
 	dst farm.Selecter,
+	sweeps []func([]string),
 	wait waiter,
 	src <-chan []string,
 	maxSize int,
+	window int,
+	scores scoreRange,
 	instr instrumentation.WalkInstrumentation,
 ) {
 	defer func(t time.Time) { log.Printf("single walk complete, %s", time.Since(t)) }(time.Now())
 	for batch := range src {
 		log.Printf("walk: received batch of %d, requesting tokens", len(batch))
 		wait.Wait(int64(len(batch)))
-		log.Printf("walk: received tokens, performing Select")
-		dst.SelectOffset(batch, 0, maxSize)
+		if dst != nil {
+			log.Printf("walk: received tokens, performing Select")
+			switch {
+			case window > 0:
+				if scores == nil {
+					scores = allScores
+				}
+				start, stop := scores()
+				walkWindows(dst, batch, start, stop, maxSize, window)
+			case scores != nil:
+				start, stop := scores()
+				if _, err := dst.SelectRange(batch, start, stop, maxSize); err != nil {
+					log.Printf("walk: SelectRange of %d key(s): %s", len(batch), err)
+				}
+			default:
+				dst.SelectOffset(batch, 0, maxSize, common.Descending)
+			}
+			log.Printf("walk: performed Select")
+		}
+		for _, sweep := range sweeps {
+			sweep(batch)
+		}
 		instr.WalkKeys(len(batch))
-		log.Printf("walk: performed Select, waiting for next batch")
+		log.Printf("walk: waiting for next batch")
+	}
+}
+
+// walkWindows pages through the keys with SelectRange from start to stop,
+// at most window members per key at a time, until maxSize members of each
+// key have been selected. Every window triggers its own repairs, so peak
+// memory is bounded by the window rather than maxSize.
+//
+// Keys which are at the same cursor are selected together. That's all keys
+// in the first window, but typically every key on its own afterwards.
+func walkWindows(dst farm.Selecter, keys []string, start, stop common.Cursor, maxSize, window int) {
+	cursors := map[common.Cursor][]string{start: keys}
+	for selected := 0; selected < maxSize && len(cursors) > 0; selected += window {
+		limit := window
+		if remaining := maxSize - selected; limit > remaining {
+			limit = remaining
+		}
+		next := map[common.Cursor][]string{}
+		for start, keys := range cursors {
+			results, err := dst.SelectRange(keys, start, stop, limit)
+			if err != nil {
+				log.Printf("walk: SelectRange of %d key(s): %s", len(keys), err)
+				continue
+			}
+			for _, key := range keys {
+				a := results[key]
+				if len(a) < limit {
+					continue // key exhausted
+				}
+				last := a[len(a)-1]
+				cursor := common.Cursor{Score: last.Score, Member: last.Member}
+				next[cursor] = append(next[cursor], key)
+			}
+		}
+		cursors = next
+	}
+}
+
+// expirer returns a function which sets the TTL on keys in every cluster
+// which implements cluster.Expirer. Failures are logged.
+func expirer(clusters []cluster.Cluster, ttl time.Duration) func([]string) {
+	expirers := []cluster.Expirer{}
+	for i, c := range clusters {
+		e, ok := c.(cluster.Expirer)
+		if !ok {
+			log.Printf("warning: cluster index %d doesn't support TTLs; its keys won't expire", i)
+			continue
+		}
+		expirers = append(expirers, e)
+	}
+	return func(keys []string) {
+		for _, e := range expirers {
+			if err := e.Expire(keys, ttl); err != nil {
+				log.Printf("walk: setting TTL on %d key(s): %s", len(keys), err)
+			}
+		}
+	}
+}
+
+// tombstoneTrimmer returns a function which trims the tombstones of keys in
+// every cluster which implements cluster.TombstoneTrimmer. Failures are
+// logged.
+func tombstoneTrimmer(clusters []cluster.Cluster, grace float64) func([]string) {
+	trimmers := []cluster.TombstoneTrimmer{}
+	for i, c := range clusters {
+		t, ok := c.(cluster.TombstoneTrimmer)
+		if !ok {
+			log.Printf("warning: cluster index %d doesn't support trimming tombstones; its tombstones won't be trimmed", i)
+			continue
+		}
+		trimmers = append(trimmers, t)
+	}
+	return func(keys []string) {
+		for _, t := range trimmers {
+			if err := t.TrimTombstones(keys, grace); err != nil {
+				log.Printf("walk: trimming tombstones of %d key(s): %s", len(keys), err)
+			}
+		}
+	}
+}
+
+// tombstoneTrim is how tombstoneReporter trims the tombstones of the keys
+// it reports: the ones with scores below the stop of before, at a rate
+// limited by wait.
+type tombstoneTrim struct {
+	before scoreRange
+	wait   waiter
+}
+
+// tombstoneReporter returns a function which logs the keys with at least
+// ratio tombstones per inserted member, and at least min tombstones, in
+// every cluster which implements cluster.Cardinalizer, and trims their
+// tombstones if trim isn't nil. Keys without inserts are only walked with
+// walk.set.ttl, so otherwise they're never reported. Failures are logged.
+func tombstoneReporter(clusters []cluster.Cluster, ratio float64, min int, trim *tombstoneTrim) func([]string) {
+	type target struct {
+		index int
+		cluster.Cardinalizer
+		trimmer cluster.TombstoneScoreTrimmer // nil if it can't trim
+	}
+	targets := []target{}
+	for i, c := range clusters {
+		cardinalizer, ok := c.(cluster.Cardinalizer)
+		if !ok {
+			log.Printf("warning: cluster index %d doesn't support counting tombstones; its keys won't be reported", i)
+			continue
+		}
+		t := target{index: i, Cardinalizer: cardinalizer}
+		if trim != nil {
+			if t.trimmer, ok = c.(cluster.TombstoneScoreTrimmer); !ok {
+				log.Printf("warning: cluster index %d doesn't support trimming tombstones by score; its tombstones won't be trimmed", i)
+			}
+		}
+		targets = append(targets, t)
+	}
+	return func(keys []string) {
+		for _, t := range targets {
+			cardinalities, err := t.Cardinalities(keys)
+			if err != nil {
+				log.Printf("walk: counting tombstones of %d key(s) in cluster index %d: %s", len(keys), t.index, err)
+				continue
+			}
+			heavy := []string{}
+			for _, key := range keys {
+				c := cardinalities[key]
+				if c.Deletes >= min && float64(c.Deletes) >= ratio*float64(c.Inserts) {
+					log.Printf("walk: cluster index %d: key %q has %d tombstone(s), %d insert(s)", t.index, key, c.Deletes, c.Inserts)
+					heavy = append(heavy, key)
+				}
+			}
+			if len(heavy) <= 0 || t.trimmer == nil {
+				continue
+			}
+			trim.wait.Wait(int64(len(heavy)))
+			_, before := trim.before()
+			if err := t.trimmer.TrimTombstonesBefore(heavy, before.Score); err != nil {
+				log.Printf("walk: trimming tombstones of %d key(s) in cluster index %d: %s", len(heavy), t.index, err)
+			}
+		}
+	}
+}
+
+// probeClocks periodically reads the clock of every Redis instance, and
+// reports the spread between the fastest and slowest one. Scores are often
+// timestamps taken on the same hosts, and skewed clocks silently change
+// which write wins a conflict.
+func probeClocks(clusters []cluster.Cluster, interval time.Duration, instr instrumentation.WalkInstrumentation) {
+	for range time.Tick(interval) {
+		var (
+			first            = true
+			min, max         time.Duration
+			minID, maxID     string
+			probed, unprobed int
+		)
+		for _, c := range clusters {
+			r, ok := c.(cluster.ClockReader)
+			if !ok {
+				unprobed++
+				continue
+			}
+			for id, offset := range r.ClockOffsets() {
+				probed++
+				if first || offset < min {
+					min, minID = offset, id
+				}
+				if first || offset > max {
+					max, maxID = offset, id
+				}
+				first = false
+			}
+		}
+		if probed == 0 {
+			log.Printf("clock probe: no instances could be probed (%d cluster(s) without clock support)", unprobed)
+			continue
+		}
+		skew := max - min
+		log.Printf("clock probe: %d instance(s), skew %s (slowest %s %s, fastest %s %s)", probed, skew, minID, min, maxID, max)
+		instr.WalkClockSkew(skew)
 	}
 }
 
 type waiter interface {
 	Wait(int64) time.Duration
 }
+
+// parseIndexes parses a comma-separated list of cluster indexes, each less
+// than n. Blank means all of them.
+func parseIndexes(s string, n int) ([]int, error) {
+	var (
+		indexes = []int{}
+		seen    = map[int]bool{}
+	)
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		index, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= n {
+			return nil, fmt.Errorf("cluster index %d out of range (%d cluster(s))", index, n)
+		}
+		if !seen[index] {
+			seen[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	if len(indexes) <= 0 {
+		for i := 0; i < n; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// repairLog writes a JSON line for every key which needed repairs. Keys are
+// encoded as base64, like in the responses of roshi-server, as they may be
+// binary.
+type repairLog struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+func newRepairLog(w io.Writer) *repairLog {
+	return &repairLog{enc: json.NewEncoder(w)}
+}
+
+// observe is a repair observer, see farm.WithRepairObserver.
+func (l *repairLog) observe(key string, diverged int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if err := l.enc.Encode(struct {
+		Key      []byte `json:"key"`
+		Diverged int    `json:"diverged"`
+		Time     string `json:"time"`
+	}{
+		Key:      []byte(key),
+		Diverged: diverged,
+		Time:     time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		log.Printf("walk.repair.log: %s", err)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/memcluster"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+func TestScanDeleteOnlyKeys(t *testing.T) {
+	c := memcluster.New(1000)
+	c.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	c.Delete([]common.KeyScoreMember{{Key: "bar", Score: 1, Member: "a"}})
+
+	for _, testCase := range []struct {
+		deletes  bool
+		expected []string
+	}{
+		{false, []string{"foo"}},
+		{true, []string{"bar", "foo"}},
+	} {
+		var keys []string
+		for batch := range scan([]cluster.Cluster{c}, []int{0}, 10, testCase.deletes, time.Minute, rand.New(rand.NewSource(1))) {
+			keys = append(keys, batch...)
+		}
+		sort.Strings(keys)
+		if expected, got := testCase.expected, keys; !reflect.DeepEqual(expected, got) {
+			t.Errorf("deletes=%v: expected %v, got %v", testCase.deletes, expected, got)
+		}
+	}
+}
+
+func TestWalkWindows(t *testing.T) {
+	var (
+		src = memcluster.New(1000)
+		dst = memcluster.New(1000)
+		f   = farm.New([]cluster.Cluster{src, dst}, 2, farm.SendAllReadAll, farm.AllRepairs, nil)
+	)
+	src.Insert([]common.KeyScoreMember{{Key: "foo", Score: math.MaxFloat64, Member: "max"}})
+	for i := 0; i < 5; i++ {
+		src.Insert([]common.KeyScoreMember{
+			{Key: "foo", Score: float64(i), Member: strconv.Itoa(i)},
+			{Key: "bar", Score: float64(i), Member: strconv.Itoa(i)},
+		})
+	}
+
+	// Windows of 2 repair the first 4 members of every key.
+	start, stop := allScores()
+	walkWindows(f, []string{"foo", "bar"}, start, stop, 4, 2)
+
+	members := func(key string) []string {
+		a := []string{}
+		for e := range dst.SelectOffset([]string{key}, 0, 10, common.Descending) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			for _, ksm := range e.KeyScoreMembers {
+				a = append(a, ksm.Member)
+			}
+		}
+		return a
+	}
+	if expected, got := []string{"max", "4", "3", "2"}, members("foo"); !reflect.DeepEqual(expected, got) {
+		t.Errorf("foo: expected %v, got %v", expected, got)
+	}
+	if expected, got := []string{"4", "3", "2", "1"}, members("bar"); !reflect.DeepEqual(expected, got) {
+		t.Errorf("bar: expected %v, got %v", expected, got)
+	}
+}
+
+func TestScoreRanges(t *testing.T) {
+	start, stop := allScores()
+	if !math.IsInf(start.Score, 1) || !math.IsInf(stop.Score, -1) {
+		t.Errorf("allScores: expected +Inf to -Inf, got %v to %v", start.Score, stop.Score)
+	}
+
+	now := float64(time.Now().Unix())
+	for _, testCase := range []struct {
+		since, until                time.Duration
+		expectedStart, expectedStop float64
+	}{
+		{0, 0, math.Inf(1), math.Inf(-1)},
+		{time.Hour, 0, math.Inf(1), now - 3600},
+		{0, time.Minute, now - 60, math.Inf(-1)},
+		{time.Hour, time.Minute, now - 60, now - 3600},
+	} {
+		start, stop := recentScores(testCase.since, testCase.until, time.Second)()
+		if !near(testCase.expectedStart, start.Score) || !near(testCase.expectedStop, stop.Score) {
+			t.Errorf("since %s, until %s: expected %v to %v, got %v to %v", testCase.since, testCase.until, testCase.expectedStart, testCase.expectedStop, start.Score, stop.Score)
+		}
+	}
+}
+
+// near tells whether the scores are equal, or within a few seconds.
+func near(a, b float64) bool {
+	return a == b || math.Abs(a-b) < 5
+}
+
+func TestParseIndexes(t *testing.T) {
+	for _, testCase := range []struct {
+		s        string
+		expected []int
+	}{
+		{"", []int{0, 1, 2}},
+		{" ", []int{0, 1, 2}},
+		{"1", []int{1}},
+		{"2, 0", []int{2, 0}},
+		{"1,1", []int{1}},
+	} {
+		indexes, err := parseIndexes(testCase.s, 3)
+		if err != nil {
+			t.Errorf("%q: %s", testCase.s, err)
+			continue
+		}
+		if expected, got := testCase.expected, indexes; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%q: expected %v, got %v", testCase.s, expected, got)
+		}
+	}
+	for _, s := range []string{"3", "-1", "x"} {
+		if _, err := parseIndexes(s, 3); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestExpirer(t *testing.T) {
+	var (
+		sweeping = newSweepingCluster()
+		plain    = memcluster.New(1000) // doesn't support TTLs, and is skipped
+		expire   = expirer([]cluster.Cluster{sweeping, plain}, time.Hour)
+	)
+	expire([]string{"foo", "bar"})
+	if expected, got := []string{"Expire [foo bar] 1h0m0s"}, sweeping.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestTombstoneTrimmer(t *testing.T) {
+	var (
+		sweeping = newSweepingCluster()
+		plain    = memcluster.New(1000)
+		trim     = tombstoneTrimmer([]cluster.Cluster{plain, sweeping}, 10)
+	)
+	trim([]string{"foo"})
+	if expected, got := []string{"TrimTombstones [foo] 10"}, sweeping.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestTombstoneReporter(t *testing.T) {
+	sweeping := newSweepingCluster()
+	sweeping.cardinalities = map[string]cluster.Cardinality{
+		"heavy":    {Inserts: 10, Deletes: 200},
+		"few":      {Inserts: 0, Deletes: 50},    // below min
+		"balanced": {Inserts: 200, Deletes: 200}, // below ratio
+	}
+	keys := []string{"heavy", "few", "balanced", "missing"}
+
+	// Without a trim, keys are only counted.
+	report := tombstoneReporter([]cluster.Cluster{sweeping}, 2, 100, nil)
+	report(keys)
+	if expected, got := []string{"Cardinalities [heavy few balanced missing]"}, sweeping.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	// With a trim, the reported keys are trimmed before its stop, after
+	// waiting for them.
+	var (
+		w    = &countingWaiter{}
+		trim = &tombstoneTrim{
+			before: func() (start, stop common.Cursor) { return common.Cursor{Score: math.Inf(1)}, common.Cursor{Score: 42} },
+			wait:   w,
+		}
+	)
+	sweeping = newSweepingCluster()
+	sweeping.cardinalities = map[string]cluster.Cardinality{"heavy": {Inserts: 10, Deletes: 200}}
+	tombstoneReporter([]cluster.Cluster{sweeping}, 2, 100, trim)(keys)
+	if expected, got := []string{"Cardinalities [heavy few balanced missing]", "TrimTombstonesBefore [heavy] 42"}, sweeping.get(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if expected, got := int64(1), w.n; expected != got {
+		t.Errorf("expected to wait for %d key(s), got %d", expected, got)
+	}
+}
+
+func TestRepairLog(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		l   = newRepairLog(&buf)
+	)
+	l.observe("foo", 2)
+	l.observe("\xff", 1)
+
+	dec := json.NewDecoder(&buf)
+	for _, expected := range []struct {
+		key      string
+		diverged int
+	}{
+		{"foo", 2},
+		{"\xff", 1},
+	} {
+		var line struct {
+			Key      []byte `json:"key"`
+			Diverged int    `json:"diverged"`
+			Time     string `json:"time"`
+		}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		if string(line.Key) != expected.key || line.Diverged != expected.diverged {
+			t.Errorf("expected key %q diverged %d, got key %q diverged %d", expected.key, expected.diverged, line.Key, line.Diverged)
+		}
+		if _, err := time.Parse(time.RFC3339, line.Time); err != nil {
+			t.Errorf("time: %s", err)
+		}
+	}
+}
+
+// sweepingCluster records the calls of the sweeps, and reports fixed
+// cardinalities.
+type sweepingCluster struct {
+	cluster.Cluster
+	cardinalities map[string]cluster.Cardinality
+
+	mtx   sync.Mutex
+	calls []string
+}
+
+func newSweepingCluster() *sweepingCluster {
+	return &sweepingCluster{Cluster: memcluster.New(1000)}
+}
+
+func (c *sweepingCluster) record(format string, args ...interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.calls = append(c.calls, fmt.Sprintf(format, args...))
+}
+
+func (c *sweepingCluster) get() []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]string{}, c.calls...)
+}
+
+func (c *sweepingCluster) Expire(keys []string, ttl time.Duration) error {
+	c.record("Expire %v %s", keys, ttl)
+	return nil
+}
+
+func (c *sweepingCluster) TrimTombstones(keys []string, grace float64) error {
+	c.record("TrimTombstones %v %v", keys, grace)
+	return nil
+}
+
+func (c *sweepingCluster) TrimTombstonesBefore(keys []string, maxScore float64) error {
+	c.record("TrimTombstonesBefore %v %v", keys, maxScore)
+	return nil
+}
+
+func (c *sweepingCluster) Cardinalities(keys []string) (map[string]cluster.Cardinality, error) {
+	c.record("Cardinalities %v", keys)
+	return c.cardinalities, nil
+}
+
+type countingWaiter struct{ n int64 }
+
+func (w *countingWaiter) Wait(n int64) time.Duration {
+	w.n += n
+	return 0
+}